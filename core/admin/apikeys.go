@@ -0,0 +1,180 @@
+package admin
+
+import (
+	"embed"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux"
+	"github.com/kamalshkeir/kago/core/kamux/apikeys"
+	"github.com/kamalshkeir/kago/core/kamux/perms"
+	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+// defaultTemplates are the admin pages not shipped with the assets, a file with the same name in templates/admin override them
+//
+//go:embed templates
+var defaultTemplates embed.FS
+
+func init() {
+	kamux.AddDefaultTemplates(defaultTemplates, "templates", "admin")
+}
+
+func unixFormat(sec int64) string {
+	if sec == 0 {
+		return ""
+	}
+	return time.Unix(sec, 0).Format("2006-01-02 15:04")
+}
+
+var APIKeysView = func(c *kamux.Context) {
+	keys, err := apikeys.List(0)
+	if logger.CheckError(err) {
+		c.Status(http.StatusInternalServerError).Text("unable to list api keys")
+		return
+	}
+	emails := map[int]string{}
+	if users, err := orm.Model[models.User]().All(); err == nil {
+		for _, u := range users {
+			emails[u.Id] = u.Email
+		}
+	}
+	rows := make([]map[string]any, 0, len(keys))
+	for _, k := range keys {
+		status := "active"
+		if k.RevokedAt != 0 {
+			status = "revoked"
+		} else if k.ExpiresAt != 0 && time.Now().Unix() > k.ExpiresAt {
+			status = "expired"
+		}
+		rows = append(rows, map[string]any{
+			"id":         k.Id,
+			"name":       k.Name,
+			"prefix":     k.Prefix,
+			"user":       emails[k.UserId],
+			"scopes":     apikeys.ScopeList(k),
+			"created_at": unixFormat(k.CreatedAt),
+			"expires_at": unixFormat(k.ExpiresAt),
+			"last_used":  unixFormat(k.LastUsed),
+			"status":     status,
+		})
+	}
+	c.Html("admin/admin_apikeys.html", map[string]any{
+		"keys": rows,
+	})
+}
+
+// scopeActions are the permissions a scope verb need, other verbs need the permission of the same name
+var scopeActions = map[string][]string{
+	"read":  {perms.View},
+	"write": {perms.Add, perms.Change, perms.Delete},
+	"*":     orm.PERMISSION_ACTIONS,
+}
+
+// scopeAllowed report if user can grant scope, "orders:read" need orders.view, "orders:*" all permissions on orders, "*" is for admins
+func scopeAllowed(user models.User, scope string) bool {
+	if user.IsAdmin {
+		return true
+	}
+	table, verb, ok := strings.Cut(scope, ":")
+	if !ok || table == "" || table == "*" || verb == "" {
+		return false
+	}
+	actions, ok := scopeActions[verb]
+	if !ok {
+		actions = []string{verb}
+	}
+	codenames := make([]string, 0, len(actions))
+	for _, a := range actions {
+		codenames = append(codenames, perms.Codename(table, a))
+	}
+	return perms.Has(user, codenames...)
+}
+
+// APIKeysCreatePost create a key from {"email","name","scopes","expires_days"}, the plain key is returned once.
+// Without email the key is for the caller, only admins create keys for others, and scopes can't exceed the caller permissions
+var APIKeysCreatePost = func(c *kamux.Context) {
+	data := c.BodyJson()
+	email, _ := data["email"].(string)
+	name, _ := data["name"].(string)
+	scopes, _ := data["scopes"].(string)
+	caller, ok := c.User()
+	if !ok {
+		c.Status(http.StatusUnauthorized).Json(map[string]any{
+			"error": "not logged in",
+		})
+		return
+	}
+	user := caller
+	if email != "" && email != caller.Email {
+		if !caller.IsAdmin {
+			c.Status(http.StatusForbidden).Json(map[string]any{
+				"error": "only admins can create keys for other users",
+			})
+			return
+		}
+		var err error
+		user, err = orm.Model[models.User]().Where("email = ?", email).One()
+		if err != nil {
+			c.Status(http.StatusBadRequest).Json(map[string]any{
+				"error": "user " + email + " not found",
+			})
+			return
+		}
+	}
+	for _, scope := range apikeys.ScopeList(models.APIKey{Scopes: scopes}) {
+		if !scopeAllowed(caller, scope) {
+			c.Status(http.StatusForbidden).Json(map[string]any{
+				"error": "not allowed to grant scope " + scope,
+			})
+			return
+		}
+	}
+	var ttl time.Duration
+	if days := fmt.Sprint(data["expires_days"]); days != "" && days != "<nil>" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			c.Status(http.StatusBadRequest).Json(map[string]any{
+				"error": "expires_days must be a positive number of days",
+			})
+			return
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	}
+	plain, key, err := apikeys.Create(user.Id, name, []string{scopes}, ttl)
+	if logger.CheckError(err) {
+		c.Status(http.StatusInternalServerError).Json(map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.Json(map[string]any{
+		"success": "Done !",
+		"key":     plain,
+		"id":      key.Id,
+		"prefix":  key.Prefix,
+	})
+}
+
+var APIKeysRevokePost = func(c *kamux.Context) {
+	data := c.BodyJson()
+	id, err := strconv.Atoi(fmt.Sprint(data["id"]))
+	if err == nil {
+		err = apikeys.Revoke(id)
+	}
+	if err != nil {
+		c.Status(http.StatusBadRequest).Json(map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.Json(map[string]any{
+		"success": "Done !",
+		"id":      id,
+	})
+}
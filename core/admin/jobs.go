@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kamalshkeir/kago/core/jobs"
+	"github.com/kamalshkeir/kago/core/kamux"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+// JOBS_PER_PAGE is the number of jobs shown by JobsView
+var JOBS_PER_PAGE = 50
+
+// JobsView list the background jobs, filtered by ?status=, the last updated first
+var JobsView = func(c *kamux.Context) {
+	status := c.QueryParam("status")
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	list, err := jobs.STORE.List(status, JOBS_PER_PAGE, (page-1)*JOBS_PER_PAGE)
+	if logger.CheckError(err) {
+		c.Status(http.StatusInternalServerError).Text("unable to list jobs")
+		return
+	}
+	counts, err := jobs.STORE.Counts()
+	if logger.CheckError(err) {
+		counts = map[string]int{}
+	}
+	rows := make([]map[string]any, 0, len(list))
+	for _, j := range list {
+		rows = append(rows, map[string]any{
+			"id":           j.Id,
+			"name":         j.Name,
+			"payload":      j.Payload,
+			"status":       j.Status,
+			"attempts":     j.Attempts,
+			"max_attempts": j.MaxAttempts,
+			"run_at":       unixFormat(j.RunAt),
+			"updated_at":   unixFormat(j.UpdatedAt),
+			"last_error":   j.LastError,
+			"retryable":    j.Status == jobs.PENDING || j.Status == jobs.DEAD,
+		})
+	}
+	c.Html("admin/admin_jobs.html", map[string]any{
+		"jobs":     rows,
+		"status":   status,
+		"statuses": []string{jobs.PENDING, jobs.RUNNING, jobs.DONE, jobs.DEAD},
+		"counts":   counts,
+		"page":     page,
+		"prev":     page - 1,
+		"next":     page + 1,
+		"more":     len(list) == JOBS_PER_PAGE,
+	})
+}
+
+func jobId(c *kamux.Context) (int, bool) {
+	id, ok := c.BodyJson()["id"].(float64)
+	if !ok || id <= 0 {
+		c.Status(http.StatusBadRequest).Json(map[string]any{
+			"error": "id is missing",
+		})
+		return 0, false
+	}
+	return int(id), true
+}
+
+// JobsRetryPost run again now {"id"}, a dead or pending job
+var JobsRetryPost = func(c *kamux.Context) {
+	id, ok := jobId(c)
+	if !ok {
+		return
+	}
+	if err := jobs.Retry(id); err != nil {
+		c.Status(http.StatusBadRequest).Json(map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.Json(map[string]any{
+		"success": "Done !",
+	})
+}
+
+// JobsDeletePost delete {"id"}
+var JobsDeletePost = func(c *kamux.Context) {
+	id, ok := jobId(c)
+	if !ok {
+		return
+	}
+	if logger.CheckError(jobs.STORE.Delete(id)) {
+		c.Status(http.StatusInternalServerError).Json(map[string]any{
+			"error": "unable to delete job " + strconv.Itoa(id),
+		})
+		return
+	}
+	c.Json(map[string]any{
+		"success": "Done !",
+	})
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/kamux"
+	"github.com/kamalshkeir/kago/core/kamux/throttle"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+// LocksView list the accounts and ips locked after too many failed logins
+var LocksView = func(c *kamux.Context) {
+	locks, err := throttle.Locks()
+	if logger.CheckError(err) {
+		c.Status(http.StatusInternalServerError).Text("unable to list locks")
+		return
+	}
+	rows := make([]map[string]any, 0, len(locks))
+	for _, l := range locks {
+		kind, name, _ := strings.Cut(l.LockKey, ":")
+		rows = append(rows, map[string]any{
+			"key":          l.LockKey,
+			"kind":         kind,
+			"name":         name,
+			"failures":     l.Failures,
+			"last_failure": unixFormat(l.LastFailure),
+			"locked_until": unixFormat(l.LockedUntil),
+			"left":         time.Until(time.Unix(l.LockedUntil, 0)).Round(time.Second).String(),
+		})
+	}
+	c.Html("admin/admin_locks.html", map[string]any{
+		"locks":   rows,
+		"lockout": throttle.LOCKOUT.String(),
+	})
+}
+
+// LocksClearPost unlock {"key"}, an account:email or ip:addr
+var LocksClearPost = func(c *kamux.Context) {
+	key, _ := c.BodyJson()["key"].(string)
+	if key == "" {
+		c.Status(http.StatusBadRequest).Json(map[string]any{
+			"error": "key is empty",
+		})
+		return
+	}
+	if logger.CheckError(throttle.Clear(key)) {
+		c.Status(http.StatusInternalServerError).Json(map[string]any{
+			"error": "unable to clear " + key,
+		})
+		return
+	}
+	c.Json(map[string]any{
+		"success": "Done !",
+		"key":     key,
+	})
+}
@@ -11,3 +11,180 @@ type User struct {
 	Image     string    `json:"image,omitempty" orm:"size:100;default:''"`
 	CreatedAt time.Time `json:"created_at,omitempty" orm:"now"`
 }
+
+// Session is a server side session, dates are unix seconds
+type Session struct {
+	Id         int    `json:"id,omitempty" orm:"pk"`
+	SessionKey string `json:"session_key,omitempty" orm:"size:64;iunique"`
+	UserId     int    `json:"user_id,omitempty" orm:"default:0;index"`
+	Data       string `json:"data,omitempty" orm:"text"`
+	CreatedAt  int64  `json:"created_at,omitempty"`
+	LastSeen   int64  `json:"last_seen,omitempty"`
+}
+
+// RevokedToken is a revoked jwt id or refresh family, kept until ExpiresAt (unix seconds)
+type RevokedToken struct {
+	Id        int    `json:"id,omitempty" orm:"pk"`
+	Jti       string `json:"jti,omitempty" orm:"size:64;iunique"`
+	ExpiresAt int64  `json:"expires_at,omitempty" orm:"index"`
+}
+
+// APIKey is a key for machine to machine access, only the argon2 hash of its secret is stored.
+// Scopes are comma separated, dates are unix seconds, 0 meaning never
+type APIKey struct {
+	Id        int    `json:"id,omitempty" orm:"pk"`
+	UserId    int    `json:"user_id,omitempty" orm:"index"`
+	Name      string `json:"name,omitempty" orm:"size:100"`
+	Prefix    string `json:"prefix,omitempty" orm:"size:40;iunique"`
+	Hash      string `json:"-" orm:"size:150"`
+	Scopes    string `json:"scopes,omitempty" orm:"size:255;default:''"`
+	ExpiresAt int64  `json:"expires_at,omitempty" orm:"default:0"`
+	LastUsed  int64  `json:"last_used,omitempty" orm:"default:0"`
+	RevokedAt int64  `json:"revoked_at,omitempty" orm:"default:0"`
+	CreatedAt int64  `json:"created_at,omitempty"`
+}
+
+// Identity link an external account (oauth/oidc provider and subject) to a user, ProviderKey is provider:subject
+type Identity struct {
+	Id          int    `json:"id,omitempty" orm:"pk"`
+	UserId      int    `json:"user_id,omitempty" orm:"index"`
+	Provider    string `json:"provider,omitempty" orm:"size:50"`
+	Subject     string `json:"subject,omitempty" orm:"size:200"`
+	ProviderKey string `json:"provider_key,omitempty" orm:"size:255;iunique"`
+	Email       string `json:"email,omitempty" orm:"size:100;default:''"`
+	CreatedAt   int64  `json:"created_at,omitempty"`
+}
+
+// UserToken is a single use token sent to a user by mail, only its sha256 is stored, dates are unix seconds
+type UserToken struct {
+	Id        int    `json:"id,omitempty" orm:"pk"`
+	UserId    int    `json:"user_id,omitempty" orm:"index"`
+	Purpose   string `json:"purpose,omitempty" orm:"size:20"`
+	TokenHash string `json:"-" orm:"size:64;iunique"`
+	ExpiresAt int64  `json:"expires_at,omitempty" orm:"index"`
+	UsedAt    int64  `json:"used_at,omitempty" orm:"default:0"`
+	CreatedAt int64  `json:"created_at,omitempty"`
+}
+
+// VerifiedEmail record that a user proved owning Email, changing the user email make it unverified
+type VerifiedEmail struct {
+	Id         int    `json:"id,omitempty" orm:"pk"`
+	UserId     int    `json:"user_id,omitempty" orm:"index"`
+	Email      string `json:"email,omitempty" orm:"size:100;iunique"`
+	VerifiedAt int64  `json:"verified_at,omitempty"`
+}
+
+// TwoFactor is the totp secret of a user, encrypted when settings.Secret is set.
+// ConfirmedAt is 0 until the user enter a first code, LastStep prevent reusing a code
+type TwoFactor struct {
+	Id          int    `json:"id,omitempty" orm:"pk"`
+	UserId      int    `json:"user_id,omitempty" orm:"unique"`
+	Secret      string `json:"-" orm:"size:255"`
+	ConfirmedAt int64  `json:"confirmed_at,omitempty" orm:"default:0"`
+	LastStep    int64  `json:"last_step,omitempty" orm:"default:0"`
+	CreatedAt   int64  `json:"created_at,omitempty"`
+}
+
+// RecoveryCode is a single use code replacing a totp code, only its sha256 is stored
+type RecoveryCode struct {
+	Id       int    `json:"id,omitempty" orm:"pk"`
+	UserId   int    `json:"user_id,omitempty" orm:"index"`
+	CodeHash string `json:"-" orm:"size:64;iunique"`
+	UsedAt   int64  `json:"used_at,omitempty" orm:"default:0"`
+}
+
+// LoginLock count the failed logins of a Key, an account (account:email) or an ip (ip:addr), dates are unix seconds
+type LoginLock struct {
+	Id          int    `json:"id,omitempty" orm:"pk"`
+	LockKey     string `json:"lock_key,omitempty" orm:"size:150;iunique"`
+	Failures    int    `json:"failures,omitempty" orm:"default:0"`
+	LastFailure int64  `json:"last_failure,omitempty" orm:"index"`
+	LockedUntil int64  `json:"locked_until,omitempty" orm:"default:0"`
+}
+
+// RateLimit count the requests of a LimitKey, Value is a number of requests or of thousandths of tokens,
+// UpdatedAt is in unix milliseconds and ExpiresAt in unix seconds
+type RateLimit struct {
+	Id        int    `json:"id,omitempty" orm:"pk"`
+	LimitKey  string `json:"limit_key,omitempty" orm:"size:200;iunique"`
+	Value     int64  `json:"value,omitempty" orm:"default:0"`
+	UpdatedAt int64  `json:"updated_at,omitempty" orm:"default:0"`
+	ExpiresAt int64  `json:"expires_at,omitempty" orm:"index"`
+}
+
+// Job is a background job run by the workers of the jobs package, Payload is json and dates are unix seconds.
+// UniqueKey is suffixed by #id when the job is done or dead, so the key can be used again
+type Job struct {
+	Id          int    `json:"id,omitempty" orm:"pk"`
+	Name        string `json:"name,omitempty" orm:"size:100;index"`
+	Payload     string `json:"payload,omitempty" orm:"text"`
+	UniqueKey   string `json:"unique_key,omitempty" orm:"size:200;unique"`
+	Status      string `json:"status,omitempty" orm:"size:20;index"`
+	Attempts    int    `json:"attempts,omitempty" orm:"default:0"`
+	MaxAttempts int    `json:"max_attempts,omitempty" orm:"default:0"`
+	RunAt       int64  `json:"run_at,omitempty" orm:"index"`
+	LockedBy    string `json:"locked_by,omitempty" orm:"size:100"`
+	LockedUntil int64  `json:"locked_until,omitempty" orm:"default:0"`
+	LastError   string `json:"last_error,omitempty" orm:"text"`
+	CreatedAt   int64  `json:"created_at,omitempty"`
+	UpdatedAt   int64  `json:"updated_at,omitempty" orm:"index"`
+}
+
+// CronJob is a job of the cron scheduler, shared by the instances to run each occurrence once, dates are unix seconds.
+// LastRun is the scheduled time of the last occurrence claimed, Triggered is set by the shell to run the job now
+type CronJob struct {
+	Id          int    `json:"id,omitempty" orm:"pk"`
+	Name        string `json:"name,omitempty" orm:"size:100;unique"`
+	Spec        string `json:"spec,omitempty" orm:"size:100"`
+	LastRun     int64  `json:"last_run,omitempty" orm:"default:0"`
+	NextRun     int64  `json:"next_run,omitempty" orm:"default:0"`
+	LockedBy    string `json:"locked_by,omitempty" orm:"size:100"`
+	LockedUntil int64  `json:"locked_until,omitempty" orm:"default:0"`
+	Triggered   int64  `json:"triggered,omitempty" orm:"default:0"`
+	UpdatedAt   int64  `json:"updated_at,omitempty"`
+}
+
+// CronRun is a run of a CronJob, Duration is in milliseconds
+type CronRun struct {
+	Id        int    `json:"id,omitempty" orm:"pk"`
+	Name      string `json:"name,omitempty" orm:"size:100;index"`
+	Instance  string `json:"instance,omitempty" orm:"size:100"`
+	StartedAt int64  `json:"started_at,omitempty" orm:"index"`
+	Duration  int64  `json:"duration,omitempty" orm:"default:0"`
+	Error     string `json:"error,omitempty" orm:"text"`
+	Manual    bool   `json:"manual,omitempty" orm:"default:false"`
+}
+
+// Group gather permissions given to its users, like support or editors
+type Group struct {
+	Id   int    `json:"id,omitempty" orm:"pk"`
+	Name string `json:"name,omitempty" orm:"size:100;iunique"`
+}
+
+// Permission allow an Action on a table, its Codename is table.action, ex: orders.change.
+// orm.Migrate create the view, add, change, delete and drop permissions of each table
+type Permission struct {
+	Id        int    `json:"id,omitempty" orm:"pk"`
+	Codename  string `json:"codename,omitempty" orm:"size:150;iunique"`
+	TableName string `json:"table_name,omitempty" orm:"size:100;index"`
+	Action    string `json:"action,omitempty" orm:"size:20"`
+}
+
+type GroupPermission struct {
+	Id           int `json:"id,omitempty" orm:"pk"`
+	GroupId      int `json:"group_id,omitempty" orm:"index"`
+	PermissionId int `json:"permission_id,omitempty" orm:"index"`
+}
+
+type UserGroup struct {
+	Id      int `json:"id,omitempty" orm:"pk"`
+	UserId  int `json:"user_id,omitempty" orm:"index"`
+	GroupId int `json:"group_id,omitempty" orm:"index"`
+}
+
+// UserPermission give a permission to a user directly, without group
+type UserPermission struct {
+	Id           int `json:"id,omitempty" orm:"pk"`
+	UserId       int `json:"user_id,omitempty" orm:"index"`
+	PermissionId int `json:"permission_id,omitempty" orm:"index"`
+}
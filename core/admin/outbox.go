@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kamalshkeir/kago/core/kamux"
+	"github.com/kamalshkeir/kago/core/mail"
+)
+
+// OutboxView list the mails kept by mail.OUTBOX, when it is the mail.TRANSPORT
+var OutboxView = func(c *kamux.Context) {
+	sent := mail.OUTBOX.Messages()
+	rows := make([]map[string]any, 0, len(sent))
+	for _, s := range sent {
+		files := []string{}
+		for _, a := range s.Message.Attachments {
+			files = append(files, a.Filename)
+		}
+		rows = append(rows, map[string]any{
+			"id":          s.Id,
+			"at":          s.At.Format("2006-01-02 15:04:05"),
+			"from":        s.Message.From,
+			"to":          strings.Join(append(append(append([]string{}, s.Message.To...), s.Message.Cc...), s.Message.Bcc...), ", "),
+			"subject":     s.Message.Subject,
+			"html":        s.Message.HTML != "",
+			"text":        s.Message.Text,
+			"attachments": strings.Join(files, ", "),
+		})
+	}
+	c.Html("admin/admin_outbox.html", map[string]any{
+		"mails":  rows,
+		"active": mail.TRANSPORT == mail.Transport(mail.OUTBOX),
+	})
+}
+
+// OutboxMailView show the html of a mail, sandboxed, with its inline images
+var OutboxMailView = func(c *kamux.Context) {
+	id, _ := strconv.Atoi(c.Params["id"])
+	s, ok := mail.OUTBOX.Get(id)
+	if !ok {
+		c.Status(http.StatusNotFound).Text("mail not found")
+		return
+	}
+	if c.QueryParam("raw") != "" {
+		c.Download(s.Raw, "mail-"+strconv.Itoa(id)+".eml")
+		return
+	}
+	html := s.Message.HTML
+	if html == "" {
+		c.Text(s.Message.Text)
+		return
+	}
+	for _, a := range s.Message.Attachments {
+		if a.ContentID == "" {
+			continue
+		}
+		ct := a.ContentType
+		if ct == "" {
+			ct = mime.TypeByExtension(filepath.Ext(a.Filename))
+		}
+		html = strings.ReplaceAll(html, "cid:"+a.ContentID, "data:"+ct+";base64,"+base64.StdEncoding.EncodeToString(a.Data))
+	}
+	// the mail is shown as is, without scripts, isolated from the admin
+	c.SetHeader("Content-Security-Policy", "sandbox; default-src 'none'; img-src data: https:; style-src 'unsafe-inline' https:; font-src https: data:")
+	c.ServeEmbededFile("text/html; charset=utf-8", []byte(html))
+}
+
+// OutboxClearPost empty the outbox
+var OutboxClearPost = func(c *kamux.Context) {
+	mail.OUTBOX.Clear()
+	c.Json(map[string]any{
+		"success": "Done !",
+	})
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/kamalshkeir/kago/core/kamux"
+	"github.com/kamalshkeir/kago/core/kamux/perms"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+// can report if the user have the permission of action on table, answering 403 if not
+func can(c *kamux.Context, table, action string) bool {
+	codename := perms.Codename(table, action)
+	if c.HasPermission(codename) {
+		return true
+	}
+	c.Status(http.StatusForbidden).Json(map[string]any{
+		"error": "permission " + codename + " required",
+	})
+	return false
+}
+
+// tablePermissions return the actions allowed on table, for templates to hide the others
+func tablePermissions(c *kamux.Context, table string) map[string]bool {
+	res := map[string]bool{}
+	for _, action := range orm.PERMISSION_ACTIONS {
+		res[action] = c.HasPermission(perms.Codename(table, action))
+	}
+	return res
+}
+
+// canEditRow prevent users who are not admins to change or delete admins, and so to take their account
+func canEditRow(c *kamux.Context, table string, id any) bool {
+	if user, _ := c.User(); user.IsAdmin || table != "users" {
+		return true
+	}
+	row, err := orm.Table("users").Where("id = ?", id).One()
+	if err == nil {
+		if v := row["is_admin"]; v != int64(0) && v != 0 && v != false && v != nil {
+			c.Status(http.StatusForbidden).Json(map[string]any{
+				"error": "only admins can edit admins",
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// searchFilters build a parameterised where from filters {"column": value}, a string value containing % is matched using LIKE,
+// columns must belong to t, so users who are not admins never send sql
+func searchFilters(t orm.TableEntity, filters map[string]any) (string, []any, error) {
+	cols := make([]string, 0, len(filters))
+	for col := range filters {
+		if !hasColumn(t, col) {
+			return "", nil, fmt.Errorf("unknown column %q", col)
+		}
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	conds := make([]string, 0, len(cols))
+	args := make([]any, 0, len(cols))
+	for _, col := range cols {
+		name := t.Name + "." + col
+		switch v := filters[col].(type) {
+		case string:
+			if strings.Contains(v, "%") {
+				conds = append(conds, name+" LIKE ?")
+			} else {
+				conds = append(conds, name+" = ?")
+			}
+			args = append(args, v)
+		case float64, bool:
+			conds = append(conds, name+" = ?")
+			args = append(args, v)
+		default:
+			return "", nil, fmt.Errorf("invalid value for column %q", col)
+		}
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+func hasColumn(t orm.TableEntity, col string) bool {
+	for _, c := range t.Columns {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// adminOnly restrict handler to admins, for pages not tied to a table like the logs
+func adminOnly(handler kamux.Handler) kamux.Handler {
+	return func(c *kamux.Context) {
+		if user, _ := c.User(); !user.IsAdmin {
+			c.Status(http.StatusForbidden).Text("Middleware : Not allowed to access this page")
+			return
+		}
+		handler(c)
+	}
+}
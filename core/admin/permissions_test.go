@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+func TestSearchFilters(t *testing.T) {
+	table := orm.TableEntity{Name: "users", Columns: []string{"id", "email", "is_admin"}}
+	where, args, err := searchFilters(table, map[string]any{"email": "%@x.com", "is_admin": false, "id": 3.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "users.email LIKE ? AND users.id = ? AND users.is_admin = ?"; where != want {
+		t.Errorf("where = %q, want %q", where, want)
+	}
+	if want := []any{"%@x.com", 3.0, false}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+	for _, filters := range []map[string]any{
+		{"1=1 OR email": "x"},
+		{"email": []any{"x"}},
+		{"email": nil},
+	} {
+		if _, _, err := searchFilters(table, filters); err == nil {
+			t.Errorf("searchFilters(%v) accepted", filters)
+		}
+	}
+}
+
+func TestScopeAllowed(t *testing.T) {
+	admin := models.User{Id: 1, IsAdmin: true}
+	if !scopeAllowed(admin, "*") {
+		t.Error("admins can grant everything")
+	}
+	// not an admin and no permissions table, only malformed scopes are checked before the permissions
+	user := models.User{Id: 2}
+	for _, scope := range []string{"*", "*:read", "orders", ":read", "orders:"} {
+		if scopeAllowed(user, scope) {
+			t.Errorf("scopeAllowed(%q) = true", scope)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// kago-ws.js, client for kamux.WsEvents
+//
+//   const ws = new KagoWs("/ws/chat", { codec: "json" }) // or "msgpack"
+//   ws.on("message", (data) => console.log(data))
+//   ws.emit("message", { text: "hello" })
+//   const resp = await ws.request("join", { room: "general" }) // wait for the handler result
+//
+(function (root) {
+  "use strict";
+
+  // minimal MessagePack, enough for kamux.MsgPackCodec
+  const msgpack = (function () {
+    const te = new TextEncoder();
+    const td = new TextDecoder();
+
+    function encode(value) {
+      const out = [];
+      const push8 = (b) => out.push(b & 0xff);
+      const push16 = (n) => out.push((n >>> 8) & 0xff, n & 0xff);
+      const push32 = (n) => out.push((n >>> 24) & 0xff, (n >>> 16) & 0xff, (n >>> 8) & 0xff, n & 0xff);
+      const pushBytes = (b) => { for (let i = 0; i < b.length; i++) out.push(b[i]); };
+      const pushLen = (n, fix, fixMax, c16, c32) => {
+        if (n <= fixMax) push8(fix | n);
+        else if (n <= 0xffff) { push8(c16); push16(n); }
+        else { push8(c32); push32(n); }
+      };
+      const enc = (v) => {
+        if (v === null || v === undefined) return push8(0xc0);
+        if (v === false) return push8(0xc2);
+        if (v === true) return push8(0xc3);
+        if (typeof v === "number") {
+          if (Number.isInteger(v) && v >= 0 && v <= 0xffffffff) {
+            if (v <= 0x7f) push8(v);
+            else if (v <= 0xff) { push8(0xcc); push8(v); }
+            else if (v <= 0xffff) { push8(0xcd); push16(v); }
+            else { push8(0xce); push32(v); }
+          } else if (Number.isInteger(v) && v < 0 && v >= -0x80000000) {
+            if (v >= -32) push8(v);
+            else if (v >= -0x80) { push8(0xd0); push8(v); }
+            else if (v >= -0x8000) { push8(0xd1); push16(v); }
+            else { push8(0xd2); push32(v); }
+          } else {
+            const dv = new DataView(new ArrayBuffer(8));
+            dv.setFloat64(0, v);
+            push8(0xcb);
+            pushBytes(new Uint8Array(dv.buffer));
+          }
+          return;
+        }
+        if (typeof v === "string") {
+          const b = te.encode(v);
+          if (b.length <= 31) push8(0xa0 | b.length);
+          else if (b.length <= 0xff) { push8(0xd9); push8(b.length); }
+          else if (b.length <= 0xffff) { push8(0xda); push16(b.length); }
+          else { push8(0xdb); push32(b.length); }
+          return pushBytes(b);
+        }
+        if (v instanceof Uint8Array || v instanceof ArrayBuffer) {
+          const b = v instanceof ArrayBuffer ? new Uint8Array(v) : v;
+          if (b.length <= 0xff) { push8(0xc4); push8(b.length); }
+          else if (b.length <= 0xffff) { push8(0xc5); push16(b.length); }
+          else { push8(0xc6); push32(b.length); }
+          return pushBytes(b);
+        }
+        if (v instanceof Date) return enc(v.toISOString());
+        if (Array.isArray(v)) {
+          pushLen(v.length, 0x90, 15, 0xdc, 0xdd);
+          return v.forEach(enc);
+        }
+        if (typeof v === "object") {
+          const keys = Object.keys(v).filter((k) => v[k] !== undefined);
+          pushLen(keys.length, 0x80, 15, 0xde, 0xdf);
+          return keys.forEach((k) => { enc(k); enc(v[k]); });
+        }
+        throw new Error("msgpack: cannot encode " + typeof v);
+      };
+      enc(value);
+      return new Uint8Array(out);
+    }
+
+    function decode(buf) {
+      const b = buf instanceof Uint8Array ? buf : new Uint8Array(buf);
+      const dv = new DataView(b.buffer, b.byteOffset, b.byteLength);
+      let pos = 0;
+      const u8 = () => b[pos++];
+      const u16 = () => { const n = dv.getUint16(pos); pos += 2; return n; };
+      const u32 = () => { const n = dv.getUint32(pos); pos += 4; return n; };
+      const str = (n) => { const s = td.decode(b.subarray(pos, pos + n)); pos += n; return s; };
+      const bin = (n) => { const s = b.slice(pos, pos + n); pos += n; return s; };
+      const arr = (n) => { const a = new Array(n); for (let i = 0; i < n; i++) a[i] = dec(); return a; };
+      const map = (n) => { const o = {}; for (let i = 0; i < n; i++) { const k = dec(); o[k] = dec(); } return o; };
+      const dec = () => {
+        const c = u8();
+        if (c <= 0x7f) return c;
+        if (c >= 0xe0) return c - 0x100;
+        if ((c & 0xe0) === 0xa0) return str(c & 0x1f);
+        if ((c & 0xf0) === 0x90) return arr(c & 0x0f);
+        if ((c & 0xf0) === 0x80) return map(c & 0x0f);
+        let n;
+        switch (c) {
+          case 0xc0: return null;
+          case 0xc2: return false;
+          case 0xc3: return true;
+          case 0xc4: return bin(u8());
+          case 0xc5: return bin(u16());
+          case 0xc6: return bin(u32());
+          case 0xca: n = dv.getFloat32(pos); pos += 4; return n;
+          case 0xcb: n = dv.getFloat64(pos); pos += 8; return n;
+          case 0xcc: return u8();
+          case 0xcd: return u16();
+          case 0xce: return u32();
+          case 0xcf: n = dv.getBigUint64(pos); pos += 8; return Number(n);
+          case 0xd0: n = dv.getInt8(pos); pos += 1; return n;
+          case 0xd1: n = dv.getInt16(pos); pos += 2; return n;
+          case 0xd2: n = dv.getInt32(pos); pos += 4; return n;
+          case 0xd3: n = dv.getBigInt64(pos); pos += 8; return Number(n);
+          case 0xd9: return str(u8());
+          case 0xda: return str(u16());
+          case 0xdb: return str(u32());
+          case 0xdc: return arr(u16());
+          case 0xdd: return arr(u32());
+          case 0xde: return map(u16());
+          case 0xdf: return map(u32());
+        }
+        throw new Error("msgpack: invalid code 0x" + c.toString(16));
+      };
+      return dec();
+    }
+
+    return { encode, decode };
+  })();
+
+  class KagoWs {
+    constructor(url, opts = {}) {
+      this.url = KagoWs.absolute(url);
+      this.codec = opts.codec || "json";
+      this.protocols = opts.protocols;
+      // token, or function returning it, sent as a "bearer." subprotocol for kamux.TokenAuth
+      this.token = opts.token;
+      this.timeout = opts.timeout || 10000;
+      this.reconnect = opts.reconnect !== false;
+      this.reconnectDelay = opts.reconnectDelay || 1000;
+      this.handlers = {};
+      this.pending = new Map();
+      this.queue = [];
+      this.seq = 0;
+      this.closed = false;
+      this.connect();
+    }
+
+    static absolute(url) {
+      if (/^wss?:\/\//.test(url)) return url;
+      const proto = location.protocol === "https:" ? "wss://" : "ws://";
+      return proto + location.host + (url.startsWith("/") ? url : "/" + url);
+    }
+
+    connect() {
+      let protocols = this.protocols;
+      const token = typeof this.token === "function" ? this.token() : this.token;
+      if (token) {
+        protocols = [].concat(protocols || "kago", "bearer." + token);
+      }
+      this.ws = new WebSocket(this.url, protocols);
+      this.ws.binaryType = "arraybuffer";
+      this.ws.onopen = () => {
+        this.trigger("open");
+        const queued = this.queue;
+        this.queue = [];
+        queued.forEach((m) => this.ws.send(m));
+      };
+      this.ws.onmessage = (e) => {
+        let msg;
+        try {
+          msg = this.codec === "msgpack" ? msgpack.decode(e.data) : JSON.parse(e.data);
+        } catch (err) {
+          return this.trigger("error", err);
+        }
+        if (msg.ack) {
+          const p = this.pending.get(msg.ack);
+          if (!p) return;
+          this.pending.delete(msg.ack);
+          clearTimeout(p.timer);
+          return msg.error ? p.reject(new Error(msg.error)) : p.resolve(msg.data);
+        }
+        if (msg.event && msg.id) return this.answer(msg);
+        if (msg.event) this.trigger(msg.event, msg.data);
+      };
+      this.ws.onclose = (e) => {
+        this.trigger("close", e);
+        this.pending.forEach((p) => { clearTimeout(p.timer); p.reject(new Error("connection closed")); });
+        this.pending.clear();
+        if (this.reconnect && !this.closed) {
+          setTimeout(() => this.connect(), this.reconnectDelay);
+        }
+      };
+      this.ws.onerror = (e) => this.trigger("error", e);
+    }
+
+    on(event, fn) {
+      (this.handlers[event] = this.handlers[event] || []).push(fn);
+      return this;
+    }
+
+    off(event, fn) {
+      if (!fn) delete this.handlers[event];
+      else if (this.handlers[event]) this.handlers[event] = this.handlers[event].filter((h) => h !== fn);
+      return this;
+    }
+
+    trigger(event, data) {
+      (this.handlers[event] || []).forEach((fn) => fn(data));
+    }
+
+    // answer a server EmitWithAck with the value, or promise, returned by the first handler of the event
+    answer(msg) {
+      const fns = this.handlers[msg.event] || [];
+      if (!fns.length) return this.send({ ack: msg.id, error: "unknown event" });
+      Promise.resolve()
+        .then(() => fns[0](msg.data))
+        .then(
+          (data) => this.send({ ack: msg.id, data }),
+          (err) => this.send({ ack: msg.id, error: String((err && err.message) || err) })
+        );
+      fns.slice(1).forEach((fn) => fn(msg.data));
+    }
+
+    send(msg) {
+      const data = this.codec === "msgpack" ? msgpack.encode(msg) : JSON.stringify(msg);
+      if (this.ws.readyState === WebSocket.OPEN) this.ws.send(data);
+      else this.queue.push(data);
+    }
+
+    // emit send an event without waiting for an answer
+    emit(event, data) {
+      this.send({ event, data });
+    }
+
+    // request send an event and resolve with the value returned by the server handler
+    request(event, data, timeout) {
+      const id = ++this.seq;
+      return new Promise((resolve, reject) => {
+        const timer = setTimeout(() => {
+          this.pending.delete(id);
+          reject(new Error("timeout waiting ack for " + event));
+        }, timeout || this.timeout);
+        this.pending.set(id, { resolve, reject, timer });
+        this.send({ event, id, data });
+      });
+    }
+
+    close() {
+      this.closed = true;
+      this.ws.close();
+    }
+  }
+
+  KagoWs.msgpack = msgpack;
+  root.KagoWs = KagoWs;
+})(typeof window !== "undefined" ? window : this);
@@ -0,0 +1,185 @@
+package admin
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux"
+	"github.com/kamalshkeir/kago/core/kamux/perms"
+	"github.com/kamalshkeir/kago/core/kamux/throttle"
+	"github.com/kamalshkeir/kago/core/kamux/totp"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+// twoFactorUser return the logged in user, or the one who must enroll to finish logging in
+func twoFactorUser(c *kamux.Context) (user models.User, pending bool, ok bool) {
+	if user, ok := c.User(); ok {
+		return user, false, true
+	}
+	if user, ok := c.TwoFactorPending(); ok && !totp.Enabled(user.Id) {
+		return user, true, true
+	}
+	return models.User{}, false, false
+}
+
+// throttled report if err come from too many wrong codes
+func throttled(err error) bool {
+	return errors.Is(err, throttle.ErrLocked) || errors.Is(err, throttle.ErrTooSoon)
+}
+
+func codeRequest(c *kamux.Context) string {
+	code, _ := c.BodyJson()["code"].(string)
+	return code
+}
+
+var TwoFactorLoginView = func(c *kamux.Context) {
+	user, ok := c.TwoFactorPending()
+	if !ok {
+		c.Status(http.StatusFound).Redirect("/admin/login")
+		return
+	}
+	if !totp.Enabled(user.Id) {
+		c.Status(http.StatusFound).Redirect(kamux.TWO_FACTOR_PATH)
+		return
+	}
+	c.Html("admin/admin_2fa_login.html", map[string]any{"email": user.Email, "path": kamux.TWO_FACTOR_LOGIN_PATH})
+}
+
+// TwoFactorLoginPost check {"code"}, a totp or a recovery code, and log the pending user in
+var TwoFactorLoginPost = func(c *kamux.Context) {
+	user, ok := c.TwoFactorPending()
+	if !ok {
+		c.Status(http.StatusUnauthorized).Json(map[string]any{"error": "login expired, enter your password again", "redirect": "/admin/login"})
+		return
+	}
+	if wait, err := c.VerifyTwoFactor(user, codeRequest(c)); err != nil {
+		if throttled(err) {
+			c.AuthenticateFailed(wait, err)
+			return
+		}
+		if c.TwoFactorFailed() {
+			c.Status(http.StatusForbidden).Json(map[string]any{"error": "too many wrong codes, enter your password again", "redirect": "/admin/login"})
+			return
+		}
+		c.Status(http.StatusUnauthorized).Json(map[string]any{"error": "invalid code"})
+		return
+	}
+	next, err := c.CompleteTwoFactor()
+	if logger.CheckError(err) {
+		c.Status(http.StatusInternalServerError).Json(map[string]any{"error": "unable to create session"})
+		return
+	}
+	c.Json(map[string]any{"success": "U Are Logged In", "redirect": next})
+}
+
+// TwoFactorView show the status of the user 2fa, or a qr code to enroll, use it with kamux.Auth
+var TwoFactorView = func(c *kamux.Context) {
+	user, pending, ok := twoFactorUser(c)
+	if !ok {
+		c.Status(http.StatusFound).Redirect("/admin/login")
+		return
+	}
+	data := map[string]any{
+		"email":    user.Email,
+		"path":     kamux.TWO_FACTOR_PATH,
+		"pending":  pending,
+		"required": kamux.TWO_FACTOR_ADMINS && perms.IsStaff(user),
+	}
+	if totp.Enabled(user.Id) {
+		data["enabled"] = true
+		data["recovery_left"] = totp.RecoveryLeft(user.Id)
+		c.Html("admin/admin_2fa.html", data)
+		return
+	}
+	secret, err := totp.Enroll(user.Id)
+	var qr string
+	if err == nil {
+		qr, err = totp.QR(user.Email, secret)
+	}
+	if logger.CheckError(err) {
+		c.Status(http.StatusInternalServerError).Text("unable to start enrollment")
+		return
+	}
+	data["secret"] = secret
+	data["qr"] = template.HTML(qr)
+	c.Html("admin/admin_2fa.html", data)
+}
+
+// TwoFactorEnablePost confirm the enrollment with a first {"code"} and return the recovery codes
+var TwoFactorEnablePost = func(c *kamux.Context) {
+	user, pending, ok := twoFactorUser(c)
+	if !ok {
+		c.Status(http.StatusUnauthorized).Json(map[string]any{"error": "not logged in"})
+		return
+	}
+	codes, err := totp.Confirm(user.Id, codeRequest(c))
+	if err != nil {
+		if pending && c.TwoFactorFailed() {
+			c.Status(http.StatusForbidden).Json(map[string]any{"error": "too many wrong codes, enter your password again", "redirect": "/admin/login"})
+			return
+		}
+		c.Status(http.StatusBadRequest).Json(map[string]any{"error": err.Error()})
+		return
+	}
+	res := map[string]any{"success": "two factor authentication enabled", "recovery_codes": codes}
+	if pending {
+		next, err := c.CompleteTwoFactor()
+		if logger.CheckError(err) {
+			c.Status(http.StatusInternalServerError).Json(map[string]any{"error": "unable to create session"})
+			return
+		}
+		res["redirect"] = next
+	}
+	c.Json(res)
+}
+
+// TwoFactorDisablePost disable 2fa of the logged in user after checking {"code"}, unless it's required
+var TwoFactorDisablePost = func(c *kamux.Context) {
+	user, ok := c.User()
+	if !ok {
+		c.Status(http.StatusUnauthorized).Json(map[string]any{"error": "not logged in"})
+		return
+	}
+	if kamux.TWO_FACTOR_ADMINS && perms.IsStaff(user) {
+		c.Status(http.StatusForbidden).Json(map[string]any{"error": "two factor authentication is required for admins"})
+		return
+	}
+	if wait, err := c.VerifyTwoFactor(user, codeRequest(c)); err != nil {
+		if throttled(err) {
+			c.AuthenticateFailed(wait, err)
+			return
+		}
+		c.Status(http.StatusUnauthorized).Json(map[string]any{"error": "invalid code"})
+		return
+	}
+	if logger.CheckError(totp.Reset(user.Id)) {
+		c.Status(http.StatusInternalServerError).Json(map[string]any{"error": "unable to disable"})
+		return
+	}
+	c.Json(map[string]any{"success": "two factor authentication disabled"})
+}
+
+// TwoFactorRecoveryPost replace the recovery codes of the logged in user after checking {"code"}
+var TwoFactorRecoveryPost = func(c *kamux.Context) {
+	user, ok := c.User()
+	if !ok {
+		c.Status(http.StatusUnauthorized).Json(map[string]any{"error": "not logged in"})
+		return
+	}
+	if wait, err := c.VerifyTwoFactor(user, codeRequest(c)); err != nil {
+		if throttled(err) {
+			c.AuthenticateFailed(wait, err)
+			return
+		}
+		c.Status(http.StatusUnauthorized).Json(map[string]any{"error": "invalid code"})
+		return
+	}
+	codes, err := totp.NewRecoveryCodes(user.Id)
+	if logger.CheckError(err) {
+		c.Status(http.StatusInternalServerError).Json(map[string]any{"error": "unable to create recovery codes"})
+		return
+	}
+	c.Json(map[string]any{"success": "new recovery codes created", "recovery_codes": codes})
+}
@@ -4,7 +4,10 @@ import (
 	"sync"
 
 	"github.com/kamalshkeir/kago/core/kamux"
+	"github.com/kamalshkeir/kago/core/kamux/cache"
+	"github.com/kamalshkeir/kago/core/kamux/sse"
 	"github.com/kamalshkeir/kago/core/settings"
+	"github.com/kamalshkeir/kago/core/utils/eventbus"
 )
 
 var once = sync.Once{}
@@ -14,16 +17,44 @@ func UrlPatterns(r *kamux.Router) {
 	r.GET("/offline", OfflineView)
 	r.GET("/manifest.webmanifest", ManifestView)
 	r.GET("/sw.js", ServiceWorkerView)
+	r.GET("/kago-ws.js", KagoWsJsView)
 	r.GET("/robots.txt", RobotsTxtView)
 	r.GET("/admin", kamux.Admin(IndexView))
 	r.GET("/admin/login", kamux.Auth(LoginView))
 	r.POST("/admin/login", kamux.Auth(LoginPOSTView))
+	r.GET(kamux.TWO_FACTOR_LOGIN_PATH, TwoFactorLoginView)
+	r.POST(kamux.TWO_FACTOR_LOGIN_PATH, TwoFactorLoginPost)
+	r.GET(kamux.TWO_FACTOR_PATH, kamux.Auth(TwoFactorView))
+	r.POST(kamux.TWO_FACTOR_PATH+"/enable", kamux.Auth(TwoFactorEnablePost))
+	r.POST(kamux.TWO_FACTOR_PATH+"/disable", kamux.Auth(TwoFactorDisablePost))
+	r.POST(kamux.TWO_FACTOR_PATH+"/recovery", kamux.Auth(TwoFactorRecoveryPost))
 	r.GET("/admin/logout", LogoutView)
+	// jwt endpoints are called by non browser clients, sending no Origin
+	r.POST("/auth/token", kamux.JWTLogin, "*")
+	r.POST("/auth/token/refresh", kamux.JWTRefresh, "*")
+	r.POST("/auth/token/revoke", kamux.JWTRevoke, "*")
+	r.GET("/auth/password/forgot", kamux.ForgotPasswordView)
+	r.POST("/auth/password/forgot", kamux.ForgotPasswordPost)
+	r.GET("/auth/password/reset", kamux.ResetPasswordView)
+	r.POST("/auth/password/reset", kamux.ResetPasswordPost)
+	r.GET("/auth/email/verify", kamux.VerifyEmailView)
+	r.POST("/auth/email/verify", kamux.Auth(kamux.SendVerificationPost))
+	r.GET("/admin/apikeys", kamux.RequirePermission("api_keys.view")(APIKeysView))
+	r.POST("/admin/apikeys/create", kamux.RequirePermission("api_keys.add")(APIKeysCreatePost))
+	r.POST("/admin/apikeys/revoke", kamux.RequirePermission("api_keys.change")(APIKeysRevokePost))
+	r.GET("/admin/locks", kamux.RequirePermission("login_locks.view")(LocksView))
+	r.POST("/admin/locks/clear", kamux.RequirePermission("login_locks.delete")(LocksClearPost))
+	r.GET("/admin/jobs", kamux.RequirePermission("jobs.view")(JobsView))
+	r.POST("/admin/jobs/retry", kamux.RequirePermission("jobs.change")(JobsRetryPost))
+	r.POST("/admin/jobs/delete", kamux.RequirePermission("jobs.delete")(JobsDeletePost))
+	r.GET("/admin/outbox", kamux.Admin(adminOnly(OutboxView)))
+	r.GET("/admin/outbox/id:int", kamux.Admin(adminOnly(OutboxMailView)))
+	r.POST("/admin/outbox/clear", kamux.Admin(adminOnly(OutboxClearPost)))
 	r.POST("/admin/delete/row", kamux.Admin(DeleteRowPost))
 	r.POST("/admin/update/row", kamux.Admin(UpdateRowPost))
 	r.POST("/admin/create/row", kamux.Admin(CreateModelView))
 	r.POST("/admin/drop/table", kamux.Admin(DropTablePost))
-	r.GET("/admin/table/model:str", kamux.Admin(AllModelsGet))
+	r.GET("/admin/table/model:str", kamux.Admin(kamux.Cache(cache.Options{})(AllModelsGet)))
 	r.POST("/admin/table/model:str/search", kamux.Admin(AllModelsSearch))
 	r.GET("/admin/get/model:str/id:int", kamux.Admin(SingleModelGet))
 	r.GET("/admin/export/table:str", kamux.Admin(ExportView))
@@ -31,8 +62,13 @@ func UrlPatterns(r *kamux.Router) {
 	if settings.Config.Logs {
 		once.Do(func() {
 			r.UseMiddlewares(kamux.LOGS)
+			eventbus.Subscribe("internal-logs", func(data map[string]string) {
+				if log := data["log"]; log != "" {
+					LogsBroker.Publish("logs", sse.Event{Data: log})
+				}
+			})
 		})
-		r.GET("/logs", kamux.Admin(LogsGetView))
-		r.SSE("/sse/logs", kamux.Admin(LogsSSEView))
+		r.GET("/logs", kamux.Admin(adminOnly(LogsGetView)))
+		r.SSE("/sse/logs", kamux.Admin(adminOnly(LogsSSEView)))
 	}
 }
@@ -1,9 +1,11 @@
 package admin
 
 import (
+	_ "embed"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -12,79 +14,106 @@ import (
 	"time"
 
 	"github.com/kamalshkeir/kago/core/kamux"
+	"github.com/kamalshkeir/kago/core/kamux/oauth"
+	"github.com/kamalshkeir/kago/core/kamux/perms"
+	"github.com/kamalshkeir/kago/core/kamux/sse"
 	"github.com/kamalshkeir/kago/core/orm"
 	"github.com/kamalshkeir/kago/core/settings"
 	"github.com/kamalshkeir/kago/core/utils"
-	"github.com/kamalshkeir/kago/core/utils/encryption/encryptor"
 	"github.com/kamalshkeir/kago/core/utils/encryption/hash"
 	"github.com/kamalshkeir/kago/core/utils/logger"
 )
 
 var PAGINATION_PER = 10
 
+// kagoWsJs is the default client of kamux.WsEvents, a kago-ws.js in the static folder override it
+//
+//go:embed static/kago-ws.js
+var kagoWsJs []byte
+
+// LogsBroker stream logs to /sse/logs, the last 50 lines are kept for reconnecting clients
+var LogsBroker = sse.NewBroker(50)
+
 var IndexView = func(c *kamux.Context) {
-	allTables := orm.GetAllTables()
+	allTables := []string{}
+	permissions := map[string]map[string]bool{}
+	for _, t := range orm.GetAllTables() {
+		if c.HasPermission(perms.Codename(t, perms.View)) {
+			allTables = append(allTables, t)
+			permissions[t] = tablePermissions(c, t)
+		}
+	}
 	c.Html("admin/admin_index.html", map[string]any{
-		"tables": allTables,
+		"tables":      allTables,
+		"permissions": permissions,
 	})
 }
 
+// PASSWORD_LOGIN allow the email and password admin login, disable it to require the providers added with router.OAuth (SSO)
+var PASSWORD_LOGIN = true
+
 var LoginView = func(c *kamux.Context) {
-	c.Html("admin/admin_login.html", nil)
+	if _, ok := c.TwoFactorPending(); ok {
+		c.Status(http.StatusFound).Redirect(kamux.TWO_FACTOR_LOGIN_PATH)
+		return
+	}
+	providers := oauth.Providers()
+	if !PASSWORD_LOGIN && len(providers) == 1 {
+		c.Status(http.StatusFound).Redirect(kamux.OAUTH_PATH + "/" + providers[0].Name + "?next=/admin")
+		return
+	}
+	c.Html("admin/admin_login.html", map[string]any{
+		"providers":      providers,
+		"oauth_path":     kamux.OAUTH_PATH,
+		"password_login": PASSWORD_LOGIN,
+	})
 }
 
 var LoginPOSTView = func(c *kamux.Context) {
+	if !PASSWORD_LOGIN {
+		c.Status(http.StatusForbidden).Json(map[string]any{
+			"error": "password login is disabled, use single sign on",
+		})
+		return
+	}
 	requestData := c.BodyJson()
-	email := requestData["email"]
-	passRequest := requestData["password"]
+	email, _ := requestData["email"].(string)
+	passRequest, _ := requestData["password"].(string)
 
-	data, err := orm.Table("users").Where("email = ?", email).One()
+	// unknown emails and wrong passwords get the same answer, after the same time, and count as failures
+	user, wait, err := c.Authenticate(email, passRequest)
 	if err != nil {
-		c.Status(500).Json(map[string]any{
-			"error": err.Error(),
-		})
+		c.AuthenticateFailed(wait, err)
 		return
 	}
-	if data["email"] == "" || data["email"] == nil {
-		c.Status(http.StatusNotFound).Json(map[string]any{
-			"error": "User doesn not Exist",
+	// admins and users having permissions can enter the admin
+	if !perms.IsStaff(user) {
+		c.Status(http.StatusForbidden).Json(map[string]any{
+			"error": "Not Allowed to access this page",
 		})
 		return
 	}
-	if data["is_admin"] == int64(0) || data["is_admin"] == 0 || data["is_admin"] == false {
-		c.Status(http.StatusForbidden).Json(map[string]any{
-			"error": "Not Allowed to access this page",
+	pending, err := c.LoginOrChallenge(user, "/admin")
+	if logger.CheckError(err) {
+		c.Status(500).Json(map[string]any{
+			"error": "unable to create session",
 		})
 		return
 	}
-
-	if passDB, ok := data["password"].(string); ok {
-		if pp, ok := passRequest.(string); ok {
-			match, err := hash.ComparePasswordToHash(pp, passDB)
-			if !match || err != nil {
-				c.Status(http.StatusForbidden).Json(map[string]any{
-					"error": "Wrong Password",
-				})
-				return
-			} else {
-				if uuid, ok := data["uuid"].(string); ok {
-					if kamux.SESSION_ENCRYPTION {
-						uuid, err = encryptor.Encrypt(uuid)
-						logger.CheckError(err)
-					}
-					c.SetCookie("session", uuid)
-					c.Json(map[string]any{
-						"success": "U Are Logged In",
-					})
-					return
-				}
-			}
-		}
+	if pending {
+		c.Json(map[string]any{
+			"success":  "Enter your authentication code",
+			"redirect": kamux.TWO_FACTOR_LOGIN_PATH,
+		})
+		return
 	}
+	c.Json(map[string]any{
+		"success": "U Are Logged In",
+	})
 }
 
 var LogoutView = func(c *kamux.Context) {
-	c.DeleteCookie("session")
+	logger.CheckError(c.Logout())
 	c.Status(http.StatusTemporaryRedirect).Redirect("/")
 }
 
@@ -96,6 +125,9 @@ var AllModelsGet = func(c *kamux.Context) {
 		})
 		return
 	}
+	if !can(c, model, perms.View) {
+		return
+	}
 	idString := "id"
 	t, _ := orm.GetMemoryTable(model,orm.DefaultDB)
 	if t.Pk != "" && t.Pk != "id" {
@@ -124,6 +156,7 @@ var AllModelsGet = func(c *kamux.Context) {
 			"dbcolumns":  dbCols,
 			"pk":         t.Pk,
 			"columnsOrdered":t.Columns,
+			"can":        tablePermissions(c, model),
 		})
 	} else {
 		logger.Error("dbType not known, do you have .env", settings.Config.Db.Type, err)
@@ -142,27 +175,49 @@ var AllModelsSearch = func(c *kamux.Context) {
 		return
 	}
 
+	if !can(c, model, perms.View) {
+		return
+	}
 	body := c.BodyJson()
-	
+	t, err := orm.GetMemoryTable(model, orm.DefaultDB)
+	if err != nil {
+		c.Status(http.StatusNotFound).Json(map[string]any{
+			"error": "table " + model + " not found",
+		})
+		return
+	}
+
 	blder := orm.Table(model)
-	if query,ok := body["query"];ok {
-		if v,ok := query.(string);ok {
-			if v != "" {
-				blder.Where(v)
-			}
-		} 
-	} 
+	user, _ := c.User()
+	if v, _ := body["query"].(string); v != "" {
+		// raw sql could read tables the user can't view, others use filters
+		if !user.IsAdmin {
+			c.Status(http.StatusForbidden).Json(map[string]any{
+				"error": "raw queries are reserved to admins, use filters",
+			})
+			return
+		}
+		blder.Where(v)
+	} else if filters, ok := body["filters"].(map[string]any); ok && len(filters) > 0 {
+		where, args, err := searchFilters(t, filters)
+		if err != nil {
+			c.Status(http.StatusBadRequest).Json(map[string]any{
+				"error": err.Error(),
+			})
+			return
+		}
+		blder.Where(where, args...)
+	}
 
-	oB := ""
-	t, _ := orm.GetMemoryTable(model,orm.DefaultDB)
-	if orderby,ok := body["orderby"];ok {
-		if v,ok := orderby.(string);ok && v != ""{
-			oB=v
-		} else {
-			oB="-"+t.Pk
+	oB := "-" + t.Pk
+	if v, _ := body["orderby"].(string); v != "" {
+		if col := strings.TrimLeft(v, "+-"); !hasColumn(t, col) {
+			c.Status(http.StatusBadRequest).Json(map[string]any{
+				"error": "unknown column " + col,
+			})
+			return
 		}
-	} else {
-		oB="-"+t.Pk
+		oB = v
 	}
 	blder.OrderBy(oB)
 	if v, ok := body["page_num"]; ok && v != "" {
@@ -204,6 +259,9 @@ var DeleteRowPost = func(c *kamux.Context) {
 	if data["mission"] == "delete_row" {
 		if model, ok := data["model_name"]; ok {
 			if mm, ok := model.(string); ok {
+				if !can(c, mm, perms.Delete) || !canEditRow(c, mm, data["id"]) {
+					return
+				}
 				idString := "id"
 				t, _ := orm.GetMemoryTable(mm,orm.DefaultDB)
 				if t.Pk != "" && t.Pk != "id" {
@@ -253,19 +311,30 @@ var DeleteRowPost = func(c *kamux.Context) {
 	}
 }
 
+// formTooLarge answer forms missing their fields, usually cut by the body limit
+func formTooLarge(c *kamux.Context) {
+	c.Status(http.StatusBadRequest).Json(map[string]any{
+		"error": "invalid form, or bigger than " + strconv.Itoa(kamux.MultipartSize>>20) + "MB",
+	})
+}
+
 var CreateModelView = func(c *kamux.Context) {
-	parseErr := c.Request.ParseMultipartForm(int64(kamux.MultipartSize))
-	if parseErr != nil {
-		logger.Error("Parse error = ", parseErr)
+	// limited to kamux.MultipartSize
+	data, _ := c.ParseMultipartForm()
+	model := data.Get("table")
+	if model == "" {
+		formTooLarge(c)
+		return
+	}
+	if !can(c, model, perms.Add) {
+		return
+	}
+	if user, _ := c.User(); !user.IsAdmin && model == "users" && data.Get("is_admin") != "" && data.Get("is_admin") != "0" && data.Get("is_admin") != "false" {
+		c.Status(http.StatusForbidden).Json(map[string]any{
+			"error": "only admins can create admins",
+		})
+		return
 	}
-	data := c.Request.Form
-
-	defer func() {
-		err := c.Request.MultipartForm.RemoveAll()
-		logger.CheckError(err)
-	}()
-
-	model := data["table"][0]
 
 	fields := []string{}
 	values := []any{}
@@ -322,6 +391,9 @@ var SingleModelGet = func(c *kamux.Context) {
 		})
 		return
 	}
+	if !can(c, model, perms.View) {
+		return
+	}
 	idString := "id"
 	t, _ := orm.GetMemoryTable(model,orm.DefaultDB)
 	if t.Pk != "" && t.Pk != "id" {
@@ -343,14 +415,28 @@ var SingleModelGet = func(c *kamux.Context) {
 		"columns":    t.ModelTypes,
 		"dbcolumns":  dbCols,
 		"pk":         t.Pk,
+		"can":        tablePermissions(c, model),
 	})
 }
 
 var UpdateRowPost = func(c *kamux.Context) {
-	// parse the form and get data values + files
-	data, files := utils.ParseMultipartForm(c.Request)
+	// parse the form and get data values + files, limited to kamux.MultipartSize
+	data, files := c.ParseMultipartForm()
+	if data.Get("row_id") == "" || data.Get("table") == "" {
+		formTooLarge(c)
+		return
+	}
 	// id from string to int
 	id := data["row_id"][0]
+	if !can(c, data["table"][0], perms.Change) || !canEditRow(c, data["table"][0], id) {
+		return
+	}
+	if user, _ := c.User(); !user.IsAdmin && data["table"][0] == "users" && len(data["is_admin"]) > 0 && data["is_admin"][0] != "0" && data["is_admin"][0] != "false" {
+		c.Status(http.StatusForbidden).Json(map[string]any{
+			"error": "only admins can make admins",
+		})
+		return
+	}
 	//handle file upload
 	//get model from database
 	idString := "id"
@@ -475,6 +561,9 @@ var DropTablePost = func(c *kamux.Context) {
 	data := c.BodyJson()
 	if table, ok := data["table"]; ok && table != "" {
 		if t, ok := data["table"].(string); ok {
+			if !can(c, t, perms.Drop) {
+				return
+			}
 			_, err := orm.Table(t).Drop()
 			if logger.CheckError(err) {
 				c.Status(http.StatusBadRequest).Json(map[string]any{
@@ -482,6 +571,7 @@ var DropTablePost = func(c *kamux.Context) {
 				})
 				return
 			}
+			logger.CheckError(orm.DeleteTablePermissions(t))
 		} else {
 			c.Status(http.StatusBadRequest).Json(map[string]any{
 				"error": "expecting 'table' to be string",
@@ -505,13 +595,62 @@ var ExportView = func(c *kamux.Context) {
 		})
 		return
 	}
-	data, err := orm.Table(table).All()
-	logger.CheckError(err)
+	if !can(c, table, perms.View) {
+		return
+	}
+	if !utils.SliceContains(orm.GetAllTables(), table) {
+		c.Status(http.StatusNotFound).Json(map[string]any{
+			"error": "table " + table + " not found",
+		})
+		return
+	}
 
-	data_bytes, err := json.Marshal(data)
-	logger.CheckError(err)
+	// rows are encoded one by one into a temporary file, so neither the table nor the export are held in memory
+	f, err := os.CreateTemp("", "kago-export-*.json")
+	if logger.CheckError(err) {
+		c.Status(http.StatusInternalServerError).Json(map[string]any{
+			"error": "unable to create export file",
+		})
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	err = writeJSONArray(f, func(yield func(row map[string]any) error) error {
+		return orm.QueryEach("", "SELECT * FROM "+table, yield)
+	})
+	if logger.CheckError(err) {
+		c.Status(http.StatusInternalServerError).Json(map[string]any{
+			"error": "unable to export table",
+		})
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); logger.CheckError(err) {
+		return
+	}
+	c.DownloadReader(f, table+".json", time.Now())
+}
 
-	c.Download(data_bytes, table+".json")
+// writeJSONArray write the rows given by each to w as a json array, encoding them one at a time
+func writeJSONArray(w io.Writer, each func(yield func(row map[string]any) error) error) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	first := true
+	err := each(func(row map[string]any) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		return enc.Encode(row)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
 }
 
 var ImportView = func(c *kamux.Context) {
@@ -523,6 +662,9 @@ var ImportView = func(c *kamux.Context) {
 		})
 		return
 	}
+	if !can(c, table, perms.Add) {
+		return
+	}
 	// upload file and return bytes of file
 	_, dataBytes, err := c.UploadFile("thefile", "backup", "json")
 	if logger.CheckError(err) {
@@ -594,6 +736,19 @@ var ServiceWorkerView = func(c *kamux.Context) {
 	}
 }
 
+var KagoWsJsView = func(c *kamux.Context) {
+	if settings.Config.Embed.Static {
+		if f, err := kamux.Static.ReadFile(settings.STATIC_DIR + "/kago-ws.js"); err == nil {
+			c.ServeEmbededFile("application/javascript; charset=utf-8", f)
+			return
+		}
+	} else if _, err := os.Stat(settings.STATIC_DIR + "/kago-ws.js"); err == nil {
+		c.ServeFile("application/javascript; charset=utf-8", settings.STATIC_DIR+"/kago-ws.js")
+		return
+	}
+	c.ServeEmbededFile("application/javascript; charset=utf-8", kagoWsJs)
+}
+
 var RobotsTxtView = func(c *kamux.Context) {
 	c.ServeFile("text/plain; charset=utf-8", "./static/robots.txt")
 }
@@ -603,25 +758,11 @@ var OfflineView = func(c *kamux.Context) {
 }
 
 var LogsSSEView = func(c *kamux.Context) {
-	lenStream := len(logger.StreamLogs)
-	if lenStream > 0 {
-		err := c.StreamResponse(logger.StreamLogs[lenStream-1])
-		if err != nil {
-			logger.Error(err)
-		}
-		if lenStream > 2 {
-			err := c.StreamResponse(logger.StreamLogs[lenStream-2])
-			if err != nil {
-				logger.Error(err)
-			}
-		} else if lenStream > 50 {
-			err := c.StreamResponse(logger.StreamLogs[lenStream-2])
-			if err != nil {
-				logger.Error(err)
-			}
-			logger.StreamLogs = []string{}
-		}
+	err := LogsBroker.Stream(c.SSE(), "logs")
+	if err != nil && err != sse.ErrStreamingUnsupported {
+		return
 	}
+	logger.CheckError(err)
 }
 
 var LogsGetView = func(c *kamux.Context) {
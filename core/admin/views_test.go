@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestWriteJSONArray(t *testing.T) {
+	rows := []map[string]any{{"id": 1.0, "name": "a"}, {"id": 2.0, "name": "b"}}
+	var buf bytes.Buffer
+	err := writeJSONArray(&buf, func(yield func(row map[string]any) error) error {
+		for _, r := range rows {
+			if err := yield(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if len(got) != 2 || got[1]["name"] != "b" {
+		t.Fatal("got", got)
+	}
+
+	buf.Reset()
+	if err := writeJSONArray(&buf, func(yield func(row map[string]any) error) error { return nil }); err != nil || buf.String() != "[]\n" {
+		t.Fatalf("empty table: %q %v", buf.String(), err)
+	}
+
+	boom := errors.New("boom")
+	if err := writeJSONArray(&buf, func(yield func(row map[string]any) error) error { return boom }); !errors.Is(err, boom) {
+		t.Fatal("expected the query error, got", err)
+	}
+}
@@ -0,0 +1,316 @@
+// Package cron run named jobs on cron schedules, like "0 3 * * MON", in any time zone.
+// A job never overlap itself, and when SINGLE_INSTANCE is true, the leases of the STORE make sure each occurrence run on a single instance.
+// Runs are recorded with their duration and error, the shell list the jobs and trigger them
+package cron
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+var (
+	// SINGLE_INSTANCE run each occurrence of a job on a single instance, using leases saved in the STORE, false run jobs on all instances
+	SINGLE_INSTANCE = true
+	// LEASE of a running job, renewed while it runs, so the job of a crashed instance can run again once expired
+	LEASE = time.Minute
+	// POLL_EVERY is the interval between looks for jobs triggered from the shell
+	POLL_EVERY = 5 * time.Second
+	// KEEP_RUNS is how long runs are kept
+	KEEP_RUNS = 30 * 24 * time.Hour
+)
+
+var (
+	ErrNotFound  = errors.New("cron job not found")
+	ErrDuplicate = errors.New("a cron job with this name already exist")
+)
+
+// Options of a job
+type Options struct {
+	// Location of the schedule, time.Local by default, CRON_TZ= in the expression override it
+	Location *time.Location
+	// Jitter delay each run by a random duration up to Jitter, to spread the jobs of many apps
+	Jitter time.Duration
+	// Timeout cancel the context of runs lasting longer
+	Timeout time.Duration
+}
+
+type entry struct {
+	name     string
+	spec     string
+	schedule Schedule
+	fn       func(ctx context.Context) error
+	opts     Options
+	next     time.Time
+	running  bool
+}
+
+type scheduler struct {
+	instance string
+	entries  map[string]*entry
+	stop     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
+
+var sched = newScheduler()
+
+func newScheduler() *scheduler {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return &scheduler{
+		instance: host + "-" + strconv.Itoa(os.Getpid()) + "-" + hex.EncodeToString(b),
+		entries:  map[string]*entry{},
+	}
+}
+
+// Add schedule fn as the job name, using a cron expression, see ParseIn, ex:
+//
+//	cron.Add("weekly_report", "0 3 * * MON", func(ctx context.Context) error {...})
+//	cron.Add("cleanup", "@every 10m", cleanup, cron.Options{Jitter: time.Minute})
+func Add(name, expr string, fn func(ctx context.Context) error, opts ...Options) error {
+	var o Options
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	schedule, err := ParseIn(expr, o.Location)
+	if err != nil {
+		return err
+	}
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	if _, ok := sched.entries[name]; ok {
+		return ErrDuplicate
+	}
+	e := &entry{name: name, spec: expr, schedule: schedule, fn: fn, opts: o, next: schedule.Next(time.Now())}
+	sched.entries[name] = e
+	if sched.stop != nil {
+		logger.CheckError(STORE.Register(name, expr, e.next))
+	}
+	return nil
+}
+
+// Remove unschedule the job name, without stopping it if running
+func Remove(name string) {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	delete(sched.entries, name)
+}
+
+// Entry describe a scheduled job
+type Entry struct {
+	Name    string
+	Spec    string
+	Next    time.Time
+	Running bool
+}
+
+// Entries return the jobs of this instance, sorted by name
+func Entries() []Entry {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	res := make([]Entry, 0, len(sched.entries))
+	for _, e := range sched.entries {
+		res = append(res, Entry{Name: e.name, Spec: e.spec, Next: e.next, Running: e.running})
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Name < res[b].Name })
+	return res
+}
+
+// Trigger ask to run name now, on this instance if it has the job, on the first running instance polling the STORE otherwise
+func Trigger(name string) error {
+	sched.mu.Lock()
+	_, local := sched.entries[name]
+	sched.mu.Unlock()
+	if err := STORE.Trigger(name); err != nil {
+		return err
+	}
+	if local {
+		sched.pollTriggered()
+	}
+	return nil
+}
+
+// Start run the scheduled jobs until Stop, it does nothing if already started
+func Start() {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	if sched.stop != nil {
+		return
+	}
+	sched.stop = make(chan struct{})
+	sched.ctx, sched.cancel = context.WithCancel(context.Background())
+	for _, e := range sched.entries {
+		e.next = e.schedule.Next(time.Now())
+		logger.CheckError(STORE.Register(e.name, e.spec, e.next))
+	}
+	sched.wg.Add(1)
+	go sched.loop(sched.stop)
+}
+
+// Stop stop scheduling jobs, cancel the context of the running ones and wait for them
+func Stop() {
+	sched.mu.Lock()
+	stop, cancel := sched.stop, sched.cancel
+	sched.stop = nil
+	sched.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	cancel()
+	sched.wg.Wait()
+}
+
+func (s *scheduler) loop(stop chan struct{}) {
+	defer s.wg.Done()
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	lastPoll := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			s.runDue(now)
+			if now.Sub(lastPoll) >= POLL_EVERY {
+				lastPoll = now
+				s.pollTriggered()
+			}
+		}
+	}
+}
+
+// runDue start the jobs whose next run is passed, and compute their following one
+func (s *scheduler) runDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.next.IsZero() || e.next.After(now) {
+			continue
+		}
+		at := e.next
+		e.next = e.schedule.Next(now)
+		if e.running {
+			logger.Warn("cron job", e.name, "still running, skipping its run of", at.Format(time.RFC3339))
+			continue
+		}
+		e.running = true
+		s.wg.Add(1)
+		go s.run(e, at, false)
+	}
+}
+
+func (s *scheduler) pollTriggered() {
+	names, err := STORE.Triggered()
+	if logger.CheckError(err) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range names {
+		e, ok := s.entries[name]
+		if !ok || e.running || s.stop == nil {
+			continue
+		}
+		e.running = true
+		s.wg.Add(1)
+		go s.run(e, time.Now(), true)
+	}
+}
+
+// run e after its jitter, if this instance get the lease of the occurrence at, recording the run
+func (s *scheduler) run(e *entry, at time.Time, manual bool) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		e.running = false
+		s.mu.Unlock()
+	}()
+	if e.opts.Jitter > 0 && !manual {
+		select {
+		case <-time.After(time.Duration(mrand.Int63n(int64(e.opts.Jitter)))):
+		case <-s.ctx.Done():
+			return
+		}
+	}
+	var ok bool
+	var err error
+	switch {
+	case manual:
+		ok, err = STORE.AcquireTriggered(e.name, s.instance, time.Now(), LEASE)
+	case SINGLE_INSTANCE:
+		ok, err = STORE.Acquire(e.name, s.instance, at, time.Now(), LEASE)
+	default:
+		ok = true
+	}
+	if logger.CheckError(err) || !ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	if e.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(s.ctx, e.opts.Timeout)
+	}
+	done := make(chan struct{})
+	go s.heartbeat(e.name, done)
+	start := time.Now()
+	err = call(ctx, e.fn)
+	close(done)
+	cancel()
+
+	rec := models.CronRun{
+		Name:      e.name,
+		Instance:  s.instance,
+		StartedAt: start.Unix(),
+		Duration:  time.Since(start).Milliseconds(),
+		Manual:    manual,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+		logger.Error("cron job", e.name, "failed:", err)
+	}
+	logger.CheckError(STORE.Record(rec))
+	s.mu.Lock()
+	next := e.next
+	s.mu.Unlock()
+	logger.CheckError(STORE.Release(e.name, s.instance, next))
+}
+
+// heartbeat extend the lease of this instance on name until done
+func (s *scheduler) heartbeat(name string, done chan struct{}) {
+	t := time.NewTicker(LEASE / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			logger.CheckError(STORE.Extend(name, s.instance, time.Now().Add(LEASE)))
+		}
+	}
+}
+
+// call run fn, returning panics as errors
+func call(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,100 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no tzdata")
+	}
+	from := time.Date(2024, 3, 27, 10, 30, 0, 0, time.UTC) // a wednesday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 3, 27, 10, 45, 0, 0, time.UTC)},
+		{"30 */10 * * * *", time.Date(2024, 3, 27, 10, 30, 30, 0, time.UTC)},
+		{"0 3 * * MON", time.Date(2024, 4, 1, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * FRI", time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC)}, // the 13th or a friday
+		{"0 9-17/4 * feb-apr 1-5", time.Date(2024, 3, 27, 13, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, 3, 27, 10, 31, 30, 0, time.UTC)},
+		{"CRON_TZ=Europe/Paris 30 2 * * *", time.Date(2024, 3, 28, 2, 30, 0, 0, paris)},
+	}
+	for _, tt := range tests {
+		s, err := ParseIn(tt.expr, time.UTC)
+		if err != nil {
+			t.Fatal(tt.expr, err)
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Error(tt.expr, "got", got, "want", tt.want)
+		}
+	}
+	// 02:30 doesn't exist in Paris on the 31th, clocks go from 02:00 to 03:00
+	s, _ := ParseIn("30 2 * * *", paris)
+	if got, want := s.Next(time.Date(2024, 3, 30, 12, 0, 0, 0, time.UTC)), time.Date(2024, 4, 1, 2, 30, 0, 0, paris); !got.Equal(want) {
+		t.Error("daylight saving: got", got, "want", want)
+	}
+	for _, bad := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "TZ=Nowhere/City * * * * *", "@every 1ms"} {
+		if _, err := Parse(bad); err == nil {
+			t.Error("expected an error for", bad)
+		}
+	}
+}
+
+func TestSingleInstance(t *testing.T) {
+	store := NewMemory()
+	at := time.Now()
+	if ok, _ := store.Acquire("a", "one", at, at, time.Minute); !ok {
+		t.Fatal("expected the lease")
+	}
+	if ok, _ := store.Acquire("a", "two", at, at, time.Minute); ok {
+		t.Fatal("occurrence run twice")
+	}
+	// the next occurrence wait for the running one
+	if ok, _ := store.Acquire("a", "two", at.Add(time.Second), at.Add(time.Second), time.Minute); ok {
+		t.Fatal("job overlapping")
+	}
+	_ = store.Release("a", "one", at.Add(time.Hour))
+	if ok, _ := store.Acquire("a", "two", at.Add(time.Second), at.Add(time.Second), time.Minute); !ok {
+		t.Fatal("expected the lease once released")
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	STORE = NewMemory()
+	sched = newScheduler()
+	ran := make(chan bool, 1)
+	if err := Add("report", "0 0 1 1 *", func(ctx context.Context) error {
+		ran <- true
+		return errors.New("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add("report", "@daily", nil); !errors.Is(err, ErrDuplicate) {
+		t.Fatal("expected duplicate, got", err)
+	}
+	Start()
+	defer Stop()
+	if err := Trigger("report"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ran:
+	case <-time.After(2 * time.Second):
+		t.Fatal("not triggered")
+	}
+	Stop()
+	runs, _ := STORE.Runs("report", 10)
+	if len(runs) != 1 || runs[0].Error != "boom" || !runs[0].Manual {
+		t.Fatal("run not recorded", runs)
+	}
+}
@@ -0,0 +1,221 @@
+package cron
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule give the next activation of a job strictly after t, a zero time if there is none
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// spec is a parsed cron expression, each field is a bitset of the allowed values
+type spec struct {
+	second, minute, hour, dom, month, dow uint64
+	// when day of month and day of week are both restricted, a day matching one of them is enough, like in crontab
+	domStar, dowStar bool
+	loc              *time.Location
+}
+
+// every run a job each d, @every 10m
+type every struct {
+	d time.Duration
+}
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(e.d - time.Duration(t.Nanosecond())*time.Nanosecond)
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// Parse parse a cron expression in the local time zone, see ParseIn
+func Parse(expr string) (Schedule, error) {
+	return ParseIn(expr, time.Local)
+}
+
+// ParseIn parse a cron expression evaluated in loc, unless it start with CRON_TZ=Zone or TZ=Zone.
+// Expressions have 5 fields, minute hour day-of-month month day-of-week, or 6 starting with the seconds,
+// using *, ?, lists 1,15, ranges 1-5, steps */10 or 0-30/5, and names JAN-DEC and SUN-SAT, 7 being sunday too.
+// Macros @yearly, @monthly, @weekly, @daily, @hourly and @every <duration> are supported, ex:
+//
+//	cron.Parse("0 3 * * MON")                     // every monday at 03:00
+//	cron.Parse("CRON_TZ=Europe/Paris */30 9-18 * * 1-5") // every 30 minutes during office hours in Paris
+//	cron.Parse("@every 90s")
+func ParseIn(expr string, loc *time.Location) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if loc == nil {
+		loc = time.Local
+	}
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		zone, rest, _ := strings.Cut(expr, " ")
+		_, name, _ := strings.Cut(zone, "=")
+		l, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, errors.New("cron: unknown time zone " + name)
+		}
+		loc, expr = l, strings.TrimSpace(rest)
+	}
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(expr[len("@every "):]))
+		if err != nil || d < time.Second {
+			return nil, errors.New("cron: invalid duration in " + expr)
+		}
+		return every{d: d}, nil
+	}
+	if m, ok := macros[expr]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, errors.New("cron: expected 5 or 6 fields in " + strconv.Quote(expr))
+	}
+	s := &spec{loc: loc}
+	var err error
+	if s.second, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.minute, err = parseField(fields[1], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[2], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[3], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[4], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[5], 0, 7, dayNames); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domStar = fields[3] == "*" || fields[3] == "?"
+	s.dowStar = fields[5] == "*" || fields[5] == "?"
+	return s, nil
+}
+
+// parseField return the bitset of the values allowed by field, a comma separated list of ranges with optional steps
+func parseField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, errors.New("cron: invalid step in " + strconv.Quote(part))
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" && rng != "?" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = value(a, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = value(b, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, errors.New("cron: " + strconv.Quote(part) + " out of range " + strconv.Itoa(min) + "-" + strconv.Itoa(max))
+		}
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func value(s string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("cron: invalid value " + strconv.Quote(s))
+	}
+	return n, nil
+}
+
+func (s *spec) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next find the next matching second, moving field by field, hours, minutes and seconds are added to handle daylight saving changes
+func (s *spec) Next(t time.Time) time.Time {
+	orig := t.Location()
+	t = t.In(s.loc)
+	t = t.Add(time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
+	limit := t.Year() + 5
+
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for s.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		t = t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second).Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Add(-time.Duration(t.Second())*time.Second + time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	for s.second&(1<<uint(t.Second())) == 0 {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+	return t.In(orig)
+}
@@ -0,0 +1,351 @@
+package cron
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+// Store keep the jobs, their leases and their runs
+type Store interface {
+	// Register save the job name and its schedule, so the shell can list and trigger it
+	Register(name, spec string, next time.Time) error
+	// Acquire lease name to instance for its occurrence scheduled at, false if another instance already ran it or still run name
+	Acquire(name, instance string, at, now time.Time, lease time.Duration) (bool, error)
+	// AcquireTriggered lease name to instance if it was triggered and is not running, clearing the trigger
+	AcquireTriggered(name, instance string, now time.Time, lease time.Duration) (bool, error)
+	// Extend the lease of instance on name
+	Extend(name, instance string, until time.Time) error
+	// Release the lease of instance on name if it hold it, saving the next run of name
+	Release(name, instance string, next time.Time) error
+	// Trigger ask the running instances to run name now
+	Trigger(name string) error
+	// Triggered return the names of the triggered jobs
+	Triggered() ([]string, error)
+	Jobs() ([]models.CronJob, error)
+	Record(run models.CronRun) error
+	// Runs return the last runs of name, of all jobs if empty, the last first
+	Runs(name string, limit int) ([]models.CronRun, error)
+	// DeleteExpired delete the runs older than KEEP_RUNS
+	DeleteExpired(now time.Time) error
+}
+
+// STORE keep the jobs and their runs, kamux.New use the tables cron_jobs and cron_runs when the database is ready, they are kept in memory otherwise
+var STORE Store = NewMemory()
+
+// Memory is an in memory Store, for a single instance
+type Memory struct {
+	jobs map[string]*models.CronJob
+	runs []models.CronRun
+	mu   sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{jobs: map[string]*models.CronJob{}}
+}
+
+func (m *Memory) job(name string) *models.CronJob {
+	j, ok := m.jobs[name]
+	if !ok {
+		j = &models.CronJob{Id: len(m.jobs) + 1, Name: name}
+		m.jobs[name] = j
+	}
+	return j
+}
+
+func (m *Memory) Register(name, spec string, next time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.job(name)
+	j.Spec = spec
+	j.NextRun = next.Unix()
+	j.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+func (m *Memory) Acquire(name, instance string, at, now time.Time, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.job(name)
+	if j.LastRun >= at.Unix() || j.LockedUntil >= now.Unix() {
+		return false, nil
+	}
+	j.LastRun = at.Unix()
+	j.LockedBy = instance
+	j.LockedUntil = now.Add(lease).Unix()
+	return true, nil
+}
+
+func (m *Memory) AcquireTriggered(name, instance string, now time.Time, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.job(name)
+	if j.Triggered == 0 || j.LockedUntil >= now.Unix() {
+		return false, nil
+	}
+	j.Triggered = 0
+	j.LockedBy = instance
+	j.LockedUntil = now.Add(lease).Unix()
+	return true, nil
+}
+
+func (m *Memory) Extend(name, instance string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.jobs[name]; ok && j.LockedBy == instance {
+		j.LockedUntil = until.Unix()
+	}
+	return nil
+}
+
+func (m *Memory) Release(name, instance string, next time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.jobs[name]; ok {
+		if j.LockedBy == instance {
+			j.LockedUntil = 0
+		}
+		j.NextRun = next.Unix()
+		j.UpdatedAt = time.Now().Unix()
+	}
+	return nil
+}
+
+func (m *Memory) Trigger(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return ErrNotFound
+	}
+	j.Triggered = time.Now().Unix()
+	return nil
+}
+
+func (m *Memory) Triggered() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := []string{}
+	for name, j := range m.jobs {
+		if j.Triggered > 0 {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (m *Memory) Jobs() ([]models.CronJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]models.CronJob, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		res = append(res, *j)
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Name < res[b].Name })
+	return res, nil
+}
+
+func (m *Memory) Record(run models.CronRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run.Id = len(m.runs) + 1
+	m.runs = append(m.runs, run)
+	return nil
+}
+
+func (m *Memory) Runs(name string, limit int) ([]models.CronRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []models.CronRun{}
+	for i := len(m.runs) - 1; i >= 0 && (limit <= 0 || len(res) < limit); i-- {
+		if name == "" || m.runs[i].Name == name {
+			res = append(res, m.runs[i])
+		}
+	}
+	return res, nil
+}
+
+func (m *Memory) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := now.Add(-KEEP_RUNS).Unix()
+	kept := m.runs[:0]
+	for _, r := range m.runs {
+		if r.StartedAt >= before {
+			kept = append(kept, r)
+		}
+	}
+	m.runs = kept
+	return nil
+}
+
+// ORM keep the jobs in the table cron_jobs and their runs in cron_runs, leases are conditional updates, so a single instance win each occurrence
+type ORM struct {
+	dbName string
+}
+
+// NewORM return a store using dbName, the default database if empty
+func NewORM(dbName ...string) *ORM {
+	s := &ORM{}
+	if len(dbName) > 0 {
+		s.dbName = dbName[0]
+	}
+	return s
+}
+
+func (o *ORM) query(statement string, args ...any) ([]map[string]any, error) {
+	rows, err := orm.Query(o.dbName, statement, args...)
+	if err != nil {
+		if err.Error() == "no data found" {
+			return []map[string]any{}, nil
+		}
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (o *ORM) Register(name, spec string, next time.Time) error {
+	now := time.Now().Unix()
+	n, err := orm.ExecAffected(o.dbName, "UPDATE cron_jobs SET spec = ?, next_run = ?, updated_at = ? WHERE name = ?", spec, next.Unix(), now, name)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = orm.ExecAffected(o.dbName, "INSERT INTO cron_jobs (name,spec,last_run,next_run,locked_by,locked_until,triggered,updated_at) VALUES (?,?,0,?,'',0,0,?)",
+		name, spec, next.Unix(), now)
+	if err != nil {
+		// registered meanwhile by another instance
+		if rows, qerr := o.query("SELECT id FROM cron_jobs WHERE name = ?", name); qerr == nil && len(rows) > 0 {
+			return nil
+		}
+	}
+	return err
+}
+
+func (o *ORM) Acquire(name, instance string, at, now time.Time, lease time.Duration) (bool, error) {
+	n, err := orm.ExecAffected(o.dbName, "UPDATE cron_jobs SET last_run = ?, locked_by = ?, locked_until = ? WHERE name = ? AND last_run < ? AND locked_until < ?",
+		at.Unix(), instance, now.Add(lease).Unix(), name, at.Unix(), now.Unix())
+	return n == 1, err
+}
+
+func (o *ORM) AcquireTriggered(name, instance string, now time.Time, lease time.Duration) (bool, error) {
+	n, err := orm.ExecAffected(o.dbName, "UPDATE cron_jobs SET triggered = 0, locked_by = ?, locked_until = ? WHERE name = ? AND triggered > 0 AND locked_until < ?",
+		instance, now.Add(lease).Unix(), name, now.Unix())
+	return n == 1, err
+}
+
+func (o *ORM) Extend(name, instance string, until time.Time) error {
+	_, err := orm.ExecAffected(o.dbName, "UPDATE cron_jobs SET locked_until = ? WHERE name = ? AND locked_by = ?", until.Unix(), name, instance)
+	return err
+}
+
+func (o *ORM) Release(name, instance string, next time.Time) error {
+	_, err := orm.ExecAffected(o.dbName, "UPDATE cron_jobs SET locked_until = CASE WHEN locked_by = ? THEN 0 ELSE locked_until END, next_run = ?, updated_at = ? WHERE name = ?",
+		instance, next.Unix(), time.Now().Unix(), name)
+	return err
+}
+
+func (o *ORM) Trigger(name string) error {
+	n, err := orm.ExecAffected(o.dbName, "UPDATE cron_jobs SET triggered = ? WHERE name = ?", time.Now().Unix(), name)
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (o *ORM) Triggered() ([]string, error) {
+	rows, err := o.query("SELECT name FROM cron_jobs WHERE triggered > 0")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(rows))
+	for _, r := range rows {
+		names = append(names, toString(r["name"]))
+	}
+	return names, nil
+}
+
+func (o *ORM) Jobs() ([]models.CronJob, error) {
+	rows, err := o.query("SELECT id,name,spec,last_run,next_run,locked_by,locked_until,triggered,updated_at FROM cron_jobs ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	res := make([]models.CronJob, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, models.CronJob{
+			Id:          int(orm.ToInt64(r["id"])),
+			Name:        toString(r["name"]),
+			Spec:        toString(r["spec"]),
+			LastRun:     orm.ToInt64(r["last_run"]),
+			NextRun:     orm.ToInt64(r["next_run"]),
+			LockedBy:    toString(r["locked_by"]),
+			LockedUntil: orm.ToInt64(r["locked_until"]),
+			Triggered:   orm.ToInt64(r["triggered"]),
+			UpdatedAt:   orm.ToInt64(r["updated_at"]),
+		})
+	}
+	return res, nil
+}
+
+func (o *ORM) Record(run models.CronRun) error {
+	_, err := orm.ExecAffected(o.dbName, "INSERT INTO cron_runs (name,instance,started_at,duration,error,manual) VALUES (?,?,?,?,?,?)",
+		run.Name, run.Instance, run.StartedAt, run.Duration, run.Error, run.Manual)
+	return err
+}
+
+func (o *ORM) Runs(name string, limit int) ([]models.CronRun, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	var rows []map[string]any
+	var err error
+	if name == "" {
+		rows, err = o.query("SELECT id,name,instance,started_at,duration,error,manual FROM cron_runs ORDER BY started_at DESC, id DESC LIMIT ?", limit)
+	} else {
+		rows, err = o.query("SELECT id,name,instance,started_at,duration,error,manual FROM cron_runs WHERE name = ? ORDER BY started_at DESC, id DESC LIMIT ?", name, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make([]models.CronRun, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, models.CronRun{
+			Id:        int(orm.ToInt64(r["id"])),
+			Name:      toString(r["name"]),
+			Instance:  toString(r["instance"]),
+			StartedAt: orm.ToInt64(r["started_at"]),
+			Duration:  orm.ToInt64(r["duration"]),
+			Error:     toString(r["error"]),
+			Manual:    toBool(r["manual"]),
+		})
+	}
+	return res, nil
+}
+
+func (o *ORM) DeleteExpired(now time.Time) error {
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM cron_runs WHERE started_at < ?", now.Add(-KEEP_RUNS).Unix())
+	return err
+}
+
+func toString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	}
+	return ""
+}
+
+func toBool(v any) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string, []byte:
+		s := toString(t)
+		return s == "1" || s == "true" || s == "t"
+	}
+	return orm.ToInt64(v) != 0
+}
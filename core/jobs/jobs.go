@@ -0,0 +1,340 @@
+// Package jobs run background jobs saved in the table jobs, so they survive restarts, using a pool of workers.
+// Failed jobs are retried with an exponential backoff until their MaxAttempts, then they are dead, kept to be inspected and retried from the admin.
+// Instances sharing the database share the jobs, each one being leased to a single worker at a time
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/utils/eventbus"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+// status of a job
+const (
+	PENDING = "pending"
+	RUNNING = "running"
+	DONE    = "done"
+	DEAD    = "dead"
+)
+
+var (
+	// WORKERS is the number of jobs run at the same time by an instance
+	WORKERS = 4
+	// POLL_EVERY is the interval between looks for due jobs
+	POLL_EVERY = time.Second
+	// LEASE of a running job, renewed while it runs, another worker can take the job once expired, if the instance running it crashed
+	LEASE = time.Minute
+	// TIMEOUT cancel the context of jobs running longer
+	TIMEOUT = 10 * time.Minute
+	// MAX_ATTEMPTS of a job when not set in Options
+	MAX_ATTEMPTS = 5
+	// BASE_BACKOFF is the delay before the first retry, doubled at each attempt up to MAX_BACKOFF
+	BASE_BACKOFF = 10 * time.Second
+	MAX_BACKOFF  = time.Hour
+	// KEEP_DONE is how long done jobs are kept, dead ones are kept until deleted
+	KEEP_DONE = 7 * 24 * time.Hour
+	// DEAD_TOPIC receive {"id","name","error"} when a job is dead
+	DEAD_TOPIC = "job-dead"
+)
+
+var (
+	ErrDuplicate     = errors.New("a job with this unique key is already queued")
+	ErrNotFound      = errors.New("job not found")
+	ErrLeaseLost     = errors.New("job lease lost")
+	ErrNotRetryable  = errors.New("only pending and dead jobs can be retried")
+	ErrNotRegistered = errors.New("no handler registered for this job")
+)
+
+// BACKOFF return the delay before retrying a job failed attempt times, exponential with a jitter of 20%
+var BACKOFF = func(attempt int) time.Duration {
+	d := BASE_BACKOFF
+	for i := 1; i < attempt && d < MAX_BACKOFF; i++ {
+		d *= 2
+	}
+	if d > MAX_BACKOFF {
+		d = MAX_BACKOFF
+	}
+	if jitter := int64(d) / 5; jitter > 0 {
+		d += time.Duration(mrand.Int63n(2*jitter) - jitter)
+	}
+	return d
+}
+
+type permanent struct{ err error }
+
+func (p permanent) Error() string { return p.err.Error() }
+func (p permanent) Unwrap() error { return p.err }
+
+// Permanent wrap err so the job is dead right away, without retries, like for an invalid payload
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanent{err}
+}
+
+type handler func(ctx context.Context, payload []byte) error
+
+var (
+	handlers   = map[string]handler{}
+	handlersMu sync.RWMutex
+)
+
+// Register set fn as the handler of the jobs named name, their payload is decoded from json into T, ex:
+//
+//	jobs.Register("send_invoice", func(ctx context.Context, inv Invoice) error {...})
+func Register[T any](name string, fn func(ctx context.Context, payload T) error) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[name] = func(ctx context.Context, data []byte) error {
+		var payload T
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return Permanent(err)
+		}
+		return fn(ctx, payload)
+	}
+}
+
+func registered() []string {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options of an enqueued job
+type Options struct {
+	// Delay run the job later, At at a given time
+	Delay time.Duration
+	At    time.Time
+	// Unique key, Enqueue return ErrDuplicate while a job of the same name and key is pending, running or failing
+	Unique string
+	// MaxAttempts before the job is dead, MAX_ATTEMPTS by default
+	MaxAttempts int
+}
+
+// Enqueue save a job name with payload encoded as json, to be run by the first worker available, ex:
+//
+//	id, err := jobs.Enqueue("send_invoice", inv, jobs.Options{Delay: time.Hour, Unique: strconv.Itoa(inv.Id)})
+func Enqueue[T any](name string, payload T, opts ...Options) (int, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	var o Options
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	now := time.Now()
+	j := &models.Job{
+		Name:        name,
+		Payload:     string(data),
+		Status:      PENDING,
+		MaxAttempts: MAX_ATTEMPTS,
+		RunAt:       now.Unix(),
+		CreatedAt:   now.Unix(),
+		UpdatedAt:   now.Unix(),
+	}
+	if o.MaxAttempts > 0 {
+		j.MaxAttempts = o.MaxAttempts
+	}
+	if !o.At.IsZero() {
+		j.RunAt = o.At.Unix()
+	} else if o.Delay > 0 {
+		j.RunAt = now.Add(o.Delay).Unix()
+	}
+	if o.Unique != "" {
+		j.UniqueKey = name + ":" + o.Unique
+	} else {
+		j.UniqueKey = name + ":" + randomKey()
+	}
+	if err := STORE.Insert(j); err != nil {
+		return 0, err
+	}
+	return j.Id, nil
+}
+
+func randomKey() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Retry reschedule now the dead or pending job id
+func Retry(id int) error {
+	return STORE.Retry(id, time.Now())
+}
+
+type pool struct {
+	id     string
+	slots  chan struct{}
+	stop   chan struct{}
+	claims int64
+	wg     sync.WaitGroup
+}
+
+var (
+	current *pool
+	poolMu  sync.Mutex
+)
+
+// Start run workers, claiming the registered jobs until Stop, it does nothing if already started
+func Start(workers int) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if current != nil || workers <= 0 {
+		return
+	}
+	host, _ := os.Hostname()
+	p := &pool{
+		id:    host + "-" + strconv.Itoa(os.Getpid()) + "-" + randomKey()[:8],
+		slots: make(chan struct{}, workers),
+		stop:  make(chan struct{}),
+	}
+	current = p
+	p.wg.Add(1)
+	go p.loop()
+}
+
+// Stop stop claiming jobs and wait for the running ones to finish
+func Stop() {
+	poolMu.Lock()
+	p := current
+	current = nil
+	poolMu.Unlock()
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+}
+
+func (p *pool) loop() {
+	defer p.wg.Done()
+	t := time.NewTicker(POLL_EVERY)
+	defer t.Stop()
+	for {
+		p.poll()
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// poll claim as many jobs as free workers, each claim use its own worker id, owning the leases of the jobs it returned
+func (p *pool) poll() {
+	free := cap(p.slots) - len(p.slots)
+	names := registered()
+	if free == 0 || len(names) == 0 {
+		return
+	}
+	worker := p.id + "-" + strconv.FormatInt(atomic.AddInt64(&p.claims, 1), 10)
+	claimed, err := STORE.Claim(worker, names, free, time.Now(), LEASE)
+	if logger.CheckError(err) {
+		return
+	}
+	for _, j := range claimed {
+		p.slots <- struct{}{}
+		p.wg.Add(1)
+		go func(j models.Job) {
+			defer func() {
+				<-p.slots
+				p.wg.Done()
+			}()
+			run(j)
+		}(j)
+	}
+}
+
+// run j, renewing its lease while running, then complete it, reschedule it or mark it dead
+func run(j models.Job) {
+	handlersMu.RLock()
+	h, ok := handlers[j.Name]
+	handlersMu.RUnlock()
+	var err error
+	if !ok {
+		err = ErrNotRegistered
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+		done := make(chan struct{})
+		go heartbeat(j, cancel, done)
+		err = call(ctx, h, []byte(j.Payload))
+		close(done)
+		cancel()
+	}
+	now := time.Now()
+	if err == nil {
+		if e := STORE.Complete(j.Id, j.LockedBy, now); e != nil && !errors.Is(e, ErrLeaseLost) {
+			logger.Error("job", j.Name, j.Id, ":", e)
+		}
+		return
+	}
+	var retryAt time.Time
+	var p permanent
+	if !errors.As(err, &p) && j.Attempts < j.MaxAttempts {
+		retryAt = now.Add(BACKOFF(j.Attempts))
+	}
+	if e := STORE.Fail(j.Id, j.LockedBy, err.Error(), retryAt, now); e != nil {
+		if !errors.Is(e, ErrLeaseLost) {
+			logger.Error("job", j.Name, j.Id, ":", e)
+		}
+		return
+	}
+	if retryAt.IsZero() {
+		logger.Error("job", j.Name, j.Id, "is dead after", j.Attempts, "attempts:", err)
+		eventbus.Publish(DEAD_TOPIC, map[string]string{
+			"id":    strconv.Itoa(j.Id),
+			"name":  j.Name,
+			"error": err.Error(),
+		})
+	}
+}
+
+// heartbeat extend the lease of j until done, cancelling the job if another worker took it
+func heartbeat(j models.Job, cancel context.CancelFunc, done chan struct{}) {
+	t := time.NewTicker(LEASE / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			if err := STORE.Extend(j.Id, j.LockedBy, time.Now().Add(LEASE)); errors.Is(err, ErrLeaseLost) {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// call run h, returning panics as errors
+func call(ctx context.Context, h handler, payload []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, payload)
+}
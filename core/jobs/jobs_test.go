@@ -0,0 +1,89 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+)
+
+func TestWorkers(t *testing.T) {
+	STORE = NewMemory()
+	POLL_EVERY = 10 * time.Millisecond
+	BACKOFF = func(int) time.Duration { return 0 }
+	var calls int32
+	Register("flaky", func(ctx context.Context, n int) error {
+		if atomic.AddInt32(&calls, 1) < 2 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	Register("broken", func(ctx context.Context, n int) error {
+		return Permanent(errors.New("invalid"))
+	})
+
+	ok, err := Enqueue("flaky", 1, Options{Unique: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Enqueue("flaky", 2, Options{Unique: "a"}); !errors.Is(err, ErrDuplicate) {
+		t.Fatal("expected duplicate, got", err)
+	}
+	dead, _ := Enqueue("broken", 1)
+	later, _ := Enqueue("flaky", 3, Options{Delay: time.Hour})
+
+	Start(2)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		a, _ := STORE.Get(ok)
+		b, _ := STORE.Get(dead)
+		if a.Status == DONE && b.Status == DEAD {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	Stop()
+
+	if j, _ := STORE.Get(ok); j.Status != DONE || j.Attempts != 2 {
+		t.Fatal("flaky job not retried", j.Status, j.Attempts)
+	}
+	if j, _ := STORE.Get(dead); j.Status != DEAD || j.Attempts != 1 || j.LastError != "invalid" {
+		t.Fatal("permanent error retried", j.Status, j.Attempts, j.LastError)
+	}
+	if j, _ := STORE.Get(later); j.Status != PENDING || j.Attempts != 0 {
+		t.Fatal("delayed job run", j.Status)
+	}
+	// the unique key is released once done
+	if _, err := Enqueue("flaky", 4, Options{Unique: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Retry(dead); err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := STORE.Get(dead); j.Status != PENDING || j.Attempts != 0 {
+		t.Fatal("dead job not retried", j.Status)
+	}
+}
+
+func TestLeaseExpired(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+	_ = m.Insert(&models.Job{Name: "a", UniqueKey: "a:1", Status: PENDING, MaxAttempts: 3, RunAt: now.Unix()})
+	first, _ := m.Claim("w1", []string{"a"}, 10, now, time.Minute)
+	if len(first) != 1 {
+		t.Fatal("expected a job")
+	}
+	if again, _ := m.Claim("w2", []string{"a"}, 10, now, time.Minute); len(again) != 0 {
+		t.Fatal("job claimed twice")
+	}
+	taken, _ := m.Claim("w2", []string{"a"}, 10, now.Add(2*time.Minute), time.Minute)
+	if len(taken) != 1 || taken[0].Attempts != 2 {
+		t.Fatal("expired lease not reclaimed", taken)
+	}
+	if err := m.Complete(first[0].Id, "w1", now); !errors.Is(err, ErrLeaseLost) {
+		t.Fatal("expected lease lost, got", err)
+	}
+}
@@ -0,0 +1,559 @@
+package jobs
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/settings"
+)
+
+// Store persist the jobs
+type Store interface {
+	// Insert add j, setting its Id, ErrDuplicate if its UniqueKey is used by a job not done nor dead
+	Insert(j *models.Job) error
+	// Claim lease to worker up to n jobs named names due at now, or running but whose lease expired, incrementing their attempts.
+	// worker must be unique for each call, it own the leases of the returned jobs
+	Claim(worker string, names []string, n int, now time.Time, lease time.Duration) ([]models.Job, error)
+	// Extend the lease of the running job id
+	Extend(id int, worker string, until time.Time) error
+	Complete(id int, worker string, now time.Time) error
+	// Fail record errMsg and reschedule the job at retryAt, or mark it dead if retryAt is zero
+	Fail(id int, worker string, errMsg string, retryAt time.Time, now time.Time) error
+	// Retry reschedule a dead or pending job now, with its attempts reset
+	Retry(id int, now time.Time) error
+	Get(id int) (models.Job, error)
+	Delete(id int) error
+	// List return the jobs having status, all if empty, the last updated first
+	List(status string, limit, offset int) ([]models.Job, error)
+	// Counts return the number of jobs by status
+	Counts() (map[string]int, error)
+	// DeleteExpired delete the jobs done since KEEP_DONE
+	DeleteExpired(now time.Time) error
+}
+
+// STORE keep the jobs, kamux.New use the table jobs when the database is ready, they are kept in memory otherwise
+var STORE Store = NewMemory()
+
+func released(j models.Job) string {
+	return j.UniqueKey + "#" + strconv.Itoa(j.Id)
+}
+
+// Memory is an in memory Store, lost on restart
+type Memory struct {
+	jobs   map[int]*models.Job
+	unique map[string]int
+	lastId int
+	mu     sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{jobs: map[int]*models.Job{}, unique: map[string]int{}}
+}
+
+func (m *Memory) Insert(j *models.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.unique[j.UniqueKey]; ok {
+		return ErrDuplicate
+	}
+	m.lastId++
+	j.Id = m.lastId
+	cp := *j
+	m.jobs[j.Id] = &cp
+	m.unique[j.UniqueKey] = j.Id
+	return nil
+}
+
+func claimable(j *models.Job, now int64) bool {
+	return (j.Status == PENDING && j.RunAt <= now) || (j.Status == RUNNING && j.LockedUntil < now)
+}
+
+func (m *Memory) Claim(worker string, names []string, n int, now time.Time, lease time.Duration) ([]models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	due := []*models.Job{}
+	for _, j := range m.jobs {
+		if claimable(j, now.Unix()) && contains(names, j.Name) {
+			due = append(due, j)
+		}
+	}
+	sort.Slice(due, func(a, b int) bool {
+		if due[a].RunAt == due[b].RunAt {
+			return due[a].Id < due[b].Id
+		}
+		return due[a].RunAt < due[b].RunAt
+	})
+	res := []models.Job{}
+	for _, j := range due {
+		if len(res) == n {
+			break
+		}
+		j.Status = RUNNING
+		j.LockedBy = worker
+		j.LockedUntil = now.Add(lease).Unix()
+		j.Attempts++
+		j.UpdatedAt = now.Unix()
+		res = append(res, *j)
+	}
+	return res, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) running(id int, worker string) (*models.Job, bool) {
+	j, ok := m.jobs[id]
+	return j, ok && j.Status == RUNNING && j.LockedBy == worker
+}
+
+func (m *Memory) Extend(id int, worker string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.running(id, worker)
+	if !ok {
+		return ErrLeaseLost
+	}
+	j.LockedUntil = until.Unix()
+	return nil
+}
+
+// finish release the unique key of j, m.mu must be held
+func (m *Memory) finish(j *models.Job, status string, now time.Time) {
+	delete(m.unique, j.UniqueKey)
+	j.UniqueKey = released(*j)
+	m.unique[j.UniqueKey] = j.Id
+	j.Status = status
+	j.LockedUntil = 0
+	j.UpdatedAt = now.Unix()
+}
+
+func (m *Memory) Complete(id int, worker string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.running(id, worker)
+	if !ok {
+		return ErrLeaseLost
+	}
+	m.finish(j, DONE, now)
+	return nil
+}
+
+func (m *Memory) Fail(id int, worker string, errMsg string, retryAt time.Time, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.running(id, worker)
+	if !ok {
+		return ErrLeaseLost
+	}
+	j.LastError = errMsg
+	if retryAt.IsZero() {
+		m.finish(j, DEAD, now)
+		return nil
+	}
+	j.Status = PENDING
+	j.RunAt = retryAt.Unix()
+	j.LockedUntil = 0
+	j.UpdatedAt = now.Unix()
+	return nil
+}
+
+func (m *Memory) Retry(id int, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if j.Status == RUNNING || j.Status == DONE {
+		return ErrNotRetryable
+	}
+	if j.Status == DEAD {
+		key := strings.TrimSuffix(j.UniqueKey, "#"+strconv.Itoa(j.Id))
+		if _, used := m.unique[key]; used {
+			return ErrDuplicate
+		}
+		delete(m.unique, j.UniqueKey)
+		j.UniqueKey = key
+		m.unique[key] = j.Id
+	}
+	j.Status = PENDING
+	j.Attempts = 0
+	j.RunAt = now.Unix()
+	j.UpdatedAt = now.Unix()
+	return nil
+}
+
+func (m *Memory) Get(id int) (models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return models.Job{}, ErrNotFound
+	}
+	return *j, nil
+}
+
+func (m *Memory) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.jobs[id]; ok {
+		delete(m.unique, j.UniqueKey)
+		delete(m.jobs, id)
+	}
+	return nil
+}
+
+func (m *Memory) List(status string, limit, offset int) ([]models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []models.Job{}
+	for _, j := range m.jobs {
+		if status == "" || j.Status == status {
+			res = append(res, *j)
+		}
+	}
+	sort.Slice(res, func(a, b int) bool {
+		if res[a].UpdatedAt == res[b].UpdatedAt {
+			return res[a].Id > res[b].Id
+		}
+		return res[a].UpdatedAt > res[b].UpdatedAt
+	})
+	if offset >= len(res) {
+		return []models.Job{}, nil
+	}
+	res = res[offset:]
+	if limit > 0 && limit < len(res) {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (m *Memory) Counts() (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := map[string]int{}
+	for _, j := range m.jobs {
+		res[j.Status]++
+	}
+	return res, nil
+}
+
+func (m *Memory) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := now.Add(-KEEP_DONE).Unix()
+	for id, j := range m.jobs {
+		if j.Status == DONE && j.UpdatedAt < before {
+			delete(m.unique, j.UniqueKey)
+			delete(m.jobs, id)
+		}
+	}
+	return nil
+}
+
+// ORM keep the jobs in the table jobs, shared by all the instances using the database.
+// Postgres and mysql claim jobs using SELECT ... FOR UPDATE SKIP LOCKED, sqlite using a conditional update of the lease
+type ORM struct {
+	dbName string
+}
+
+// NewORM return a store using dbName, the default database if empty
+func NewORM(dbName ...string) *ORM {
+	s := &ORM{}
+	if len(dbName) > 0 {
+		s.dbName = dbName[0]
+	}
+	return s
+}
+
+func (o *ORM) db() (*orm.DatabaseEntity, error) {
+	name := o.dbName
+	if name == "" {
+		name = settings.Config.Db.Name
+	}
+	return orm.GetMemoryDatabase(name)
+}
+
+const jobColumns = "id,name,payload,unique_key,status,attempts,max_attempts,run_at,locked_by,locked_until,last_error,created_at,updated_at"
+
+func toString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	}
+	return ""
+}
+
+func jobFromRow(r map[string]any) models.Job {
+	return models.Job{
+		Id:          int(orm.ToInt64(r["id"])),
+		Name:        toString(r["name"]),
+		Payload:     toString(r["payload"]),
+		UniqueKey:   toString(r["unique_key"]),
+		Status:      toString(r["status"]),
+		Attempts:    int(orm.ToInt64(r["attempts"])),
+		MaxAttempts: int(orm.ToInt64(r["max_attempts"])),
+		RunAt:       orm.ToInt64(r["run_at"]),
+		LockedBy:    toString(r["locked_by"]),
+		LockedUntil: orm.ToInt64(r["locked_until"]),
+		LastError:   toString(r["last_error"]),
+		CreatedAt:   orm.ToInt64(r["created_at"]),
+		UpdatedAt:   orm.ToInt64(r["updated_at"]),
+	}
+}
+
+func (o *ORM) query(statement string, args ...any) ([]models.Job, error) {
+	rows, err := orm.Query(o.dbName, statement, args...)
+	if err != nil {
+		if err.Error() == "no data found" {
+			return []models.Job{}, nil
+		}
+		return nil, err
+	}
+	res := make([]models.Job, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, jobFromRow(r))
+	}
+	return res, nil
+}
+
+func (o *ORM) Insert(j *models.Job) error {
+	db, err := o.db()
+	if err != nil {
+		return err
+	}
+	if n, err := o.count("SELECT COUNT(*) AS n FROM jobs WHERE unique_key = ?", j.UniqueKey); err == nil && n > 0 {
+		return ErrDuplicate
+	}
+	statement := "INSERT INTO jobs (name,payload,unique_key,status,attempts,max_attempts,run_at,locked_by,locked_until,last_error,created_at,updated_at)" +
+		" VALUES (?,?,?,?,?,?,?,?,?,?,?,?)"
+	args := []any{j.Name, j.Payload, j.UniqueKey, j.Status, j.Attempts, j.MaxAttempts, j.RunAt, j.LockedBy, j.LockedUntil, j.LastError, j.CreatedAt, j.UpdatedAt}
+	if db.Dialect == orm.POSTGRES {
+		var id int
+		if err := db.Conn.QueryRow(orm.Rebind(db, statement+" RETURNING id"), args...).Scan(&id); err != nil {
+			return o.duplicate(j, err)
+		}
+		j.Id = id
+		return nil
+	}
+	res, err := orm.ExecResult(o.dbName, statement, args...)
+	if err != nil {
+		return o.duplicate(j, err)
+	}
+	id, err := res.LastInsertId()
+	j.Id = int(id)
+	return err
+}
+
+// duplicate return ErrDuplicate if err is caused by the unique key of j, inserted meanwhile
+func (o *ORM) duplicate(j *models.Job, err error) error {
+	if n, cerr := o.count("SELECT COUNT(*) AS n FROM jobs WHERE unique_key = ?", j.UniqueKey); cerr == nil && n > 0 {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (o *ORM) count(statement string, args ...any) (int, error) {
+	rows, err := orm.Query(o.dbName, statement, args...)
+	if err != nil {
+		if err.Error() == "no data found" {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return int(orm.ToInt64(rows[0]["n"])), nil
+}
+
+const claimWhere = "((status = 'pending' AND run_at <= ?) OR (status = 'running' AND locked_until < ?))"
+
+func (o *ORM) Claim(worker string, names []string, n int, now time.Time, lease time.Duration) ([]models.Job, error) {
+	if len(names) == 0 || n <= 0 {
+		return []models.Job{}, nil
+	}
+	db, err := o.db()
+	if err != nil {
+		return nil, err
+	}
+	unix, until := now.Unix(), now.Add(lease).Unix()
+	where := claimWhere + " AND name IN (" + strings.TrimSuffix(strings.Repeat("?,", len(names)), ",") + ")"
+	args := []any{unix, unix}
+	for _, name := range names {
+		args = append(args, name)
+	}
+	switch db.Dialect {
+	case orm.POSTGRES, orm.MYSQL, orm.MARIA:
+		if err := o.claimSkipLocked(db, worker, where, args, n, unix, until); err != nil {
+			return nil, err
+		}
+	default:
+		candidates, err := o.query("SELECT id FROM jobs WHERE "+where+" ORDER BY run_at, id LIMIT ?", append(args, n)...)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range candidates {
+			// the condition is checked again, only one worker update the row
+			if _, err := orm.ExecAffected(o.dbName, "UPDATE jobs SET status = 'running', locked_by = ?, locked_until = ?, attempts = attempts + 1, updated_at = ?"+
+				" WHERE id = ? AND "+claimWhere, worker, until, unix, c.Id, unix, unix); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return o.query("SELECT "+jobColumns+" FROM jobs WHERE status = 'running' AND locked_by = ? ORDER BY run_at, id", worker)
+}
+
+func (o *ORM) claimSkipLocked(db *orm.DatabaseEntity, worker, where string, args []any, n int, unix, until int64) error {
+	tx, err := db.Conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	rows, err := tx.Query(orm.Rebind(db, "SELECT id FROM jobs WHERE "+where+" ORDER BY run_at, id LIMIT ? FOR UPDATE SKIP LOCKED"), append(args, n)...)
+	if err != nil {
+		return err
+	}
+	ids := []any{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return nil
+	}
+	in := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	if _, err := tx.Exec(orm.Rebind(db, "UPDATE jobs SET status = 'running', locked_by = ?, locked_until = ?, attempts = attempts + 1, updated_at = ? WHERE id IN ("+in+")"),
+		append([]any{worker, until, unix}, ids...)...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (o *ORM) running(n int64, err error) error {
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (o *ORM) Extend(id int, worker string, until time.Time) error {
+	return o.running(orm.ExecAffected(o.dbName, "UPDATE jobs SET locked_until = ? WHERE id = ? AND status = 'running' AND locked_by = ?", until.Unix(), id, worker))
+}
+
+func (o *ORM) finish(id int, worker, status, errMsg string, now time.Time) error {
+	suffix := "#" + strconv.Itoa(id)
+	return o.running(orm.ExecAffected(o.dbName, "UPDATE jobs SET status = ?, unique_key = "+concat(o, "unique_key", "?")+", locked_until = 0, last_error = ?, updated_at = ?"+
+		" WHERE id = ? AND status = 'running' AND locked_by = ?", status, suffix, errMsg, now.Unix(), id, worker))
+}
+
+// concat return the sql concatenation of a and b for the dialect of o
+func concat(o *ORM, a, b string) string {
+	if db, err := o.db(); err == nil && (db.Dialect == orm.MYSQL || db.Dialect == orm.MARIA) {
+		return "CONCAT(" + a + "," + b + ")"
+	}
+	return a + " || " + b
+}
+
+func (o *ORM) Complete(id int, worker string, now time.Time) error {
+	return o.finish(id, worker, DONE, "", now)
+}
+
+func (o *ORM) Fail(id int, worker string, errMsg string, retryAt time.Time, now time.Time) error {
+	if retryAt.IsZero() {
+		return o.finish(id, worker, DEAD, errMsg, now)
+	}
+	return o.running(orm.ExecAffected(o.dbName, "UPDATE jobs SET status = 'pending', run_at = ?, locked_until = 0, last_error = ?, updated_at = ? WHERE id = ? AND status = 'running' AND locked_by = ?",
+		retryAt.Unix(), errMsg, now.Unix(), id, worker))
+}
+
+func (o *ORM) Retry(id int, now time.Time) error {
+	j, err := o.Get(id)
+	if err != nil {
+		return err
+	}
+	if j.Status == RUNNING || j.Status == DONE {
+		return ErrNotRetryable
+	}
+	key := j.UniqueKey
+	if j.Status == DEAD {
+		key = strings.TrimSuffix(key, "#"+strconv.Itoa(j.Id))
+	}
+	n, err := orm.ExecAffected(o.dbName, "UPDATE jobs SET status = 'pending', unique_key = ?, attempts = 0, run_at = ?, updated_at = ? WHERE id = ? AND status = ?",
+		key, now.Unix(), now.Unix(), id, j.Status)
+	if err != nil {
+		if c, cerr := o.count("SELECT COUNT(*) AS n FROM jobs WHERE unique_key = ?", key); cerr == nil && c > 0 {
+			return ErrDuplicate
+		}
+		return err
+	}
+	if n == 0 {
+		return ErrNotRetryable
+	}
+	return nil
+}
+
+func (o *ORM) Get(id int) (models.Job, error) {
+	res, err := o.query("SELECT "+jobColumns+" FROM jobs WHERE id = ?", id)
+	if err != nil {
+		return models.Job{}, err
+	}
+	if len(res) == 0 {
+		return models.Job{}, ErrNotFound
+	}
+	return res[0], nil
+}
+
+func (o *ORM) Delete(id int) error {
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM jobs WHERE id = ?", id)
+	return err
+}
+
+func (o *ORM) List(status string, limit, offset int) ([]models.Job, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	if status == "" {
+		return o.query("SELECT "+jobColumns+" FROM jobs ORDER BY updated_at DESC, id DESC LIMIT ? OFFSET ?", limit, offset)
+	}
+	return o.query("SELECT "+jobColumns+" FROM jobs WHERE status = ? ORDER BY updated_at DESC, id DESC LIMIT ? OFFSET ?", status, limit, offset)
+}
+
+func (o *ORM) Counts() (map[string]int, error) {
+	rows, err := orm.Query(o.dbName, "SELECT status, COUNT(*) AS n FROM jobs GROUP BY status")
+	res := map[string]int{}
+	if err != nil {
+		if err.Error() == "no data found" {
+			return res, nil
+		}
+		return nil, err
+	}
+	for _, r := range rows {
+		res[toString(r["status"])] = int(orm.ToInt64(r["n"]))
+	}
+	return res, nil
+}
+
+func (o *ORM) DeleteExpired(now time.Time) error {
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM jobs WHERE status = 'done' AND updated_at < ?", now.Add(-KEEP_DONE).Unix())
+	return err
+}
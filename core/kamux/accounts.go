@@ -0,0 +1,243 @@
+package kamux
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux/accounts"
+	"github.com/kamalshkeir/kago/core/kamux/apikeys"
+	"github.com/kamalshkeir/kago/core/kamux/sessions"
+	"github.com/kamalshkeir/kago/core/mail"
+	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/utils/encryption/hash"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+// defaultTemplatesFS hold the pages and mails of the account views, templates/accounts/<name> override them
+//
+//go:embed defaults
+var defaultTemplatesFS embed.FS
+
+func init() {
+	AddDefaultTemplates(defaultTemplatesFS, "defaults", "")
+	mail.RENDER = RenderTemplate
+}
+
+var (
+	// ACCOUNTS_PATH prefix the account routes, ACCOUNTS_PATH/password/forgot, ACCOUNTS_PATH/password/reset and ACCOUNTS_PATH/email/verify
+	ACCOUNTS_PATH       = "/auth"
+	LOGIN_URL           = "/admin/login"
+	PASSWORD_MIN_LENGTH = 8
+	RESET_SUBJECT       = "Reset your password"
+	VERIFY_SUBJECT      = "Verify your email"
+)
+
+// MAILER send account mails, the default use mail.Send, failing when no transport is set
+var MAILER = func(to, subject, html string) error {
+	return mail.Send(&mail.Message{To: []string{to}, Subject: subject, HTML: html})
+}
+
+// RenderTemplate execute the template name, like Context.Html but into a string, for mails
+func RenderTemplate(name string, data map[string]any) (string, error) {
+	var buff bytes.Buffer
+	if err := allTemplates.ExecuteTemplate(&buff, name, data); err != nil {
+		return "", err
+	}
+	return buff.String(), nil
+}
+
+func validFor(d time.Duration) string {
+	switch {
+	case d == 24*time.Hour:
+		return "1 day"
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	case d == time.Hour:
+		return "1 hour"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+	return d.String()
+}
+
+func accountMail(user models.User, template, subject, link string, ttl time.Duration) error {
+	html, err := RenderTemplate(template, map[string]any{
+		"user":      user,
+		"link":      link,
+		"valid_for": validFor(ttl),
+	})
+	if err != nil {
+		return err
+	}
+	return MAILER(user.Email, subject, html)
+}
+
+// SendPasswordReset mail user a link to reset the password
+func (c *Context) SendPasswordReset(user models.User) error {
+	base, err := c.BaseURL()
+	if err != nil {
+		return err
+	}
+	token, err := accounts.Issue(user.Id, accounts.PasswordReset, accounts.RESET_TTL)
+	if err != nil {
+		return err
+	}
+	link := base + ACCOUNTS_PATH + "/password/reset?token=" + token
+	return accountMail(user, "accounts/mail_reset_password.html", RESET_SUBJECT, link, accounts.RESET_TTL)
+}
+
+// SendVerification mail user a link to verify the email
+func (c *Context) SendVerification(user models.User) error {
+	base, err := c.BaseURL()
+	if err != nil {
+		return err
+	}
+	token, err := accounts.Issue(user.Id, accounts.VerifyEmail, accounts.VERIFY_TTL)
+	if err != nil {
+		return err
+	}
+	link := base + ACCOUNTS_PATH + "/email/verify?token=" + token
+	return accountMail(user, "accounts/mail_verify_email.html", VERIFY_SUBJECT, link, accounts.VERIFY_TTL)
+}
+
+// ResetPassword set the password of userId and log the user out of all sessions, its jwt tokens are revoked
+// by the password change, see jwt.CheckStamp, and its api keys are revoked
+func ResetPassword(userId int, password string) error {
+	h, err := hash.GenerateHash(password)
+	if err != nil {
+		return err
+	}
+	if _, err := orm.Model[models.User]().Where("id = ?", userId).Set("password = ?", h); err != nil {
+		return err
+	}
+	if err := sessions.LogoutEverywhere(SESSION_STORE, userId); err != nil {
+		return err
+	}
+	return apikeys.RevokeUser(userId)
+}
+
+func wantsJson(c *Context) bool {
+	return strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") ||
+		strings.Contains(c.Request.Header.Get("Accept"), "application/json")
+}
+
+// accountResponse answer json clients, and render page for forms
+func accountResponse(c *Context, status int, page string, data map[string]any) {
+	if wantsJson(c) {
+		res := map[string]any{}
+		if e, ok := data["error"]; ok {
+			res["error"] = e
+		} else {
+			res["success"] = data["message"]
+		}
+		c.Status(status).Json(res)
+		return
+	}
+	c.Status(status).Html(page, data)
+}
+
+var ForgotPasswordView = func(c *Context) {
+	c.Html("accounts/forgot_password.html", map[string]any{"title": "Forgot password"})
+}
+
+// ForgotPasswordPost mail a reset link to {"email"}, the answer is the same whether the account exist or not
+var ForgotPasswordPost = func(c *Context) {
+	email := strings.TrimSpace(tokenRequest(c, "email")["email"])
+	if user, err := orm.Model[models.User]().Where("email = ?", email).One(); err == nil && user.Id != 0 && email != "" {
+		// sent in background, so the response time don't tell if the account exist
+		cc := &Context{Request: c.Request.Clone(context.Background())}
+		go func() {
+			logger.CheckError(cc.SendPasswordReset(user))
+		}()
+	}
+	accountResponse(c, http.StatusOK, "accounts/forgot_password.html", map[string]any{
+		"title":   "Forgot password",
+		"message": "If an account use this email, a link to reset its password was sent to it.",
+	})
+}
+
+func resetPage(data map[string]any) map[string]any {
+	data["title"] = "Reset password"
+	data["min_length"] = PASSWORD_MIN_LENGTH
+	data["login_url"] = LOGIN_URL
+	data["forgot_url"] = ACCOUNTS_PATH + "/password/forgot"
+	return data
+}
+
+var ResetPasswordView = func(c *Context) {
+	token := c.QueryParam("token")
+	if _, err := accounts.Check(token, accounts.PasswordReset); err != nil {
+		c.Status(http.StatusBadRequest).Html("accounts/reset_password.html", resetPage(map[string]any{
+			"error": "This link is invalid or expired.",
+		}))
+		return
+	}
+	c.Html("accounts/reset_password.html", resetPage(map[string]any{"token": token}))
+}
+
+// ResetPasswordPost set {"password"} using {"token"}, the token can be used once and all sessions of the user are closed
+var ResetPasswordPost = func(c *Context) {
+	req := tokenRequest(c, "token", "password", "password_confirm")
+	page := "accounts/reset_password.html"
+	if len(req["password"]) < PASSWORD_MIN_LENGTH || (req["password_confirm"] != "" && req["password_confirm"] != req["password"]) {
+		msg := "Passwords don't match."
+		if len(req["password"]) < PASSWORD_MIN_LENGTH {
+			msg = "The password is too short."
+		}
+		accountResponse(c, http.StatusBadRequest, page, resetPage(map[string]any{"error": msg, "token": req["token"]}))
+		return
+	}
+	userId, err := accounts.Consume(req["token"], accounts.PasswordReset)
+	if err != nil {
+		accountResponse(c, http.StatusBadRequest, page, resetPage(map[string]any{"error": "This link is invalid or expired."}))
+		return
+	}
+	user, err := userById(userId)
+	if err == nil {
+		err = ResetPassword(userId, req["password"])
+	}
+	if logger.CheckError(err) {
+		accountResponse(c, http.StatusInternalServerError, page, resetPage(map[string]any{"error": "Unable to change the password."}))
+		return
+	}
+	// the link was received by mail, it prove the email
+	logger.CheckError(accounts.STORE.MarkVerified(user.Id, user.Email, time.Now()))
+	accountResponse(c, http.StatusOK, page, resetPage(map[string]any{"message": "Your password was changed, you can log in."}))
+}
+
+// SendVerificationPost mail a verification link to the logged in user, use it with Auth
+var SendVerificationPost = func(c *Context) {
+	user, ok := c.User()
+	if !ok {
+		c.Status(http.StatusUnauthorized).Json(map[string]any{"error": "not logged in"})
+		return
+	}
+	if logger.CheckError(c.SendVerification(user)) {
+		c.Status(http.StatusInternalServerError).Json(map[string]any{"error": "unable to send the email"})
+		return
+	}
+	c.Json(map[string]any{"success": "a verification link was sent to " + user.Email})
+}
+
+var VerifyEmailView = func(c *Context) {
+	page := "accounts/email_verified.html"
+	userId, err := accounts.Consume(c.QueryParam("token"), accounts.VerifyEmail)
+	var user models.User
+	if err == nil {
+		user, err = userById(userId)
+	}
+	if err == nil {
+		err = accounts.STORE.MarkVerified(user.Id, user.Email, time.Now())
+	}
+	if err != nil {
+		c.Status(http.StatusBadRequest).Html(page, map[string]any{"title": "Verify email", "error": "This link is invalid or expired."})
+		return
+	}
+	c.Html(page, map[string]any{"title": "Verify email", "message": "Your email " + user.Email + " is verified."})
+}
@@ -0,0 +1,102 @@
+// Package accounts issue the single use tokens mailed to users to reset their password or verify their email.
+// Tokens are random, only their sha256 is stored, they expire and can be consumed once
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+)
+
+const (
+	PasswordReset = "reset"
+	VerifyEmail   = "verify"
+)
+
+var (
+	RESET_TTL  = time.Hour
+	VERIFY_TTL = 48 * time.Hour
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Store persist tokens and verified emails
+type Store interface {
+	Save(t models.UserToken) error
+	// Find return the valid token having hash for purpose, without consuming it
+	Find(hash, purpose string, now time.Time) (models.UserToken, error)
+	// Consume mark the token used, only one caller can consume a token
+	Consume(hash, purpose string, now time.Time) (models.UserToken, error)
+	// DeleteUser delete the tokens of userId for purpose
+	DeleteUser(userId int, purpose string) error
+	DeleteExpired(now time.Time) error
+	MarkVerified(userId int, email string, at time.Time) error
+	IsVerified(userId int, email string) (bool, error)
+}
+
+// STORE keep the tokens, New use the orm tables user_tokens and verified_emails, BareBone keep them in memory
+var STORE Store = NewMemory()
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue return a new token of userId for purpose valid ttl, the previous tokens of the same purpose are deleted
+func Issue(userId int, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if err := STORE.DeleteUser(userId, purpose); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	err := STORE.Save(models.UserToken{
+		UserId:    userId,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(ttl).Unix(),
+		CreatedAt: now.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Check return the user id of a valid token without consuming it, to display a form before using it
+func Check(token, purpose string) (int, error) {
+	if token == "" {
+		return 0, ErrInvalidToken
+	}
+	t, err := STORE.Find(hashToken(token), purpose, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return t.UserId, nil
+}
+
+// Consume use token and return its user id, a token can be consumed once
+func Consume(token, purpose string) (int, error) {
+	if token == "" {
+		return 0, ErrInvalidToken
+	}
+	t, err := STORE.Consume(hashToken(token), purpose, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return t.UserId, nil
+}
+
+// IsVerified report if user proved owning their current email
+func IsVerified(user models.User) bool {
+	ok, err := STORE.IsVerified(user.Id, user.Email)
+	return err == nil && ok
+}
@@ -0,0 +1,58 @@
+package accounts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+)
+
+func TestTokens(t *testing.T) {
+	STORE = NewMemory()
+	token, err := Issue(1, PasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := Check(token, PasswordReset); err != nil || id != 1 {
+		t.Fatal("check", id, err)
+	}
+	if _, err := Check(token, VerifyEmail); err != ErrInvalidToken {
+		t.Fatal("token used for another purpose")
+	}
+	if id, err := Consume(token, PasswordReset); err != nil || id != 1 {
+		t.Fatal("consume", id, err)
+	}
+	if _, err := Consume(token, PasswordReset); err != ErrInvalidToken {
+		t.Fatal("token consumed twice")
+	}
+
+	old, _ := Issue(1, PasswordReset, time.Hour)
+	token, _ = Issue(1, PasswordReset, time.Hour)
+	if _, err := Check(old, PasswordReset); err != ErrInvalidToken {
+		t.Fatal("previous token still valid")
+	}
+	if _, err := Check(token, PasswordReset); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, _ := Issue(2, VerifyEmail, -time.Second)
+	if _, err := Consume(expired, VerifyEmail); err != ErrInvalidToken {
+		t.Fatal("expired token consumed")
+	}
+}
+
+func TestVerified(t *testing.T) {
+	STORE = NewMemory()
+	user := models.User{Id: 1, Email: "a@b.c"}
+	if IsVerified(user) {
+		t.Fatal("verified before proving")
+	}
+	_ = STORE.MarkVerified(1, "a@b.c", time.Now())
+	if !IsVerified(user) {
+		t.Fatal("not verified")
+	}
+	user.Email = "new@b.c"
+	if IsVerified(user) {
+		t.Fatal("changed email still verified")
+	}
+}
@@ -0,0 +1,179 @@
+package accounts
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+// Memory is an in memory Store, lost on restart
+type Memory struct {
+	tokens   map[string]models.UserToken
+	verified map[int]string
+	mu       sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{tokens: map[string]models.UserToken{}, verified: map[int]string{}}
+}
+
+func (m *Memory) Save(t models.UserToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[t.TokenHash] = t
+	return nil
+}
+
+func (m *Memory) find(hash, purpose string, now time.Time) (models.UserToken, error) {
+	t, ok := m.tokens[hash]
+	if !ok || t.Purpose != purpose || t.UsedAt != 0 || now.Unix() > t.ExpiresAt {
+		return models.UserToken{}, ErrInvalidToken
+	}
+	return t, nil
+}
+
+func (m *Memory) Find(hash, purpose string, now time.Time) (models.UserToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.find(hash, purpose, now)
+}
+
+func (m *Memory) Consume(hash, purpose string, now time.Time) (models.UserToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.find(hash, purpose, now)
+	if err != nil {
+		return t, err
+	}
+	t.UsedAt = now.Unix()
+	m.tokens[hash] = t
+	return t, nil
+}
+
+func (m *Memory) DeleteUser(userId int, purpose string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for h, t := range m.tokens {
+		if t.UserId == userId && t.Purpose == purpose {
+			delete(m.tokens, h)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for h, t := range m.tokens {
+		if now.Unix() > t.ExpiresAt {
+			delete(m.tokens, h)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) MarkVerified(userId int, email string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, e := range m.verified {
+		if strings.EqualFold(e, email) {
+			delete(m.verified, id)
+		}
+	}
+	m.verified[userId] = email
+	return nil
+}
+
+func (m *Memory) IsVerified(userId int, email string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.verified[userId]
+	return ok && strings.EqualFold(e, email), nil
+}
+
+// ORM keep tokens in the table user_tokens and verified emails in verified_emails
+type ORM struct {
+	dbName string
+}
+
+// NewORM return a store using dbName, the default database if empty
+func NewORM(dbName ...string) *ORM {
+	s := &ORM{}
+	if len(dbName) > 0 {
+		s.dbName = dbName[0]
+	}
+	return s
+}
+
+func (o *ORM) Save(t models.UserToken) error {
+	_, err := orm.ExecAffected(o.dbName, "INSERT INTO user_tokens (user_id,purpose,token_hash,expires_at,used_at,created_at) VALUES (?,?,?,?,?,?)",
+		t.UserId, t.Purpose, t.TokenHash, t.ExpiresAt, t.UsedAt, t.CreatedAt)
+	return err
+}
+
+func (o *ORM) Find(hash, purpose string, now time.Time) (models.UserToken, error) {
+	rows, err := orm.Query(o.dbName, "SELECT id,user_id,expires_at,created_at FROM user_tokens WHERE token_hash = ? AND purpose = ? AND used_at = 0 AND expires_at >= ?", hash, purpose, now.Unix())
+	if err != nil || len(rows) == 0 {
+		if err == nil || err.Error() == "no data found" {
+			err = ErrInvalidToken
+		}
+		return models.UserToken{}, err
+	}
+	return models.UserToken{
+		Id:        int(orm.ToInt64(rows[0]["id"])),
+		UserId:    int(orm.ToInt64(rows[0]["user_id"])),
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: orm.ToInt64(rows[0]["expires_at"]),
+		CreatedAt: orm.ToInt64(rows[0]["created_at"]),
+	}, nil
+}
+
+// Consume update the token only if unused, so concurrent requests can't both consume it
+func (o *ORM) Consume(hash, purpose string, now time.Time) (models.UserToken, error) {
+	t, err := o.Find(hash, purpose, now)
+	if err != nil {
+		return t, err
+	}
+	n, err := orm.ExecAffected(o.dbName, "UPDATE user_tokens SET used_at = ? WHERE id = ? AND used_at = 0", now.Unix(), t.Id)
+	if err != nil {
+		return t, err
+	}
+	if n != 1 {
+		return models.UserToken{}, ErrInvalidToken
+	}
+	t.UsedAt = now.Unix()
+	return t, nil
+}
+
+func (o *ORM) DeleteUser(userId int, purpose string) error {
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM user_tokens WHERE user_id = ? AND purpose = ?", userId, purpose)
+	return err
+}
+
+func (o *ORM) DeleteExpired(now time.Time) error {
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM user_tokens WHERE expires_at < ?", now.Unix())
+	return err
+}
+
+func (o *ORM) MarkVerified(userId int, email string, at time.Time) error {
+	if _, err := orm.ExecAffected(o.dbName, "DELETE FROM verified_emails WHERE user_id = ? OR email = ?", userId, email); err != nil {
+		return err
+	}
+	_, err := orm.ExecAffected(o.dbName, "INSERT INTO verified_emails (user_id,email,verified_at) VALUES (?,?,?)", userId, email, at.Unix())
+	return err
+}
+
+func (o *ORM) IsVerified(userId int, email string) (bool, error) {
+	_, err := orm.Query(o.dbName, "SELECT id FROM verified_emails WHERE user_id = ? AND email = ?", userId, email)
+	if err != nil {
+		if err.Error() == "no data found" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
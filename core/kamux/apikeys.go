@@ -0,0 +1,82 @@
+package kamux
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux/apikeys"
+	"github.com/kamalshkeir/kago/core/utils"
+)
+
+// API_KEY_HEADER carry the api key, "Authorization: Bearer <key>" is accepted too
+var API_KEY_HEADER = "X-API-Key"
+
+// APIKeyToken return the api key sent with r, keys are never read from the url, it end up in logs
+func APIKeyToken(r *http.Request) string {
+	if key := r.Header.Get(API_KEY_HEADER); key != "" {
+		return strings.TrimSpace(key)
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		if _, ok := apikeys.Prefix(strings.TrimSpace(auth[7:])); ok {
+			return strings.TrimSpace(auth[7:])
+		}
+	}
+	return ""
+}
+
+// RequireScope authenticate requests using an api key granting all scopes, the key and its user are available using c.APIKey() and c.User().
+// Requests without a valid key get 401, keys missing a scope get 403
+var RequireScope = func(scopes ...string) func(Handler) Handler {
+	const userKey utils.ContextKey = "user"
+	const apiKey utils.ContextKey = "apikey"
+	return func(handler Handler) Handler {
+		return func(c *Context) {
+			plain := APIKeyToken(c.Request)
+			if plain == "" {
+				c.SetHeader("WWW-Authenticate", "Bearer")
+				c.Status(http.StatusUnauthorized).Json(map[string]any{
+					"error": "api key required",
+				})
+				return
+			}
+			key, err := apikeys.Verify(plain)
+			var user models.User
+			if err == nil {
+				user, err = userById(key.UserId)
+			}
+			if err != nil {
+				c.SetHeader("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.Status(http.StatusUnauthorized).Json(map[string]any{
+					"error": apikeys.ErrInvalid.Error(),
+				})
+				return
+			}
+			for _, scope := range scopes {
+				if !apikeys.HasScope(key, scope) {
+					c.SetHeader("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+					c.Status(http.StatusForbidden).Json(map[string]any{
+						"error": "missing scope " + scope,
+					})
+					return
+				}
+			}
+			ctx := context.WithValue(c.Request.Context(), userKey, user)
+			ctx = context.WithValue(ctx, apiKey, key)
+			*c = Context{
+				ResponseWriter: c.ResponseWriter,
+				Request:        c.Request.WithContext(ctx),
+				Params:         c.Params,
+			}
+			handler(c)
+		}
+	}
+}
+
+// APIKey return the api key that authenticated the request, see RequireScope
+func (c *Context) APIKey() (models.APIKey, bool) {
+	const key utils.ContextKey = "apikey"
+	k, ok := c.Request.Context().Value(key).(models.APIKey)
+	return k, ok
+}
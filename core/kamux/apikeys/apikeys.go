@@ -0,0 +1,201 @@
+// Package apikeys issue and verify per user api keys for machine to machine access.
+// A key look like kago_<16 hex id>_<secret>, the id is stored in clear as the key prefix, the secret is argon2 hashed
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/utils/encryption/hash"
+)
+
+var (
+	// PREFIX start every key, it make keys easy to spot in logs and secret scanners
+	PREFIX = "kago"
+	// LAST_USED_EVERY limit the writes of the last usage of a key
+	LAST_USED_EVERY = time.Minute
+)
+
+var (
+	ErrInvalid  = errors.New("invalid api key")
+	ErrExpired  = errors.New("api key expired")
+	ErrRevoked  = errors.New("api key revoked")
+	ErrNotFound = errors.New("api key not found")
+)
+
+const idLen = 16
+
+// Create issue a key for userId, ttl 0 for a key that never expire. The returned plain key is shown once, it cannot be recovered
+func Create(userId int, name string, scopes []string, ttl time.Duration) (string, models.APIKey, error) {
+	id := make([]byte, idLen/2)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", models.APIKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", models.APIKey{}, err
+	}
+	prefix := PREFIX + "_" + hex.EncodeToString(id)
+	plain := prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	h, err := hash.GenerateHash(plain)
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+	now := time.Now()
+	k := models.APIKey{
+		UserId:    userId,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      h,
+		Scopes:    strings.Join(cleanScopes(scopes), ","),
+		CreatedAt: now.Unix(),
+	}
+	if ttl > 0 {
+		k.ExpiresAt = now.Add(ttl).Unix()
+	}
+	if err := STORE.Create(&k); err != nil {
+		return "", models.APIKey{}, err
+	}
+	return plain, k, nil
+}
+
+// Prefix return the stored prefix of plain, or false if plain is not shaped like a key
+func Prefix(plain string) (string, bool) {
+	if !strings.HasPrefix(plain, PREFIX+"_") {
+		return "", false
+	}
+	rest := plain[len(PREFIX)+1:]
+	if len(rest) < idLen+2 || rest[idLen] != '_' {
+		return "", false
+	}
+	if _, err := hex.DecodeString(rest[:idLen]); err != nil {
+		return "", false
+	}
+	return plain[:len(PREFIX)+1+idLen], true
+}
+
+// verified cache sha256(plain) -> hash of successfully compared keys, argon2 is too slow to run on every request.
+// The row is still loaded each time, so revocation and expiry apply right away
+var (
+	verified   = map[string]string{}
+	verifiedMu sync.RWMutex
+)
+
+// Verify return the key matching plain, updating its last usage
+func Verify(plain string) (models.APIKey, error) {
+	prefix, ok := Prefix(plain)
+	if !ok {
+		return models.APIKey{}, ErrInvalid
+	}
+	k, err := STORE.ByPrefix(prefix)
+	if err == ErrNotFound {
+		return k, ErrInvalid
+	} else if err != nil {
+		return k, err
+	}
+	if !matchHash(plain, k.Hash) {
+		return models.APIKey{}, ErrInvalid
+	}
+	now := time.Now()
+	if k.RevokedAt != 0 {
+		return k, ErrRevoked
+	}
+	if k.ExpiresAt != 0 && now.Unix() > k.ExpiresAt {
+		return k, ErrExpired
+	}
+	if now.Sub(time.Unix(k.LastUsed, 0)) >= LAST_USED_EVERY {
+		k.LastUsed = now.Unix()
+		if err := STORE.Touch(k.Id, now); err != nil {
+			return k, err
+		}
+	}
+	return k, nil
+}
+
+func matchHash(plain, h string) bool {
+	sum := sha256.Sum256([]byte(plain))
+	digest := hex.EncodeToString(sum[:])
+	verifiedMu.RLock()
+	cached, ok := verified[digest]
+	verifiedMu.RUnlock()
+	if ok && subtle.ConstantTimeCompare([]byte(cached), []byte(h)) == 1 {
+		return true
+	}
+	if match, err := hash.ComparePasswordToHash(plain, h); err != nil || !match {
+		return false
+	}
+	verifiedMu.Lock()
+	if len(verified) > 10000 {
+		verified = map[string]string{}
+	}
+	verified[digest] = h
+	verifiedMu.Unlock()
+	return true
+}
+
+// Revoke revoke the key id, it stay listed with its revocation date
+func Revoke(id int) error {
+	return STORE.Revoke(id, time.Now())
+}
+
+// RevokeUser revoke all the keys of userId, when its password is reset
+func RevokeUser(userId int) error {
+	if userId == 0 {
+		return nil
+	}
+	keys, err := STORE.List(userId)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, k := range keys {
+		if k.RevokedAt != 0 {
+			continue
+		}
+		if err := STORE.Revoke(k.Id, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List return the keys of userId, all keys if userId is 0
+func List(userId int) ([]models.APIKey, error) {
+	return STORE.List(userId)
+}
+
+// ScopeList return the scopes of k
+func ScopeList(k models.APIKey) []string {
+	return cleanScopes(strings.Split(k.Scopes, ","))
+}
+
+// HasScope report if k grant scope, a key scope "*" grant everything and "orders:*" grant "orders:read" and "orders:write"
+func HasScope(k models.APIKey, scope string) bool {
+	for _, s := range ScopeList(k) {
+		if s == "*" || s == scope {
+			return true
+		}
+		if strings.HasSuffix(s, ":*") && strings.HasPrefix(scope, strings.TrimSuffix(s, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func cleanScopes(scopes []string) []string {
+	res := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
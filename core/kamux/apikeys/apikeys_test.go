@@ -0,0 +1,70 @@
+package apikeys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+)
+
+func TestKeys(t *testing.T) {
+	STORE = NewMemory()
+	plain, k, err := Create(7, "ci", []string{"orders:read, invoices:*"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := Prefix(plain); !ok || p != k.Prefix {
+		t.Fatal("prefix", p, k.Prefix)
+	}
+	got, err := Verify(plain)
+	if err != nil || got.UserId != 7 || got.LastUsed == 0 {
+		t.Fatal(got, err)
+	}
+	if _, err := Verify(plain[:len(plain)-1] + "x"); err != ErrInvalid {
+		t.Fatal("wrong secret accepted", err)
+	}
+	if _, err := Verify("kago_nothex"); err != ErrInvalid {
+		t.Fatal("malformed key accepted", err)
+	}
+	for scope, want := range map[string]bool{
+		"orders:read":    true,
+		"orders:write":   false,
+		"invoices:write": true,
+		"invoices":       false,
+	} {
+		if HasScope(got, scope) != want {
+			t.Fatal("scope", scope, !want)
+		}
+	}
+	if !HasScope(models.APIKey{Scopes: "*"}, "anything") {
+		t.Fatal("* must grant all scopes")
+	}
+
+	if err := Revoke(k.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(plain); err != ErrRevoked {
+		t.Fatal("revoked key accepted", err)
+	}
+
+	expiring, k2, _ := Create(7, "tmp", nil, time.Hour)
+	STORE.(*Memory).update(k2.Id, func(k *models.APIKey) { k.ExpiresAt = time.Now().Add(-time.Minute).Unix() })
+	if _, err := Verify(expiring); err != ErrExpired {
+		t.Fatal("expired key accepted", err)
+	}
+	if keys, _ := List(7); len(keys) != 2 || keys[0].Id != k2.Id {
+		t.Fatal("list", keys)
+	}
+
+	other, _, _ := Create(8, "other", nil, 0)
+	valid, _, _ := Create(7, "valid", nil, 0)
+	if err := RevokeUser(7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(valid); err != ErrRevoked {
+		t.Fatal("key of the user not revoked", err)
+	}
+	if _, err := Verify(other); err != nil {
+		t.Fatal("key of another user revoked", err)
+	}
+}
@@ -0,0 +1,192 @@
+package apikeys
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+// Store persist api keys
+type Store interface {
+	// Create save k, setting its Id
+	Create(k *models.APIKey) error
+	ByPrefix(prefix string) (models.APIKey, error)
+	// List return the keys of userId, all keys if userId is 0, newest first
+	List(userId int) ([]models.APIKey, error)
+	Revoke(id int, at time.Time) error
+	Touch(id int, at time.Time) error
+}
+
+// STORE keep the keys, New use the orm table api_keys, BareBone keep them in memory
+var STORE Store = NewMemory()
+
+// Memory is an in memory Store, lost on restart
+type Memory struct {
+	keys   map[int]models.APIKey
+	nextId int
+	mu     sync.RWMutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{keys: map[int]models.APIKey{}}
+}
+
+func (m *Memory) Create(k *models.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextId++
+	k.Id = m.nextId
+	m.keys[k.Id] = *k
+	return nil
+}
+
+func (m *Memory) ByPrefix(prefix string) (models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range m.keys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+	return models.APIKey{}, ErrNotFound
+}
+
+func (m *Memory) List(userId int) ([]models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []models.APIKey{}
+	for _, k := range m.keys {
+		if userId == 0 || k.UserId == userId {
+			res = append(res, k)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Id > res[j].Id })
+	return res, nil
+}
+
+func (m *Memory) Revoke(id int, at time.Time) error {
+	return m.update(id, func(k *models.APIKey) {
+		if k.RevokedAt == 0 {
+			k.RevokedAt = at.Unix()
+		}
+	})
+}
+
+func (m *Memory) Touch(id int, at time.Time) error {
+	return m.update(id, func(k *models.APIKey) { k.LastUsed = at.Unix() })
+}
+
+func (m *Memory) update(id int, fn func(k *models.APIKey)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	fn(&k)
+	m.keys[id] = k
+	return nil
+}
+
+// ORM keep the keys in the table api_keys (models.APIKey), migrated with the initial models
+type ORM struct {
+	dbName string
+}
+
+// NewORM return a store using the table api_keys of dbName, the default database if empty
+func NewORM(dbName ...string) *ORM {
+	s := &ORM{}
+	if len(dbName) > 0 {
+		s.dbName = dbName[0]
+	}
+	return s
+}
+
+func (o *ORM) model() *orm.Builder[models.APIKey] {
+	b := orm.Model[models.APIKey]()
+	if o.dbName != "" {
+		b = b.Database(o.dbName)
+	}
+	return b
+}
+
+func (o *ORM) Create(k *models.APIKey) error {
+	if _, err := o.model().Insert(k); err != nil {
+		return err
+	}
+	created, err := o.ByPrefix(k.Prefix)
+	if err != nil {
+		return err
+	}
+	k.Id = created.Id
+	return nil
+}
+
+// ByPrefix read without the orm cache, a revocation must apply right away
+func (o *ORM) ByPrefix(prefix string) (models.APIKey, error) {
+	keys, err := o.query("prefix = ?", prefix)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if len(keys) == 0 {
+		return models.APIKey{}, ErrNotFound
+	}
+	return keys[0], nil
+}
+
+func (o *ORM) List(userId int) ([]models.APIKey, error) {
+	if userId == 0 {
+		return o.query("1 = 1 ORDER BY id DESC")
+	}
+	return o.query("user_id = ? ORDER BY id DESC", userId)
+}
+
+func (o *ORM) Revoke(id int, at time.Time) error {
+	_, err := o.model().Where("id = ? AND revoked_at = 0", id).Set("revoked_at = ?", at.Unix())
+	return err
+}
+
+// Touch write directly, builder writes would flush the orm cache on every key usage
+func (o *ORM) Touch(id int, at time.Time) error {
+	_, err := orm.ExecAffected(o.dbName, "UPDATE api_keys SET last_used = ? WHERE id = ?", at.Unix(), id)
+	return err
+}
+
+func (o *ORM) query(where string, args ...any) ([]models.APIKey, error) {
+	rows, err := orm.Query(o.dbName, "SELECT id,user_id,name,prefix,hash,scopes,expires_at,last_used,revoked_at,created_at FROM api_keys WHERE "+where, args...)
+	if err != nil {
+		if err.Error() == "no data found" {
+			return []models.APIKey{}, nil
+		}
+		return nil, err
+	}
+	res := make([]models.APIKey, 0, len(rows))
+	for _, row := range rows {
+		res = append(res, models.APIKey{
+			Id:        int(orm.ToInt64(row["id"])),
+			UserId:    int(orm.ToInt64(row["user_id"])),
+			Name:      toString(row["name"]),
+			Prefix:    toString(row["prefix"]),
+			Hash:      toString(row["hash"]),
+			Scopes:    toString(row["scopes"]),
+			ExpiresAt: orm.ToInt64(row["expires_at"]),
+			LastUsed:  orm.ToInt64(row["last_used"]),
+			RevokedAt: orm.ToInt64(row["revoked_at"]),
+			CreatedAt: orm.ToInt64(row["created_at"]),
+		})
+	}
+	return res, nil
+}
+
+func toString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	}
+	return ""
+}
@@ -0,0 +1,343 @@
+// Package cache answer conditional requests, If-None-Match and If-Modified-Since, with 304 using the ETag of the buffered response,
+// and can store whole responses for a TTL, keyed by route, query and Vary headers, invalidated when the orm write in given tables.
+// Nonces of the Content-Security-Policy are replaced in stored responses by the nonce of each request
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/kamux/secure"
+	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/utils/eventbus"
+)
+
+// MAX_BODY is the biggest response buffered, bigger ones are sent as they are written, without ETag
+var MAX_BODY = 2 << 20
+
+// Options of a cache
+type Options struct {
+	// TTL store the responses for this duration, if zero only conditional requests are answered
+	TTL time.Duration
+	// Vary headers are part of the key and sent in the Vary header, like "Accept-Language", or "Cookie" for pages depending on the user
+	Vary []string
+	// Tables invalidate the stored responses when the orm insert, update or delete in them
+	Tables []string
+	// CacheControl is sent with the responses not having one, like "public, max-age=60"
+	CacheControl string
+	// Key of the request, host, path, sorted query and Vary headers by default
+	Key   func(r *http.Request) string
+	Store Store
+	// SessionCookie authenticate users, requests carrying it are stored only if Vary include "Cookie", "session" by default
+	SessionCookie string
+}
+
+type Cache struct {
+	Options
+	tags []string
+}
+
+var (
+	stores    = []Store{}
+	storesMu  sync.Mutex
+	subscribe sync.Once
+)
+
+// New return a cache using opts, stored responses are invalidated on writes in opts.Tables
+func New(opts Options) *Cache {
+	ca := &Cache{Options: opts}
+	if ca.Key == nil {
+		ca.Key = ca.defaultKey
+	}
+	if ca.SessionCookie == "" {
+		ca.SessionCookie = "session"
+	}
+	for _, t := range opts.Tables {
+		ca.tags = append(ca.tags, "table:"+t)
+	}
+	if len(ca.tags) > 0 {
+		register(ca.store())
+		subscribe.Do(func() {
+			eventbus.Subscribe(orm.WRITE_TOPIC, func(data map[string]string) {
+				Invalidate(data["table"])
+			})
+		})
+	}
+	return ca
+}
+
+func register(s Store) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	for _, st := range stores {
+		if st == s {
+			return
+		}
+	}
+	stores = append(stores, s)
+}
+
+// Invalidate delete the stored responses of caches using tables
+func Invalidate(tables ...string) {
+	tags := make([]string, 0, len(tables))
+	for _, t := range tables {
+		tags = append(tags, "table:"+t)
+	}
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	for _, s := range stores {
+		s.Invalidate(tags...)
+	}
+}
+
+func (ca *Cache) store() Store {
+	if ca.Store != nil {
+		return ca.Store
+	}
+	return STORE
+}
+
+func (ca *Cache) defaultKey(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Host)
+	b.WriteString(r.URL.Path)
+	if q := r.URL.Query(); len(q) > 0 {
+		b.WriteString("?" + q.Encode())
+	}
+	for _, h := range ca.Vary {
+		b.WriteString("|" + h + "=" + url.QueryEscape(r.Header.Get(h)))
+	}
+	return b.String()
+}
+
+func (ca *Cache) varies(header string) bool {
+	for _, h := range ca.Vary {
+		if strings.EqualFold(h, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// stored report if responses to r can be stored, requests carrying credentials must vary on them,
+// otherwise the page of a logged in user, and its csrf token, would be served to everyone
+func (ca *Cache) stored(r *http.Request) bool {
+	if ca.TTL <= 0 {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" && !ca.varies("Authorization") {
+		return false
+	}
+	if _, err := r.Cookie(ca.SessionCookie); err == nil && !ca.varies("Cookie") {
+		return false
+	}
+	return true
+}
+
+var noncePlaceholder = []byte("\x00csp-nonce\x00")
+
+// Serve answer r from the stored response, or run next and answer conditional requests from its response
+func (ca *Cache) Serve(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		next(w, r)
+		return
+	}
+	nonce := secure.Nonce(r)
+	key := ""
+	if ca.stored(r) {
+		key = ca.Key(r)
+		if e, ok := ca.store().Get(key, time.Now()); ok {
+			ca.write(w, r, e, nonce, "HIT")
+			return
+		}
+	}
+	rec := &recorder{w: w, header: http.Header{}, vary: ca.Vary}
+	next(rec, r)
+	if rec.passthrough {
+		return
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	e := &Entry{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}
+	if nonce != "" {
+		e.Body = bytes.ReplaceAll(e.Body, []byte(nonce), noncePlaceholder)
+	}
+	xcache := ""
+	if e.Status == http.StatusOK {
+		if e.ETag = e.Header.Get("ETag"); e.ETag == "" {
+			e.ETag = etag(e.Body)
+		}
+		if lm := e.Header.Get("Last-Modified"); lm != "" {
+			e.LastModified, _ = http.ParseTime(lm)
+		}
+		if key != "" && r.Method == http.MethodGet && storable(e.Header) {
+			now := time.Now()
+			if e.LastModified.IsZero() {
+				e.LastModified = now
+			}
+			e.Expires = now.Add(ca.TTL)
+			e.Tags = ca.tags
+			ca.store().Set(key, e)
+			xcache = "MISS"
+		}
+	}
+	ca.write(w, r, e, nonce, xcache)
+}
+
+// Middleware cache the responses of next
+func (ca *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ca.Serve(w, r, next.ServeHTTP)
+	})
+}
+
+func (ca *Cache) write(w http.ResponseWriter, r *http.Request, e *Entry, nonce, xcache string) {
+	h := w.Header()
+	for k, v := range e.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	for _, v := range ca.Vary {
+		h.Add("Vary", v)
+	}
+	if ca.CacheControl != "" && h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", ca.CacheControl)
+	}
+	if e.Status == http.StatusOK {
+		h.Set("ETag", e.ETag)
+		if !e.LastModified.IsZero() {
+			h.Set("Last-Modified", e.LastModified.UTC().Format(http.TimeFormat))
+		}
+		if xcache != "" {
+			h.Set("X-Cache", xcache)
+		}
+		if notModified(r, e) {
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	body := e.Body
+	if nonce != "" {
+		body = bytes.ReplaceAll(body, noncePlaceholder, []byte(nonce))
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.Status)
+		return
+	}
+	if e.Status != http.StatusNoContent && e.Status != http.StatusNotModified {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(e.Status)
+	_, _ = w.Write(body)
+}
+
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:12]) + `"`
+}
+
+// storable report if the handler allow to store the response
+func storable(h http.Header) bool {
+	if len(h.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	cc := strings.ToLower(h.Get("Cache-Control"))
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
+
+// notModified check If-None-Match, or If-Modified-Since when absent
+func notModified(r *http.Request, e *Entry) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(e.ETag, "W/")
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !e.LastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !e.LastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// recorder buffer the response, until it's too big or flushed, like server sent events, then let it through
+type recorder struct {
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	vary        []string
+	passthrough bool
+}
+
+func (rec *recorder) Header() http.Header {
+	if rec.passthrough {
+		return rec.w.Header()
+	}
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if !rec.passthrough && rec.body.Len()+len(b) > MAX_BODY {
+		rec.send()
+	}
+	if rec.passthrough {
+		return rec.w.Write(b)
+	}
+	return rec.body.Write(b)
+}
+
+// send write the headers and the buffered body, the next writes go through
+func (rec *recorder) send() {
+	if rec.passthrough {
+		return
+	}
+	rec.passthrough = true
+	h := rec.w.Header()
+	for k, v := range rec.header {
+		h[k] = v
+	}
+	for _, v := range rec.vary {
+		h.Add("Vary", v)
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.w.WriteHeader(rec.status)
+	_, _ = rec.w.Write(rec.body.Bytes())
+	rec.body.Reset()
+}
+
+// Unwrap let http.ResponseController and kamux reach the connection, to set its deadlines
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.w
+}
+
+func (rec *recorder) Flush() {
+	rec.send()
+	if f, ok := rec.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConditional(t *testing.T) {
+	ca := New(Options{})
+	h := ca.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	tag := w.Header().Get("ETag")
+	if w.Code != 200 || w.Body.String() != "hello" || tag == "" {
+		t.Fatal("bad response", w.Code, w.Body.String(), tag)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("If-None-Match", `"other", `+tag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatal("expected 304, got", w.Code)
+	}
+}
+
+func TestStoreAndInvalidate(t *testing.T) {
+	calls := 0
+	ca := New(Options{TTL: time.Minute, Tables: []string{"products"}, Vary: []string{"Accept-Language"}, Store: NewMemory()})
+	h := ca.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	get := func(lang string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/products?b=2&a=1", nil)
+		r.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("en"); w.Header().Get("X-Cache") != "MISS" || w.Body.String() != "en" {
+		t.Fatal("expected miss", w.Header())
+	}
+	if w := get("en"); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "en" || calls != 1 {
+		t.Fatal("expected hit", w.Header(), calls)
+	}
+	if w := get("fr"); w.Body.String() != "fr" || calls != 2 {
+		t.Fatal("vary ignored", calls)
+	}
+
+	Invalidate("products")
+	if get("en"); calls != 3 {
+		t.Fatal("not invalidated", calls)
+	}
+}
+
+func TestSessionNotStored(t *testing.T) {
+	calls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		c, _ := r.Cookie("session")
+		_, _ = w.Write([]byte("page of " + c.String()))
+	})
+	get := func(h http.Handler, cookie string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/account", nil)
+		if cookie != "" {
+			r.Header.Set("Cookie", "session="+cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	h := New(Options{TTL: time.Minute, Store: NewMemory()}).Middleware(handler)
+	get(h, "alice")
+	if w := get(h, ""); calls != 2 || strings.Contains(w.Body.String(), "alice") {
+		t.Fatal("page of a logged in user stored", calls, w.Body.String())
+	}
+
+	calls = 0
+	h = New(Options{TTL: time.Minute, Vary: []string{"Cookie"}, Store: NewMemory()}).Middleware(handler)
+	get(h, "alice")
+	if w := get(h, "alice"); calls != 1 || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("expected hit when varying on cookie", calls)
+	}
+	if w := get(h, "bob"); calls != 2 || strings.Contains(w.Body.String(), "alice") {
+		t.Fatal("page served to another user", w.Body.String())
+	}
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Entry is a stored response
+type Entry struct {
+	Status       int
+	Header       http.Header
+	Body         []byte
+	ETag         string
+	LastModified time.Time
+	Expires      time.Time
+	// Tags invalidate the entry, like "table:products"
+	Tags []string
+}
+
+// Store keep the responses of the caches
+type Store interface {
+	// Get return the entry of key, false if missing or expired
+	Get(key string, now time.Time) (*Entry, bool)
+	Set(key string, e *Entry)
+	Delete(keys ...string)
+	// Invalidate delete the entries having one of tags
+	Invalidate(tags ...string)
+	DeleteExpired(now time.Time) error
+}
+
+// STORE is the default store of the caches
+var STORE Store = NewMemory()
+
+// MEMORY_MAX_ENTRIES evict entries of the memory store when reached, the expired first
+var MEMORY_MAX_ENTRIES = 10_000
+
+// Memory is an in memory Store
+type Memory struct {
+	entries map[string]*Entry
+	tags    map[string]map[string]struct{}
+	mu      sync.RWMutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: map[string]*Entry{}, tags: map[string]map[string]struct{}{}}
+}
+
+func (m *Memory) Get(key string, now time.Time) (*Entry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.entries[key]
+	if !ok || !e.Expires.After(now) {
+		return nil, false
+	}
+	return e, true
+}
+
+func (m *Memory) Set(key string, e *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[key]; ok {
+		m.delete(key)
+	} else if len(m.entries) >= MEMORY_MAX_ENTRIES {
+		m.evict(time.Now())
+	}
+	m.entries[key] = e
+	for _, t := range e.Tags {
+		if m.tags[t] == nil {
+			m.tags[t] = map[string]struct{}{}
+		}
+		m.tags[t][key] = struct{}{}
+	}
+}
+
+// delete remove key and its tags, m.mu must be held
+func (m *Memory) delete(key string) {
+	e, ok := m.entries[key]
+	if !ok {
+		return
+	}
+	delete(m.entries, key)
+	for _, t := range e.Tags {
+		delete(m.tags[t], key)
+		if len(m.tags[t]) == 0 {
+			delete(m.tags, t)
+		}
+	}
+}
+
+// evict make room for a new entry, m.mu must be held
+func (m *Memory) evict(now time.Time) {
+	for k, e := range m.entries {
+		if !e.Expires.After(now) {
+			m.delete(k)
+		}
+	}
+	for k := range m.entries {
+		if len(m.entries) < MEMORY_MAX_ENTRIES*9/10 {
+			break
+		}
+		m.delete(k)
+	}
+}
+
+func (m *Memory) Delete(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		m.delete(k)
+	}
+}
+
+func (m *Memory) Invalidate(tags ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range tags {
+		for k := range m.tags[t] {
+			m.delete(k)
+		}
+	}
+}
+
+func (m *Memory) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range m.entries {
+		if !e.Expires.After(now) {
+			m.delete(k)
+		}
+	}
+	return nil
+}
@@ -3,21 +3,28 @@ package kamux
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux/perms"
+	"github.com/kamalshkeir/kago/core/kamux/ratelimiter"
+	"github.com/kamalshkeir/kago/core/kamux/secure"
+	"github.com/kamalshkeir/kago/core/kamux/sse"
 	"github.com/kamalshkeir/kago/core/settings"
 	"github.com/kamalshkeir/kago/core/utils"
 	"github.com/kamalshkeir/kago/core/utils/logger"
 )
 
+// MultipartSize is the biggest multipart body accepted by ParseMultipartForm, UploadFile and UploadFiles
 var MultipartSize = 10 << 20
 
 type M map[string]any
@@ -28,6 +35,7 @@ type Context struct {
 	*http.Request
 	Params map[string]string
 	status int
+	sse    *sse.Writer
 }
 
 // Status set status to context, will not be writed to header
@@ -93,12 +101,22 @@ func (c *Context) Text(body string) {
 }
 
 func (c *Context) IsAuthenticated() bool {
-	const key utils.ContextKey = "user"
-	if _, ok := c.Request.Context().Value(key).(map[string]any); ok {
-		return true
-	} else {
-		return false
+	_, ok := c.User()
+	return ok
+}
+
+// BASE_URL is the public url of the site, ex: https://app.example.com, required to build absolute links in mails and oauth callbacks.
+// The host of the request is never used, it is set by the client
+var BASE_URL = ""
+
+var ErrNoBaseURL = errors.New("kamux.BASE_URL is not set, absolute links can't be built")
+
+// BaseURL return BASE_URL without trailing slash, or ErrNoBaseURL if not set
+func (c *Context) BaseURL() (string, error) {
+	if BASE_URL == "" {
+		return "", ErrNoBaseURL
 	}
+	return strings.TrimSuffix(BASE_URL, "/"), nil
 }
 
 func (c *Context) User() (models.User,bool) {
@@ -119,6 +137,7 @@ func (c *Context) Html(template_name string, data map[string]any) {
 	}
 	data["Request"] = c.Request
 	data["Logs"] = settings.Config.Logs
+	data["CSPNonce"] = secure.Nonce(c.Request)
 	user, ok := c.User()
 	if ok {
 		data["IsAuthenticated"] = true
@@ -145,18 +164,17 @@ func (c *Context) Html(template_name string, data map[string]any) {
 	logger.CheckError(err)
 }
 
-// StreamResponse send SSE Streaming Response
-func (c *Context) StreamResponse(response string) error {
-	c.SetHeader("Content-Type", "text/event-stream")
-	b := strings.Builder{}
-	b.WriteString("data: ")
-	b.WriteString(response)
-	b.WriteString("\n\n")
-	_, err := c.ResponseWriter.Write([]byte(b.String()))
-	if err != nil {
-		return err
+// SSE return the server sent events writer of the request, created once per Context
+func (c *Context) SSE() *sse.Writer {
+	if c.sse == nil {
+		c.sse = sse.NewWriter(c.ResponseWriter, c.Request)
 	}
-	return nil
+	return c.sse
+}
+
+// StreamResponse send SSE Streaming Response, data only, use c.SSE() to send id, event and retry fields
+func (c *Context) StreamResponse(response string) error {
+	return c.SSE().Data(response)
 }
 
 // BodyJson get json body from request and return map
@@ -206,16 +224,24 @@ func (c *Context) ServeEmbededFile(content_type string, embed_file []byte) {
 	_, _ = c.ResponseWriter.Write(embed_file)
 }
 
+// ParseMultipartForm parse the multipart body, keeping size bytes in memory, 32MB by default.
+// The body is limited to MultipartSize, or to the MaxBodySize of the route if set using Limits
 func (c *Context) ParseMultipartForm(size ...int64) (formData url.Values, formFiles map[string][]*multipart.FileHeader) {
 	s := int64(32 << 20)
 	if len(size) > 0 {
 		s = size[0]
 	}
 	r := c.Request
+	if _, limited := r.Context().Value(maxBodyKey).(int64); !limited && MultipartSize > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(c.ResponseWriter, r.Body, int64(MultipartSize))
+	}
 	parseErr := r.ParseMultipartForm(s)
 	if parseErr != nil {
 		logger.Error("ParseMultipartForm error = ", parseErr)
 	}
+	if r.MultipartForm == nil {
+		return r.Form, map[string][]*multipart.FileHeader{}
+	}
 	defer func() {
 		err := r.MultipartForm.RemoveAll()
 		logger.CheckError(err)
@@ -338,10 +364,81 @@ func (c *Context) DeleteFile(path string) error {
 
 // Download download data_bytes(content) asFilename(test.json,data.csv,...) to the client
 func (c *Context) Download(data_bytes []byte, asFilename string) {
-	bytesReader := bytes.NewReader(data_bytes)
-	c.SetHeader("Content-Disposition", "attachment; filename="+strconv.Quote(asFilename))
-	c.SetHeader("Content-Type", c.Request.Header.Get("Content-Type"))
-	io.Copy(c.ResponseWriter, bytesReader)
+	c.DownloadReader(bytes.NewReader(data_bytes), asFilename, time.Time{})
+}
+
+// DownloadReader stream rs to the client as an attachment asFilename, Range, If-Range and conditional headers are handled,
+// Content-Type is detected from asFilename extension or sniffed from content if not already set
+func (c *Context) DownloadReader(rs io.ReadSeeker, asFilename string, modtime time.Time) {
+	c.SetHeader("Content-Disposition", contentDisposition("attachment", asFilename))
+	http.ServeContent(c.ResponseWriter, c.Request, asFilename, modtime, rs)
+}
+
+// DownloadFile stream the file at path to the client as an attachment, asFilename default to the base name of path
+func (c *Context) DownloadFile(path string, asFilename ...string) {
+	f, err := os.Open(path)
+	if err != nil {
+		c.Status(http.StatusNotFound).Text("file not found")
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		c.Status(http.StatusNotFound).Text("file not found")
+		return
+	}
+	name := filepath.Base(path)
+	if len(asFilename) > 0 && asFilename[0] != "" {
+		name = asFilename[0]
+	}
+	if c.ResponseWriter.Header().Get("Etag") == "" {
+		c.SetHeader("Etag", fmt.Sprintf(`W/"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()))
+	}
+	c.DownloadReader(f, name, stat.ModTime())
+}
+
+// contentDisposition build a Content-Disposition header value following RFC 6266,
+// with an ascii fallback filename and an UTF-8 encoded filename* when needed
+func contentDisposition(dispType, filename string) string {
+	fallback := strings.Builder{}
+	needExt := false
+	for _, r := range filename {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fallback.WriteByte('_')
+			needExt = true
+		case r > 0x7e:
+			fallback.WriteByte('_')
+			needExt = true
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	v := dispType + `; filename="` + fallback.String() + `"`
+	if needExt {
+		v += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return v
+}
+
+// encodeRFC5987 percent encode s keeping only attr-char as defined in RFC 5987
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || strings.IndexByte("!#$&+-.^_`|~", ch) >= 0 {
+			b.WriteByte(ch)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[ch>>4])
+		b.WriteByte(hex[ch&0x0f])
+	}
+	return b.String()
 }
 
 // EnableTranslations get user ip, then location country using nmap, so don't use it if u don't have it install, and then it parse csv file to find the language spoken in this country, to finaly set cookie 'lang' to 'en' or 'fr'...
@@ -359,13 +456,13 @@ func (c *Context) EnableTranslations() {
 	}
 }
 
+// GetUserIP return the ip of the client, forwarded headers are only read from ratelimiter.TRUSTED_PROXIES
 func (c *Context) GetUserIP() string {
-	IPAddress := c.Request.Header.Get("X-Real-Ip")
-	if IPAddress == "" {
-		IPAddress = c.Request.Header.Get("X-Forwarded-For")
-	}
-	if IPAddress == "" {
-		IPAddress = c.Request.RemoteAddr
-	}
-	return IPAddress
+	return ratelimiter.IP(c.Request)
+}
+
+// HasPermission report if the user of the request have all codenames, like "orders.change", use it after Auth or Admin
+func (c *Context) HasPermission(codenames ...string) bool {
+	user, ok := c.User()
+	return ok && perms.Has(user, codenames...)
 }
@@ -0,0 +1,94 @@
+package kamux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name, filename, want string
+	}{
+		{"ascii", "report.csv", `attachment; filename="report.csv"`},
+		{"quotes", `say "hi"\.txt`, `attachment; filename="say \"hi\"\\.txt"`},
+		{"crlf", "a\r\nContent-Type: text/html.txt", `attachment; filename="a__Content-Type: text/html.txt"; filename*=UTF-8''a%0D%0AContent-Type%3A%20text%2Fhtml.txt`},
+		{"non ascii", "résumé 2024.pdf", `attachment; filename="r_sum_ 2024.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9%202024.pdf`},
+		{"emoji", "😀.png", `attachment; filename="_.png"; filename*=UTF-8''%F0%9F%98%80.png`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := contentDisposition("attachment", tt.filename)
+			if got != tt.want {
+				t.Fatalf("got  %s\nwant %s", got, tt.want)
+			}
+			if strings.ContainsAny(got, "\r\n") {
+				t.Fatal("line break in header", got)
+			}
+		})
+	}
+}
+
+func TestEncodeRFC5987(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"abcXYZ019", "abcXYZ019"},
+		{"!#$&+-.^_`|~", "!#$&+-.^_`|~"},
+		{"a b", "a%20b"},
+		{`"'%*;,/\`, "%22%27%25%2A%3B%2C%2F%5C"},
+		{"é", "%C3%A9"},
+		{"\r\n", "%0D%0A"},
+	}
+	for _, tt := range tests {
+		if got := encodeRFC5987(tt.in); got != tt.want {
+			t.Errorf("encodeRFC5987(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadReader(t *testing.T) {
+	const content = "hello world"
+	modtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		etag    string
+		status  int
+		body    string
+	}{
+		{"full", nil, "", http.StatusOK, content},
+		{"range", map[string]string{"Range": "bytes=0-4"}, "", http.StatusPartialContent, "hello"},
+		{"suffix range", map[string]string{"Range": "bytes=-5"}, "", http.StatusPartialContent, "world"},
+		{"if-range date", map[string]string{"Range": "bytes=6-", "If-Range": modtime.Format(http.TimeFormat)}, "", http.StatusPartialContent, "world"},
+		{"if-range stale date", map[string]string{"Range": "bytes=6-", "If-Range": modtime.Add(-time.Hour).Format(http.TimeFormat)}, "", http.StatusOK, content},
+		{"if-range etag", map[string]string{"Range": "bytes=0-4", "If-Range": `"v1"`}, `"v1"`, http.StatusPartialContent, "hello"},
+		{"if-range stale etag", map[string]string{"Range": "bytes=0-4", "If-Range": `"v0"`}, `"v1"`, http.StatusOK, content},
+		{"unsatisfiable", map[string]string{"Range": "bytes=100-"}, "", http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/download", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			if tt.etag != "" {
+				w.Header().Set("Etag", tt.etag)
+			}
+			c := &Context{ResponseWriter: w, Request: r, Params: map[string]string{}}
+			c.DownloadReader(strings.NewReader(content), "hello.txt", modtime)
+			if w.Code != tt.status {
+				t.Fatal("status", w.Code, w.Body.String())
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Fatal("body", w.Body.String())
+			}
+			if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="hello.txt"` {
+				t.Fatal("Content-Disposition", got)
+			}
+		})
+	}
+}
@@ -24,6 +24,10 @@ func init() {
 
 // SetCookie set cookie given key and value
 func (c *Context) SetCookie(key, value string) {
+	c.setCookie(key, value, COOKIES_Expires)
+}
+
+func (c *Context) setCookie(key, value string, expires time.Duration) {
 	if !COOKIES_Secure {
 		if c.Request.TLS != nil {
 			COOKIES_Secure=true
@@ -33,11 +37,11 @@ func (c *Context) SetCookie(key, value string) {
 		Name:     key,
 		Value:    value,
 		Path:     "/",
-		Expires:  time.Now().Add(COOKIES_Expires),
+		Expires:  time.Now().Add(expires),
 		HttpOnly: COOKIES_HttpOnly,
 		SameSite: COOKIES_SameSite,
 		Secure: COOKIES_Secure,
-		MaxAge: int(COOKIES_Expires.Seconds()),
+		MaxAge: int(expires.Seconds()),
 	})
 }
 
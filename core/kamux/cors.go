@@ -0,0 +1,236 @@
+package kamux
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/utils"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+// CorsPolicy allow cross origin requests, attach it using UseCors, Cors or Group.Cors.
+// Only the matching origin is sent back, never the list of origins
+type CorsPolicy struct {
+	// Origins allowed: "*", "https://app.example.com", "app.example.com" or "*.example.com", "*" is ignored with Credentials
+	Origins []string
+	// Methods allowed, GET, HEAD, POST, PUT, PATCH and DELETE by default
+	Methods []string
+	// Headers the requests can send, all the headers asked by the preflight if empty, or only corsSafeHeaders with Credentials
+	Headers []string
+	// ExposedHeaders the browser let scripts read from the responses
+	ExposedHeaders []string
+	// Credentials allow cookies and authorization headers, the origins must then be listed
+	Credentials bool
+	// MaxAge let browsers cache preflight answers
+	MaxAge time.Duration
+}
+
+var corsDefaultMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// corsSafeHeaders are the headers allowed by credentialed policies without Headers
+var corsSafeHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"}
+
+type corsRoute struct {
+	pattern *regexp.Regexp
+	policy  *CorsPolicy
+}
+
+const corsKey utils.ContextKey = "cors"
+
+func (p *CorsPolicy) methods() []string {
+	if len(p.Methods) == 0 {
+		return corsDefaultMethods
+	}
+	return p.Methods
+}
+
+func (p *CorsPolicy) allowsOrigin(origin string, r *http.Request) bool {
+	if !p.Credentials {
+		return AllowOrigins(p.Origins...)(origin, r)
+	}
+	// with "*" any site could read the responses of the logged in users
+	return p.listsOrigin(origin, r)
+}
+
+// listsOrigin report if origin match one of the origins other than "*"
+func (p *CorsPolicy) listsOrigin(origin string, r *http.Request) bool {
+	origins := make([]string, 0, len(p.Origins))
+	for _, o := range p.Origins {
+		if o != "*" {
+			origins = append(origins, o)
+		}
+	}
+	return len(origins) > 0 && AllowOrigins(origins...)(origin, r)
+}
+
+func (p *CorsPolicy) headers() []string {
+	if len(p.Headers) == 0 && p.Credentials {
+		return corsSafeHeaders
+	}
+	return p.Headers
+}
+
+// check warn about policies that can't work as written
+func (p *CorsPolicy) check() {
+	if p != nil && p.Credentials && p.anyOrigin() {
+		logger.Error("CorsPolicy: \"*\" origins are ignored with Credentials, list the allowed origins")
+	}
+}
+
+func (p *CorsPolicy) anyOrigin() bool {
+	for _, o := range p.Origins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Apply set the cors headers of the response to r and answer preflight requests,
+// handled is true when the response is written, allowed when the origin is accepted
+func (p *CorsPolicy) Apply(w http.ResponseWriter, r *http.Request) (handled bool, allowed bool) {
+	origin := r.Header.Get("Origin")
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	preflight := isPreflight(r)
+	if preflight {
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+	}
+	if origin == "" {
+		return false, false
+	}
+	if !p.allowsOrigin(origin, r) {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+			return true, false
+		}
+		return false, false
+	}
+	if p.anyOrigin() && !p.Credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if len(p.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+		}
+		return false, true
+	}
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !utils.SliceContains(p.methods(), method) {
+		w.WriteHeader(http.StatusForbidden)
+		return true, false
+	}
+	requested := []string{}
+	for _, v := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			requested = append(requested, v)
+		}
+	}
+	if headers := p.headers(); len(headers) > 0 {
+		for _, req := range requested {
+			ok := false
+			for _, allowed := range headers {
+				if strings.EqualFold(req, allowed) {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				w.WriteHeader(http.StatusForbidden)
+				return true, false
+			}
+		}
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(p.methods(), ", "))
+	if len(requested) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true, true
+}
+
+// Middleware apply the policy to all requests of next, to use with handlers outside the router
+func (p *CorsPolicy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handled, _ := p.Apply(w, r); !handled {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// UseCors apply policy to all routes, routes having their own policy using Cors use it instead
+func (router *Router) UseCors(policy *CorsPolicy) {
+	policy.check()
+	router.cors = policy
+}
+
+// Cors apply policy to the routes matching patterns, like "/api/users/id:int", or "/api/*" for all the routes starting with /api/.
+// Preflight requests are answered by the router, so there is no need to handle OPTIONS
+func (router *Router) Cors(policy *CorsPolicy, patterns ...string) {
+	policy.check()
+	for _, pattern := range patterns {
+		var re *regexp.Regexp
+		if strings.HasSuffix(pattern, "*") {
+			re = regexp.MustCompile("^" + regexp.QuoteMeta(strings.TrimSuffix(pattern, "*")))
+		} else {
+			re = regexp.MustCompile(adaptParams(pattern))
+		}
+		router.corsRoutes = append(router.corsRoutes, corsRoute{pattern: re, policy: policy})
+	}
+}
+
+// corsPolicy return the policy of path, the one of the longest matching pattern, or the global one
+func (router *Router) corsPolicy(path string) *CorsPolicy {
+	var found *CorsPolicy
+	longest := -1
+	for _, cr := range router.corsRoutes {
+		if cr.pattern.MatchString(path) && len(cr.pattern.String()) > longest {
+			found, longest = cr.policy, len(cr.pattern.String())
+		}
+	}
+	if found != nil {
+		return found
+	}
+	return router.cors
+}
+
+// applyCors run the policy of r, it return false if the response is already written
+func (router *Router) applyCors(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	policy := router.corsPolicy(r.URL.Path)
+	if policy == nil {
+		return r, true
+	}
+	handled, allowed := policy.Apply(w, r)
+	if handled {
+		return r, false
+	}
+	// "*" only let other sites in without cookies, those carrying the session still need a listed origin
+	if allowed && (r.Header.Get("Cookie") == "" || policy.listsOrigin(r.Header.Get("Origin"), r)) {
+		r = r.WithContext(context.WithValue(r.Context(), corsKey, true))
+	}
+	return r, true
+}
+
+// corsAllowed report if the origin of the request was accepted by a cors policy, unsafe methods then skip the same site check
+func corsAllowed(r *http.Request) bool {
+	ok, _ := r.Context().Value(corsKey).(bool)
+	return ok
+}
@@ -0,0 +1,131 @@
+package kamux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCorsPolicy(t *testing.T) {
+	p := &CorsPolicy{
+		Origins:        []string{"https://app.example.com", "*.example.org"},
+		Methods:        []string{"GET", "POST"},
+		Headers:        []string{"Content-Type", "X-CSRF-Token"},
+		ExposedHeaders: []string{"X-Total"},
+		Credentials:    true,
+		MaxAge:         time.Hour,
+	}
+	do := func(method, origin string, headers map[string]string) (*httptest.ResponseRecorder, bool, bool) {
+		r := httptest.NewRequest(method, "http://api.example.com/users", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handled, allowed := p.Apply(w, r)
+		return w, handled, allowed
+	}
+
+	w, handled, allowed := do("GET", "https://sub.example.org", nil)
+	if handled || !allowed || w.Header().Get("Access-Control-Allow-Origin") != "https://sub.example.org" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Access-Control-Expose-Headers") != "X-Total" ||
+		w.Header().Get("Vary") != "Origin" {
+		t.Fatal(w.Header())
+	}
+	if w, _, allowed := do("GET", "https://evil.com", nil); allowed || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("other origin allowed", w.Header())
+	}
+
+	w, handled, allowed = do("OPTIONS", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "content-type, x-csrf-token",
+	})
+	if !handled || !allowed || w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "GET, POST" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "content-type, x-csrf-token" || w.Header().Get("Access-Control-Max-Age") != "3600" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if w, handled, _ := do("OPTIONS", "https://app.example.com", map[string]string{"Access-Control-Request-Method": "DELETE"}); !handled || w.Code != http.StatusForbidden {
+		t.Fatal("method not allowed accepted", w.Code)
+	}
+	if w, handled, _ := do("OPTIONS", "https://app.example.com", map[string]string{"Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "X-Other"}); !handled || w.Code != http.StatusForbidden {
+		t.Fatal("header not allowed accepted", w.Code)
+	}
+	// a plain OPTIONS is not a preflight and reach the route
+	if _, handled, _ := do("OPTIONS", "https://app.example.com", nil); handled {
+		t.Fatal("plain options answered")
+	}
+
+	wildcard := &CorsPolicy{Origins: []string{"*"}}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://x.com")
+	w = httptest.NewRecorder()
+	wildcard.Apply(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatal(w.Header())
+	}
+
+	// credentials need listed origins, and only allow safe headers when none are given
+	credentialed := &CorsPolicy{Origins: []string{"*", "https://app.example.com"}, Credentials: true}
+	w = httptest.NewRecorder()
+	if _, allowed := credentialed.Apply(w, r); allowed || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("any origin allowed with credentials", w.Header())
+	}
+	preflight := func(headers string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("OPTIONS", "/", nil)
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		r.Header.Set("Access-Control-Request-Headers", headers)
+		w := httptest.NewRecorder()
+		credentialed.Apply(w, r)
+		return w
+	}
+	if w := preflight("content-type"); w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatal("safe header refused", w.Code, w.Header())
+	}
+	if w := preflight("content-type, x-admin"); w.Code != http.StatusForbidden {
+		t.Fatal("requested header echoed", w.Code, w.Header())
+	}
+}
+
+func TestRouterCors(t *testing.T) {
+	router := &Router{Routes: map[int][]Route{}, DefaultRoute: func(c *Context) { c.Status(404).Text("") }}
+	api := router.Group("/api")
+	api.POST("/users", func(c *Context) { c.Text("created") })
+	api.Cors(&CorsPolicy{Origins: []string{"https://app.example.com"}})
+
+	r := httptest.NewRequest("OPTIONS", "http://api.example.com/api/users", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Fatal("preflight", w.Code, w.Header())
+	}
+
+	r = httptest.NewRequest("POST", "http://api.example.com/api/users", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "created" {
+		t.Fatal("cross origin post", w.Code, w.Body.String())
+	}
+
+	// a "*" policy don't let other sites post with the cookies of the user
+	router.POST("/comments", func(c *Context) { c.Text("posted") })
+	router.Cors(&CorsPolicy{Origins: []string{"*"}}, "/comments")
+	for cookie, want := range map[string]int{"": http.StatusOK, "session=x": http.StatusBadRequest} {
+		r = httptest.NewRequest("POST", "http://api.example.com/comments", nil)
+		r.Header.Set("Origin", "https://evil.com")
+		if cookie != "" {
+			r.Header.Set("Cookie", cookie)
+		}
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Fatalf("cookie %q: %d %s, want %d", cookie, w.Code, w.Body.String(), want)
+		}
+	}
+}
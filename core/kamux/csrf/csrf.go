@@ -1,107 +1,262 @@
+// Package csrf protect unsafe requests using signed double submit tokens bound to the session.
+// The token is set in the COOKIE on safe requests, and must come back on unsafe ones in the HEADER or the FIELD of a form.
+// It's signed with the session id, so a token planted by another site or left by a previous session is refused,
+// and the Origin or Referer of unsafe requests must be the host itself or one of TRUSTED_ORIGINS
 package csrf
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/kamalshkeir/kago/core/settings"
 	"github.com/kamalshkeir/kago/core/utils"
-	"github.com/kamalshkeir/kago/core/utils/eventbus"
-	"github.com/kamalshkeir/kago/core/utils/safemap"
 )
 
-var Used bool
-var Csrf_rand = utils.GenerateRandomString(20)
-var CSRF_CLEAN_EVERY = 20 * time.Minute
-var CSRF_TIMEOUT_RETRY = 20
-var Csrf_tokens = safemap.New[string, Token]()
-var onc sync.Once
+var (
+	COOKIE = "csrf_token"
+	HEADER = "X-CSRF-Token"
+	FIELD  = "csrf_token"
+	// MAX_AGE is the lifetime of a token, a new one is set on the next safe request
+	MAX_AGE = 12 * time.Hour
+	// TRUSTED_ORIGINS can send unsafe requests besides the host itself, like "https://app.example.com" or "https://*.example.com"
+	TRUSTED_ORIGINS = []string{}
+	// SessionID return the session tokens are bound to, kamux set it to read its session cookie
+	SessionID = func(r *http.Request) string {
+		if c, err := r.Cookie("session"); err == nil {
+			return c.Value
+		}
+		return ""
+	}
+)
 
-type Token struct {
-	Used    bool
-	Retry   int
-	Value   string
-	Remote  string
-	Created time.Time
+const ctxKey utils.ContextKey = "csrf"
+
+var (
+	exempt   = map[string]bool{}
+	prefixes = []string{}
+	exemptMu sync.RWMutex
+)
+
+// Exempt skip the check for paths, like webhooks authenticated by a signature, a path ending with * exempt its prefix
+func Exempt(paths ...string) {
+	exemptMu.Lock()
+	defer exemptMu.Unlock()
+	for _, p := range paths {
+		if strings.HasSuffix(p, "*") {
+			prefixes = append(prefixes, strings.TrimSuffix(p, "*"))
+		} else {
+			exempt[p] = true
+		}
+	}
 }
 
-var CSRF = func(handler http.Handler) http.Handler {
-	onc.Do(func() {
-		if !Used {
-			i := time.Now()
-			eventbus.Subscribe("csrf-clean", func(data string) {
-				if data != "" {
-					Csrf_tokens.Delete(data)
-				}
-				if time.Since(i) > time.Hour {
-					Csrf_tokens.Flush()
-					i = time.Now()
-				}
-			})
-			Used = true
+// IsExempt report if path is exempted
+func IsExempt(path string) bool {
+	exemptMu.RLock()
+	defer exemptMu.RUnlock()
+	if exempt[path] {
+		return true
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
 		}
-	})
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case "GET":
-			token := r.Header.Get("X-CSRF-Token")
-			tok, ok := Csrf_tokens.Get(token)
-
-			if !ok || token == "" || (tok.Used && (tok.Retry > CSRF_TIMEOUT_RETRY || time.Since(tok.Created) > CSRF_CLEAN_EVERY)) {
-				t := Csrf_rand
-				Csrf_tokens.Set(t, Token{
-					Value:   t,
-					Used:    false,
-					Retry:   0,
-					Remote:  r.UserAgent(),
-					Created: time.Now(),
-				})
-				http.SetCookie(w, &http.Cookie{
-					Name:     "csrf_token",
-					Value:    t,
-					Path:     "/",
-					Expires:  time.Now().Add(5 * time.Minute),
-					SameSite: http.SameSiteStrictMode,
-				})
-			} else {
-				if token != tok.Value {
-					http.SetCookie(w, &http.Cookie{
-						Name:     "csrf_token",
-						Value:    tok.Value,
-						Path:     "/",
-						Expires:  time.Now().Add(5 * time.Minute),
-						SameSite: http.SameSiteStrictMode,
-					})
-				}
-			}
+	}
+	return false
+}
 
-			handler.ServeHTTP(w, r)
-			return
+var (
+	key     []byte
+	keyOnce sync.Once
+)
 
-		case "POST", "PATCH", "PUT", "UPDATE", "DELETE":
-			token := r.Header.Get("X-CSRF-Token")
-			tok, ok := Csrf_tokens.Get(token)
-			if !ok || token == "" || (tok.Used && (tok.Retry > CSRF_TIMEOUT_RETRY || time.Since(tok.Created) > CSRF_CLEAN_EVERY)) {
-				eventbus.Publish("csrf-clean", tok.Value)
-				Csrf_rand=utils.GenerateRandomString(20)
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(map[string]any{
-					"error": "CSRF not allowed",
-				})
-				return
-			}
-
-			Csrf_tokens.Set(tok.Value, Token{
-				Value:   tok.Value,
-				Used:    true,
-				Retry:   tok.Retry + 1,
-				Remote:  r.UserAgent(),
-				Created: tok.Created,
-			})
-			handler.ServeHTTP(w, r)
+// signingKey derive from settings.Secret, shared by all instances, or is random for this process if not set
+func signingKey() []byte {
+	keyOnce.Do(func() {
+		if settings.Secret != "" {
+			h := sha256.Sum256([]byte("csrf:" + settings.Secret))
+			key = h[:]
 			return
-		default:
+		}
+		key = make([]byte, 32)
+		_, _ = rand.Read(key)
+	})
+	return key
+}
+
+const (
+	nonceLen = 16
+	tsLen    = 8
+	macLen   = sha256.Size
+)
+
+func sign(payload []byte, sessionId string) []byte {
+	mac := hmac.New(sha256.New, signingKey())
+	mac.Write(payload)
+	mac.Write([]byte(sessionId))
+	return mac.Sum(nil)
+}
+
+// NewToken return a token bound to sessionId
+func NewToken(sessionId string) string {
+	b := make([]byte, nonceLen+tsLen, nonceLen+tsLen+macLen)
+	_, _ = rand.Read(b[:nonceLen])
+	binary.BigEndian.PutUint64(b[nonceLen:], uint64(time.Now().Unix()))
+	b = append(b, sign(b, sessionId)...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Valid report if token was issued for sessionId and is not older than MAX_AGE
+func Valid(token, sessionId string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != nonceLen+tsLen+macLen {
+		return false
+	}
+	payload := b[:nonceLen+tsLen]
+	if !hmac.Equal(b[nonceLen+tsLen:], sign(payload, sessionId)) {
+		return false
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(payload[nonceLen:])), 0)
+	return time.Since(issued) < MAX_AGE
+}
+
+// Token return the token of the request, to render in forms or pages, see the template function csrf_token
+func Token(r *http.Request) string {
+	if t, ok := r.Context().Value(ctxKey).(string); ok {
+		return t
+	}
+	if c, err := r.Cookie(COOKIE); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// Refresh set a new token bound to sessionId, kamux call it when the session cookie change.
+// It does nothing if the request was not checked by CSRF or Csrf
+func Refresh(w http.ResponseWriter, r *http.Request, sessionId string) *http.Request {
+	if _, ok := r.Context().Value(ctxKey).(string); !ok {
+		return r
+	}
+	return issue(w, r, sessionId)
+}
+
+func issue(w http.ResponseWriter, r *http.Request, sessionId string) *http.Request {
+	t := NewToken(sessionId)
+	http.SetCookie(w, &http.Cookie{
+		Name:     COOKIE,
+		Value:    t,
+		Path:     "/",
+		MaxAge:   int(MAX_AGE / time.Second),
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return r.WithContext(context.WithValue(r.Context(), ctxKey, t))
+}
+
+func safe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func trusted(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, t := range TRUSTED_ORIGINS {
+		tu, err := url.Parse(t)
+		if err != nil || tu.Scheme != u.Scheme {
+			continue
+		}
+		if strings.EqualFold(tu.Host, u.Host) {
+			return true
+		}
+		if strings.HasPrefix(tu.Host, "*.") && strings.HasSuffix(strings.ToLower(u.Host), strings.ToLower(tu.Host[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin check the Origin, or the Referer when the browser don't send it, requests having neither rely on the token only
+func sameOrigin(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin != "null" && trusted(origin, r)
+	}
+	if ref := r.Header.Get("Referer"); ref != "" {
+		return trusted(ref, r)
+	}
+	return true
+}
+
+func submitted(r *http.Request) string {
+	if t := r.Header.Get(HEADER); t != "" {
+		return t
+	}
+	ct := r.Header.Get("Content-Type")
+	if strings.HasPrefix(ct, "application/x-www-form-urlencoded") || strings.HasPrefix(ct, "multipart/form-data") {
+		return r.PostFormValue(FIELD)
+	}
+	return ""
+}
+
+// REJECT answer refused requests
+var REJECT = func(w http.ResponseWriter, r *http.Request, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":  "CSRF not allowed",
+		"reason": reason,
+	})
+}
+
+// Check set the token on safe requests and verify it on unsafe ones, it return the request to continue with,
+// or false after answering with REJECT
+func Check(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	sid := SessionID(r)
+	cookie := ""
+	if c, err := r.Cookie(COOKIE); err == nil {
+		cookie = c.Value
+	}
+	if safe(r.Method) || IsExempt(r.URL.Path) {
+		if cookie != "" && Valid(cookie, sid) {
+			return r.WithContext(context.WithValue(r.Context(), ctxKey, cookie)), true
+		}
+		return issue(w, r, sid), true
+	}
+	if !sameOrigin(r) {
+		REJECT(w, r, "origin not allowed")
+		return r, false
+	}
+	sent := submitted(r)
+	if cookie == "" || sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(cookie)) != 1 || !Valid(cookie, sid) {
+		REJECT(w, r, "invalid or missing token")
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), ctxKey, cookie)), true
+}
+
+// CSRF protect all the routes of handler
+var CSRF = func(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r, ok := Check(w, r); ok {
 			handler.ServeHTTP(w, r)
 		}
 	})
@@ -0,0 +1,70 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	h := CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(Token(r)))
+	}))
+	do := func(r *http.Request, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	session := &http.Cookie{Name: "session", Value: "s1"}
+
+	w := do(httptest.NewRequest("GET", "http://example.com/", nil), session)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != COOKIE || w.Body.String() != cookies[0].Value {
+		t.Fatal("no token set", cookies)
+	}
+	token := cookies[0]
+	if w := do(httptest.NewRequest("GET", "http://example.com/", nil), session, token); len(w.Result().Cookies()) != 0 {
+		t.Fatal("valid token replaced")
+	}
+
+	if w := do(httptest.NewRequest("POST", "http://example.com/", nil), session, token); w.Code != http.StatusForbidden {
+		t.Fatal("post without token", w.Code)
+	}
+	r := httptest.NewRequest("POST", "http://example.com/", nil)
+	r.Header.Set(HEADER, token.Value)
+	if w := do(r, session, token); w.Code != 200 {
+		t.Fatal("header refused", w.Code, w.Body.String())
+	}
+	r = httptest.NewRequest("POST", "http://example.com/", strings.NewReader(url.Values{FIELD: {token.Value}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := do(r, session, token); w.Code != 200 {
+		t.Fatal("form field refused", w.Code, w.Body.String())
+	}
+	r = httptest.NewRequest("POST", "http://example.com/", nil)
+	r.Header.Set(HEADER, token.Value)
+	if w := do(r, &http.Cookie{Name: "session", Value: "s2"}, token); w.Code != http.StatusForbidden {
+		t.Fatal("token of another session accepted")
+	}
+	r = httptest.NewRequest("POST", "http://example.com/", nil)
+	r.Header.Set(HEADER, token.Value)
+	r.Header.Set("Origin", "https://evil.com")
+	if w := do(r, session, token); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "origin") {
+		t.Fatal("other origin accepted", w.Code)
+	}
+	TRUSTED_ORIGINS = []string{"https://*.example.org"}
+	defer func() { TRUSTED_ORIGINS = nil }()
+	r.Header.Set("Origin", "https://app.example.org")
+	if w := do(r, session, token); w.Code != 200 {
+		t.Fatal("trusted origin refused", w.Code)
+	}
+
+	Exempt("/hooks/*")
+	if w := do(httptest.NewRequest("POST", "http://example.com/hooks/stripe", nil)); w.Code != 200 {
+		t.Fatal("exempted path refused", w.Code)
+	}
+}
@@ -0,0 +1,81 @@
+package kamux
+
+// Group register routes sharing a prefix and middlewares, ex:
+//
+//	api := app.Group("/api", kamux.Auth)
+//	api.GET("/users", UsersView) // /api/users, using Auth
+type Group struct {
+	router      *Router
+	prefix      string
+	middlewares []func(Handler) Handler
+}
+
+// Group return a group of routes prefixed by prefix, wrapped by middlewares, the first one being the outermost
+func (router *Router) Group(prefix string, middlewares ...func(Handler) Handler) *Group {
+	return &Group{router: router, prefix: prefix, middlewares: middlewares}
+}
+
+// Group return a sub group, using the middlewares of g then middlewares
+func (g *Group) Group(prefix string, middlewares ...func(Handler) Handler) *Group {
+	mws := append(append([]func(Handler) Handler{}, g.middlewares...), middlewares...)
+	return &Group{router: g.router, prefix: g.prefix + prefix, middlewares: mws}
+}
+
+// Use add middlewares to the routes registered after
+func (g *Group) Use(middlewares ...func(Handler) Handler) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// Cors apply policy to all the routes of the group
+func (g *Group) Cors(policy *CorsPolicy) {
+	g.router.Cors(policy, g.prefix+"*")
+}
+
+// Prefix return the prefix of the routes of g
+func (g *Group) Prefix() string {
+	return g.prefix
+}
+
+func (g *Group) wrap(handler Handler) Handler {
+	for i := len(g.middlewares) - 1; i >= 0; i-- {
+		handler = g.middlewares[i](handler)
+	}
+	return handler
+}
+
+func (g *Group) GET(pattern string, handler Handler) {
+	g.router.GET(g.prefix+pattern, g.wrap(handler))
+}
+
+func (g *Group) POST(pattern string, handler Handler, allowed_origines ...string) {
+	g.router.POST(g.prefix+pattern, g.wrap(handler), allowed_origines...)
+}
+
+func (g *Group) PUT(pattern string, handler Handler, allowed_origines ...string) {
+	g.router.PUT(g.prefix+pattern, g.wrap(handler), allowed_origines...)
+}
+
+func (g *Group) PATCH(pattern string, handler Handler, allowed_origines ...string) {
+	g.router.PATCH(g.prefix+pattern, g.wrap(handler), allowed_origines...)
+}
+
+func (g *Group) DELETE(pattern string, handler Handler, allowed_origines ...string) {
+	g.router.DELETE(g.prefix+pattern, g.wrap(handler), allowed_origines...)
+}
+
+func (g *Group) HEAD(pattern string, handler Handler, allowed_origines ...string) {
+	g.router.HEAD(g.prefix+pattern, g.wrap(handler), allowed_origines...)
+}
+
+func (g *Group) OPTIONS(pattern string, handler Handler, allowed_origines ...string) {
+	g.router.OPTIONS(g.prefix+pattern, g.wrap(handler), allowed_origines...)
+}
+
+func (g *Group) SSE(pattern string, handler Handler, allowed_origines ...string) {
+	g.router.SSE(g.prefix+pattern, g.wrap(handler), allowed_origines...)
+}
+
+// Handle handle method, or "*" for all methods, see Router.Handle
+func (g *Group) Handle(method string, pattern string, handler Handler, allowed ...string) {
+	g.router.Handle(method, g.prefix+pattern, g.wrap(handler), allowed...)
+}
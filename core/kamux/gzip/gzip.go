@@ -1,47 +1,176 @@
+// Package gzip compress responses with gzip or deflate, depending on the Accept-Encoding of the request,
+// only when their Content-Type is in TYPES and their body reach MIN_SIZE. Writers are pooled,
+// and Flush compress what was written so far, so server sent events are received as they are sent
 package gzip
 
 import (
 	"bufio"
 	"compress/gzip"
+	"compress/zlib"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 )
 
+var (
+	// MIN_SIZE is the smallest body compressed, smaller ones cost more than they save
+	MIN_SIZE = 1024
+	// LEVEL of compression, gzip.DefaultCompression by default
+	LEVEL = gzip.DefaultCompression
+	// TYPES compressed, "text/" match all text types
+	TYPES = []string{
+		"text/",
+		"application/json",
+		"application/javascript",
+		"application/xml",
+		"application/xhtml+xml",
+		"application/rss+xml",
+		"application/atom+xml",
+		"application/manifest+json",
+		"application/wasm",
+		"image/svg+xml",
+		"font/ttf",
+		"font/otf",
+	}
+)
+
+// Options of a compression middleware, zero values use MIN_SIZE, LEVEL and TYPES
+type Options struct {
+	MinSize int
+	Level   int
+	Types   []string
+}
+
+type compressor struct {
+	opts  Options
+	gzips sync.Pool
+	zlibs sync.Pool
+}
+
+// New return a compression middleware using opts
+func New(opts Options) func(http.Handler) http.Handler {
+	if opts.MinSize <= 0 {
+		opts.MinSize = MIN_SIZE
+	}
+	if opts.Level == 0 {
+		opts.Level = LEVEL
+	}
+	if len(opts.Types) == 0 {
+		opts.Types = TYPES
+	}
+	c := &compressor{opts: opts}
+	c.gzips.New = func() any {
+		w, err := gzip.NewWriterLevel(nil, opts.Level)
+		if err != nil {
+			w = gzip.NewWriter(nil)
+		}
+		return w
+	}
+	c.zlibs.New = func() any {
+		w, err := zlib.NewWriterLevel(nil, opts.Level)
+		if err != nil {
+			w = zlib.NewWriter(nil)
+		}
+		return w
+	}
+	return c.middleware
+}
+
+var global = struct {
+	once sync.Once
+	mw   func(http.Handler) http.Handler
+}{}
+
+// GZIP compress the responses using MIN_SIZE, LEVEL and TYPES, read on the first request
 var GZIP = func(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.URL.Path, "metrics") {
+		global.once.Do(func() {
+			global.mw = New(Options{})
+		})
+		global.mw(handler).ServeHTTP(w, r)
+	})
+}
+
+func (c *compressor) middleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "metrics") || r.Method == http.MethodHead {
 			handler.ServeHTTP(w, r)
 			return
 		}
 		//check if connection is ws
 		for _, header := range r.Header["Upgrade"] {
-			if header == "websocket" {
+			if strings.EqualFold(header, "websocket") {
 				// connection is ws
 				handler.ServeHTTP(w, r)
 				return
 			}
 		}
-		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			gwriter := NewWrappedResponseWriter(w)
-			defer gwriter.Flush()
-			gwriter.Header().Set("Content-Encoding", "gzip")
-			handler.ServeHTTP(gwriter, r)
+		addVary(w.Header())
+		encoding := negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			handler.ServeHTTP(w, r)
 			return
 		}
-		handler.ServeHTTP(w, r)
+		wrw := &WrappedResponseWriter{w: w, c: c, encoding: encoding}
+		defer wrw.Close()
+		handler.ServeHTTP(wrw, r)
 	})
 }
 
-type WrappedResponseWriter struct {
-	w       http.ResponseWriter
-	gwriter *gzip.Writer
+func addVary(h http.Header) {
+	for _, v := range h.Values("Vary") {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// negotiate return gzip or deflate, the one having the best quality, gzip if equal, or empty
+func negotiate(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if f, err := strconv.ParseFloat(params[2:], 64); err == nil {
+				q = f
+			}
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "*" {
+			name = "gzip"
+		}
+		if (name != "gzip" && name != "deflate") || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
 }
 
-func NewWrappedResponseWriter(w http.ResponseWriter) *WrappedResponseWriter {
-	gwriter := gzip.NewWriter(w)
-	return &WrappedResponseWriter{w, gwriter}
+// WrappedResponseWriter buffer the beginning of the body to decide if it's worth compressing
+type WrappedResponseWriter struct {
+	w        http.ResponseWriter
+	c        *compressor
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	cw       flushWriter
 }
 
 func (wrw *WrappedResponseWriter) Header() http.Header {
@@ -49,16 +178,145 @@ func (wrw *WrappedResponseWriter) Header() http.Header {
 }
 
 func (wrw *WrappedResponseWriter) WriteHeader(statuscode int) {
-	wrw.w.WriteHeader(statuscode)
+	if statuscode < 200 {
+		// informational, like 103 Early Hints
+		wrw.w.WriteHeader(statuscode)
+		return
+	}
+	if wrw.status != 0 {
+		return
+	}
+	wrw.status = statuscode
+	if !bodyAllowed(statuscode) {
+		_ = wrw.decide(false)
+	}
 }
 
 func (wrw *WrappedResponseWriter) Write(d []byte) (int, error) {
-	return wrw.gwriter.Write(d)
+	if wrw.status == 0 {
+		wrw.status = http.StatusOK
+	}
+	if !wrw.decided {
+		wrw.buf = append(wrw.buf, d...)
+		if len(wrw.buf) < wrw.c.opts.MinSize {
+			return len(d), nil
+		}
+		if err := wrw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(d), nil
+	}
+	if wrw.cw != nil {
+		return wrw.cw.Write(d)
+	}
+	return wrw.w.Write(d)
 }
 
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// compressible report if the response can be compressed, setting its Content-Type if missing
+func (wrw *WrappedResponseWriter) compressible() bool {
+	h := wrw.w.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" || wrw.status == http.StatusPartialContent || !bodyAllowed(wrw.status) {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		if len(wrw.buf) == 0 {
+			return false
+		}
+		ct = http.DetectContentType(wrw.buf)
+		h.Set("Content-Type", ct)
+	}
+	ct = strings.ToLower(ct)
+	for _, t := range wrw.c.opts.Types {
+		if strings.HasPrefix(ct, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// decide send the headers, compressed if compress and the response allow it, and the buffered body
+func (wrw *WrappedResponseWriter) decide(compress bool) error {
+	if wrw.decided {
+		return nil
+	}
+	wrw.decided = true
+	if wrw.status == 0 {
+		wrw.status = http.StatusOK
+	}
+	if compress && wrw.compressible() {
+		h := wrw.w.Header()
+		h.Set("Content-Encoding", wrw.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		if wrw.encoding == "gzip" {
+			gw := wrw.c.gzips.Get().(*gzip.Writer)
+			gw.Reset(wrw.w)
+			wrw.cw = gw
+		} else {
+			zw := wrw.c.zlibs.Get().(*zlib.Writer)
+			zw.Reset(wrw.w)
+			wrw.cw = zw
+		}
+	}
+	wrw.w.WriteHeader(wrw.status)
+	if len(wrw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if wrw.cw != nil {
+		_, err = wrw.cw.Write(wrw.buf)
+	} else {
+		_, err = wrw.w.Write(wrw.buf)
+	}
+	wrw.buf = nil
+	return err
+}
+
+// Flush send what was written so far, compressing it even under the minimum size, as more is coming
 func (wrw *WrappedResponseWriter) Flush() {
-	wrw.gwriter.Flush()
-	wrw.gwriter.Close()
+	_ = wrw.decide(true)
+	if wrw.cw != nil {
+		_ = wrw.cw.Flush()
+	}
+	if f, ok := wrw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close end the response, the body is compressed only if it reached the minimum size
+func (wrw *WrappedResponseWriter) Close() error {
+	if !wrw.decided {
+		if wrw.status == 0 && len(wrw.buf) == 0 {
+			// nothing written, let the server answer 200 with an empty body
+			wrw.decided = true
+			return nil
+		}
+		_ = wrw.decide(len(wrw.buf) >= wrw.c.opts.MinSize)
+	}
+	if wrw.cw == nil {
+		return nil
+	}
+	err := wrw.cw.Close()
+	switch w := wrw.cw.(type) {
+	case *gzip.Writer:
+		wrw.c.gzips.Put(w)
+	case *zlib.Writer:
+		wrw.c.zlibs.Put(w)
+	}
+	wrw.cw = nil
+	return err
+}
+
+// Unwrap let http.ResponseController and kamux reach the connection, to set its deadlines
+func (wrw *WrappedResponseWriter) Unwrap() http.ResponseWriter {
+	return wrw.w
 }
 
 func (wrw *WrappedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
@@ -0,0 +1,81 @@
+package gzip
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	big := strings.Repeat("kago ", 500)
+	h := New(Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big":
+			w.Header().Set("Content-Length", "2500")
+			_, _ = w.Write([]byte(big))
+		case "/small":
+			_, _ = w.Write([]byte("small"))
+		case "/png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte(big))
+		case "/304":
+			w.WriteHeader(http.StatusNotModified)
+		}
+	}))
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/big", "deflate;q=0.5, gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Content-Length") != "" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatal("bad headers", w.Header())
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(gr); string(b) != big {
+		t.Fatal("bad body")
+	}
+	if w := get("/big", "gzip;q=0.1, deflate"); w.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatal("deflate not chosen", w.Header())
+	}
+	for _, path := range []string{"/small", "/png", "/304"} {
+		if w := get(path, "gzip"); w.Header().Get("Content-Encoding") != "" {
+			t.Fatal(path, "should not be compressed")
+		}
+	}
+	if w := get("/304", "gzip"); w.Code != http.StatusNotModified {
+		t.Fatal("status lost", w.Code)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	h := New(Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("data: 2\n\n"))
+	}))
+	r := httptest.NewRequest("GET", "/sse", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !w.Flushed || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("not flushed", w.Header())
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(gr); string(b) != "data: 1\n\ndata: 2\n\n" {
+		t.Fatal("bad body", string(b))
+	}
+}
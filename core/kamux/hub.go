@@ -0,0 +1,488 @@
+package kamux
+
+import (
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/utils/backplane"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+	"github.com/kamalshkeir/kago/core/utils/websocket"
+)
+
+// SlowClientPolicy decide what happen when a client queue is full
+type SlowClientPolicy uint8
+
+const (
+	// DropClient close the connection of a client that can't keep up
+	DropClient SlowClientPolicy = iota
+	// DropMessage skip the message for this client only
+	DropMessage
+)
+
+var (
+	WS_QUEUE_SIZE  = 256
+	WS_PING_EVERY  = 30 * time.Second
+	WS_PONG_WAIT   = 60 * time.Second
+	WS_WRITE_WAIT  = 10 * time.Second
+	WS_SLOW_POLICY = DropClient
+	// WS_MAX_MESSAGE_SIZE limit the size of received messages, after reassembly and decompression
+	WS_MAX_MESSAGE_SIZE = 10 << 20
+	// WS_COMPRESSION enable permessage-deflate when the client support it, nil to disable
+	WS_COMPRESSION = &websocket.CompressionOptions{
+		Level:                   flate.BestSpeed,
+		Threshold:               512,
+		ServerNoContextTakeover: true,
+	}
+)
+
+var (
+	ErrWsClosed    = errors.New("websocket connection closed")
+	ErrSlowClient  = errors.New("websocket client too slow, message dropped")
+	ErrWsNoSuchKey = errors.New("websocket client not found")
+)
+
+// wsMessage is an already encoded frame waiting in a client queue
+type wsMessage struct {
+	data []byte
+	typ  byte
+}
+
+// frameCodec send wsMessage as is, with its own frame type
+var frameCodec = websocket.Codec{
+	Marshal: func(v any) ([]byte, byte, error) {
+		m := v.(wsMessage)
+		return m.data, m.typ, nil
+	},
+}
+
+// Hub keep track of the connections of a WS route, every connection has its own writer goroutine and bounded queue,
+// so a slow client never block a broadcast
+type Hub struct {
+	QueueSize  int
+	PingEvery  time.Duration
+	PongWait   time.Duration
+	WriteWait  time.Duration
+	SlowPolicy SlowClientPolicy
+	clients    map[*WsClient]struct{}
+	keys       map[string]*WsClient
+	rooms      map[string]map[*WsClient]struct{}
+	bp         backplane.Backplane
+	bpChannel  string
+	bpUnsub    func()
+	mu         sync.RWMutex
+}
+
+// hubRemote is a broadcast, room broadcast, SendTo or Close forwarded to hubs of other instances
+type hubRemote struct {
+	Room  string `json:"room,omitempty"`
+	Key   string `json:"key,omitempty"`
+	Close bool   `json:"close,omitempty"`
+	Typ   byte   `json:"typ,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// WsClient is a connection registered in a Hub
+type WsClient struct {
+	Key    string
+	conn   *websocket.Conn
+	hub    *Hub
+	send   chan wsMessage
+	done   chan struct{}
+	rooms  map[string]struct{}
+	closed bool
+	mu     sync.Mutex
+}
+
+// NewHub create a hub using WS_* package settings
+func NewHub() *Hub {
+	return &Hub{
+		QueueSize:  WS_QUEUE_SIZE,
+		PingEvery:  WS_PING_EVERY,
+		PongWait:   WS_PONG_WAIT,
+		WriteWait:  WS_WRITE_WAIT,
+		SlowPolicy: WS_SLOW_POLICY,
+		clients:    map[*WsClient]struct{}{},
+		keys:       map[string]*WsClient{},
+		rooms:      map[string]map[*WsClient]struct{}{},
+	}
+}
+
+// register add conn to the hub and start its writer
+func (h *Hub) register(conn *websocket.Conn) *WsClient {
+	size := h.QueueSize
+	if size <= 0 {
+		size = WS_QUEUE_SIZE
+	}
+	cl := &WsClient{
+		conn:  conn,
+		hub:   h,
+		send:  make(chan wsMessage, size),
+		done:  make(chan struct{}),
+		rooms: map[string]struct{}{},
+	}
+	if h.PingEvery > 0 && h.PongWait > 0 {
+		// a client not answering pings is dead, its pending read will fail
+		_ = conn.SetReadDeadline(time.Now().Add(h.PongWait))
+		conn.PongHandler = func(string) {
+			_ = conn.SetReadDeadline(time.Now().Add(h.PongWait))
+		}
+	}
+	h.mu.Lock()
+	h.clients[cl] = struct{}{}
+	h.mu.Unlock()
+	go cl.writePump()
+	return cl
+}
+
+// unregister remove cl from the hub and its rooms, queued messages are flushed before returning
+func (h *Hub) unregister(cl *WsClient) {
+	cl.markClosed()
+	h.detach(cl)
+	<-cl.done
+}
+
+func (h *Hub) detach(cl *WsClient) {
+	h.mu.Lock()
+	delete(h.clients, cl)
+	if cl.Key != "" && h.keys[cl.Key] == cl {
+		delete(h.keys, cl.Key)
+	}
+	for room := range cl.rooms {
+		if members, ok := h.rooms[room]; ok {
+			delete(members, cl)
+			if len(members) == 0 {
+				delete(h.rooms, room)
+			}
+		}
+	}
+	h.mu.Unlock()
+}
+
+// setKey name the client, a previous client with the same key is replaced
+func (h *Hub) setKey(cl *WsClient, key string) {
+	h.mu.Lock()
+	if cl.isClosed() {
+		h.mu.Unlock()
+		return
+	}
+	if cl.Key != "" && h.keys[cl.Key] == cl {
+		delete(h.keys, cl.Key)
+	}
+	cl.Key = key
+	h.keys[key] = cl
+	h.mu.Unlock()
+}
+
+// Get return the client added with key
+func (h *Hub) Get(key string) (*WsClient, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	cl, ok := h.keys[key]
+	return cl, ok
+}
+
+// Join add cl to room, closed clients are ignored
+func (h *Hub) Join(cl *WsClient, room string) {
+	h.mu.Lock()
+	// clients are marked closed before being detached, so a closed client can't be added back after its detach
+	if cl.isClosed() {
+		h.mu.Unlock()
+		return
+	}
+	members, ok := h.rooms[room]
+	if !ok {
+		members = map[*WsClient]struct{}{}
+		h.rooms[room] = members
+	}
+	members[cl] = struct{}{}
+	cl.rooms[room] = struct{}{}
+	h.mu.Unlock()
+}
+
+// Leave remove cl from room
+func (h *Hub) Leave(cl *WsClient, room string) {
+	h.mu.Lock()
+	if members, ok := h.rooms[room]; ok {
+		delete(members, cl)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+	delete(cl.rooms, room)
+	h.mu.Unlock()
+}
+
+// Count return the number of connections
+func (h *Hub) Count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// RoomCount return the number of connections in room
+func (h *Hub) RoomCount(room string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[room])
+}
+
+// Rooms return rooms having at least one connection with their count
+func (h *Hub) Rooms() map[string]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	res := make(map[string]int, len(h.rooms))
+	for room, members := range h.rooms {
+		res[room] = len(members)
+	}
+	return res
+}
+
+// Broadcast send data as json to all connections except the ones in except
+func (h *Hub) Broadcast(data any, except ...*WsClient) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	h.broadcast(wsMessage{data: b, typ: websocket.TextFrame}, except)
+	return nil
+}
+
+// BroadcastRoom send data as json to all connections in room except the ones in except
+func (h *Hub) BroadcastRoom(room string, data any, except ...*WsClient) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	h.broadcastRoom(room, wsMessage{data: b, typ: websocket.TextFrame}, except)
+	return nil
+}
+
+func (h *Hub) broadcast(m wsMessage, except []*WsClient) {
+	h.localBroadcast(m, except)
+	h.forward(hubRemote{Typ: m.typ, Data: m.data})
+}
+
+func (h *Hub) broadcastRoom(room string, m wsMessage, except []*WsClient) {
+	h.localBroadcastRoom(room, m, except)
+	h.forward(hubRemote{Room: room, Typ: m.typ, Data: m.data})
+}
+
+func (h *Hub) localBroadcast(m wsMessage, except []*WsClient) {
+	h.mu.RLock()
+	targets := make([]*WsClient, 0, len(h.clients))
+	for cl := range h.clients {
+		targets = append(targets, cl)
+	}
+	h.mu.RUnlock()
+	h.fanout(targets, m, except)
+}
+
+func (h *Hub) localBroadcastRoom(room string, m wsMessage, except []*WsClient) {
+	h.mu.RLock()
+	targets := make([]*WsClient, 0, len(h.rooms[room]))
+	for cl := range h.rooms[room] {
+		targets = append(targets, cl)
+	}
+	h.mu.RUnlock()
+	h.fanout(targets, m, except)
+}
+
+// SendTo send data as json to the client added with key, when using a backplane and the key is not local,
+// it's forwarded to other instances
+func (h *Hub) SendTo(key string, data any) error {
+	cl, ok := h.Get(key)
+	if ok {
+		return cl.Json(data)
+	}
+	if !h.hasBackplane() {
+		return ErrWsNoSuchKey
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	h.forward(hubRemote{Key: key, Typ: websocket.TextFrame, Data: b})
+	return nil
+}
+
+// Close disconnect the client added with key, on any instance when using a backplane
+func (h *Hub) Close(key string) {
+	if cl, ok := h.Get(key); ok {
+		cl.Close()
+		return
+	}
+	h.forward(hubRemote{Key: key, Close: true})
+}
+
+// UseBackplane forward broadcasts to the hubs of other instances subscribed to channel, nil stop forwarding
+func (h *Hub) UseBackplane(bp backplane.Backplane, channel string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.bpUnsub != nil {
+		h.bpUnsub()
+		h.bp, h.bpUnsub = nil, nil
+	}
+	if bp == nil {
+		return nil
+	}
+	unsub, err := bp.Subscribe(channel, h.receive)
+	if err != nil {
+		return err
+	}
+	h.bp, h.bpChannel, h.bpUnsub = bp, channel, unsub
+	return nil
+}
+
+func (h *Hub) hasBackplane() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.bp != nil
+}
+
+func (h *Hub) forward(r hubRemote) {
+	h.mu.RLock()
+	bp, channel := h.bp, h.bpChannel
+	h.mu.RUnlock()
+	if bp == nil {
+		return
+	}
+	b, _ := json.Marshal(r)
+	if err := bp.Publish(channel, b); err != nil {
+		logger.Error("websocket backplane:", err)
+	}
+}
+
+// receive deliver a message forwarded by another instance to local clients only
+func (h *Hub) receive(msg backplane.Message) {
+	var r hubRemote
+	if err := json.Unmarshal(msg.Data, &r); err != nil {
+		return
+	}
+	m := wsMessage{data: r.Data, typ: r.Typ}
+	switch {
+	case r.Key != "":
+		if cl, ok := h.Get(r.Key); ok {
+			if r.Close {
+				cl.Close()
+			} else {
+				_ = cl.enqueue(m)
+			}
+		}
+	case r.Room != "":
+		h.localBroadcastRoom(r.Room, m, nil)
+	default:
+		h.localBroadcast(m, nil)
+	}
+}
+
+func (h *Hub) fanout(targets []*WsClient, m wsMessage, except []*WsClient) {
+loop:
+	for _, cl := range targets {
+		for _, ex := range except {
+			if cl == ex {
+				continue loop
+			}
+		}
+		// errors are per client, one slow client don't stop the broadcast
+		_ = cl.enqueue(m)
+	}
+}
+
+// Json queue data encoded as json
+func (cl *WsClient) Json(data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return cl.enqueue(wsMessage{data: b, typ: websocket.TextFrame})
+}
+
+// Text queue a text message
+func (cl *WsClient) Text(data string) error {
+	return cl.enqueue(wsMessage{data: []byte(data), typ: websocket.TextFrame})
+}
+
+// Binary queue a binary message
+func (cl *WsClient) Binary(data []byte) error {
+	return cl.enqueue(wsMessage{data: data, typ: websocket.BinaryFrame})
+}
+
+// Close disconnect the client, the handler reading from it will get an error
+func (cl *WsClient) Close() {
+	cl.markClosed()
+	cl.hub.detach(cl)
+	_ = cl.conn.Close()
+}
+
+// markClosed stop the client queue, its writer flush what is queued and return
+func (cl *WsClient) markClosed() {
+	cl.mu.Lock()
+	if !cl.closed {
+		cl.closed = true
+		close(cl.send)
+	}
+	cl.mu.Unlock()
+}
+
+func (cl *WsClient) isClosed() bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.closed
+}
+
+func (cl *WsClient) enqueue(m wsMessage) error {
+	cl.mu.Lock()
+	if cl.closed {
+		cl.mu.Unlock()
+		return ErrWsClosed
+	}
+	select {
+	case cl.send <- m:
+		cl.mu.Unlock()
+		return nil
+	default:
+	}
+	cl.mu.Unlock()
+	if cl.hub.SlowPolicy == DropClient {
+		cl.Close()
+	}
+	return ErrSlowClient
+}
+
+// writePump is the only goroutine writing to the connection
+func (cl *WsClient) writePump() {
+	var ping <-chan time.Time
+	if cl.hub.PingEvery > 0 {
+		t := time.NewTicker(cl.hub.PingEvery)
+		defer t.Stop()
+		ping = t.C
+	}
+	defer close(cl.done)
+	for {
+		select {
+		case m, ok := <-cl.send:
+			if !ok {
+				return
+			}
+			if err := cl.write(m); err != nil {
+				cl.Close()
+				return
+			}
+		case <-ping:
+			if err := cl.write(wsMessage{typ: websocket.PingFrame}); err != nil {
+				cl.Close()
+				return
+			}
+		}
+	}
+}
+
+func (cl *WsClient) write(m wsMessage) error {
+	if cl.hub.WriteWait > 0 {
+		_ = cl.conn.SetWriteDeadline(time.Now().Add(cl.hub.WriteWait))
+	}
+	return frameCodec.Send(cl.conn, m)
+}
@@ -0,0 +1,160 @@
+package kamux
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/utils/websocket"
+)
+
+// serveHub serve handler on a test server, the connections are registered in hub
+func serveHub(t *testing.T, hub *Hub, handler WsHandler) string {
+	srv := httptest.NewServer(websocket.Server{Handler: func(conn *websocket.Conn) {
+		cl := hub.register(conn)
+		defer hub.unregister(cl)
+		handler(&WsContext{Ws: conn, Request: conn.Request(), Params: map[string]string{}, Route: Route{Hub: hub}, client: cl})
+	}})
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dialHub(t *testing.T, url string) *websocket.Conn {
+	conn, err := websocket.Dial(url, "", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func sendEvent(t *testing.T, conn *websocket.Conn, e WsEvent) {
+	if err := websocket.JSON.Send(conn, e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) WsEvent {
+	var e WsEvent
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := websocket.JSON.Receive(conn, &e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestHubRooms(t *testing.T) {
+	hub := NewHub()
+	ws := NewWsEvents()
+	On(ws, "join", func(c *WsContext, room string) (any, error) {
+		c.Join(room)
+		return c.Hub.RoomCount(room), nil
+	})
+	On(ws, "say", func(c *WsContext, text string) (any, error) {
+		return nil, c.EmitRoomExceptCaller("general", "said", text)
+	})
+	url := serveHub(t, hub, ws.Handler)
+	a, b, other := dialHub(t, url), dialHub(t, url), dialHub(t, url)
+
+	sendEvent(t, a, WsEvent{Event: "join", Id: 1, Data: "general"})
+	if ack := readEvent(t, a); ack.Ack != 1 || ack.Data != 1.0 {
+		t.Fatal("bad join ack", ack)
+	}
+	sendEvent(t, b, WsEvent{Event: "join", Id: 1, Data: "general"})
+	if ack := readEvent(t, b); ack.Ack != 1 || ack.Data != 2.0 {
+		t.Fatal("bad join ack", ack)
+	}
+	if n := hub.Count(); n != 3 {
+		t.Fatal("expected 3 connections, got", n)
+	}
+
+	sendEvent(t, a, WsEvent{Event: "say", Data: "hello"})
+	if e := readEvent(t, b); e.Event != "said" || e.Data != "hello" {
+		t.Fatal("room message not received", e)
+	}
+	// the caller and clients outside the room only get the broadcast
+	_ = hub.Broadcast(WsEvent{Event: "all"})
+	for _, conn := range []*websocket.Conn{a, b, other} {
+		if e := readEvent(t, conn); e.Event != "all" {
+			t.Fatal("expected the broadcast, got", e)
+		}
+	}
+
+	b.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.RoomCount("general") != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("closed client still in room", hub.Rooms())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubJoinClosed(t *testing.T) {
+	hub := NewHub()
+	cl := &WsClient{hub: hub, send: make(chan wsMessage, 1), rooms: map[string]struct{}{}}
+	cl.markClosed()
+	hub.Join(cl, "general")
+	hub.setKey(cl, "me")
+	if hub.RoomCount("general") != 0 {
+		t.Fatal("closed client joined a room")
+	}
+	if _, ok := hub.Get("me"); ok {
+		t.Fatal("closed client got a key")
+	}
+}
+
+func TestEmitWithAck(t *testing.T) {
+	old := WS_ACK_TIMEOUT
+	WS_ACK_TIMEOUT = 100 * time.Millisecond
+	defer func() { WS_ACK_TIMEOUT = old }()
+
+	type answer struct {
+		resp any
+		err  error
+	}
+	answers := make(chan answer, 2)
+	ws := NewWsEvents()
+	On(ws, "ready", func(c *WsContext, _ any) (any, error) {
+		return nil, c.EmitWithAck("confirm", "are you there ?", func(resp any, err error) {
+			answers <- answer{resp, err}
+		})
+	})
+	conn := dialHub(t, serveHub(t, NewHub(), ws.Handler))
+
+	sendEvent(t, conn, WsEvent{Event: "ready"})
+	e := readEvent(t, conn)
+	if e.Event != "confirm" || e.Id == 0 {
+		t.Fatal("expected a request with an id, got", e)
+	}
+	sendEvent(t, conn, WsEvent{Ack: e.Id, Data: "yes"})
+	if a := <-answers; a.err != nil || a.resp != "yes" {
+		t.Fatal("bad answer", a)
+	}
+
+	// no answer
+	sendEvent(t, conn, WsEvent{Event: "ready"})
+	late := readEvent(t, conn)
+	select {
+	case a := <-answers:
+		if a.err != ErrWsAckTimeout {
+			t.Fatal("expected a timeout, got", a)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("callback not called")
+	}
+	// a late answer is ignored
+	sendEvent(t, conn, WsEvent{Ack: late.Id, Data: "yes"})
+	sendEvent(t, conn, WsEvent{Event: "unknown", Id: 9})
+	if ack := readEvent(t, conn); ack.Ack != 9 || ack.Error != ErrWsUnknownEvent.Error() {
+		b, _ := json.Marshal(ack)
+		t.Fatal("bad ack", string(b))
+	}
+	select {
+	case a := <-answers:
+		t.Fatal("late answer delivered", a)
+	default:
+	}
+}
@@ -0,0 +1,158 @@
+package kamux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux/jwt"
+	"github.com/kamalshkeir/kago/core/kamux/totp"
+	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/utils"
+)
+
+// JWT authenticate requests having an "Authorization: Bearer" access token, never read from the url, requests without token fall back to the session cookie like Auth,
+// so both can be used on the same routes. Invalid tokens get 401
+var JWT = func(handler Handler) Handler {
+	const key utils.ContextKey = "user"
+	return func(c *Context) {
+		token := authorizationToken(c.Request)
+		if token == "" {
+			Auth(handler)(c)
+			return
+		}
+		user, err := jwtUser(token)
+		if err != nil {
+			c.SetHeader("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.Status(http.StatusUnauthorized).Json(map[string]any{
+				"error": err.Error(),
+			})
+			return
+		}
+		ctx := context.WithValue(c.Request.Context(), key, user)
+		*c = Context{
+			ResponseWriter: c.ResponseWriter,
+			Request:        c.Request.WithContext(ctx),
+			Params:         c.Params,
+		}
+		handler(c)
+	}
+}
+
+// jwtUser return the user of a valid access token, it can be used with TokenAuth
+func jwtUser(token string) (models.User, error) {
+	claims, err := jwt.ParseAccess(token)
+	if err != nil {
+		return models.User{}, err
+	}
+	user, err := userById(claims.UserID())
+	if err != nil {
+		return user, err
+	}
+	// tokens issued before a password change are rejected
+	return user, jwt.CheckStamp(claims, user)
+}
+
+func userById(id int) (models.User, error) {
+	return orm.Model[models.User]().Where("id = ?", id).One()
+}
+
+// tokenRequest read fields from a json or form body
+func tokenRequest(c *Context, fields ...string) map[string]string {
+	res := map[string]string{}
+	if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		body := map[string]any{}
+		_ = json.NewDecoder(http.MaxBytesReader(c.ResponseWriter, c.Request.Body, 1<<20)).Decode(&body)
+		for _, f := range fields {
+			if v, ok := body[f].(string); ok {
+				res[f] = v
+			}
+		}
+		return res
+	}
+	for _, f := range fields {
+		res[f] = c.Request.FormValue(f)
+	}
+	return res
+}
+
+// JWTLogin exchange {"email","password"} for a jwt.Pair, users needing two factor authentication must also send {"code"},
+// a totp or a recovery code
+var JWTLogin = func(c *Context) {
+	req := tokenRequest(c, "email", "password", "code")
+	user, wait, err := c.Authenticate(req["email"], req["password"])
+	if err != nil {
+		c.AuthenticateFailed(wait, err)
+		return
+	}
+	if NeedsTwoFactor(user) {
+		if !totp.Enabled(user.Id) {
+			c.Status(http.StatusForbidden).Json(map[string]any{
+				"error": "two factor authentication required, enroll at " + TWO_FACTOR_PATH,
+			})
+			return
+		}
+		if req["code"] == "" {
+			c.Status(http.StatusUnauthorized).Json(map[string]any{
+				"error":      "two factor code required",
+				"two_factor": true,
+			})
+			return
+		}
+		if wait, err := c.VerifyTwoFactor(user, req["code"]); err != nil {
+			if throttled(err) {
+				c.AuthenticateFailed(wait, err)
+				return
+			}
+			c.Status(http.StatusUnauthorized).Json(map[string]any{
+				"error":      "invalid code",
+				"two_factor": true,
+			})
+			return
+		}
+	}
+	pair, err := jwt.Issue(user)
+	if err != nil {
+		c.Status(http.StatusInternalServerError).Json(map[string]any{
+			"error": "unable to issue tokens",
+		})
+		return
+	}
+	c.Json(pair)
+}
+
+// JWTRefresh exchange {"refresh_token"} for a new jwt.Pair, the refresh token can be used only once
+var JWTRefresh = func(c *Context) {
+	req := tokenRequest(c, "refresh_token")
+	pair, err := jwt.Refresh(req["refresh_token"], userById)
+	if err != nil {
+		c.Status(http.StatusUnauthorized).Json(map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.Json(pair)
+}
+
+// JWTRevoke revoke the family of {"refresh_token"}, logging out the client, and the bearer access token if sent
+var JWTRevoke = func(c *Context) {
+	req := tokenRequest(c, "refresh_token")
+	claims, err := jwt.Parse(req["refresh_token"])
+	if err == nil {
+		err = jwt.RevokeFamily(claims.Family)
+	}
+	if err != nil {
+		c.Status(http.StatusBadRequest).Json(map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+	if access, err := jwt.Parse(authorizationToken(c.Request)); err == nil {
+		_ = jwt.Revoke(access)
+	}
+	c.Json(map[string]any{
+		"success": "token revoked",
+	})
+}
@@ -0,0 +1,335 @@
+// Package jwt sign and verify json web tokens using HS256, RS256, ES256 or EdDSA, and issue access/refresh token pairs for users
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/settings"
+	"github.com/kamalshkeir/kago/core/utils"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ISSUER = "kago"
+	// LEEWAY tolerate clock skew between servers when checking exp and nbf
+	LEEWAY = 30 * time.Second
+)
+
+var (
+	ErrMalformed      = errors.New("jwt: malformed token")
+	ErrSignature      = errors.New("jwt: invalid signature")
+	ErrAlgorithm      = errors.New("jwt: unexpected algorithm")
+	ErrExpired        = errors.New("jwt: token expired")
+	ErrNotYetValid    = errors.New("jwt: token not valid yet")
+	ErrIssuer         = errors.New("jwt: invalid issuer")
+	ErrCannotSign     = errors.New("jwt: keys can only verify")
+	ErrUnsupportedKey = errors.New("jwt: unsupported key type")
+	ErrRevoked        = errors.New("jwt: token revoked")
+	ErrTokenType      = errors.New("jwt: wrong token type")
+)
+
+// Keys sign and verify tokens with one algorithm, tokens using another one are rejected
+type Keys struct {
+	Alg   string
+	KeyID string
+	hmac  []byte
+	rsa   *rsa.PrivateKey
+	rsaP  *rsa.PublicKey
+	ec    *ecdsa.PrivateKey
+	ecP   *ecdsa.PublicKey
+	ed    ed25519.PrivateKey
+	edP   ed25519.PublicKey
+}
+
+// HMAC return HS256 keys using secret
+func HMAC(secret []byte) *Keys {
+	return &Keys{Alg: HS256, hmac: secret}
+}
+
+// RSA return RS256 keys, pub only keys can't sign
+func RSA(priv *rsa.PrivateKey, pub ...*rsa.PublicKey) *Keys {
+	k := &Keys{Alg: RS256, rsa: priv}
+	if priv != nil {
+		k.rsaP = &priv.PublicKey
+	} else if len(pub) > 0 {
+		k.rsaP = pub[0]
+	}
+	return k
+}
+
+// ECDSA return ES256 keys using a P-256 key, pub only keys can't sign
+func ECDSA(priv *ecdsa.PrivateKey, pub ...*ecdsa.PublicKey) *Keys {
+	k := &Keys{Alg: ES256, ec: priv}
+	if priv != nil {
+		k.ecP = &priv.PublicKey
+	} else if len(pub) > 0 {
+		k.ecP = pub[0]
+	}
+	return k
+}
+
+// Ed25519 return EdDSA keys, pub only keys can't sign
+func Ed25519(priv ed25519.PrivateKey, pub ...ed25519.PublicKey) *Keys {
+	k := &Keys{Alg: EdDSA, ed: priv}
+	if priv != nil {
+		k.edP = priv.Public().(ed25519.PublicKey)
+	} else if len(pub) > 0 {
+		k.edP = pub[0]
+	}
+	return k
+}
+
+// LoadKeyFile read a PEM private key (RSA PKCS1/PKCS8, EC SEC1/PKCS8, Ed25519 PKCS8) or public key (PKIX), public keys can only verify
+func LoadKeyFile(path string) (*Keys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(b)
+}
+
+// ParseKey parse a PEM key, see LoadKeyFile
+func ParseKey(pemBytes []byte) (*Keys, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("jwt: no PEM data found")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return RSA(k), nil
+	case *rsa.PublicKey:
+		return RSA(nil, k), nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, ErrUnsupportedKey
+		}
+		return ECDSA(k), nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, ErrUnsupportedKey
+		}
+		return ECDSA(nil, k), nil
+	case ed25519.PrivateKey:
+		return Ed25519(k), nil
+	case ed25519.PublicKey:
+		return Ed25519(nil, k), nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+var (
+	keys   *Keys
+	keysMu sync.RWMutex
+)
+
+// UseKeys set the keys used to issue and parse tokens
+func UseKeys(k *Keys) {
+	keysMu.Lock()
+	keys = k
+	keysMu.Unlock()
+}
+
+// UseKeyFile load path and use it to sign and verify tokens
+func UseKeyFile(path string) error {
+	k, err := LoadKeyFile(path)
+	if err != nil {
+		return err
+	}
+	UseKeys(k)
+	return nil
+}
+
+// DefaultKeys return the keys set using UseKeys, HS256 using settings.Secret otherwise
+func DefaultKeys() *Keys {
+	keysMu.RLock()
+	k := keys
+	keysMu.RUnlock()
+	if k != nil {
+		return k
+	}
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	if keys == nil {
+		if settings.Secret == "" {
+			settings.Secret = utils.GenerateRandomString(32)
+		}
+		keys = HMAC([]byte(settings.Secret))
+	}
+	return keys
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid,omitempty"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// Sign encode and sign claims, claims must be json encodable
+func (k *Keys) Sign(claims any) (string, error) {
+	h, _ := json.Marshal(header{Alg: k.Alg, Typ: "JWT", Kid: k.KeyID})
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signing := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	sig, err := k.sign([]byte(signing))
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + b64.EncodeToString(sig), nil
+}
+
+func (k *Keys) sign(data []byte) ([]byte, error) {
+	switch k.Alg {
+	case HS256:
+		m := hmac.New(sha256.New, k.hmac)
+		m.Write(data)
+		return m.Sum(nil), nil
+	case RS256:
+		if k.rsa == nil {
+			return nil, ErrCannotSign
+		}
+		sum := sha256.Sum256(data)
+		return rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, sum[:])
+	case ES256:
+		if k.ec == nil {
+			return nil, ErrCannotSign
+		}
+		sum := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, sum[:])
+		if err != nil {
+			return nil, err
+		}
+		// jws use the fixed size r||s encoding, not asn1
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	case EdDSA:
+		if k.ed == nil {
+			return nil, ErrCannotSign
+		}
+		return ed25519.Sign(k.ed, data), nil
+	}
+	return nil, ErrAlgorithm
+}
+
+func (k *Keys) verify(data, sig []byte) bool {
+	switch k.Alg {
+	case HS256:
+		m := hmac.New(sha256.New, k.hmac)
+		m.Write(data)
+		return hmac.Equal(sig, m.Sum(nil))
+	case RS256:
+		if k.rsaP == nil {
+			return false
+		}
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k.rsaP, crypto.SHA256, sum[:], sig) == nil
+	case ES256:
+		if k.ecP == nil || len(sig) != 64 {
+			return false
+		}
+		sum := sha256.Sum256(data)
+		return ecdsa.Verify(k.ecP, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case EdDSA:
+		return k.edP != nil && ed25519.Verify(k.edP, data, sig)
+	}
+	return false
+}
+
+// Header return the alg and kid of token without verifying it, to pick the keys verifying it
+func Header(token string) (alg, kid string, err error) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return "", "", ErrMalformed
+	}
+	hb, err := b64.DecodeString(token[:i])
+	if err != nil {
+		return "", "", ErrMalformed
+	}
+	var h header
+	if err := json.Unmarshal(hb, &h); err != nil {
+		return "", "", ErrMalformed
+	}
+	return h.Alg, h.Kid, nil
+}
+
+// Verify check the signature of token and decode its claims into dest, the time claims are not checked
+func (k *Keys) Verify(token string, dest any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+	hb, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return ErrMalformed
+	}
+	var h header
+	if err := json.Unmarshal(hb, &h); err != nil {
+		return ErrMalformed
+	}
+	// the algorithm is fixed by the keys, never by the token, it prevent "none" and HS256/RS256 confusion
+	if h.Alg != k.Alg {
+		return ErrAlgorithm
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return ErrMalformed
+	}
+	if !k.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return ErrSignature
+	}
+	cb, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(cb, dest); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
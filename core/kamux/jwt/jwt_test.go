@@ -0,0 +1,155 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+)
+
+func TestAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	fromPem, err := ParseKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	if err != nil || fromPem.Alg != EdDSA {
+		t.Fatal(fromPem, err)
+	}
+	pub, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	rsaPub, err := ParseKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		sign, verify *Keys
+	}{
+		{HMAC([]byte("secret")), HMAC([]byte("secret"))},
+		{RSA(rsaKey), rsaPub},
+		{fromPem, Ed25519(nil, edKey.Public().(ed25519.PublicKey))},
+		{ECDSA(ecKey), ECDSA(nil, &ecKey.PublicKey)},
+	} {
+		tok, err := tc.sign.Sign(Claims{Subject: "1"})
+		if err != nil {
+			t.Fatal(tc.sign.Alg, err)
+		}
+		var c Claims
+		if err := tc.verify.Verify(tok, &c); err != nil || c.Subject != "1" {
+			t.Fatal(tc.sign.Alg, c, err)
+		}
+		// tampered payload
+		parts := strings.Split(tok, ".")
+		forged, _ := tc.sign.Sign(Claims{Subject: "2"})
+		if err := tc.verify.Verify(parts[0]+"."+strings.Split(forged, ".")[1]+"."+parts[2], &c); err != ErrSignature {
+			t.Fatal(tc.sign.Alg, "tampered token accepted", err)
+		}
+	}
+	if _, err := rsaPub.Sign(Claims{}); err != ErrCannotSign {
+		t.Fatal("public key signed")
+	}
+
+	// a HS256 token signed with the RSA public key must not pass RS256 verification
+	confused, _ := HMAC(pub).Sign(Claims{Subject: "1"})
+	if err := rsaPub.Verify(confused, &Claims{}); err != ErrAlgorithm {
+		t.Fatal("algorithm confusion", err)
+	}
+	none := b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"1"}`)) + "."
+	if err := HMAC([]byte("secret")).Verify(none, &Claims{}); err != ErrAlgorithm {
+		t.Fatal("alg none accepted", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	UseKeys(HMAC([]byte("secret")))
+	REVOCATIONS = NewMemoryRevocations()
+	user := models.User{Id: 3, Email: "a@b.c"}
+	lookup := func(id int) (models.User, error) { return user, nil }
+
+	pair, err := Issue(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseAccess(pair.AccessToken)
+	if err != nil || c.UserID() != 3 || c.Email != "a@b.c" {
+		t.Fatal(c, err)
+	}
+	if _, err := ParseAccess(pair.RefreshToken); err != ErrTokenType {
+		t.Fatal("refresh token used as access token", err)
+	}
+	if _, err := Refresh(pair.AccessToken, lookup); err != ErrTokenType {
+		t.Fatal("access token used as refresh token", err)
+	}
+
+	next, err := Refresh(pair.RefreshToken, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reusing the first refresh token revoke the family, the new tokens included
+	if _, err := Refresh(pair.RefreshToken, lookup); err != ErrRevoked {
+		t.Fatal("refresh token reused", err)
+	}
+	if _, err := Refresh(next.RefreshToken, lookup); err != ErrRevoked {
+		t.Fatal("family not revoked", err)
+	}
+	if _, err := ParseAccess(next.AccessToken); err != ErrRevoked {
+		t.Fatal("access token of revoked family accepted", err)
+	}
+
+	// a password change revoke the tokens issued before
+	pair, _ = Issue(user)
+	c, _ = ParseAccess(pair.AccessToken)
+	if err := CheckStamp(c, user); err != nil {
+		t.Fatal(err)
+	}
+	changed := user
+	changed.Password = "new hash"
+	if err := CheckStamp(c, changed); err != ErrRevoked {
+		t.Fatal("token accepted after a password change", err)
+	}
+	if _, err := Refresh(pair.RefreshToken, func(int) (models.User, error) { return changed, nil }); err != ErrRevoked {
+		t.Fatal("refreshed after a password change", err)
+	}
+
+	expired, _ := DefaultKeys().Sign(Claims{Subject: "3", Type: AccessToken, Issuer: ISSUER, ExpiresAt: time.Now().Add(-time.Hour).Unix()})
+	if _, err := ParseAccess(expired); err != ErrExpired {
+		t.Fatal("expired token accepted", err)
+	}
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	UseKeys(HMAC([]byte("secret")))
+	REVOCATIONS = NewMemoryRevocations()
+	user := models.User{Id: 3, Email: "a@b.c"}
+	lookup := func(id int) (models.User, error) { return user, nil }
+	pair, _ := Issue(user)
+
+	var wg sync.WaitGroup
+	var ok int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Refresh(pair.RefreshToken, lookup); err == nil {
+				atomic.AddInt32(&ok, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if ok != 1 {
+		t.Fatal("the same refresh token was exchanged", ok, "times")
+	}
+}
@@ -0,0 +1,144 @@
+package jwt
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+// RevocationStore keep revoked token ids and families until they expire
+type RevocationStore interface {
+	Revoke(id string, until time.Time) error
+	// RevokeOnce revoke id if it isn't already, and report if this call revoked it, so only one of concurrent calls succeed
+	RevokeOnce(id string, until time.Time) (bool, error)
+	// IsRevoked report if one of ids is revoked, empty ids are ignored
+	IsRevoked(ids ...string) (bool, error)
+	DeleteExpired(now time.Time) error
+}
+
+// REVOCATIONS is the revocation list, New use the orm table revoked_tokens, BareBone keep it in memory
+var REVOCATIONS RevocationStore = NewMemoryRevocations()
+
+// MemoryRevocations is an in memory revocation list, lost on restart
+type MemoryRevocations struct {
+	ids map[string]time.Time
+	mu  sync.RWMutex
+}
+
+func NewMemoryRevocations() *MemoryRevocations {
+	return &MemoryRevocations{ids: map[string]time.Time{}}
+}
+
+func (m *MemoryRevocations) Revoke(id string, until time.Time) error {
+	m.mu.Lock()
+	m.ids[id] = until
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryRevocations) RevokeOnce(id string, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.ids[id]; ok {
+		return false, nil
+	}
+	m.ids[id] = until
+	return true, nil
+}
+
+func (m *MemoryRevocations) IsRevoked(ids ...string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if _, ok := m.ids[id]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryRevocations) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, until := range m.ids {
+		if now.After(until) {
+			delete(m.ids, id)
+		}
+	}
+	return nil
+}
+
+// ORMRevocations keep the revocation list in the table revoked_tokens (models.RevokedToken)
+type ORMRevocations struct {
+	dbName string
+}
+
+// NewORMRevocations return a revocation list using dbName, the default database if empty
+func NewORMRevocations(dbName ...string) *ORMRevocations {
+	r := &ORMRevocations{}
+	if len(dbName) > 0 {
+		r.dbName = dbName[0]
+	}
+	return r
+}
+
+func (o *ORMRevocations) model() *orm.Builder[models.RevokedToken] {
+	b := orm.Model[models.RevokedToken]()
+	if o.dbName != "" {
+		b = b.Database(o.dbName)
+	}
+	return b
+}
+
+func (o *ORMRevocations) Revoke(id string, until time.Time) error {
+	if revoked, err := o.IsRevoked(id); err != nil || revoked {
+		return err
+	}
+	_, err := o.model().Insert(&models.RevokedToken{Jti: id, ExpiresAt: until.Unix()})
+	return err
+}
+
+// RevokeOnce rely on the unique index of jti, the insert of concurrent calls fail except for one
+func (o *ORMRevocations) RevokeOnce(id string, until time.Time) (bool, error) {
+	_, err := o.model().Insert(&models.RevokedToken{Jti: id, ExpiresAt: until.Unix()})
+	if err == nil {
+		return true, nil
+	}
+	if revoked, e := o.IsRevoked(id); e == nil && revoked {
+		return false, nil
+	}
+	return false, err
+}
+
+// IsRevoked query without the orm cache, a revocation must apply right away
+func (o *ORMRevocations) IsRevoked(ids ...string) (bool, error) {
+	placeholders, args := []string{}, []any{}
+	for _, id := range ids {
+		if id != "" {
+			placeholders = append(placeholders, "?")
+			args = append(args, id)
+		}
+	}
+	if len(args) == 0 {
+		return false, nil
+	}
+	_, err := orm.Query(o.dbName, "SELECT jti FROM revoked_tokens WHERE jti IN ("+strings.Join(placeholders, ",")+")", args...)
+	if err != nil {
+		if err.Error() == "no data found" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (o *ORMRevocations) DeleteExpired(now time.Time) error {
+	_, err := o.model().Where("expires_at < ?", now.Unix()).Delete()
+	return err
+}
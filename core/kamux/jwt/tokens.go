@@ -0,0 +1,208 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+)
+
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
+
+var (
+	ACCESS_TTL  = 15 * time.Minute
+	REFRESH_TTL = 30 * 24 * time.Hour
+	// CHECK_REVOCATION check access tokens against REVOCATIONS on every request, refresh tokens are always checked
+	CHECK_REVOCATION = true
+)
+
+// Claims are the claims of tokens issued for a models.User
+type Claims struct {
+	ID        string `json:"jti"`
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	Type      string `json:"typ"`
+	// Family is shared by the tokens issued from the same login, a reused refresh token revoke its family
+	Family  string `json:"fam,omitempty"`
+	Email   string `json:"email,omitempty"`
+	IsAdmin bool   `json:"admin,omitempty"`
+	// Stamp change with the password of the user, so changing it revoke all the tokens issued before, see CheckStamp
+	Stamp string `json:"stamp,omitempty"`
+}
+
+// UserID return the user id of the subject
+func (c Claims) UserID() int {
+	id, _ := strconv.Atoi(c.Subject)
+	return id
+}
+
+// Valid check the time claims and the issuer
+func (c Claims) Valid(now time.Time) error {
+	if c.ExpiresAt != 0 && now.Add(-LEEWAY).Unix() > c.ExpiresAt {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(LEEWAY).Unix() < c.NotBefore {
+		return ErrNotYetValid
+	}
+	if ISSUER != "" && c.Issuer != ISSUER {
+		return ErrIssuer
+	}
+	return nil
+}
+
+// Pair is the response of login and refresh endpoints
+type Pair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func userClaims(user models.User, typ, family string, ttl time.Duration) Claims {
+	now := time.Now()
+	return Claims{
+		ID:        newID(),
+		Subject:   strconv.Itoa(user.Id),
+		Issuer:    ISSUER,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		Type:      typ,
+		Family:    family,
+		Email:     user.Email,
+		IsAdmin:   user.IsAdmin,
+		Stamp:     Stamp(user),
+	}
+}
+
+// Stamp return the security stamp of user, derived from its password hash
+func Stamp(user models.User) string {
+	sum := sha256.Sum256([]byte(user.Password))
+	return hex.EncodeToString(sum[:8])
+}
+
+// CheckStamp return ErrRevoked if c was issued before the last password change of user, user must be up to date
+func CheckStamp(c Claims, user models.User) error {
+	if c.Stamp != Stamp(user) {
+		return ErrRevoked
+	}
+	return nil
+}
+
+// Issue return a new access/refresh pair for user, starting a new family
+func Issue(user models.User) (Pair, error) {
+	return issue(user, newID())
+}
+
+func issue(user models.User, family string) (Pair, error) {
+	k := DefaultKeys()
+	access, err := k.Sign(userClaims(user, AccessToken, family, ACCESS_TTL))
+	if err != nil {
+		return Pair{}, err
+	}
+	refresh, err := k.Sign(userClaims(user, RefreshToken, family, REFRESH_TTL))
+	if err != nil {
+		return Pair{}, err
+	}
+	return Pair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(ACCESS_TTL.Seconds()),
+	}, nil
+}
+
+// Parse verify token and its time claims, without revocation check
+func Parse(token string) (Claims, error) {
+	var c Claims
+	if err := DefaultKeys().Verify(token, &c); err != nil {
+		return c, err
+	}
+	return c, c.Valid(time.Now())
+}
+
+// ParseAccess parse an access token, checking REVOCATIONS if CHECK_REVOCATION
+func ParseAccess(token string) (Claims, error) {
+	c, err := Parse(token)
+	if err != nil {
+		return c, err
+	}
+	if c.Type != AccessToken {
+		return c, ErrTokenType
+	}
+	if CHECK_REVOCATION {
+		if revoked, err := REVOCATIONS.IsRevoked(c.ID, familyKey(c.Family)); err != nil {
+			return c, err
+		} else if revoked {
+			return c, ErrRevoked
+		}
+	}
+	return c, nil
+}
+
+// Refresh exchange refreshToken for a new pair of the same family, the used refresh token is revoked,
+// reusing it revoke the whole family, it mean it was stolen. lookup return the up to date user
+func Refresh(refreshToken string, lookup func(userId int) (models.User, error)) (Pair, error) {
+	c, err := Parse(refreshToken)
+	if err != nil {
+		return Pair{}, err
+	}
+	if c.Type != RefreshToken {
+		return Pair{}, ErrTokenType
+	}
+	if revoked, err := REVOCATIONS.IsRevoked(familyKey(c.Family)); err != nil {
+		return Pair{}, err
+	} else if revoked {
+		return Pair{}, ErrRevoked
+	}
+	user, err := lookup(c.UserID())
+	if err != nil {
+		return Pair{}, err
+	}
+	if err := CheckStamp(c, user); err != nil {
+		_ = RevokeFamily(c.Family)
+		return Pair{}, err
+	}
+	// checked and revoked at once, of concurrent refreshes using the same token only one get a pair
+	if first, err := REVOCATIONS.RevokeOnce(c.ID, time.Unix(c.ExpiresAt, 0)); err != nil {
+		return Pair{}, err
+	} else if !first {
+		_ = RevokeFamily(c.Family)
+		return Pair{}, ErrRevoked
+	}
+	return issue(user, c.Family)
+}
+
+// Revoke revoke the token having claims c until it expire
+func Revoke(c Claims) error {
+	return REVOCATIONS.Revoke(c.ID, time.Unix(c.ExpiresAt, 0))
+}
+
+// RevokeFamily revoke all tokens issued from the login that started family
+func RevokeFamily(family string) error {
+	if family == "" {
+		return nil
+	}
+	return REVOCATIONS.Revoke(familyKey(family), time.Now().Add(REFRESH_TTL))
+}
+
+func familyKey(family string) string {
+	if family == "" {
+		return ""
+	}
+	return "fam:" + family
+}
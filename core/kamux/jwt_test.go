@@ -0,0 +1,17 @@
+package kamux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJWTQueryToken(t *testing.T) {
+	handler := JWT(func(c *Context) { c.Text("session") })
+	r := httptest.NewRequest("GET", "/password/reset?token=not-a-jwt", nil)
+	w := httptest.NewRecorder()
+	handler(&Context{ResponseWriter: w, Request: r, Params: map[string]string{}})
+	if w.Code != http.StatusOK || w.Body.String() != "session" {
+		t.Fatal("query token read by JWT", w.Code, w.Body.String())
+	}
+}
@@ -6,12 +6,21 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/kamalshkeir/kago/core/cron"
+	"github.com/kamalshkeir/kago/core/jobs"
+	"github.com/kamalshkeir/kago/core/kamux/accounts"
+	"github.com/kamalshkeir/kago/core/kamux/apikeys"
+	"github.com/kamalshkeir/kago/core/kamux/jwt"
+	"github.com/kamalshkeir/kago/core/kamux/oauth"
+	"github.com/kamalshkeir/kago/core/kamux/sessions"
+	"github.com/kamalshkeir/kago/core/kamux/throttle"
+	"github.com/kamalshkeir/kago/core/kamux/totp"
 	"github.com/kamalshkeir/kago/core/orm"
 	"github.com/kamalshkeir/kago/core/settings"
 	"github.com/kamalshkeir/kago/core/shell"
+	"github.com/kamalshkeir/kago/core/utils/backplane"
 	"github.com/kamalshkeir/kago/core/utils/envloader"
 	"github.com/kamalshkeir/kago/core/utils/logger"
-	"golang.org/x/net/websocket"
 )
 
 const (
@@ -47,6 +56,9 @@ type Router struct {
 	Routes       map[int][]Route
 	DefaultRoute Handler
 	Server       *http.Server
+	backplane    backplane.Backplane
+	cors         *CorsPolicy
+	corsRoutes   []corsRoute
 }
 
 // Route
@@ -55,8 +67,11 @@ type Route struct {
 	Pattern *regexp.Regexp
 	Handler
 	WsHandler
-	Clients         map[string]*websocket.Conn
+	Hub             *Hub
 	AllowedOrigines []string
+	// Middlewares and CheckOrigin apply to the upgrade request of WS routes
+	Middlewares []func(Handler) Handler
+	CheckOrigin OriginPolicy
 }
 
 // New Create New Router from env file default: '.env'
@@ -93,8 +108,20 @@ func New() *Router {
 		}
 	}
 	// migrate initial models
+	dbReady := err == nil
 	err = orm.Migrate()
 	if logger.CheckError(err) {os.Exit(0)}
+	if dbReady {
+		SESSION_STORE = sessions.NewORM()
+		jwt.REVOCATIONS = jwt.NewORMRevocations()
+		apikeys.STORE = apikeys.NewORM()
+		oauth.IDENTITIES = oauth.NewORMIdentities()
+		accounts.STORE = accounts.NewORM()
+		totp.STORE = totp.NewORM()
+		throttle.STORE = throttle.NewORM()
+		jobs.STORE = jobs.NewORM()
+		cron.STORE = cron.NewORM()
+	}
 	// init orm shell
 	if shell.InitShell() {os.Exit(0)}
 	return app
@@ -122,12 +149,15 @@ func BareBone() *Router {
 // handle a route
 func (router *Router) handle(method int, pattern string, handler Handler, wshandler WsHandler, allowed []string) {
 	re := regexp.MustCompile(adaptParams(pattern))
-	route := Route{Method: methods[method], Pattern: re, Handler: handler, WsHandler: wshandler, Clients: nil, AllowedOrigines: []string{}}
+	route := Route{Method: methods[method], Pattern: re, Handler: handler, WsHandler: wshandler, AllowedOrigines: []string{}}
 	if len(allowed) > 0 && method != GET && method != HEAD && method != OPTIONS {
 		route.AllowedOrigines = append(route.AllowedOrigines, allowed...)
 	}
 	if method == WS {
-		route.Clients = map[string]*websocket.Conn{}
+		route.Hub = NewHub()
+		if router.backplane != nil {
+			logger.CheckError(route.Hub.UseBackplane(router.backplane, "ws:"+re.String()))
+		}
 	}
 	if _, ok := router.Routes[method]; !ok {
 		router.Routes[method] = []Route{}
@@ -144,6 +174,14 @@ func (router *Router) handle(method int, pattern string, handler Handler, wshand
 	router.Routes[method] = append(router.Routes[method], route)
 }
 
+// UseBackplane forward broadcasts of all websocket routes, current and future, to other instances of the app
+func (router *Router) UseBackplane(bp backplane.Backplane) {
+	router.backplane = bp
+	for _, rt := range router.Routes[WS] {
+		logger.CheckError(rt.Hub.UseBackplane(bp, "ws:"+rt.Pattern.String()))
+	}
+}
+
 // GET handle GET to a route
 func (router *Router) GET(pattern string, handler Handler) {
 	router.handle(GET, pattern, handler, nil, nil)
@@ -232,6 +270,15 @@ func (router *Router) WS(pattern string, wsHandler WsHandler, allowed_origines .
 	router.handle(WS, pattern, nil, wsHandler, allowed_origines)
 }
 
+// WSWith handle WS to a route, running middlewares on the upgrade request and checking origins using opts.Origin
+func (router *Router) WSWith(pattern string, wsHandler WsHandler, opts WsOptions, allowed_origines ...string) {
+	router.handle(WS, pattern, nil, wsHandler, allowed_origines)
+	// handle append the route last
+	rt := &router.Routes[WS][len(router.Routes[WS])-1]
+	rt.Middlewares = opts.Middlewares
+	rt.CheckOrigin = opts.Origin
+}
+
 // SSE handle SSE to a route
 func (router *Router) SSE(pattern string, handler Handler, allowed_origines ...string) {
 	router.handle(SSE, pattern, handler, nil, allowed_origines)
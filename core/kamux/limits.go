@@ -0,0 +1,171 @@
+package kamux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/utils"
+)
+
+// RouteOptions limit the requests of a route or a group, see Limits
+type RouteOptions struct {
+	// Timeout cancel the context of the request and answer 503 if the handler didn't answer in time
+	Timeout time.Duration
+	// ReadTimeout and WriteTimeout replace ReadTimeout and WriteTimeout of the server for the request, like for big uploads or downloads
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MaxBodySize of the request in bytes, bigger bodies are answered 413
+	MaxBodySize int64
+}
+
+// TIMEOUT_MESSAGE is sent with the 503 of timed out requests
+var TIMEOUT_MESSAGE = "request timed out"
+
+const (
+	streamKey  utils.ContextKey = "stream"
+	maxBodyKey utils.ContextKey = "max-body"
+)
+
+// Limits apply opts to the handlers it wraps, sse and websockets routes ignore Timeout and WriteTimeout, ex:
+//
+//	app.GET("/report", kamux.Limits(kamux.RouteOptions{Timeout: 10 * time.Second})(ReportView))
+//	api := app.Group("/api", kamux.Limits(kamux.RouteOptions{Timeout: 5 * time.Second, MaxBodySize: 1 << 20}))
+var Limits = func(opts RouteOptions) func(Handler) Handler {
+	return func(handler Handler) Handler {
+		return func(c *Context) {
+			stream := isStream(c.Request)
+			if opts.ReadTimeout > 0 {
+				setDeadline(c.ResponseWriter, true, time.Now().Add(opts.ReadTimeout))
+			}
+			if opts.WriteTimeout > 0 && !stream {
+				setDeadline(c.ResponseWriter, false, time.Now().Add(opts.WriteTimeout))
+			}
+			if opts.MaxBodySize > 0 && c.Request.Body != nil && c.Request.Body != http.NoBody {
+				if c.Request.ContentLength > opts.MaxBodySize {
+					c.Status(http.StatusRequestEntityTooLarge).Text("request body too large")
+					return
+				}
+				c.Request.Body = http.MaxBytesReader(c.ResponseWriter, c.Request.Body, opts.MaxBodySize)
+				c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), maxBodyKey, opts.MaxBodySize))
+			}
+			if opts.Timeout <= 0 || stream {
+				handler(c)
+				return
+			}
+			withTimeout(c, handler, opts.Timeout)
+		}
+	}
+}
+
+// isStream report if r was dispatched to a SSE or websocket route, headers are not trusted since any client can send them
+func isStream(r *http.Request) bool {
+	ok, _ := r.Context().Value(streamKey).(bool)
+	return ok
+}
+
+// setDeadline set the read or write deadline of the connection of w, looking through the writers wrapping it using Unwrap,
+// like http.ResponseController does, it return false if not supported
+func setDeadline(w http.ResponseWriter, read bool, t time.Time) bool {
+	for {
+		if read {
+			if d, ok := w.(interface{ SetReadDeadline(time.Time) error }); ok {
+				return d.SetReadDeadline(t) == nil
+			}
+		} else if d, ok := w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+			return d.SetWriteDeadline(t) == nil
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// Unwrap return the ResponseWriter of c, so http.ResponseController(c) reach the connection
+func (c *Context) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// withTimeout run handler with a buffered response, sent if it finish before timeout, replaced by a 503 otherwise
+func withTimeout(c *Context, handler Handler, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	tw := &timeoutWriter{w: c.ResponseWriter, header: http.Header{}}
+	inner := &Context{ResponseWriter: tw, Request: c.Request.WithContext(ctx), Params: c.Params, status: c.status}
+	done := make(chan struct{})
+	panics := make(chan any, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panics <- p
+			}
+		}()
+		handler(inner)
+		close(done)
+	}()
+	select {
+	case p := <-panics:
+		// re-panic in the serving goroutine, for RECOVERY
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		dst := c.ResponseWriter.Header()
+		for k, v := range tw.header {
+			dst[k] = v
+		}
+		if tw.status == 0 {
+			tw.status = http.StatusOK
+		}
+		c.ResponseWriter.WriteHeader(tw.status)
+		_, _ = c.ResponseWriter.Write(tw.buf.Bytes())
+	case <-ctx.Done():
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		tw.timedOut = true
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.Status(http.StatusServiceUnavailable).Text(TIMEOUT_MESSAGE)
+		}
+	}
+}
+
+// timeoutWriter buffer the response of a handler run by withTimeout, it has no Unwrap so http.ResponseController
+// can't flush or hijack the connection under the buffer and commit a response the timeout would then write again
+type timeoutWriter struct {
+	w        http.ResponseWriter
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+	mu       sync.Mutex
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = code
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.buf.Write(b)
+}
@@ -0,0 +1,78 @@
+package kamux
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	slow := func(c *Context) {
+		select {
+		case <-time.After(time.Second):
+			c.Text("late")
+		case <-c.Request.Context().Done():
+		}
+	}
+	run := func(h Handler, r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(&Context{ResponseWriter: w, Request: r, Params: map[string]string{}})
+		return w
+	}
+	limited := Limits(RouteOptions{Timeout: 20 * time.Millisecond, MaxBodySize: 10})
+
+	if w := run(limited(slow), httptest.NewRequest("GET", "/", nil)); w.Code != http.StatusServiceUnavailable || w.Body.String() != TIMEOUT_MESSAGE {
+		t.Fatal("expected timeout", w.Code, w.Body.String())
+	}
+	fast := func(c *Context) {
+		c.SetHeader("X-Test", "1")
+		c.Status(http.StatusCreated).Text("ok")
+	}
+	if w := run(limited(fast), httptest.NewRequest("GET", "/", nil)); w.Code != http.StatusCreated || w.Body.String() != "ok" || w.Header().Get("X-Test") != "1" {
+		t.Fatal("response lost", w.Code, w.Body.String(), w.Header())
+	}
+
+	if w := run(limited(fast), httptest.NewRequest("POST", "/", strings.NewReader("more than ten bytes"))); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatal("expected 413", w.Code)
+	}
+	r := httptest.NewRequest("POST", "/", strings.NewReader("more than ten bytes"))
+	r.ContentLength = -1
+	read := func(c *Context) {
+		if _, err := io.ReadAll(c.Request.Body); err == nil {
+			t.Error("body not limited")
+		}
+	}
+	run(limited(read), r)
+
+	streamed := func(c *Context) {
+		time.Sleep(40 * time.Millisecond)
+		c.Text("still there")
+	}
+	// asking for a stream on a plain route don't lift the timeout
+	spoofed := httptest.NewRequest("GET", "/report", nil)
+	spoofed.Header.Set("Accept", "text/event-stream")
+	spoofed.Header.Set("Upgrade", "websocket")
+	if w := run(limited(streamed), spoofed); w.Code != http.StatusServiceUnavailable {
+		t.Fatal("timeout skipped by headers", w.Code, w.Body.String())
+	}
+	sse := httptest.NewRequest("GET", "/sse/events", nil)
+	sse = sse.WithContext(context.WithValue(sse.Context(), streamKey, true))
+	if w := run(limited(streamed), sse); w.Body.String() != "still there" {
+		t.Fatal("stream timed out", w.Body.String())
+	}
+
+	// a flush would commit a 200 before the timeout answer
+	flushed := func(c *Context) {
+		if err := http.NewResponseController(c.ResponseWriter).Flush(); err == nil {
+			t.Error("flushed through the timeout buffer")
+		}
+		slow(c)
+	}
+	if w := run(limited(flushed), httptest.NewRequest("GET", "/", nil)); w.Code != http.StatusServiceUnavailable || w.Flushed {
+		t.Fatal("response committed by the handler", w.Code, w.Flushed)
+	}
+}
@@ -0,0 +1,67 @@
+package kamux
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux/throttle"
+	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/utils/encryption/hash"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// Authenticate check the password of email, counting the failures of the account and the ip of the request in throttle.STORE.
+// Unknown emails are compared with a dummy hash, so they take as long to refuse as wrong passwords and get the same ErrInvalidCredentials.
+// When the account or ip must wait, or another attempt on the account is being checked, it return throttle.ErrLocked or
+// throttle.ErrTooSoon and the wait, without checking the password
+func (c *Context) Authenticate(email, password string) (models.User, time.Duration, error) {
+	keys := throttle.Keys(email, c.GetUserIP())
+	if wait, err := throttle.Begin(keys...); err != nil {
+		return models.User{}, wait, err
+	}
+	// released after the failure is counted, parallel guesses wait for it
+	defer throttle.End(keys...)
+	user, err := orm.Model[models.User]().Where("email = ?", email).One()
+	found := err == nil && user.Id != 0
+	hashed := user.Password
+	if !found {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = hash.GenerateHash("kago dummy password")
+		})
+		hashed = dummyHash
+	}
+	match, _ := hash.ComparePasswordToHash(password, hashed)
+	if !found || !match || password == "" {
+		logger.CheckError(throttle.Fail(keys...))
+		return models.User{}, 0, ErrInvalidCredentials
+	}
+	logger.CheckError(throttle.Success(throttle.AccountKey(email)))
+	return user, 0, nil
+}
+
+// AuthenticateFailed answer the error of Authenticate, 429 with Retry-After when throttled, else 401
+func (c *Context) AuthenticateFailed(wait time.Duration, err error) {
+	if throttled(err) {
+		secs := int((wait + time.Second - 1) / time.Second)
+		c.SetHeader("Retry-After", strconv.Itoa(secs))
+		c.Status(http.StatusTooManyRequests).Json(map[string]any{
+			"error":       err.Error(),
+			"retry_after": secs,
+		})
+		return
+	}
+	c.Status(http.StatusUnauthorized).Json(map[string]any{
+		"error": ErrInvalidCredentials.Error(),
+	})
+}
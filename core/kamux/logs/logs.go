@@ -49,7 +49,7 @@ var LOGS = func(h http.Handler) http.Handler {
 		}
 		if settings.Config.Logs {
 			logger.StreamLogs = append(logger.StreamLogs, res)
-			eventbus.Publish("internal-logs", map[string]string{})
+			eventbus.Publish("internal-logs", map[string]string{"log": res})
 		}
 	})
 }
@@ -59,6 +59,11 @@ func (r *StatusRecorder) WriteHeader(status int) {
 	r.ResponseWriter.WriteHeader(status)
 }
 
+// Unwrap let http.ResponseController and kamux reach the connection, to set its deadlines
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func (r *StatusRecorder) Flush() {
 	if v, ok := r.ResponseWriter.(http.Flusher); ok {
 		v.Flush()
@@ -6,46 +6,28 @@ import (
 	"crypto/subtle"
 	"encoding/json"
 	"net/http"
-	"strings"
-	"sync"
-	"time"
 
-	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux/cache"
 	"github.com/kamalshkeir/kago/core/kamux/csrf"
 	"github.com/kamalshkeir/kago/core/kamux/gzip"
 	"github.com/kamalshkeir/kago/core/kamux/logs"
+	"github.com/kamalshkeir/kago/core/kamux/perms"
 	"github.com/kamalshkeir/kago/core/kamux/ratelimiter"
-	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/kamux/totp"
 	"github.com/kamalshkeir/kago/core/utils"
-	"github.com/kamalshkeir/kago/core/utils/encryption/encryptor"
-	"github.com/kamalshkeir/kago/core/utils/eventbus"
 	"github.com/kamalshkeir/kago/core/utils/logger"
 )
 
+// SESSION_ENCRYPTION is not used anymore, the session cookie hold a random id of a server side session
 var SESSION_ENCRYPTION = true
 
 // AuthMiddleware can be added to any handler to get user cookie authentication and pass it to handler and templates
 var Auth = func(handler Handler) Handler {
 	const key utils.ContextKey = "user"
 	return func(c *Context) {
-		session, err := c.GetCookie("session")
-		if err != nil || session == "" {
+		user, ok := sessionUser(c)
+		if !ok {
 			// NOT AUTHENTICATED
-			c.DeleteCookie("session")
-			handler(c)
-			return
-		}
-		if SESSION_ENCRYPTION {
-			session, err = encryptor.Decrypt(session)
-			if err != nil {
-				handler(c)
-				return
-			}
-		}
-		// Check session
-		user, err := orm.Model[models.User]().Where("uuid = ?", session).One()
-		if err != nil {
-			// session fail
 			handler(c)
 			return
 		}
@@ -64,31 +46,20 @@ var Auth = func(handler Handler) Handler {
 var Admin = func(handler Handler) Handler {
 	const key utils.ContextKey = "user"
 	return func(c *Context) {
-		session, err := c.GetCookie("session")
-		if err != nil || session == "" {
-			// NOT AUTHENTICATED
-			c.DeleteCookie("session")
+		user, ok := sessionUser(c)
+		if !ok {
+			// NOT AUTHENTICATED, OR NOT FOUND IN DB
 			c.Status(http.StatusTemporaryRedirect).Redirect("/admin/login")
 			return
 		}
-		if SESSION_ENCRYPTION {
-			session, err = encryptor.Decrypt(session)
-			if err != nil {
-				c.Status(http.StatusTemporaryRedirect).Redirect("/admin/login")
-				return
-			}
-		}
-		user, err := orm.Model[models.User]().Where("uuid = ?", session).One()
 
-		if err != nil {
-			// AUTHENTICATED BUT NOT FOUND IN DB
-			c.Status(http.StatusTemporaryRedirect).Redirect("/admin/login")
+		// Not admin, and no permission
+		if !perms.IsStaff(user) {
+			c.Status(403).Text("Middleware : Not allowed to access this page")
 			return
 		}
-
-		// Not admin
-		if !user.IsAdmin {
-			c.Status(403).Text("Middleware : Not allowed to access this page")
+		if TWO_FACTOR_ADMINS && !totp.Enabled(user.Id) {
+			c.Status(http.StatusTemporaryRedirect).Redirect(TWO_FACTOR_PATH)
 			return
 		}
 
@@ -103,6 +74,48 @@ var Admin = func(handler Handler) Handler {
 	}
 }
 
+// RequirePermission allow users having all codenames, like "orders.change", admins have them all
+var RequirePermission = func(codenames ...string) func(Handler) Handler {
+	const key utils.ContextKey = "user"
+	return func(handler Handler) Handler {
+		return func(c *Context) {
+			user, ok := sessionUser(c)
+			if !ok {
+				if wantsJson(c) {
+					c.Status(http.StatusUnauthorized).Json(map[string]any{"error": "not logged in"})
+					return
+				}
+				c.Status(http.StatusTemporaryRedirect).Redirect(LOGIN_URL)
+				return
+			}
+			if !perms.Has(user, codenames...) {
+				if wantsJson(c) {
+					c.Status(http.StatusForbidden).Json(map[string]any{"error": "permission denied"})
+					return
+				}
+				c.Status(http.StatusForbidden).Text("Middleware : Not allowed to access this page")
+				return
+			}
+			// same rule as Admin, staff pages need 2fa
+			if TWO_FACTOR_ADMINS && perms.IsStaff(user) && !totp.Enabled(user.Id) {
+				if wantsJson(c) {
+					c.Status(http.StatusForbidden).Json(map[string]any{"error": "two factor authentication required", "redirect": TWO_FACTOR_PATH})
+					return
+				}
+				c.Status(http.StatusTemporaryRedirect).Redirect(TWO_FACTOR_PATH)
+				return
+			}
+			ctx := context.WithValue(c.Request.Context(), key, user)
+			*c = Context{
+				ResponseWriter: c.ResponseWriter,
+				Request:        c.Request.WithContext(ctx),
+				Params:         c.Params,
+			}
+			handler(c)
+		}
+	}
+}
+
 var BasicAuth = func(next Handler, user, pass string) Handler {
 	return func(c *Context) {
 		// Extract the username and password from the request
@@ -146,104 +159,27 @@ var BasicAuth = func(next Handler, user, pass string) Handler {
 	}
 }
 
-var oncc sync.Once
+// Csrf protect a route like the global middleware CSRF, see the csrf package
 var Csrf = func(handler Handler) Handler {
-	oncc.Do(func() {
-		if !csrf.Used {
-			i := time.Now()
-			eventbus.Subscribe("csrf-clean", func(data string) {
-				if data != "" {
-					csrf.Csrf_tokens.Delete(data)
-				}
-				if time.Since(i) > time.Hour {
-					csrf.Csrf_tokens.Flush()
-					i = time.Now()
-				}
-			})
-			csrf.Used = true
-		}
-	})
 	return func(c *Context) {
-		switch c.Method {
-		case "GET":
-			token := c.Request.Header.Get("X-CSRF-Token")
-			tok, ok := csrf.Csrf_tokens.Get(token)
-
-			if token == "" || !ok || token != tok.Value || tok.Retry > csrf.CSRF_TIMEOUT_RETRY {
-				t, _ := encryptor.Encrypt(csrf.Csrf_rand)
-				csrf.Csrf_tokens.Set(t, csrf.Token{
-					Value:   t,
-					Used:    false,
-					Retry:   0,
-					Remote:  c.Request.UserAgent(),
-					Created: time.Now(),
-				})
-				http.SetCookie(c.ResponseWriter, &http.Cookie{
-					Name:     "csrf_token",
-					Value:    t,
-					Path:     "/",
-					Expires:  time.Now().Add(csrf.CSRF_CLEAN_EVERY),
-					SameSite: http.SameSiteStrictMode,
-				})
-			} else {
-				if token != tok.Value {
-					http.SetCookie(c.ResponseWriter, &http.Cookie{
-						Name:     "csrf_token",
-						Value:    tok.Value,
-						Path:     "/",
-						Expires:  time.Now().Add(csrf.CSRF_CLEAN_EVERY),
-						SameSite: http.SameSiteStrictMode,
-					})
-				}
-			}
-		case "POST", "PATCH", "PUT", "UPDATE", "DELETE":
-			token := c.Request.Header.Get("X-CSRF-Token")
-			tok, ok := csrf.Csrf_tokens.Get(token)
-			if !ok || token == "" || tok.Used || tok.Retry > csrf.CSRF_TIMEOUT_RETRY || time.Since(tok.Created) > csrf.CSRF_CLEAN_EVERY || c.Request.UserAgent() != tok.Remote {
-				c.ResponseWriter.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(c.ResponseWriter).Encode(map[string]any{
-					"error": "CSRF not allowed",
-				})
-				return
-
-			}
-			csrf.Csrf_tokens.Set(tok.Value, csrf.Token{
-				Value:   tok.Value,
-				Used:    true,
-				Retry:   tok.Retry + 1,
-				Remote:  c.Request.UserAgent(),
-				Created: tok.Created,
-			})
+		r, ok := csrf.Check(c.ResponseWriter, c.Request)
+		if !ok {
+			return
 		}
+		c.Request = r
 		handler(c)
 	}
 }
 
-var corsAdded = false
 var Origines = []string{}
 
+// AllowOrigines allow cross origin requests from origines to all routes, see UseCors to configure methods, headers and credentials
 func (router *Router) AllowOrigines(origines ...string) {
-	if !corsAdded {
-		midwrs = append(midwrs, cors)
-		corsAdded = true
-	}
 	Origines = append(Origines, origines...)
-}
-
-var cors = func(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set headers
-		o := strings.Join(Origines, ",")
-		w.Header().Set("Access-Control-Allow-Origin", o)
-		w.Header().Set("Access-Control-Allow-Headers:", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "*")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		// Next
-		next.ServeHTTP(w, r)
-	})
+	if router.cors == nil {
+		router.cors = &CorsPolicy{}
+	}
+	router.cors.Origins = append(router.cors.Origins, origines...)
 }
 
 var RECOVERY = func(next http.Handler) http.Handler {
@@ -267,4 +203,35 @@ var RECOVERY = func(next http.Handler) http.Handler {
 var CSRF = csrf.CSRF
 var GZIP = gzip.GZIP
 var LIMITER = ratelimiter.LIMITER
+
+// RateLimit limit a route following policy, ex: RateLimit(ratelimiter.Policy{Limit: 10, Window: time.Minute, Key: ratelimiter.KeyByUser})(handler),
+// wrap it inside Auth or RequireScope to limit by user or api key
+var RateLimit = func(policy ratelimiter.Policy) func(Handler) Handler {
+	limiter := ratelimiter.New(policy)
+	return func(handler Handler) Handler {
+		return func(c *Context) {
+			if limiter.Check(c.ResponseWriter, c.Request) {
+				handler(c)
+			}
+		}
+	}
+}
+
+// Cache answer conditional requests with 304, and store the responses for opts.TTL if set, see cache.Options.
+// Requests of logged in users are stored only if Vary include "Cookie"
+var Cache = func(opts cache.Options) func(Handler) Handler {
+	if opts.SessionCookie == "" {
+		opts.SessionCookie = SESSION_COOKIE
+	}
+	ca := cache.New(opts)
+	return func(handler Handler) Handler {
+		return func(c *Context) {
+			ca.Serve(c.ResponseWriter, c.Request, func(w http.ResponseWriter, r *http.Request) {
+				c.ResponseWriter, c.Request = w, r
+				handler(c)
+			})
+		}
+	}
+}
+
 var LOGS = logs.LOGS
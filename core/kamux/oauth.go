@@ -0,0 +1,198 @@
+package kamux
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux/oauth"
+	"github.com/kamalshkeir/kago/core/kamux/sessions"
+	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/utils"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+var (
+	// OAUTH_PATH prefix the login and callback routes of providers, OAUTH_PATH/<name> and OAUTH_PATH/<name>/callback
+	OAUTH_PATH = "/auth/oauth"
+	// OAUTH_REDIRECT is where users go after login when no ?next= was given
+	OAUTH_REDIRECT = "/"
+	// OAUTH_LINK_BY_EMAIL link an unknown identity to the user having the same email, only if the provider verified it
+	OAUTH_LINK_BY_EMAIL = true
+	// OAUTH_CREATE_USERS create a user for unknown identities having a verified email
+	OAUTH_CREATE_USERS = false
+	// OAUTH_FLOW_COOKIE keep the login flow, it's SameSite lax, the strict session cookie is not sent back by providers
+	OAUTH_FLOW_COOKIE = "oauth_flow"
+)
+
+var ErrOAuthNoUser = errors.New("oauth: no user for this identity")
+
+// OAuth register providers and their login and callback routes, login links look like OAUTH_PATH/google?next=/admin
+func (router *Router) OAuth(providers ...*oauth.Provider) {
+	for _, p := range providers {
+		oauth.Register(p)
+		router.GET(OAUTH_PATH+"/"+p.Name, oauthLogin(p))
+		router.GET(OAUTH_PATH+"/"+p.Name+"/callback", oauthCallback(p))
+	}
+}
+
+func oauthCallbackURL(c *Context, p *oauth.Provider) (string, error) {
+	base, err := c.BaseURL()
+	if err != nil {
+		return "", err
+	}
+	return base + OAUTH_PATH + "/" + p.Name + "/callback", nil
+}
+
+// localPath keep redirections on this site
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return ""
+	}
+	return next
+}
+
+func oauthLogin(p *oauth.Provider) Handler {
+	return func(c *Context) {
+		callback, err := oauthCallbackURL(c, p)
+		if logger.CheckError(err) {
+			c.Status(http.StatusInternalServerError).Text("login with " + p.Label + " is not configured")
+			return
+		}
+		flow := p.NewFlow(callback)
+		flow.Next = localPath(c.QueryParam("next"))
+		u, err := p.AuthCodeURL(c.Request.Context(), flow)
+		if logger.CheckError(err) {
+			c.Status(http.StatusBadGateway).Text("unable to reach " + p.Label)
+			return
+		}
+		b, _ := json.Marshal(flow)
+		// the flow is kept server side, in its own session, single use
+		s := sessions.New(SESSION_STORE)
+		err = s.Set("oauth", string(b))
+		if _, e := c.GetCookie(SESSION_COOKIE); e == nil && err == nil && c.Session().UserID() != 0 {
+			// logged in users link a new provider to their account
+			err = s.Set("link_user", c.Session().UserID())
+		}
+		if logger.CheckError(err) {
+			c.Status(http.StatusInternalServerError).Text("unable to start login")
+			return
+		}
+		http.SetCookie(c.ResponseWriter, &http.Cookie{
+			Name:     OAUTH_FLOW_COOKIE,
+			Value:    s.ID(),
+			Path:     OAUTH_PATH,
+			MaxAge:   int(oauth.FLOW_TTL.Seconds()),
+			HttpOnly: true,
+			Secure:   COOKIES_Secure || c.Request.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		c.Status(http.StatusFound).Redirect(u)
+	}
+}
+
+func oauthCallback(p *oauth.Provider) Handler {
+	return func(c *Context) {
+		fail := func(status int, err error) {
+			logger.Error("oauth", p.Name, err)
+			c.Status(status).Text("login with " + p.Label + " failed")
+		}
+		id, _ := c.GetCookie(OAUTH_FLOW_COOKIE)
+		http.SetCookie(c.ResponseWriter, &http.Cookie{Name: OAUTH_FLOW_COOKIE, Path: OAUTH_PATH, MaxAge: -1})
+		s, err := sessions.Load(SESSION_STORE, id)
+		if err != nil {
+			fail(http.StatusBadRequest, oauth.ErrState)
+			return
+		}
+		rec := s.Record()
+		_ = s.Destroy()
+		var flow oauth.Flow
+		raw, _ := rec.Values["oauth"].(string)
+		if json.Unmarshal([]byte(raw), &flow) != nil || flow.Provider != p.Name ||
+			subtle.ConstantTimeCompare([]byte(flow.State), []byte(c.QueryParam("state"))) != 1 {
+			fail(http.StatusBadRequest, oauth.ErrState)
+			return
+		}
+		if flow.Expired(time.Now()) {
+			fail(http.StatusBadRequest, oauth.ErrFlowExpired)
+			return
+		}
+		if e := c.QueryParam("error"); e != "" {
+			fail(http.StatusUnauthorized, errors.New(e+" "+c.QueryParam("error_description")))
+			return
+		}
+		tok, err := p.Exchange(c.Request.Context(), c.QueryParam("code"), flow)
+		if err != nil {
+			fail(http.StatusUnauthorized, err)
+			return
+		}
+		ident, err := p.Identity(c.Request.Context(), tok, flow)
+		if err != nil {
+			fail(http.StatusUnauthorized, err)
+			return
+		}
+		linkUser := 0
+		if v, ok := rec.Values["link_user"].(int); ok {
+			linkUser = v
+		} else if v, ok := rec.Values["link_user"].(float64); ok {
+			linkUser = int(v)
+		}
+		user, err := oauthUser(ident, linkUser)
+		if err != nil {
+			fail(http.StatusForbidden, err)
+			return
+		}
+		next := flow.Next
+		if next == "" {
+			next = OAUTH_REDIRECT
+		}
+		pending, err := c.LoginOrChallenge(user, next)
+		if logger.CheckError(err) {
+			c.Status(http.StatusInternalServerError).Text("unable to create session")
+			return
+		}
+		if pending {
+			next = TWO_FACTOR_LOGIN_PATH
+		}
+		c.Status(http.StatusFound).Redirect(next)
+	}
+}
+
+// oauthUser return the user linked to ident, linking it to linkUser, to the user having its verified email, or to a new user
+func oauthUser(ident oauth.Identity, linkUser int) (models.User, error) {
+	if link, err := oauth.IDENTITIES.Find(ident.Provider, ident.Subject); err == nil {
+		return userById(link.UserId)
+	} else if err != oauth.ErrNotLinked {
+		return models.User{}, err
+	}
+	var user models.User
+	var err error
+	switch {
+	case linkUser != 0:
+		user, err = userById(linkUser)
+	case ident.Email != "" && ident.EmailVerified && (OAUTH_LINK_BY_EMAIL || OAUTH_CREATE_USERS):
+		user, err = orm.Model[models.User]().Where("email = ?", ident.Email).One()
+		if (err != nil || user.Id == 0) && OAUTH_CREATE_USERS {
+			// the password is random, the user log in with the provider or reset it
+			err = orm.CreateUser(ident.Email, utils.GenerateRandomString(32), 0)
+			if err == nil {
+				user, err = orm.Model[models.User]().Where("email = ?", ident.Email).One()
+			}
+		} else if !OAUTH_LINK_BY_EMAIL {
+			err = ErrOAuthNoUser
+		}
+	default:
+		err = ErrOAuthNoUser
+	}
+	if err != nil || user.Id == 0 {
+		return models.User{}, ErrOAuthNoUser
+	}
+	if err := oauth.IDENTITIES.Link(user.Id, ident); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,143 @@
+package oauth
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+var ErrNotLinked = errors.New("oauth: identity not linked to a user")
+
+// IdentityStore link external identities to users
+type IdentityStore interface {
+	// Find return the link of the provider subject, ErrNotLinked if none
+	Find(provider, subject string) (models.Identity, error)
+	Link(userId int, id Identity) error
+	Unlink(userId int, provider string) error
+	UserIdentities(userId int) ([]models.Identity, error)
+}
+
+// IDENTITIES keep the links, New use the orm table identities, BareBone keep them in memory
+var IDENTITIES IdentityStore = NewMemoryIdentities()
+
+// ProviderKey is the unique key of an identity
+func ProviderKey(provider, subject string) string {
+	return provider + ":" + subject
+}
+
+func newLink(userId int, id Identity) models.Identity {
+	return models.Identity{
+		UserId:      userId,
+		Provider:    id.Provider,
+		Subject:     id.Subject,
+		ProviderKey: ProviderKey(id.Provider, id.Subject),
+		Email:       id.Email,
+		CreatedAt:   time.Now().Unix(),
+	}
+}
+
+// MemoryIdentities is an in memory IdentityStore, lost on restart
+type MemoryIdentities struct {
+	links map[string]models.Identity
+	mu    sync.RWMutex
+}
+
+func NewMemoryIdentities() *MemoryIdentities {
+	return &MemoryIdentities{links: map[string]models.Identity{}}
+}
+
+func (m *MemoryIdentities) Find(provider, subject string) (models.Identity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	l, ok := m.links[ProviderKey(provider, subject)]
+	if !ok {
+		return l, ErrNotLinked
+	}
+	return l, nil
+}
+
+func (m *MemoryIdentities) Link(userId int, id Identity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := newLink(userId, id)
+	l.Id = len(m.links) + 1
+	m.links[l.ProviderKey] = l
+	return nil
+}
+
+func (m *MemoryIdentities) Unlink(userId int, provider string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, l := range m.links {
+		if l.UserId == userId && l.Provider == provider {
+			delete(m.links, k)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryIdentities) UserIdentities(userId int) ([]models.Identity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []models.Identity{}
+	for _, l := range m.links {
+		if l.UserId == userId {
+			res = append(res, l)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Provider < res[j].Provider })
+	return res, nil
+}
+
+// ORMIdentities keep the links in the table identities (models.Identity)
+type ORMIdentities struct {
+	dbName string
+}
+
+// NewORMIdentities return a store using dbName, the default database if empty
+func NewORMIdentities(dbName ...string) *ORMIdentities {
+	s := &ORMIdentities{}
+	if len(dbName) > 0 {
+		s.dbName = dbName[0]
+	}
+	return s
+}
+
+func (o *ORMIdentities) model() *orm.Builder[models.Identity] {
+	b := orm.Model[models.Identity]()
+	if o.dbName != "" {
+		b = b.Database(o.dbName)
+	}
+	return b
+}
+
+func (o *ORMIdentities) Find(provider, subject string) (models.Identity, error) {
+	l, err := o.model().Where("provider_key = ?", ProviderKey(provider, subject)).One()
+	if err != nil || l.Id == 0 {
+		return l, ErrNotLinked
+	}
+	return l, nil
+}
+
+func (o *ORMIdentities) Link(userId int, id Identity) error {
+	l := newLink(userId, id)
+	_, err := o.model().Insert(&l)
+	return err
+}
+
+func (o *ORMIdentities) Unlink(userId int, provider string) error {
+	_, err := o.model().Where("user_id = ? AND provider = ?", userId, provider).Delete()
+	return err
+}
+
+func (o *ORMIdentities) UserIdentities(userId int) ([]models.Identity, error) {
+	res, err := o.model().Where("user_id = ?", userId).OrderBy("provider").All()
+	if err != nil && err.Error() == "no data found" {
+		return []models.Identity{}, nil
+	}
+	return res, err
+}
@@ -0,0 +1,197 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/kamux/jwt"
+)
+
+// JWKS_REFRESH_MIN limit the reloads of a key set when a token use an unknown key id, providers rotate their keys
+var JWKS_REFRESH_MIN = time.Minute
+
+// JWK is a public json web key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a json web key set, as served by jwks_uri
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Keys return the verification keys of k, nil for unsupported keys
+func (k JWK) Keys() *jwt.Keys {
+	b64 := base64.RawURLEncoding
+	var keys *jwt.Keys
+	switch k.Kty {
+	case "RSA":
+		n, err1 := b64.DecodeString(k.N)
+		e, err2 := b64.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+			return nil
+		}
+		keys = jwt.RSA(nil, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())})
+	case "EC":
+		x, err1 := b64.DecodeString(k.X)
+		y, err2 := b64.DecodeString(k.Y)
+		if k.Crv != "P-256" || err1 != nil || err2 != nil {
+			return nil
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil
+		}
+		keys = jwt.ECDSA(nil, pub)
+	case "OKP":
+		x, err := b64.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil
+		}
+		keys = jwt.Ed25519(nil, ed25519.PublicKey(x))
+	default:
+		return nil
+	}
+	if k.Alg != "" && k.Alg != keys.Alg {
+		return nil
+	}
+	keys.KeyID = k.Kid
+	return keys
+}
+
+type keySet struct {
+	keys    []*jwt.Keys
+	fetched time.Time
+	mu      sync.Mutex
+}
+
+// key return the key verifying tokens signed using alg by kid, the set is reloaded once when kid is unknown
+func (p *Provider) key(ctx context.Context, alg, kid string) (*jwt.Keys, error) {
+	p.mu.Lock()
+	if p.keys == nil {
+		p.keys = &keySet{}
+	}
+	set := p.keys
+	p.mu.Unlock()
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if k := set.find(alg, kid); k != nil {
+		return k, nil
+	}
+	if time.Since(set.fetched) < JWKS_REFRESH_MIN {
+		return nil, ErrNoKey
+	}
+	var jwks JWKS
+	if err := p.getJSON(ctx, p.JWKSURL, "", &jwks); err != nil {
+		return nil, err
+	}
+	set.fetched = time.Now()
+	set.keys = set.keys[:0]
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if keys := k.Keys(); keys != nil {
+			set.keys = append(set.keys, keys)
+		}
+	}
+	if k := set.find(alg, kid); k != nil {
+		return k, nil
+	}
+	return nil, ErrNoKey
+}
+
+func (s *keySet) find(alg, kid string) *jwt.Keys {
+	var match *jwt.Keys
+	for _, k := range s.keys {
+		if k.Alg != alg {
+			continue
+		}
+		if k.KeyID == kid {
+			return k
+		}
+		// tokens without kid are accepted when the set has a single key for alg
+		if kid == "" {
+			if match != nil {
+				return nil
+			}
+			match = k
+		}
+	}
+	return match
+}
+
+// VerifyIDToken verify the signature of an id token using the provider JWKS, its issuer, audience, expiry and nonce, and return its claims
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (map[string]any, error) {
+	if err := p.Discover(ctx); err != nil {
+		return nil, err
+	}
+	alg, kid, err := jwt.Header(raw)
+	if err != nil {
+		return nil, err
+	}
+	// symmetric and "none" algorithms are not accepted for id tokens
+	if alg != jwt.RS256 && alg != jwt.ES256 && alg != jwt.EdDSA {
+		return nil, jwt.ErrAlgorithm
+	}
+	keys, err := p.key(ctx, alg, kid)
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string]any{}
+	if err := keys.Verify(raw, &claims); err != nil {
+		return nil, err
+	}
+	if claimString(claims["iss"]) != p.Issuer {
+		return nil, ErrIssuer
+	}
+	if !audienceContains(claims["aud"], p.ClientID) {
+		return nil, ErrAudience
+	}
+	if aud, ok := claims["aud"].([]any); ok && len(aud) > 1 && claimString(claims["azp"]) != p.ClientID {
+		return nil, ErrAudience
+	}
+	now := time.Now()
+	exp, _ := claims["exp"].(float64)
+	if now.Add(-jwt.LEEWAY).Unix() > int64(exp) {
+		return nil, ErrExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwt.LEEWAY).Unix() < int64(nbf) {
+		return nil, jwt.ErrNotYetValid
+	}
+	if nonce != "" && subtle.ConstantTimeCompare([]byte(claimString(claims["nonce"])), []byte(nonce)) != 1 {
+		return nil, ErrNonce
+	}
+	return claims, nil
+}
+
+func audienceContains(aud any, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,398 @@
+// Package oauth log users in with external providers using the authorization code flow with PKCE.
+// OpenID Connect providers are configured from their discovery document and their id tokens are validated against their JWKS,
+// plain oauth2 providers like github use an IdentityFunc
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// FLOW_TTL is the time a user have to log in at the provider
+	FLOW_TTL = 10 * time.Minute
+	// HTTP_TIMEOUT limit the calls to providers when Provider.Client is nil
+	HTTP_TIMEOUT = 10 * time.Second
+)
+
+var (
+	ErrUnknownProvider = errors.New("oauth: unknown provider")
+	ErrState           = errors.New("oauth: invalid state")
+	ErrFlowExpired     = errors.New("oauth: login took too long")
+	ErrNoIdentity      = errors.New("oauth: provider returned no identity")
+	ErrNonce           = errors.New("oauth: invalid nonce")
+	ErrAudience        = errors.New("oauth: id token issued for another client")
+	ErrIssuer          = errors.New("oauth: invalid issuer")
+	ErrExpired         = errors.New("oauth: id token expired")
+	ErrNoKey           = errors.New("oauth: no key found to verify the id token")
+)
+
+// Provider is an oauth2 or oidc provider, OIDC endpoints are discovered from Issuer when empty
+type Provider struct {
+	// Name is used in urls, /auth/oauth/<name>, and to link identities
+	Name string
+	// Label is displayed on login buttons
+	Label        string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Issuer enable OpenID Connect, the discovery document is read from Issuer/.well-known/openid-configuration
+	Issuer      string
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	JWKSURL     string
+	// AuthParams are added to the authorization url, ex: {"prompt": "select_account"}
+	AuthParams map[string]string
+	// BasicAuth send the client credentials using basic auth to the token endpoint instead of the form
+	BasicAuth bool
+	// IdentityFunc return the identity for providers without id token
+	IdentityFunc func(ctx context.Context, p *Provider, tok *Token) (Identity, error)
+	Client       *http.Client
+
+	mu         sync.Mutex
+	discovered bool
+	keys       *keySet
+}
+
+// Token is the response of the token endpoint
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Identity is the external account of a user
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+	Claims        map[string]any
+}
+
+// Flow is kept between the redirection to the provider and the callback, usually in the session
+type Flow struct {
+	Provider    string `json:"provider"`
+	State       string `json:"state"`
+	Nonce       string `json:"nonce"`
+	Verifier    string `json:"verifier"`
+	RedirectURI string `json:"redirect_uri"`
+	// Next is where to go after login
+	Next      string `json:"next,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Expired report if the flow is older than FLOW_TTL
+func (f Flow) Expired(now time.Time) bool {
+	return now.Sub(time.Unix(f.CreatedAt, 0)) > FLOW_TTL
+}
+
+var (
+	providers   = map[string]*Provider{}
+	providersMu sync.RWMutex
+)
+
+// Register make providers available by name, see Get
+func Register(ps ...*Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	for _, p := range ps {
+		providers[p.Name] = p
+	}
+}
+
+// Get return the provider registered as name
+func Get(name string) (*Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// Providers return the registered providers sorted by name
+func Providers() []*Provider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	res := make([]*Provider, 0, len(providers))
+	for _, p := range providers {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Challenge return the S256 PKCE challenge of verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewFlow start a login redirecting back to redirectURI
+func (p *Provider) NewFlow(redirectURI string) Flow {
+	return Flow{
+		Provider:    p.Name,
+		State:       randomString(24),
+		Nonce:       randomString(24),
+		Verifier:    randomString(32),
+		RedirectURI: redirectURI,
+		CreatedAt:   time.Now().Unix(),
+	}
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{Timeout: HTTP_TIMEOUT}
+}
+
+// IsOIDC report if p is an OpenID Connect provider
+func (p *Provider) IsOIDC() bool {
+	return p.Issuer != ""
+}
+
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JwksURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Discover fill the empty endpoints of p from its discovery document, it's called once by the flow methods
+func (p *Provider) Discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovered || !p.IsOIDC() {
+		return nil
+	}
+	var d discovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", "", &d); err != nil {
+		return err
+	}
+	if d.Issuer != p.Issuer {
+		return fmt.Errorf("%w: discovery document of %s is for %s", ErrIssuer, p.Issuer, d.Issuer)
+	}
+	if p.AuthURL == "" {
+		p.AuthURL = d.AuthorizationEndpoint
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = d.TokenEndpoint
+	}
+	if p.UserInfoURL == "" {
+		p.UserInfoURL = d.UserinfoEndpoint
+	}
+	if p.JWKSURL == "" {
+		p.JWKSURL = d.JwksURI
+	}
+	if len(d.TokenAuthMethods) > 0 && !contains(d.TokenAuthMethods, "client_secret_post") && contains(d.TokenAuthMethods, "client_secret_basic") {
+		p.BasicAuth = true
+	}
+	p.discovered = true
+	return nil
+}
+
+// AuthCodeURL return the url to redirect the user to
+func (p *Provider) AuthCodeURL(ctx context.Context, f Flow) (string, error) {
+	if err := p.Discover(ctx); err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", f.RedirectURI)
+	q.Set("state", f.State)
+	q.Set("code_challenge", Challenge(f.Verifier))
+	q.Set("code_challenge_method", "S256")
+	if len(p.Scopes) > 0 {
+		q.Set("scope", strings.Join(p.Scopes, " "))
+	}
+	if p.IsOIDC() {
+		q.Set("nonce", f.Nonce)
+	}
+	for k, v := range p.AuthParams {
+		q.Set(k, v)
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + q.Encode(), nil
+}
+
+// Exchange trade the code received on the callback for tokens
+func (p *Provider) Exchange(ctx context.Context, code string, f Flow) (*Token, error) {
+	if err := p.Discover(ctx); err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", f.RedirectURI)
+	form.Set("code_verifier", f.Verifier)
+	if !p.BasicAuth {
+		form.Set("client_id", p.ClientID)
+		if p.ClientSecret != "" {
+			form.Set("client_secret", p.ClientSecret)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.BasicAuth {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	var tok struct {
+		Token
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.do(req, &tok)
+	if err != nil {
+		return nil, err
+	}
+	// github answer errors with 200
+	if tok.Error != "" || status != http.StatusOK || tok.AccessToken == "" {
+		return nil, fmt.Errorf("oauth: token exchange with %s failed: %s %s", p.Name, tok.Error, tok.ErrorDescription)
+	}
+	return &tok.Token, nil
+}
+
+// Identity return the identity of the user who obtained tok, the id token is verified using f.Nonce
+func (p *Provider) Identity(ctx context.Context, tok *Token, f Flow) (Identity, error) {
+	if p.IdentityFunc != nil {
+		id, err := p.IdentityFunc(ctx, p, tok)
+		id.Provider = p.Name
+		if err == nil && id.Subject == "" {
+			err = ErrNoIdentity
+		}
+		return id, err
+	}
+	var claims map[string]any
+	if tok.IDToken != "" {
+		c, err := p.VerifyIDToken(ctx, tok.IDToken, f.Nonce)
+		if err != nil {
+			return Identity{}, err
+		}
+		claims = c
+	}
+	// some providers keep the email out of the id token
+	if p.UserInfoURL != "" && (claims == nil || claims["email"] == nil) {
+		info := map[string]any{}
+		if err := p.getJSON(ctx, p.UserInfoURL, tok.AccessToken, &info); err != nil {
+			return Identity{}, err
+		}
+		if claims == nil {
+			claims = info
+		} else if info["sub"] == claims["sub"] {
+			for k, v := range info {
+				if _, ok := claims[k]; !ok {
+					claims[k] = v
+				}
+			}
+		}
+	}
+	id := IdentityFromClaims(claims)
+	id.Provider = p.Name
+	if id.Subject == "" {
+		return id, ErrNoIdentity
+	}
+	return id, nil
+}
+
+// IdentityFromClaims map standard oidc claims to an Identity
+func IdentityFromClaims(claims map[string]any) Identity {
+	id := Identity{Claims: claims}
+	id.Subject = claimString(claims["sub"])
+	id.Email = claimString(claims["email"])
+	id.Name = claimString(claims["name"])
+	id.Picture = claimString(claims["picture"])
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		id.EmailVerified = v
+	case string:
+		id.EmailVerified = v == "true"
+	}
+	return id
+}
+
+func claimString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return fmt.Sprintf("%.0f", t)
+	case json.Number:
+		return t.String()
+	}
+	return ""
+}
+
+func (p *Provider) getJSON(ctx context.Context, u, accessToken string, dest any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	status, err := p.do(req, dest)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("oauth: %s answered %d", u, status)
+	}
+	return err
+}
+
+func (p *Provider) do(req *http.Request, dest any) (int, error) {
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, dest); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("oauth: invalid json from %s: %w", req.URL.Host, err)
+	}
+	return resp.StatusCode, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package oauth_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/kamalshkeir/kago/core/kamux/oauth"
+	"github.com/kamalshkeir/kago/core/kamux/oauth/oauthtest"
+)
+
+// login run the flow up to the callback, returning the code
+func login(t *testing.T, srv *oauthtest.Server, p *oauth.Provider, flow oauth.Flow) string {
+	t.Helper()
+	u, err := p.AuthCodeURL(context.Background(), flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := srv.Authorize(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := url.Parse(cb)
+	if parsed.Query().Get("state") != flow.State {
+		t.Fatal("state not returned", cb)
+	}
+	return parsed.Query().Get("code")
+}
+
+func TestFlow(t *testing.T) {
+	srv := oauthtest.New("client", "secret")
+	defer srv.Close()
+	p := srv.Provider("mock")
+	ctx := context.Background()
+
+	flow := p.NewFlow("http://app.test/auth/oauth/mock/callback")
+	code := login(t, srv, p, flow)
+	tok, err := p.Exchange(ctx, code, flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := p.Identity(ctx, tok, flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Provider != "mock" || id.Subject != "1234" || id.Email != "user@example.com" || !id.EmailVerified {
+		t.Fatal("identity", id)
+	}
+	if _, err := p.Exchange(ctx, code, flow); err == nil {
+		t.Fatal("code used twice")
+	}
+
+	// pkce: the code is bound to the verifier of the flow that started the login
+	stolen := p.NewFlow(flow.RedirectURI)
+	code = login(t, srv, p, flow)
+	if _, err := p.Exchange(ctx, code, stolen); err == nil {
+		t.Fatal("code exchanged without its verifier")
+	}
+
+	// the id token must carry the nonce of the flow
+	code = login(t, srv, p, flow)
+	tok, _ = p.Exchange(ctx, code, flow)
+	if _, err := p.Identity(ctx, tok, stolen); !errors.Is(err, oauth.ErrNonce) {
+		t.Fatal("nonce not checked", err)
+	}
+
+	srv.Claims = func(c map[string]any) { c["aud"] = "other-client" }
+	code = login(t, srv, p, flow)
+	tok, _ = p.Exchange(ctx, code, flow)
+	if _, err := p.Identity(ctx, tok, flow); !errors.Is(err, oauth.ErrAudience) {
+		t.Fatal("audience not checked", err)
+	}
+	srv.Claims = func(c map[string]any) { c["iss"] = "https://evil.test" }
+	code = login(t, srv, p, flow)
+	tok, _ = p.Exchange(ctx, code, flow)
+	if _, err := p.Identity(ctx, tok, flow); !errors.Is(err, oauth.ErrIssuer) {
+		t.Fatal("issuer not checked", err)
+	}
+	srv.Claims = nil
+}
+
+func TestKeyRotationAndBasicAuth(t *testing.T) {
+	srv := oauthtest.New("client", "secret")
+	defer srv.Close()
+	p := srv.Provider("mock")
+	p.BasicAuth = true
+	ctx := context.Background()
+	oauth.JWKS_REFRESH_MIN = 0
+
+	for i := 0; i < 2; i++ {
+		flow := p.NewFlow("http://app.test/callback")
+		tok, err := p.Exchange(ctx, login(t, srv, p, flow), flow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Identity(ctx, tok, flow); err != nil {
+			t.Fatal("after rotation", i, err)
+		}
+		srv.RotateKey()
+	}
+
+	p.ClientSecret = "wrong"
+	flow := p.NewFlow("http://app.test/callback")
+	if _, err := p.Exchange(ctx, login(t, srv, p, flow), flow); err == nil {
+		t.Fatal("wrong client secret accepted")
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	store := oauth.NewMemoryIdentities()
+	id := oauth.Identity{Provider: "google", Subject: "42", Email: "a@b.c"}
+	if _, err := store.Find("google", "42"); err != oauth.ErrNotLinked {
+		t.Fatal(err)
+	}
+	_ = store.Link(3, id)
+	if l, err := store.Find("google", "42"); err != nil || l.UserId != 3 {
+		t.Fatal(l, err)
+	}
+	_ = store.Unlink(3, "google")
+	if links, _ := store.UserIdentities(3); len(links) != 0 {
+		t.Fatal(links)
+	}
+}
@@ -0,0 +1,274 @@
+// Package oauthtest serve a mock OpenID Connect provider on a kamux router, to test logins without reaching a real provider
+package oauthtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/kamux"
+	"github.com/kamalshkeir/kago/core/kamux/jwt"
+	"github.com/kamalshkeir/kago/core/kamux/oauth"
+)
+
+// User is the account logging in at the mock provider
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Server is a mock provider supporting discovery, the authorization code flow with PKCE, userinfo and jwks
+type Server struct {
+	*httptest.Server
+	Router       *kamux.Router
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// User is the account of the next logins
+	User User
+	// Claims can change the claims of the next id tokens, to test validation failures
+	Claims func(claims map[string]any)
+
+	mu     sync.Mutex
+	keys   []*rsa.PrivateKey
+	codes  map[string]grant
+	tokens map[string]User
+}
+
+type grant struct {
+	user        User
+	redirectURI string
+	challenge   string
+	nonce       string
+	expires     time.Time
+}
+
+// New start a mock provider accepting clientID and clientSecret, call Close when done
+func New(clientID, clientSecret string) *Server {
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		User:         User{Subject: "1234", Email: "user@example.com", EmailVerified: true, Name: "Test User"},
+		codes:        map[string]grant{},
+		tokens:       map[string]User{},
+		Router: &kamux.Router{
+			Routes:       map[int][]kamux.Route{},
+			DefaultRoute: func(c *kamux.Context) { c.Status(http.StatusNotFound).Text("Page Not Found") },
+		},
+	}
+	s.RotateKey()
+	s.Router.GET("/.well-known/openid-configuration", s.discovery)
+	s.Router.GET("/authorize", s.authorize)
+	s.Router.POST("/token", s.token, "*")
+	s.Router.GET("/userinfo", s.userinfo)
+	s.Router.GET("/jwks", s.jwks)
+	s.Server = httptest.NewServer(s.Router)
+	s.Issuer = s.URL
+	return s
+}
+
+// Provider return an oidc provider configured to use s
+func (s *Server) Provider(name string) *oauth.Provider {
+	p := oauth.OIDC(name, s.Issuer, s.ClientID, s.ClientSecret)
+	p.Client = s.Client()
+	return p
+}
+
+// RotateKey sign the next id tokens with a new key, the previous keys stay published
+func (s *Server) RotateKey() {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s.mu.Lock()
+	s.keys = append(s.keys, k)
+	s.mu.Unlock()
+}
+
+// Authorize act as the browser of User on authURL, and return the callback url the provider redirect to
+func (s *Server) Authorize(authURL string) (string, error) {
+	client := s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return "", errors.New("oauthtest: authorize answered " + resp.Status)
+	}
+	return resp.Header.Get("Location"), nil
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) discovery(c *kamux.Context) {
+	c.Json(map[string]any{
+		"issuer":                                s.Issuer,
+		"authorization_endpoint":                s.Issuer + "/authorize",
+		"token_endpoint":                        s.Issuer + "/token",
+		"userinfo_endpoint":                     s.Issuer + "/userinfo",
+		"jwks_uri":                              s.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.RS256},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_post", "client_secret_basic"},
+	})
+}
+
+func (s *Server) authorize(c *kamux.Context) {
+	q := c.Request.URL.Query()
+	if q.Get("client_id") != s.ClientID || q.Get("response_type") != "code" || q.Get("redirect_uri") == "" {
+		c.Status(http.StatusBadRequest).Text("invalid request")
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		c.Status(http.StatusBadRequest).Text("pkce required")
+		return
+	}
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = grant{
+		user:        s.User,
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		expires:     time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		c.Status(http.StatusBadRequest).Text("invalid redirect_uri")
+		return
+	}
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	c.Status(http.StatusFound).Redirect(redirect.String())
+}
+
+func (s *Server) token(c *kamux.Context) {
+	tokenError := func(e string) {
+		c.Status(http.StatusBadRequest).Json(map[string]any{"error": e})
+	}
+	r := c.Request
+	if err := r.ParseForm(); err != nil {
+		tokenError("invalid_request")
+		return
+	}
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id != s.ClientID || secret != s.ClientSecret {
+		tokenError("invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError("unsupported_grant_type")
+		return
+	}
+	s.mu.Lock()
+	g, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+	if !ok || time.Now().After(g.expires) || g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		oauth.Challenge(r.PostForm.Get("code_verifier")) != g.challenge {
+		tokenError("invalid_grant")
+		return
+	}
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            s.Issuer,
+		"sub":            g.user.Subject,
+		"aud":            s.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+	}
+	if s.Claims != nil {
+		s.Claims(claims)
+	}
+	s.mu.Lock()
+	key := s.keys[len(s.keys)-1]
+	kid := keyID(len(s.keys) - 1)
+	access := randomString()
+	s.tokens[access] = g.user
+	s.mu.Unlock()
+	keys := jwt.RSA(key)
+	keys.KeyID = kid
+	idToken, err := keys.Sign(claims)
+	if err != nil {
+		tokenError("server_error")
+		return
+	}
+	c.SetHeader("Cache-Control", "no-store")
+	c.Json(map[string]any{
+		"access_token": access,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) userinfo(c *kamux.Context) {
+	tok := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	u, ok := s.tokens[tok]
+	s.mu.Unlock()
+	if !ok {
+		c.Status(http.StatusUnauthorized).Json(map[string]any{"error": "invalid_token"})
+		return
+	}
+	c.Json(map[string]any{
+		"sub":            u.Subject,
+		"email":          u.Email,
+		"email_verified": u.EmailVerified,
+		"name":           u.Name,
+	})
+}
+
+func keyID(i int) string {
+	return "key-" + string(rune('a'+i))
+}
+
+func (s *Server) jwks(c *kamux.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b64 := base64.RawURLEncoding
+	keys := []oauth.JWK{}
+	for i, k := range s.keys {
+		keys = append(keys, oauth.JWK{
+			Kty: "RSA",
+			Kid: keyID(i),
+			Use: "sig",
+			Alg: jwt.RS256,
+			N:   b64.EncodeToString(k.N.Bytes()),
+			E:   b64.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		})
+	}
+	c.Json(oauth.JWKS{Keys: keys})
+}
@@ -0,0 +1,106 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// OIDC return an OpenID Connect provider configured from the discovery document of issuer
+func OIDC(name, issuer, clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         name,
+		Label:        name,
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+func Google(clientID, clientSecret string) *Provider {
+	p := OIDC("google", "https://accounts.google.com", clientID, clientSecret)
+	p.Label = "Google"
+	return p
+}
+
+// Microsoft return a provider for the Entra ID (Azure AD) tenant, a tenant id, the multi tenant "common" endpoint issue tokens of varying issuers
+func Microsoft(tenant, clientID, clientSecret string) *Provider {
+	p := OIDC("microsoft", "https://login.microsoftonline.com/"+tenant+"/v2.0", clientID, clientSecret)
+	p.Label = "Microsoft"
+	return p
+}
+
+// GitLab return a provider for gitlab.com, or a self hosted instance at baseURL
+func GitLab(clientID, clientSecret string, baseURL ...string) *Provider {
+	issuer := "https://gitlab.com"
+	if len(baseURL) > 0 {
+		issuer = strings.TrimSuffix(baseURL[0], "/")
+	}
+	p := OIDC("gitlab", issuer, clientID, clientSecret)
+	p.Label = "GitLab"
+	return p
+}
+
+// Okta return a provider for the default authorization server of domain, ex: example.okta.com
+func Okta(domain, clientID, clientSecret string) *Provider {
+	p := OIDC("okta", "https://"+domain+"/oauth2/default", clientID, clientSecret)
+	p.Label = "Okta"
+	return p
+}
+
+// Auth0 return a provider for the tenant domain, ex: example.eu.auth0.com
+func Auth0(domain, clientID, clientSecret string) *Provider {
+	p := OIDC("auth0", "https://"+domain+"/", clientID, clientSecret)
+	p.Label = "Auth0"
+	return p
+}
+
+// Keycloak return a provider for realm of the keycloak server at baseURL
+func Keycloak(baseURL, realm, clientID, clientSecret string) *Provider {
+	p := OIDC("keycloak", strings.TrimSuffix(baseURL, "/")+"/realms/"+realm, clientID, clientSecret)
+	p.Label = "Keycloak"
+	return p
+}
+
+// GitHub is a plain oauth2 provider, its identity is read from the api, using the primary verified email
+func GitHub(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         "github",
+		Label:        "GitHub",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"read:user", "user:email"},
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserInfoURL:  "https://api.github.com/user",
+		IdentityFunc: githubIdentity,
+	}
+}
+
+func githubIdentity(ctx context.Context, p *Provider, tok *Token) (Identity, error) {
+	user := map[string]any{}
+	if err := p.getJSON(ctx, p.UserInfoURL, tok.AccessToken, &user); err != nil {
+		return Identity{}, err
+	}
+	id := Identity{
+		Subject: claimString(user["id"]),
+		Name:    claimString(user["name"]),
+		Picture: claimString(user["avatar_url"]),
+		Claims:  user,
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.UserInfoURL, "/user")+"/user/emails", tok.AccessToken, &emails); err != nil {
+		return id, fmt.Errorf("oauth: unable to read github emails: %w", err)
+	}
+	for _, e := range emails {
+		if e.Primary {
+			id.Email, id.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return id, nil
+}
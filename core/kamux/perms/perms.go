@@ -0,0 +1,237 @@
+// Package perms answer what a user can do, from the groups and permissions tables migrated by orm.Migrate.
+// Permissions are named table.action, ex: orders.change, admins (IsAdmin) have all of them
+package perms
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+// actions of the permissions created for each table
+const (
+	View   = "view"
+	Add    = "add"
+	Change = "change"
+	Delete = "delete"
+	Drop   = "drop"
+)
+
+var (
+	ErrUnknownGroup      = errors.New("unknown group")
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+// Codename return the name of the permission to do action on table
+func Codename(table, action string) string {
+	return table + "." + action
+}
+
+func migrated() bool {
+	_, err := orm.GetMemoryTable("auth_permissions")
+	return err == nil
+}
+
+func toInt(v any) int {
+	switch t := v.(type) {
+	case int64:
+		return int(t)
+	case int32:
+		return int(t)
+	case int:
+		return t
+	case float64:
+		return int(t)
+	case []byte:
+		n, _ := strconv.Atoi(string(t))
+		return n
+	case string:
+		n, _ := strconv.Atoi(t)
+		return n
+	}
+	return 0
+}
+
+// Of return the permissions of user, given directly or by their groups, the queries are cached by the orm until a write
+func Of(user models.User) map[string]bool {
+	res := map[string]bool{}
+	if user.Id == 0 || !migrated() {
+		return res
+	}
+	rows, err := orm.Table("auth_permissions").Query(
+		"SELECT codename FROM auth_permissions WHERE id IN (SELECT permission_id FROM auth_user_permissions WHERE user_id = ?)"+
+			" OR id IN (SELECT gp.permission_id FROM auth_group_permissions gp JOIN auth_user_groups ug ON ug.group_id = gp.group_id WHERE ug.user_id = ?)",
+		user.Id, user.Id).All()
+	if err != nil {
+		return res
+	}
+	for _, r := range rows {
+		switch v := r["codename"].(type) {
+		case string:
+			res[v] = true
+		case []byte:
+			res[string(v)] = true
+		}
+	}
+	return res
+}
+
+// Has report if user have all codenames
+func Has(user models.User, codenames ...string) bool {
+	if user.IsAdmin {
+		return true
+	}
+	if user.Id == 0 {
+		return false
+	}
+	all := Of(user)
+	for _, c := range codenames {
+		if !all[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// IsStaff report if user can enter the admin, being admin or having at least a permission
+func IsStaff(user models.User) bool {
+	return user.IsAdmin || len(Of(user)) > 0
+}
+
+func groupId(name string) (int, error) {
+	g, err := orm.Table("auth_groups").Where("name = ?", name).One()
+	if err != nil || len(g) == 0 {
+		return 0, ErrUnknownGroup
+	}
+	return toInt(g["id"]), nil
+}
+
+func permissionIds(codenames []string) ([]int, error) {
+	ids := make([]int, 0, len(codenames))
+	for _, c := range codenames {
+		p, err := orm.Table("auth_permissions").Where("codename = ?", c).One()
+		if err != nil || len(p) == 0 {
+			return nil, ErrUnknownPermission
+		}
+		ids = append(ids, toInt(p["id"]))
+	}
+	return ids, nil
+}
+
+// CreateGroup create the group name, having codenames
+func CreateGroup(name string, codenames ...string) error {
+	if _, err := orm.Table("auth_groups").Insert("name", []any{name}); err != nil {
+		return err
+	}
+	return Grant(name, codenames...)
+}
+
+// DeleteGroup delete the group name, its users lose its permissions
+func DeleteGroup(name string) error {
+	id, err := groupId(name)
+	if err != nil {
+		return err
+	}
+	for _, t := range []string{"auth_group_permissions", "auth_user_groups"} {
+		if _, err := orm.Table(t).Where("group_id = ?", id).Delete(); err != nil {
+			return err
+		}
+	}
+	_, err = orm.Table("auth_groups").Where("id = ?", id).Delete()
+	return err
+}
+
+// Grant give codenames to the group
+func Grant(group string, codenames ...string) error {
+	id, err := groupId(group)
+	if err != nil {
+		return err
+	}
+	pids, err := permissionIds(codenames)
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if g, err := orm.Table("auth_group_permissions").Where("group_id = ? AND permission_id = ?", id, pid).One(); err == nil && len(g) > 0 {
+			continue
+		}
+		if _, err := orm.Table("auth_group_permissions").Insert("group_id,permission_id", []any{id, pid}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revoke remove codenames from the group
+func Revoke(group string, codenames ...string) error {
+	id, err := groupId(group)
+	if err != nil {
+		return err
+	}
+	pids, err := permissionIds(codenames)
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if _, err := orm.Table("auth_group_permissions").Where("group_id = ? AND permission_id = ?", id, pid).Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddUser add userId to the group
+func AddUser(group string, userId int) error {
+	id, err := groupId(group)
+	if err != nil {
+		return err
+	}
+	if g, err := orm.Table("auth_user_groups").Where("user_id = ? AND group_id = ?", userId, id).One(); err == nil && len(g) > 0 {
+		return nil
+	}
+	_, err = orm.Table("auth_user_groups").Insert("user_id,group_id", []any{userId, id})
+	return err
+}
+
+// RemoveUser remove userId from the group
+func RemoveUser(group string, userId int) error {
+	id, err := groupId(group)
+	if err != nil {
+		return err
+	}
+	_, err = orm.Table("auth_user_groups").Where("user_id = ? AND group_id = ?", userId, id).Delete()
+	return err
+}
+
+// GrantUser give codenames to userId directly
+func GrantUser(userId int, codenames ...string) error {
+	pids, err := permissionIds(codenames)
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if g, err := orm.Table("auth_user_permissions").Where("user_id = ? AND permission_id = ?", userId, pid).One(); err == nil && len(g) > 0 {
+			continue
+		}
+		if _, err := orm.Table("auth_user_permissions").Insert("user_id,permission_id", []any{userId, pid}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeUser remove codenames given directly to userId
+func RevokeUser(userId int, codenames ...string) error {
+	pids, err := permissionIds(codenames)
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if _, err := orm.Table("auth_user_permissions").Where("user_id = ? AND permission_id = ?", userId, pid).Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
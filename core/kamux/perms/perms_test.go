@@ -0,0 +1,27 @@
+package perms
+
+import (
+	"testing"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+)
+
+func TestWithoutTables(t *testing.T) {
+	if Codename("orders", Change) != "orders.change" {
+		t.Fatal(Codename("orders", Change))
+	}
+	admin := models.User{Id: 1, IsAdmin: true}
+	if !Has(admin, "orders.drop") || !IsStaff(admin) {
+		t.Fatal("admins have all permissions")
+	}
+	user := models.User{Id: 2}
+	if Has(user, "orders.view") || IsStaff(user) || len(Of(user)) != 0 {
+		t.Fatal("permission without tables")
+	}
+	if !Has(user) {
+		t.Fatal("no permission required")
+	}
+	if Has(models.User{}) {
+		t.Fatal("anonymous user")
+	}
+}
@@ -1,40 +1,383 @@
+// Package ratelimiter limit the requests of each client, identified by ip, user, api key or any key of the request.
+// A Limiter apply a Policy using a sliding window or a token bucket, counted in STORE, memory or the orm table rate_limits
+// for deployments with many instances. Responses carry RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and Retry-After when rejected
 package ratelimiter
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/utils"
+	"github.com/kamalshkeir/kago/core/utils/logger"
 	"golang.org/x/time/rate"
 )
 
-var banned = sync.Map{}
+type Algorithm int
+
+const (
+	// SlidingWindow allow Limit requests in any Window, weighting the previous window by its overlap
+	SlidingWindow Algorithm = iota
+	// TokenBucket allow bursts of Burst requests, refilled at Limit per Window
+	TokenBucket
+)
+
+// Policy describe how many requests a client can make
+type Policy struct {
+	// Name prefix the keys of the policy, policies with the same name share their counters, unique by default
+	Name      string
+	Limit     int
+	Window    time.Duration
+	Algorithm Algorithm
+	// Burst is the size of the token bucket, Limit by default
+	Burst int
+	// Ban reject the client for this long once limited, even if tokens come back
+	Ban time.Duration
+	// Key identify the client of a request, KeyByIP by default, an empty key is not limited
+	Key func(r *http.Request) string
+	// Store count the requests, STORE by default
+	Store Store
+}
+
+// Result of a request for a Policy, Remaining can be negative for the sliding window
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// LIMITER_TOKENS is the burst of requests LIMITER allow by ip, refilled at one per second,
+// LIMITER_TIMEOUT how long the clients exceeding it are banned
 var LIMITER_TOKENS = 50
 var LIMITER_TIMEOUT = 5 * time.Minute
+
+// LIMITER is the global middleware, limiting each ip separately, see New for other policies
 var LIMITER = func(next http.Handler) http.Handler {
-	var limiter = rate.NewLimiter(1, LIMITER_TOKENS)
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		v, ok := banned.Load(r.RemoteAddr)
+	return New(Policy{
+		Name:      "global",
+		Limit:     1,
+		Window:    time.Second,
+		Algorithm: TokenBucket,
+		Burst:     LIMITER_TOKENS,
+		Ban:       LIMITER_TIMEOUT,
+	}).Middleware(next)
+}
+
+// Limiter apply a Policy
+type Limiter struct {
+	Policy
+}
+
+var names int64
+
+// New return a Limiter applying p, Limit and Window must be positive
+func New(p Policy) *Limiter {
+	if p.Limit <= 0 {
+		p.Limit = 1
+	}
+	if p.Window <= 0 {
+		p.Window = time.Second
+	}
+	if p.Burst <= 0 {
+		p.Burst = p.Limit
+	}
+	if p.Key == nil {
+		p.Key = KeyByIP
+	}
+	if p.Name == "" {
+		p.Name = "p" + strconv.FormatInt(atomic.AddInt64(&names, 1), 10)
+	}
+	return &Limiter{Policy: p}
+}
+
+func (l *Limiter) store() Store {
+	if l.Store != nil {
+		return l.Store
+	}
+	return STORE
+}
+
+// Allow count the request r and report if it's allowed, requests without key are always allowed
+func (l *Limiter) Allow(r *http.Request) (Result, error) {
+	key := l.Key(r)
+	if key == "" {
+		return Result{Allowed: true, Limit: l.Limit, Remaining: l.Limit}, nil
+	}
+	return l.AllowKey(key, time.Now())
+}
+
+// AllowKey count a request of key at now
+func (l *Limiter) AllowKey(key string, now time.Time) (Result, error) {
+	key = l.Name + ":" + key
+	if l.Ban > 0 {
+		if e, ok, err := l.store().Get("ban:"+key, now); err != nil {
+			return Result{Allowed: true}, err
+		} else if ok {
+			wait := time.Unix(e.ExpiresAt, 0).Sub(now)
+			return Result{Limit: l.Limit, Reset: wait, RetryAfter: wait}, nil
+		}
+	}
+	var res Result
+	var err error
+	if l.Algorithm == TokenBucket {
+		res, err = l.tokenBucket(key, now)
+	} else {
+		res, err = l.slidingWindow(key, now)
+	}
+	if err == nil && !res.Allowed && l.Ban > 0 {
+		if _, err := l.store().Incr("ban:"+key, now, now.Add(l.Ban)); err != nil {
+			return res, err
+		}
+		res.RetryAfter, res.Reset = l.Ban, l.Ban
+	}
+	return res, err
+}
+
+func (l *Limiter) slidingWindow(key string, now time.Time) (Result, error) {
+	w := l.Window
+	idx := now.UnixNano() / int64(w)
+	start := time.Unix(0, idx*int64(w))
+	end := start.Add(w)
+	cur, err := l.store().Incr(key+":"+strconv.FormatInt(idx, 10), now, end.Add(w))
+	if err != nil {
+		return Result{Allowed: true}, err
+	}
+	var prev int64
+	if e, ok, err := l.store().Get(key+":"+strconv.FormatInt(idx-1, 10), now); err != nil {
+		return Result{Allowed: true}, err
+	} else if ok {
+		prev = e.Value
+	}
+	weight := float64(end.Sub(now)) / float64(w)
+	count := float64(prev)*weight + float64(cur)
+	limit := float64(l.Limit)
+	res := Result{
+		Allowed:   count <= limit,
+		Limit:     l.Limit,
+		Remaining: int(math.Floor(limit - count)),
+		Reset:     end.Sub(now),
+	}
+	if !res.Allowed {
+		// time until the weighted count drop under the limit
+		if float64(cur) < limit && prev > 0 {
+			res.RetryAfter = end.Sub(now) - time.Duration((limit-float64(cur))/float64(prev)*float64(w))
+		} else {
+			res.RetryAfter = end.Sub(now) + time.Duration((1-limit/float64(cur+1))*float64(w))
+		}
+		if res.RetryAfter < time.Second {
+			res.RetryAfter = time.Second
+		}
+	}
+	return res, nil
+}
+
+// tokenBucket keep thousandths of tokens in Value and the last refill in UpdatedAt, in unix milliseconds,
+// the bucket is replaced only if unchanged since read, so concurrent instances can't spend the same token
+func (l *Limiter) tokenBucket(key string, now time.Time) (Result, error) {
+	capacity := int64(l.Burst) * 1000
+	perMs := float64(l.Limit) * 1000 / float64(l.Window.Milliseconds())
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	for try := 0; try < 5; try++ {
+		old, ok, err := l.store().Get(key, now)
+		if err != nil {
+			return Result{Allowed: true}, err
+		}
+		tokens := capacity
 		if ok {
-			if time.Since(v.(time.Time)) > LIMITER_TIMEOUT {
-				banned.Delete(r.RemoteAddr)
-			} else {
-				w.WriteHeader(http.StatusTooManyRequests)
-				w.Write([]byte("<h1>YOU DID TOO MANY REQUEST, YOU HAVE BEEN BANNED FOR 5 MINUTES </h1>"))
-				banned.Store(r.RemoteAddr, time.Now())
-				return
+			tokens = old.Value + int64(float64(nowMs-old.UpdatedAt)*perMs)
+			if tokens > capacity {
+				tokens = capacity
 			}
 		}
-		if !limiter.Allow() {
-			w.WriteHeader(http.StatusTooManyRequests)
-			w.Write([]byte("<h1>YOU DID TOO MANY REQUEST, YOU HAVE BEEN BANNED FOR 5 MINUTES </h1>"))
-			banned.Store(r.RemoteAddr, time.Now())
-			return
+		res := Result{Limit: l.Burst}
+		if tokens < 1000 {
+			res.Remaining = 0
+			res.RetryAfter = time.Duration(float64(1000-tokens)/perMs) * time.Millisecond
+			res.Reset = time.Duration(float64(capacity-tokens)/perMs) * time.Millisecond
+			return res, nil
+		}
+		tokens -= 1000
+		full := time.Duration(float64(capacity-tokens)/perMs) * time.Millisecond
+		swapped, err := l.store().Swap(key, old, models.RateLimit{
+			LimitKey:  key,
+			Value:     tokens,
+			UpdatedAt: nowMs,
+			ExpiresAt: now.Add(full).Unix() + 1,
+		})
+		if err != nil {
+			return Result{Allowed: true}, err
+		}
+		if swapped {
+			res.Allowed = true
+			res.Remaining = int(tokens / 1000)
+			res.Reset = full
+			return res, nil
+		}
+	}
+	// too busy to agree on the bucket, let it go rather than failing
+	return Result{Allowed: true, Limit: l.Burst}, nil
+}
+
+// SetHeaders write the RateLimit-* headers of res, and Retry-After if rejected
+func SetHeaders(w http.ResponseWriter, res Result) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	remaining := res.Remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+	h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(seconds(res.RetryAfter)))
+	}
+}
+
+func seconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// REJECT answer limited requests, json for api clients, html otherwise
+var REJECT = func(w http.ResponseWriter, r *http.Request, res Result) {
+	if wantsJson(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"error":       "too many requests",
+			"retry_after": seconds(res.RetryAfter),
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, "<h1>Too many requests</h1><p>Please try again in %d seconds.</p>", seconds(res.RetryAfter))
+}
+
+func wantsJson(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") ||
+		r.Header.Get("X-Requested-With") == "XMLHttpRequest"
+}
+
+// Check count r, write the headers, and the rejection if limited, it return true if the request can continue.
+// Store errors are logged and let the request go
+func (l *Limiter) Check(w http.ResponseWriter, r *http.Request) bool {
+	res, err := l.Allow(r)
+	if err != nil {
+		logger.Error("ratelimiter:", err)
+		return true
+	}
+	SetHeaders(w, res)
+	if !res.Allowed {
+		REJECT(w, r, res)
+		return false
+	}
+	return true
+}
+
+// Middleware limit all requests handled by next
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.Check(w, r) {
+			next.ServeHTTP(w, r)
 		}
-		next.ServeHTTP(w, r)
 	})
 }
 
+// TRUSTED_PROXIES are the ips or cidrs, like "10.0.0.0/8", of the proxies in front of the app. X-Real-Ip and X-Forwarded-For
+// are only read from them, so clients can't choose their ip, empty to use the address of the connection
+var TRUSTED_PROXIES = []string{}
+
+func trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, p := range TRUSTED_PROXIES {
+		if _, cidr, err := net.ParseCIDR(p); err == nil {
+			if cidr.Contains(addr) {
+				return true
+			}
+		} else if other := net.ParseIP(p); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IP return the ip of the client of r, RemoteAddr without port, or when it's one of TRUSTED_PROXIES, X-Real-Ip
+// or the last X-Forwarded-For address not in TRUSTED_PROXIES
+func IP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !trustedProxy(ip) {
+		return ip
+	}
+	if real := strings.TrimSpace(r.Header.Get("X-Real-Ip")); net.ParseIP(real) != nil {
+		return real
+	}
+	// each proxy append the address it got the request from, the first untrusted from the right is the client
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		f := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(f) == nil {
+			break
+		}
+		ip = f
+		if !trustedProxy(f) {
+			break
+		}
+	}
+	return ip
+}
+
+// KeyByIP limit each ip
+func KeyByIP(r *http.Request) string {
+	return "ip:" + IP(r)
+}
+
+// KeyByUser limit each logged in user, set by Auth, Admin, JWT or RequireScope before the limiter, and anonymous clients by ip
+func KeyByUser(r *http.Request) string {
+	if user, ok := r.Context().Value(utils.ContextKey("user")).(models.User); ok && user.Id != 0 {
+		return "user:" + strconv.Itoa(user.Id)
+	}
+	return KeyByIP(r)
+}
+
+// KeyByAPIKey limit each api key verified by RequireScope before the limiter, and other clients by ip
+func KeyByAPIKey(r *http.Request) string {
+	if k, ok := r.Context().Value(utils.ContextKey("apikey")).(models.APIKey); ok && k.Id != 0 {
+		return "apikey:" + strconv.Itoa(k.Id)
+	}
+	return KeyByIP(r)
+}
+
+// KeyByRoute limit the route for all clients together
+func KeyByRoute(r *http.Request) string {
+	return "route:" + r.Method + " " + r.URL.Path
+}
+
+// KeyByIPAndRoute limit each ip on each route
+func KeyByIPAndRoute(r *http.Request) string {
+	return KeyByIP(r) + ":" + r.Method + " " + r.URL.Path
+}
+
+// Deprecated: IPRateLimiter keep limiters in memory forever, use New(Policy{Key: KeyByIP, ...})
 type IPRateLimiter struct {
 	ips map[string]*rate.Limiter
 	mu  *sync.RWMutex
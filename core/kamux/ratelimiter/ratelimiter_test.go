@@ -0,0 +1,120 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	l := New(Policy{Limit: 3, Window: time.Minute, Store: NewMemory()})
+	now := time.Unix(0, 0).Add(10 * time.Minute)
+	for i := 0; i < 3; i++ {
+		if res, _ := l.AllowKey("a", now); !res.Allowed || res.Remaining != 2-i {
+			t.Fatal(i, res)
+		}
+	}
+	if res, _ := l.AllowKey("a", now); res.Allowed || res.RetryAfter <= 0 {
+		t.Fatal("4th request allowed", res)
+	}
+	if res, _ := l.AllowKey("b", now); !res.Allowed {
+		t.Fatal("other key limited")
+	}
+	// 5/6 of the 4 requests of the previous window still count
+	if res, _ := l.AllowKey("a", now.Add(70*time.Second)); res.Allowed {
+		t.Fatal("previous window ignored", res)
+	}
+	if res, _ := l.AllowKey("a", now.Add(150*time.Second)); !res.Allowed {
+		t.Fatal("old windows counted", res)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	l := New(Policy{Limit: 1, Window: time.Second, Burst: 2, Algorithm: TokenBucket, Store: NewMemory()})
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if res, _ := l.AllowKey("a", now); !res.Allowed {
+			t.Fatal(i, res)
+		}
+	}
+	res, _ := l.AllowKey("a", now)
+	if res.Allowed || res.RetryAfter != time.Second {
+		t.Fatal(res)
+	}
+	if res, _ := l.AllowKey("a", now.Add(time.Second)); !res.Allowed {
+		t.Fatal("not refilled", res)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l := New(Policy{Limit: 1, Window: time.Minute, Ban: time.Hour, Store: NewMemory()})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	w := do("text/html")
+	if w.Code != 200 || w.Header().Get("RateLimit-Limit") != "1" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatal(w.Code, w.Header())
+	}
+	w = do("application/json")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" || !strings.Contains(w.Body.String(), `"error"`) {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+	if w = do("text/html"); w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "<h1>") {
+		t.Fatal("ban ignored", w.Code)
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	defer func(n int) { MEMORY_MAX_KEYS = n }(MEMORY_MAX_KEYS)
+	MEMORY_MAX_KEYS = 10
+	m := NewMemory()
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		_, _ = m.Incr(strings.Repeat("k", i+1), now, now.Add(time.Minute))
+	}
+	if len(m.entries) > 10 {
+		t.Fatal(len(m.entries))
+	}
+}
+
+func TestIP(t *testing.T) {
+	defer func(p []string) { TRUSTED_PROXIES = p }(TRUSTED_PROXIES)
+	req := func(remote, real, forwarded string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remote
+		if real != "" {
+			r.Header.Set("X-Real-Ip", real)
+		}
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return r
+	}
+	TRUSTED_PROXIES = nil
+	if ip := IP(req("1.2.3.4:5678", "9.9.9.9", "8.8.8.8")); ip != "1.2.3.4" {
+		t.Errorf("untrusted headers used, ip = %s", ip)
+	}
+	TRUSTED_PROXIES = []string{"10.0.0.0/8", "192.168.1.1"}
+	tests := []struct {
+		remote, real, forwarded, want string
+	}{
+		{"1.2.3.4:5678", "9.9.9.9", "8.8.8.8", "1.2.3.4"},
+		{"10.0.0.1:5678", "9.9.9.9", "", "9.9.9.9"},
+		{"10.0.0.1:5678", "", "6.6.6.6, 8.8.8.8, 192.168.1.1", "8.8.8.8"},
+		{"192.168.1.1:5678", "", "10.0.0.2", "10.0.0.2"},
+		{"10.0.0.1:5678", "", "", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		if ip := IP(req(tt.remote, tt.real, tt.forwarded)); ip != tt.want {
+			t.Errorf("IP(%s, %q, %q) = %s, want %s", tt.remote, tt.real, tt.forwarded, ip, tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+// Store keep the counters of the limiters
+type Store interface {
+	// Incr add one to the counter key, restarting from one and expiring at expires if missing or expired, and return its value
+	Incr(key string, now time.Time, expires time.Time) (int64, error)
+	// Get return the entry of key, even expired, ok is false if missing or expired
+	Get(key string, now time.Time) (e models.RateLimit, ok bool, err error)
+	// Swap replace the entry old returned by Get by e, false if it changed meanwhile
+	Swap(key string, old, e models.RateLimit) (bool, error)
+	DeleteExpired(now time.Time) error
+}
+
+// STORE is the default store of the limiters, in memory, use NewORM to share the counters between instances,
+// at the cost of queries on each limited request
+var STORE Store = NewMemory()
+
+// MEMORY_MAX_KEYS evict keys of the memory store when reached, the expired first
+var MEMORY_MAX_KEYS = 100_000
+
+// Memory is an in memory Store, counting each instance separately
+type Memory struct {
+	entries map[string]models.RateLimit
+	mu      sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: map[string]models.RateLimit{}}
+}
+
+// evict make room for a new key, m.mu must be held
+func (m *Memory) evict(now time.Time) {
+	if len(m.entries) < MEMORY_MAX_KEYS {
+		return
+	}
+	for k, e := range m.entries {
+		if e.ExpiresAt <= now.Unix() {
+			delete(m.entries, k)
+		}
+	}
+	for k := range m.entries {
+		if len(m.entries) < MEMORY_MAX_KEYS*9/10 {
+			break
+		}
+		delete(m.entries, k)
+	}
+}
+
+func (m *Memory) Incr(key string, now time.Time, expires time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || e.ExpiresAt <= now.Unix() {
+		if !ok {
+			m.evict(now)
+		}
+		e = models.RateLimit{LimitKey: key, ExpiresAt: expires.Unix()}
+	}
+	e.Value++
+	m.entries[key] = e
+	return e.Value, nil
+}
+
+func (m *Memory) Get(key string, now time.Time) (models.RateLimit, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	return e, ok && e.ExpiresAt > now.Unix(), nil
+}
+
+func (m *Memory) Swap(key string, old, e models.RateLimit) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.entries[key]
+	if cur != old {
+		return false, nil
+	}
+	if !ok {
+		m.evict(time.Now())
+	}
+	e.LimitKey = key
+	m.entries[key] = e
+	return true, nil
+}
+
+func (m *Memory) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range m.entries {
+		if e.ExpiresAt <= now.Unix() {
+			delete(m.entries, k)
+		}
+	}
+	return nil
+}
+
+// ORM keep the counters in the table rate_limits, shared by all the instances using the database
+type ORM struct {
+	dbName string
+}
+
+// NewORM return a store using dbName, the default database if empty
+func NewORM(dbName ...string) *ORM {
+	s := &ORM{}
+	if len(dbName) > 0 {
+		s.dbName = dbName[0]
+	}
+	return s
+}
+
+func (o *ORM) Incr(key string, now time.Time, expires time.Time) (int64, error) {
+	for try := 0; try < 2; try++ {
+		n, err := orm.ExecAffected(o.dbName, "UPDATE rate_limits SET value = CASE WHEN expires_at <= ? THEN 1 ELSE value + 1 END,"+
+			" expires_at = CASE WHEN expires_at <= ? THEN ? ELSE expires_at END WHERE limit_key = ?",
+			now.Unix(), now.Unix(), expires.Unix(), key)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			// a concurrent request may insert it first, then update it
+			if _, err := orm.ExecAffected(o.dbName, "INSERT INTO rate_limits (limit_key,value,updated_at,expires_at) VALUES (?,1,0,?)", key, expires.Unix()); err != nil {
+				continue
+			}
+		}
+		e, _, err := o.Get(key, now)
+		return e.Value, err
+	}
+	e, _, err := o.Get(key, now)
+	return e.Value, err
+}
+
+func (o *ORM) Get(key string, now time.Time) (models.RateLimit, bool, error) {
+	rows, err := orm.Query(o.dbName, "SELECT id,value,updated_at,expires_at FROM rate_limits WHERE limit_key = ?", key)
+	if err != nil || len(rows) == 0 {
+		if err == nil || err.Error() == "no data found" {
+			err = nil
+		}
+		return models.RateLimit{}, false, err
+	}
+	e := models.RateLimit{
+		Id:        int(orm.ToInt64(rows[0]["id"])),
+		LimitKey:  key,
+		Value:     orm.ToInt64(rows[0]["value"]),
+		UpdatedAt: orm.ToInt64(rows[0]["updated_at"]),
+		ExpiresAt: orm.ToInt64(rows[0]["expires_at"]),
+	}
+	return e, e.ExpiresAt > now.Unix(), nil
+}
+
+func (o *ORM) Swap(key string, old, e models.RateLimit) (bool, error) {
+	if old.Id == 0 {
+		// fail on the unique key if inserted meanwhile
+		_, err := orm.ExecAffected(o.dbName, "INSERT INTO rate_limits (limit_key,value,updated_at,expires_at) VALUES (?,?,?,?)", key, e.Value, e.UpdatedAt, e.ExpiresAt)
+		return err == nil, nil
+	}
+	n, err := orm.ExecAffected(o.dbName, "UPDATE rate_limits SET value = ?, updated_at = ?, expires_at = ? WHERE id = ? AND value = ? AND updated_at = ?",
+		e.Value, e.UpdatedAt, e.ExpiresAt, old.Id, old.Value, old.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (o *ORM) DeleteExpired(now time.Time) error {
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM rate_limits WHERE expires_at <= ?", now.Unix())
+	return err
+}
@@ -0,0 +1,294 @@
+// Package secure set the security headers of responses: Strict-Transport-Security, X-Content-Type-Options, Referrer-Policy,
+// Permissions-Policy, X-Frame-Options and a Content-Security-Policy built with CSP, using a new nonce for each request.
+// The nonce is available with Nonce, in templates rendered by kamux as .CSPNonce or {{cspNonce .Request}}
+package secure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/utils"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+// NONCE in a CSP source list is replaced by the nonce of the request
+const NONCE = "'nonce'"
+
+// CSP build a Content-Security-Policy, directives are written in alphabetical order
+type CSP struct {
+	directives map[string][]string
+}
+
+// NewCSP return an empty policy
+func NewCSP() *CSP {
+	return &CSP{directives: map[string][]string{}}
+}
+
+// StrictCSP return a policy allowing only the resources of the site itself, and the inline scripts and styles having the nonce
+func StrictCSP() *CSP {
+	return NewCSP().
+		Default("'self'").
+		Script("'self'", NONCE).
+		Style("'self'", NONCE).
+		Img("'self'", "data:").
+		Font("'self'").
+		Connect("'self'").
+		Object("'none'").
+		BaseURI("'self'").
+		FormAction("'self'").
+		FrameAncestors("'none'")
+}
+
+// Set replace the sources of directive, without sources the directive is written alone, like upgrade-insecure-requests
+func (p *CSP) Set(directive string, sources ...string) *CSP {
+	p.directives[directive] = sources
+	return p
+}
+
+// Add append sources to directive
+func (p *CSP) Add(directive string, sources ...string) *CSP {
+	p.directives[directive] = append(p.directives[directive], sources...)
+	return p
+}
+
+func (p *CSP) Default(sources ...string) *CSP        { return p.Set("default-src", sources...) }
+func (p *CSP) Script(sources ...string) *CSP         { return p.Set("script-src", sources...) }
+func (p *CSP) Style(sources ...string) *CSP          { return p.Set("style-src", sources...) }
+func (p *CSP) Img(sources ...string) *CSP            { return p.Set("img-src", sources...) }
+func (p *CSP) Font(sources ...string) *CSP           { return p.Set("font-src", sources...) }
+func (p *CSP) Connect(sources ...string) *CSP        { return p.Set("connect-src", sources...) }
+func (p *CSP) Media(sources ...string) *CSP          { return p.Set("media-src", sources...) }
+func (p *CSP) Frame(sources ...string) *CSP          { return p.Set("frame-src", sources...) }
+func (p *CSP) Object(sources ...string) *CSP         { return p.Set("object-src", sources...) }
+func (p *CSP) BaseURI(sources ...string) *CSP        { return p.Set("base-uri", sources...) }
+func (p *CSP) FormAction(sources ...string) *CSP     { return p.Set("form-action", sources...) }
+func (p *CSP) FrameAncestors(sources ...string) *CSP { return p.Set("frame-ancestors", sources...) }
+
+// String return the policy for nonce, empty if the policy don't use NONCE
+func (p *CSP) String(nonce string) string {
+	names := make([]string, 0, len(p.directives))
+	for name := range p.directives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		sources := make([]string, 0, len(p.directives[name]))
+		for _, s := range p.directives[name] {
+			if s == NONCE {
+				s = "'nonce-" + nonce + "'"
+			}
+			sources = append(sources, s)
+		}
+		parts = append(parts, strings.TrimSpace(name+" "+strings.Join(sources, " ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (p *CSP) usesNonce() bool {
+	for _, sources := range p.directives {
+		for _, s := range sources {
+			if s == NONCE {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Options of the headers, empty values are not sent
+type Options struct {
+	// HSTS is the max-age of Strict-Transport-Security, sent on https requests only
+	HSTS                  time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// NoSniff send X-Content-Type-Options: nosniff
+	NoSniff           bool
+	ReferrerPolicy    string
+	PermissionsPolicy string
+	// FrameOptions is the X-Frame-Options of browsers not supporting the frame-ancestors directive, DENY or SAMEORIGIN
+	FrameOptions string
+	CSP          *CSP
+	// ReportOnly send the CSP as Content-Security-Policy-Report-Only, violations are reported but not blocked
+	ReportOnly bool
+	// ReportPath receive the violations, see ReportHandler, it's added to the CSP as report-uri and report-to
+	ReportPath string
+}
+
+// OPTIONS are used by HEADERS
+var OPTIONS = Options{
+	HSTS:              180 * 24 * time.Hour,
+	NoSniff:           true,
+	ReferrerPolicy:    "strict-origin-when-cross-origin",
+	PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=()",
+	FrameOptions:      "DENY",
+	CSP:               StrictCSP(),
+	ReportPath:        "/csp-report",
+}
+
+const ctxKey utils.ContextKey = "csp-nonce"
+
+// Nonce return the nonce of the CSP of the request, empty if the request didn't go through the headers middleware
+func Nonce(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	n, _ := r.Context().Value(ctxKey).(string)
+	return n
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func isHttps(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// Apply set the headers of opts for r, and return r holding the nonce
+func Apply(opts Options, w http.ResponseWriter, r *http.Request) *http.Request {
+	h := w.Header()
+	if opts.HSTS > 0 && isHttps(r) {
+		v := "max-age=" + strconv.Itoa(int(opts.HSTS/time.Second))
+		if opts.HSTSIncludeSubdomains {
+			v += "; includeSubDomains"
+		}
+		if opts.HSTSPreload {
+			v += "; preload"
+		}
+		h.Set("Strict-Transport-Security", v)
+	}
+	if opts.NoSniff {
+		h.Set("X-Content-Type-Options", "nosniff")
+	}
+	if opts.ReferrerPolicy != "" {
+		h.Set("Referrer-Policy", opts.ReferrerPolicy)
+	}
+	if opts.PermissionsPolicy != "" {
+		h.Set("Permissions-Policy", opts.PermissionsPolicy)
+	}
+	if opts.FrameOptions != "" {
+		h.Set("X-Frame-Options", opts.FrameOptions)
+	}
+	if opts.CSP == nil {
+		return r
+	}
+	nonce := ""
+	if opts.CSP.usesNonce() {
+		nonce = newNonce()
+		r = r.WithContext(context.WithValue(r.Context(), ctxKey, nonce))
+	}
+	policy := opts.CSP.String(nonce)
+	if opts.ReportPath != "" {
+		policy += "; report-uri " + opts.ReportPath + "; report-to csp"
+		h.Set("Reporting-Endpoints", `csp="`+opts.ReportPath+`"`)
+	}
+	if opts.ReportOnly {
+		h.Set("Content-Security-Policy-Report-Only", policy)
+	} else {
+		h.Set("Content-Security-Policy", policy)
+	}
+	return r
+}
+
+// New return a middleware setting the headers of opts
+func New(opts Options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, Apply(opts, w, r))
+		})
+	}
+}
+
+// HEADERS set the headers of OPTIONS, read on each request
+var HEADERS = func(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, Apply(OPTIONS, w, r))
+	})
+}
+
+// Violation is a reported CSP violation
+type Violation struct {
+	DocumentURI        string `json:"document-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	BlockedURI         string `json:"blocked-uri"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	Disposition        string `json:"disposition"`
+}
+
+// ON_VIOLATION receive the violations collected by ReportHandler, they are logged by default
+var ON_VIOLATION = func(v Violation, r *http.Request) {
+	logger.Warn("csp violation:", v.EffectiveDirective, "blocked", v.BlockedURI, "on", v.DocumentURI, v.SourceFile, v.LineNumber)
+}
+
+// parseReports read the report-uri format {"csp-report":{...}} and the reporting api format [{"type":"csp-violation","body":{...}}]
+func parseReports(body []byte) []Violation {
+	var legacy struct {
+		Report *Violation `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err == nil && legacy.Report != nil {
+		return []Violation{*legacy.Report}
+	}
+	var reports []struct {
+		Type string `json:"type"`
+		Body struct {
+			DocumentURL        string `json:"documentURL"`
+			EffectiveDirective string `json:"effectiveDirective"`
+			BlockedURL         string `json:"blockedURL"`
+			SourceFile         string `json:"sourceFile"`
+			LineNumber         int    `json:"lineNumber"`
+			Disposition        string `json:"disposition"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(body, &reports); err != nil {
+		return nil
+	}
+	res := []Violation{}
+	for _, rep := range reports {
+		if rep.Type != "csp-violation" {
+			continue
+		}
+		res = append(res, Violation{
+			DocumentURI:        rep.Body.DocumentURL,
+			ViolatedDirective:  rep.Body.EffectiveDirective,
+			EffectiveDirective: rep.Body.EffectiveDirective,
+			BlockedURI:         rep.Body.BlockedURL,
+			SourceFile:         rep.Body.SourceFile,
+			LineNumber:         rep.Body.LineNumber,
+			Disposition:        rep.Body.Disposition,
+		})
+	}
+	return res
+}
+
+// ReportHandler collect the violations posted by browsers to the ReportPath, and pass them to ON_VIOLATION
+var ReportHandler = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	for _, v := range parseReports(body) {
+		if v.EffectiveDirective == "" {
+			v.EffectiveDirective = v.ViolatedDirective
+		}
+		ON_VIOLATION(v, r)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
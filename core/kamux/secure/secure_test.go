@@ -0,0 +1,66 @@
+package secure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHeadersAndNonce(t *testing.T) {
+	var seen string
+	h := New(OPTIONS)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = Nonce(r)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	csp := w.Header().Get("Content-Security-Policy")
+	if seen == "" || !strings.Contains(csp, "script-src 'self' 'nonce-"+seen+"'") {
+		t.Fatal("nonce not in policy", seen, csp)
+	}
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Strict-Transport-Security") != "" {
+		t.Fatal("bad headers", w.Header())
+	}
+
+	first := seen
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if seen == first {
+		t.Fatal("nonce reused")
+	}
+	if !strings.HasPrefix(w.Header().Get("Strict-Transport-Security"), "max-age=") {
+		t.Fatal("no hsts over https")
+	}
+
+	opts := OPTIONS
+	opts.ReportOnly = true
+	w = httptest.NewRecorder()
+	New(opts)(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("Content-Security-Policy") != "" || !strings.Contains(w.Header().Get("Content-Security-Policy-Report-Only"), "report-uri /csp-report") {
+		t.Fatal("report only not used", w.Header())
+	}
+}
+
+func TestReportHandler(t *testing.T) {
+	got := []Violation{}
+	old := ON_VIOLATION
+	defer func() { ON_VIOLATION = old }()
+	ON_VIOLATION = func(v Violation, r *http.Request) { got = append(got, v) }
+
+	for _, body := range []string{
+		`{"csp-report":{"document-uri":"http://example.com/","violated-directive":"script-src","blocked-uri":"inline"}}`,
+		`[{"type":"csp-violation","body":{"documentURL":"http://example.com/","effectiveDirective":"style-src","blockedURL":"inline"}}]`,
+	} {
+		w := httptest.NewRecorder()
+		ReportHandler(w, httptest.NewRequest("POST", "/csp-report", strings.NewReader(body)))
+		if w.Code != http.StatusNoContent {
+			t.Fatal("bad status", w.Code)
+		}
+	}
+	if len(got) != 2 || got[0].EffectiveDirective != "script-src" || got[1].EffectiveDirective != "style-src" {
+		t.Fatal("reports not parsed", got)
+	}
+}
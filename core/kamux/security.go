@@ -0,0 +1,31 @@
+package kamux
+
+import (
+	"github.com/kamalshkeir/kago/core/kamux/csrf"
+	"github.com/kamalshkeir/kago/core/kamux/secure"
+)
+
+// UseSecurityHeaders set the security headers of opts, or secure.OPTIONS, on all responses,
+// and collect the CSP violations reported by browsers on its ReportPath.
+// The default strict CSP block inline scripts and styles without nonce, like the ones of the admin pages cloned from the assets repo, see the README
+func (router *Router) UseSecurityHeaders(opts ...secure.Options) {
+	o := secure.OPTIONS
+	mw := secure.HEADERS
+	if len(opts) > 0 {
+		o = opts[0]
+		mw = secure.New(o)
+	}
+	router.UseMiddlewares(mw)
+	if o.ReportPath != "" {
+		// reports are sent by browsers without cookies nor token
+		csrf.Exempt(o.ReportPath)
+		router.POST(o.ReportPath, func(c *Context) {
+			secure.ReportHandler(c.ResponseWriter, c.Request)
+		}, "*")
+	}
+}
+
+// CSPNonce return the nonce of the Content-Security-Policy of the request, to set on inline scripts and styles
+func (c *Context) CSPNonce() string {
+	return secure.Nonce(c.Request)
+}
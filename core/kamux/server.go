@@ -10,12 +10,20 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/kamalshkeir/kago/core/cron"
+	"github.com/kamalshkeir/kago/core/jobs"
 	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/kamux/accounts"
+	"github.com/kamalshkeir/kago/core/kamux/cache"
+	"github.com/kamalshkeir/kago/core/kamux/jwt"
+	"github.com/kamalshkeir/kago/core/kamux/ratelimiter"
+	"github.com/kamalshkeir/kago/core/kamux/sessions"
+	"github.com/kamalshkeir/kago/core/kamux/throttle"
 	"github.com/kamalshkeir/kago/core/settings"
 	"github.com/kamalshkeir/kago/core/utils"
 	"github.com/kamalshkeir/kago/core/utils/logger"
+	"github.com/kamalshkeir/kago/core/utils/websocket"
 	"golang.org/x/crypto/acme/autocert"
-	"golang.org/x/net/websocket"
 )
 
 var (
@@ -110,12 +118,27 @@ func (router *Router) Run() {
 			}
 		}
 	}
+	loadDefaultTemplates()
 
 	tls := router.createAndHandleServerCerts()
 
 	// graceful Shutdown server + db if exist
 	go router.gracefulShutdown()
 
+	if SESSION_CLEANUP_EVERY > 0 {
+		defer sessions.StartCleanup(SESSION_STORE, SESSION_CLEANUP_EVERY)()
+		defer sessions.StartCleanup(jwt.REVOCATIONS, SESSION_CLEANUP_EVERY)()
+		defer sessions.StartCleanup(accounts.STORE, SESSION_CLEANUP_EVERY)()
+		defer sessions.StartCleanup(throttle.STORE, SESSION_CLEANUP_EVERY)()
+		defer sessions.StartCleanup(ratelimiter.STORE, SESSION_CLEANUP_EVERY)()
+		defer sessions.StartCleanup(cache.STORE, SESSION_CLEANUP_EVERY)()
+		defer sessions.StartCleanup(jobs.STORE, SESSION_CLEANUP_EVERY)()
+		defer sessions.StartCleanup(cron.STORE, SESSION_CLEANUP_EVERY)()
+	}
+	// workers only claim the jobs registered by this instance
+	jobs.Start(jobs.WORKERS)
+	cron.Start()
+
 	if tls {
 		if err := router.Server.ListenAndServeTLS(settings.Config.Cert, settings.Config.Key); err != http.ErrServerClosed {
 			logger.Error("Unable to shutdown the server : ", err)
@@ -280,6 +303,10 @@ func checkDomain(name string) error {
 // ServeHTTP serveHTTP by handling methods,pattern,and params
 func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	const key utils.ContextKey = "params"
+	r, ok := router.applyCors(w, r)
+	if !ok {
+		return
+	}
 	c := &Context{Request: r, ResponseWriter: w, Params: map[string]string{}}
 	var allRoutes []Route
 	switch r.Method {
@@ -329,8 +356,10 @@ func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 					handleWebsockets(c, rt)
 					return
 				} else {
-					// HTTP
-					rt.Method = r.Method
+					// HTTP, SSE routes keep their method so sse headers are set
+					if rt.Method != methods[SSE] {
+						rt.Method = r.Method
+					}
 					handleHttp(c, rt)
 					return
 				}
@@ -343,6 +372,9 @@ func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // Graceful Shutdown
 func (router *Router) gracefulShutdown() {
 	err := utils.GracefulShutdown(func() error {
+		// Wait for running jobs, before closing their database
+		cron.Stop()
+		jobs.Stop()
 		// Close databases
 		if err := orm.ShutdownDatabases(); err != nil {
 			logger.Error("unable to shutdown databases:", err)
@@ -494,64 +526,65 @@ func checkSameSite(c Context) bool {
 }
 
 func handleWebsockets(c *Context, rt Route) {
-	if checkSameSite(*c) {
-		// same site
-		websocket.Handler(func(conn *websocket.Conn) {
-			conn.MaxPayloadBytes = 10 << 20
-			if conn.IsServerConn() {
-				ctx := &WsContext{
-					Ws:     conn,
-					Params: make(map[string]string),
-					Route:  rt,
-				}
-				rt.WsHandler(ctx)
-				return
-			}
-		}).ServeHTTP(c.ResponseWriter, c.Request)
-		return
-	} else {
-		// cross
-		if len(rt.AllowedOrigines) == 0 {
-			c.Status(http.StatusBadRequest).Text("you are not allowed cross origin for this url")
-			return
-		} else {
-			allowed := false
-			for _, dom := range rt.AllowedOrigines {
-				if strings.Contains(c.Request.Header.Get("Origin"), dom) {
-					allowed = true
-				}
-			}
-			if allowed {
-				websocket.Handler(func(conn *websocket.Conn) {
-					conn.MaxPayloadBytes = 10 << 20
-					if conn.IsServerConn() {
-						ctx := &WsContext{
-							Ws:     conn,
-							Params: make(map[string]string),
-							Route:  rt,
-						}
-						rt.WsHandler(ctx)
-						return
-					}
-				}).ServeHTTP(c.ResponseWriter, c.Request)
-				return
+	if origin := c.Request.Header.Get("Origin"); origin != "" {
+		policy := rt.CheckOrigin
+		if policy == nil {
+			if len(rt.AllowedOrigines) > 0 {
+				policy = AllowOrigins(rt.AllowedOrigines...)
 			} else {
-				c.Status(http.StatusBadRequest).Text("you are not allowed to access this route from cross origin")
-				return
+				policy = SameOrigin
 			}
 		}
+		if !policy(origin, c.Request) {
+			c.Status(http.StatusForbidden).Text("you are not allowed to access this route from cross origin")
+			return
+		}
 	}
+	// websockets stay open longer than the route timeouts
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), streamKey, true))
+	upgrade := func(c *Context) {
+		websocket.Server{
+			Config:    websocket.Config{Compression: WS_COMPRESSION},
+			Handshake: wsHandshake,
+			Handler: func(conn *websocket.Conn) {
+				conn.MaxPayloadBytes = WS_MAX_MESSAGE_SIZE
+				if conn.IsServerConn() {
+					client := rt.Hub.register(conn)
+					defer rt.Hub.unregister(client)
+					ctx := &WsContext{
+						Ws:      conn,
+						Request: c.Request,
+						Params:  c.Params,
+						Route:   rt,
+						client:  client,
+					}
+					rt.WsHandler(ctx)
+				}
+			},
+		}.ServeHTTP(c.ResponseWriter, c.Request)
+	}
+	for i := len(rt.Middlewares) - 1; i >= 0; i-- {
+		upgrade = rt.Middlewares[i](upgrade)
+	}
+	upgrade(c)
+}
+
+// wsHandshake set the origin, checked before by the route policy, and select the subprotocol, token subprotocols are never selected
+func wsHandshake(config *websocket.Config, r *http.Request) (err error) {
+	config.Origin, err = websocket.Origin(config, r)
+	config.Protocol = selectProtocol(config.Protocol)
+	return err
 }
 
 func handleHttp(c *Context, rt Route) {
 	switch rt.Method {
 	case "GET":
-		if rt.Method == "SSE" {
-			sseHeaders(c)
-		}
 		rt.Handler(c)
 		return
 	case "SSE":
+		// streams stay open longer than WriteTimeout
+		setDeadline(c.ResponseWriter, false, time.Time{})
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), streamKey, true))
 		sseHeaders(c)
 		rt.Handler(c)
 		return
@@ -560,7 +593,7 @@ func handleHttp(c *Context, rt Route) {
 		return
 	default:
 		// check cross origin
-		if checkSameSite(*c) {
+		if checkSameSite(*c) || corsAllowed(c.Request) {
 			// same site
 			rt.Handler(c)
 			return
@@ -594,10 +627,8 @@ func handleHttp(c *Context, rt Route) {
 
 }
 
+// sseHeaders set the headers of event streams, cross origin streams are allowed by the cors policies
 func sseHeaders(c *Context) {
-	o := strings.Join(Origines, ",")
-	c.SetHeader("Access-Control-Allow-Origin", o)
-	c.SetHeader("Access-Control-Allow-Headers", "Content-Type")
 	c.SetHeader("Cache-Control", "no-cache")
 	c.SetHeader("Connection", "keep-alive")
 }
@@ -0,0 +1,97 @@
+package kamux
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux/csrf"
+	"github.com/kamalshkeir/kago/core/kamux/sessions"
+	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/utils"
+)
+
+var (
+	// SESSION_STORE keep server side sessions, New use the orm table sessions, BareBone keep them in memory
+	SESSION_STORE  sessions.Store = sessions.NewMemory()
+	SESSION_COOKIE                = "session"
+	// SESSION_CLEANUP_EVERY delete expired sessions from SESSION_STORE, 0 to disable
+	SESSION_CLEANUP_EVERY = time.Hour
+)
+
+// Session return the session of the request, a new one is created if needed and saved on its first change,
+// the cookie is set when it is saved, so the session must be changed before writing the response
+func (c *Context) Session() *sessions.Session {
+	const key utils.ContextKey = "session"
+	if s, ok := c.Request.Context().Value(key).(*sessions.Session); ok {
+		return s
+	}
+	var s *sessions.Session
+	if id, err := c.GetCookie(SESSION_COOKIE); err == nil && id != "" {
+		s, _ = sessions.Load(SESSION_STORE, id)
+	}
+	if s == nil {
+		// no cookie for sessions never saved, so anonymous requests don't fill the store or rotate csrf tokens
+		s = sessions.New(SESSION_STORE)
+	}
+	s.OnNewID(c.setSessionCookie)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), key, s))
+	return s
+}
+
+func (c *Context) setSessionCookie(id string) {
+	expires := COOKIES_Expires
+	if sessions.ABSOLUTE_TIMEOUT > 0 {
+		expires = sessions.ABSOLUTE_TIMEOUT
+	}
+	c.setCookie(SESSION_COOKIE, id, expires)
+	// csrf tokens are bound to the session, a new one is needed when it change
+	c.Request = csrf.Refresh(c.ResponseWriter, c.Request, id)
+}
+
+func init() {
+	csrf.SessionID = func(r *http.Request) string {
+		if c, err := r.Cookie(SESSION_COOKIE); err == nil {
+			return c.Value
+		}
+		return ""
+	}
+}
+
+// Login log user in the current session, rotating its id
+func (c *Context) Login(user models.User) error {
+	return c.Session().Login(user.Id)
+}
+
+// Logout destroy the current session
+func (c *Context) Logout() error {
+	err := c.Session().Destroy()
+	c.DeleteCookie(SESSION_COOKIE)
+	c.Request = csrf.Refresh(c.ResponseWriter, c.Request, "")
+	return err
+}
+
+// LogoutEverywhere destroy all sessions of the current user, the current one included
+func (c *Context) LogoutEverywhere() error {
+	s := c.Session()
+	if id := s.UserID(); id != 0 {
+		if err := sessions.LogoutEverywhere(SESSION_STORE, id); err != nil {
+			return err
+		}
+	}
+	return c.Logout()
+}
+
+// sessionUser return the user logged in the session of c
+func sessionUser(c *Context) (models.User, bool) {
+	id := c.Session().UserID()
+	if id == 0 {
+		return models.User{}, false
+	}
+	user, err := orm.Model[models.User]().Where("id = ?", id).One()
+	if err != nil {
+		return user, false
+	}
+	return user, true
+}
@@ -0,0 +1,134 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File keep each session in a json file of a directory
+type File struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+// NewFile create dir if needed and return a store saving sessions in it
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir}, nil
+}
+
+func (f *File) path(id string) string {
+	return filepath.Join(f.dir, id+".json")
+}
+
+func (f *File) Load(id string) (Record, error) {
+	if !ValidID(id) {
+		return Record{}, ErrNotFound
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.read(f.path(id))
+}
+
+func (f *File) read(path string) (Record, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Record{}, ErrNotFound
+	} else if err != nil {
+		return Record{}, err
+	}
+	var r Record
+	err = json.Unmarshal(b, &r)
+	return r, err
+}
+
+func (f *File) Save(r Record) error {
+	if !ValidID(r.ID) {
+		return ErrNotFound
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// write then rename, so a crash never leave a partial session
+	tmp := f.path(r.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(r.ID))
+}
+
+func (f *File) Delete(id string) error {
+	if !ValidID(id) {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := os.Remove(f.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// each visit all sessions, it's fine for small deployments, use the ORM store otherwise
+func (f *File) each(fn func(path string, r Record)) error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(f.dir, e.Name())
+		r, err := f.read(path)
+		if err != nil {
+			continue
+		}
+		fn(path, r)
+	}
+	return nil
+}
+
+func (f *File) DeleteUser(userId int, except ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.each(func(path string, r Record) {
+		if r.UserID == userId && !contains(except, r.ID) {
+			_ = os.Remove(path)
+		}
+	})
+}
+
+func (f *File) UserSessions(userId int) ([]Record, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	res := []Record{}
+	err := f.each(func(path string, r Record) {
+		if r.UserID == userId {
+			res = append(res, r)
+		}
+	})
+	return res, err
+}
+
+func (f *File) DeleteExpired(now time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.each(func(path string, r Record) {
+		if r.Expired(now) {
+			_ = os.Remove(path)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package sessions
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory keep sessions in memory, they are lost on restart
+type Memory struct {
+	records map[string]Record
+	mu      sync.RWMutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{records: map[string]Record{}}
+}
+
+func (m *Memory) Load(id string) (Record, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.records[id]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return copyRecord(r), nil
+}
+
+func (m *Memory) Save(r Record) error {
+	m.mu.Lock()
+	m.records[r.ID] = copyRecord(r)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) Delete(id string) error {
+	m.mu.Lock()
+	delete(m.records, id)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) DeleteUser(userId int, except ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, r := range m.records {
+		if r.UserID == userId && !contains(except, id) {
+			delete(m.records, id)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) UserSessions(userId int) ([]Record, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []Record{}
+	for _, r := range m.records {
+		if r.UserID == userId {
+			res = append(res, copyRecord(r))
+		}
+	}
+	return res, nil
+}
+
+func (m *Memory) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, r := range m.records {
+		if r.Expired(now) {
+			delete(m.records, id)
+		}
+	}
+	return nil
+}
+
+func copyRecord(r Record) Record {
+	values := make(map[string]any, len(r.Values))
+	for k, v := range r.Values {
+		values[k] = v
+	}
+	r.Values = values
+	return r
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
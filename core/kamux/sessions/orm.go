@@ -0,0 +1,128 @@
+package sessions
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+// ORM keep sessions in the sessions table (models.Session), migrated with the initial models
+type ORM struct {
+	dbName string
+}
+
+// NewORM return a store using the table sessions of dbName, the default database if empty
+func NewORM(dbName ...string) *ORM {
+	s := &ORM{}
+	if len(dbName) > 0 {
+		s.dbName = dbName[0]
+	}
+	return s
+}
+
+// query read without the orm cache, a deleted session must not be served from it
+func (o *ORM) query(where string, args ...any) ([]Record, error) {
+	rows, err := orm.Query(o.dbName, "SELECT session_key,user_id,data,created_at,last_seen FROM sessions WHERE "+where, args...)
+	if err != nil {
+		if err.Error() == "no data found" {
+			return []Record{}, nil
+		}
+		return nil, err
+	}
+	res := make([]Record, 0, len(rows))
+	for _, row := range rows {
+		r := Record{
+			ID:        toString(row["session_key"]),
+			UserID:    int(orm.ToInt64(row["user_id"])),
+			CreatedAt: time.Unix(orm.ToInt64(row["created_at"]), 0),
+			LastSeen:  time.Unix(orm.ToInt64(row["last_seen"]), 0),
+		}
+		if data := toString(row["data"]); data != "" {
+			_ = json.Unmarshal([]byte(data), &r.Values)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func (o *ORM) Load(id string) (Record, error) {
+	records, err := o.query("session_key = ?", id)
+	if err != nil {
+		return Record{}, err
+	}
+	if len(records) == 0 {
+		return Record{}, ErrNotFound
+	}
+	return records[0], nil
+}
+
+func (o *ORM) Save(r Record) error {
+	data, err := json.Marshal(r.Values)
+	if err != nil {
+		return err
+	}
+	n, err := orm.ExecAffected(o.dbName, "UPDATE sessions SET user_id = ?, data = ?, created_at = ?, last_seen = ? WHERE session_key = ?",
+		r.UserID, string(data), r.CreatedAt.Unix(), r.LastSeen.Unix(), r.ID)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = orm.ExecAffected(o.dbName, "INSERT INTO sessions (session_key, user_id, data, created_at, last_seen) VALUES (?, ?, ?, ?, ?)",
+		r.ID, r.UserID, string(data), r.CreatedAt.Unix(), r.LastSeen.Unix())
+	return err
+}
+
+func (o *ORM) Delete(id string) error {
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM sessions WHERE session_key = ?", id)
+	return err
+}
+
+func (o *ORM) DeleteUser(userId int, except ...string) error {
+	records, err := o.query("user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if contains(except, r.ID) {
+			continue
+		}
+		if err := o.Delete(r.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *ORM) UserSessions(userId int) ([]Record, error) {
+	return o.query("user_id = ?", userId)
+}
+
+func (o *ORM) DeleteExpired(now time.Time) error {
+	where, args := "", []any{}
+	if IDLE_TIMEOUT > 0 {
+		where = "last_seen < ?"
+		args = append(args, now.Add(-IDLE_TIMEOUT).Unix())
+	}
+	if ABSOLUTE_TIMEOUT > 0 {
+		if where != "" {
+			where += " OR "
+		}
+		where += "created_at < ?"
+		args = append(args, now.Add(-ABSOLUTE_TIMEOUT).Unix())
+	}
+	if where == "" {
+		return nil
+	}
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM sessions WHERE "+where, args...)
+	return err
+}
+
+func toString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	}
+	return ""
+}
@@ -0,0 +1,277 @@
+// Package sessions keep server side sessions in a Store, the client only hold a random session id
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// IDLE_TIMEOUT expire sessions not used for this duration, 0 to disable
+	IDLE_TIMEOUT = 24 * time.Hour
+	// ABSOLUTE_TIMEOUT expire sessions this duration after their creation, whatever their activity, 0 to disable
+	ABSOLUTE_TIMEOUT = 7 * 24 * time.Hour
+	// TOUCH_EVERY limit the writes of the last activity of a session
+	TOUCH_EVERY = time.Minute
+)
+
+var ErrNotFound = errors.New("session not found")
+
+// Record is a session as saved in a Store
+type Record struct {
+	ID        string         `json:"id"`
+	UserID    int            `json:"user_id,omitempty"`
+	Values    map[string]any `json:"values,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	LastSeen  time.Time      `json:"last_seen"`
+}
+
+// Expired report if r is expired at now, using IDLE_TIMEOUT and ABSOLUTE_TIMEOUT
+func (r Record) Expired(now time.Time) bool {
+	if IDLE_TIMEOUT > 0 && now.Sub(r.LastSeen) > IDLE_TIMEOUT {
+		return true
+	}
+	if ABSOLUTE_TIMEOUT > 0 && now.Sub(r.CreatedAt) > ABSOLUTE_TIMEOUT {
+		return true
+	}
+	return false
+}
+
+// Store persist sessions, values are saved as json by persistent stores, so numbers come back as float64
+type Store interface {
+	Load(id string) (Record, error)
+	Save(r Record) error
+	Delete(id string) error
+	// DeleteUser delete all sessions of userId except the ones in except
+	DeleteUser(userId int, except ...string) error
+	// UserSessions return the sessions of userId, expired ones included
+	UserSessions(userId int) ([]Record, error)
+	DeleteExpired(now time.Time) error
+}
+
+// Session is a session loaded from a Store, changes are saved right away
+type Session struct {
+	store    Store
+	rec      Record
+	saved    bool
+	clientID string // the id known by the client
+	onNewID  func(id string)
+	mu       sync.RWMutex
+}
+
+// New return a session having a new id, it's saved on the first change
+func New(store Store) *Session {
+	now := time.Now()
+	return &Session{
+		store: store,
+		rec: Record{
+			ID:        NewID(),
+			Values:    map[string]any{},
+			CreatedAt: now,
+			LastSeen:  now,
+		},
+	}
+}
+
+// Load return the session id from store, expired sessions are deleted and ErrNotFound is returned
+func Load(store Store, id string) (*Session, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	rec, err := store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if rec.Expired(now) {
+		_ = store.Delete(id)
+		return nil, ErrNotFound
+	}
+	if rec.Values == nil {
+		rec.Values = map[string]any{}
+	}
+	s := &Session{store: store, rec: rec, saved: true, clientID: id}
+	if now.Sub(rec.LastSeen) >= TOUCH_EVERY {
+		s.rec.LastSeen = now
+		if err := store.Save(s.rec); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// NewID return a random session id
+func NewID() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ValidID report if id look like an id returned by NewID, so it can be safely used as a key or a file name
+func ValidID(id string) bool {
+	if len(id) != 43 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// ID return the session id
+func (s *Session) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rec.ID
+}
+
+// UserID return the id of the logged in user, 0 if anonymous
+func (s *Session) UserID() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rec.UserID
+}
+
+// IsNew report if the session was never saved
+func (s *Session) IsNew() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.saved
+}
+
+// OnNewID set fn called when the session is saved under an id the client doesn't know yet, on the first save of a new session
+// and after Rotate or Login, fn is called holding the session lock and must not use the session
+func (s *Session) OnNewID(fn func(id string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onNewID = fn
+}
+
+// Record return a copy of the session data
+func (s *Session) Record() Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := s.rec
+	r.Values = make(map[string]any, len(s.rec.Values))
+	for k, v := range s.rec.Values {
+		r.Values[k] = v
+	}
+	return r
+}
+
+// Get return the value of key, nil if not set
+func (s *Session) Get(key string) any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rec.Values[key]
+}
+
+// Set set key to value and save the session
+func (s *Session) Set(key string, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.Values[key] = value
+	return s.saveLocked()
+}
+
+// Delete remove key and save the session
+func (s *Session) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rec.Values[key]; !ok {
+		return nil
+	}
+	delete(s.rec.Values, key)
+	return s.saveLocked()
+}
+
+// Rotate give the session a new id keeping its data, to call when privileges change, it prevent session fixation
+func (s *Session) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotateLocked()
+}
+
+// Login attach userId to the session, rotating its id and restarting its absolute expiry
+func (s *Session) Login(userId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.UserID = userId
+	s.rec.CreatedAt = time.Now()
+	return s.rotateLocked()
+}
+
+// Destroy delete the session from the store
+func (s *Session) Destroy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.UserID = 0
+	s.rec.Values = map[string]any{}
+	s.clientID = ""
+	if !s.saved {
+		return nil
+	}
+	s.saved = false
+	return s.store.Delete(s.rec.ID)
+}
+
+func (s *Session) rotateLocked() error {
+	old := s.rec.ID
+	s.rec.ID = NewID()
+	if s.saved {
+		if err := s.store.Delete(old); err != nil {
+			return err
+		}
+		s.saved = false
+	}
+	return s.saveLocked()
+}
+
+func (s *Session) saveLocked() error {
+	s.rec.LastSeen = time.Now()
+	if err := s.store.Save(s.rec); err != nil {
+		return err
+	}
+	s.saved = true
+	if s.rec.ID != s.clientID {
+		s.clientID = s.rec.ID
+		if s.onNewID != nil {
+			s.onNewID(s.rec.ID)
+		}
+	}
+	return nil
+}
+
+// LogoutEverywhere delete all sessions of userId, except the ones in except
+func LogoutEverywhere(store Store, userId int, except ...string) error {
+	return store.DeleteUser(userId, except...)
+}
+
+// Expirer is a store able to delete its expired entries, like Store
+type Expirer interface {
+	DeleteExpired(now time.Time) error
+}
+
+// StartCleanup delete expired entries from store every interval, until stop is called
+func StartCleanup(store Expirer, every time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				_ = store.DeleteExpired(now)
+			}
+		}
+	}()
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,122 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, store Store) {
+	s := New(store)
+	if !s.IsNew() {
+		t.Fatal("new session should not be saved")
+	}
+	if _, err := Load(store, s.ID()); err != ErrNotFound {
+		t.Fatalf("unsaved session loaded, err=%v", err)
+	}
+	if err := s.Set("lang", "fr"); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(store, s.ID())
+	if err != nil || loaded.Get("lang") != "fr" {
+		t.Fatalf("got %v %v", loaded, err)
+	}
+
+	// login rotate the id and keep values
+	old := s.ID()
+	if err := s.Login(7); err != nil {
+		t.Fatal(err)
+	}
+	if s.ID() == old {
+		t.Fatal("id not rotated")
+	}
+	if _, err := Load(store, old); err != ErrNotFound {
+		t.Fatal("old id still valid")
+	}
+	loaded, err = Load(store, s.ID())
+	if err != nil || loaded.UserID() != 7 || loaded.Get("lang") != "fr" {
+		t.Fatalf("got %+v %v", loaded.Record(), err)
+	}
+
+	// log out everywhere, except one
+	other := New(store)
+	_ = other.Login(7)
+	third := New(store)
+	_ = third.Login(7)
+	if list, _ := store.UserSessions(7); len(list) != 3 {
+		t.Fatalf("got %d sessions", len(list))
+	}
+	if err := LogoutEverywhere(store, 7, third.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := store.UserSessions(7); len(list) != 1 || list[0].ID != third.ID() {
+		t.Fatalf("got %+v", list)
+	}
+
+	// expiry
+	rec := third.Record()
+	rec.LastSeen = time.Now().Add(-IDLE_TIMEOUT - time.Minute)
+	_ = store.Save(rec)
+	if _, err := Load(store, third.ID()); err != ErrNotFound {
+		t.Fatal("idle session loaded")
+	}
+	fresh := New(store)
+	_ = fresh.Set("a", 1)
+	rec = fresh.Record()
+	rec.CreatedAt = time.Now().Add(-ABSOLUTE_TIMEOUT - time.Minute)
+	_ = store.Save(rec)
+	if err := store.DeleteExpired(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(fresh.ID()); err != ErrNotFound {
+		t.Fatal("expired session not deleted")
+	}
+
+	if err := s.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(store, s.ID()); err != ErrNotFound {
+		t.Fatal("destroyed session loaded")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestFile(t *testing.T) {
+	store, err := NewFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+	if _, err := Load(store, "../../etc/passwd"); err != ErrNotFound {
+		t.Fatal("invalid id accepted")
+	}
+}
+
+func TestOnNewID(t *testing.T) {
+	store := NewMemory()
+	var ids []string
+	s := New(store)
+	s.OnNewID(func(id string) { ids = append(ids, id) })
+	if s.Get("lang"); len(ids) != 0 {
+		t.Fatal("cookie issued for a session never saved")
+	}
+	_ = s.Set("lang", "fr")
+	_ = s.Set("theme", "dark")
+	if len(ids) != 1 || ids[0] != s.ID() {
+		t.Fatal("expected one cookie on the first save, got", ids)
+	}
+
+	loaded, _ := Load(store, s.ID())
+	ids = nil
+	loaded.OnNewID(func(id string) { ids = append(ids, id) })
+	_ = loaded.Set("lang", "en")
+	if len(ids) != 0 {
+		t.Fatal("cookie issued for a known id", ids)
+	}
+	_ = loaded.Login(3)
+	if len(ids) != 1 || ids[0] != loaded.ID() {
+		t.Fatal("expected a cookie for the rotated id, got", ids)
+	}
+}
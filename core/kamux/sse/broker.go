@@ -0,0 +1,295 @@
+package sse
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/utils/backplane"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+var (
+	DEFAULT_BUFFER_SIZE = 100
+	SUBSCRIBER_QUEUE    = 64
+	HEARTBEAT_EVERY     = 15 * time.Second
+)
+
+// Broker dispatch published events to subscribers of a topic, each topic keep the last BufferSize events
+// so reconnecting clients can resume from their Last-Event-ID
+type Broker struct {
+	BufferSize int
+	Heartbeat  time.Duration
+	seq        uint64
+	ids        map[string]uint64 // sequence of the buffered events by id
+	topics     map[string]*topic
+	bp         backplane.Backplane
+	bpChannel  string
+	bpUnsub    func()
+	mu         sync.Mutex
+}
+
+type remoteEvent struct {
+	Topic string `json:"topic"`
+	Event Event  `json:"event"`
+}
+
+type topic struct {
+	buffer []bufferedEvent
+	subs   map[*subscriber]struct{}
+}
+
+type bufferedEvent struct {
+	seq uint64
+	Event
+}
+
+type subscriber struct {
+	ch     chan Event
+	closed bool
+}
+
+// NewBroker create a broker keeping bufferSize events per topic for replay, 0 use DEFAULT_BUFFER_SIZE
+func NewBroker(bufferSize ...int) *Broker {
+	size := DEFAULT_BUFFER_SIZE
+	if len(bufferSize) > 0 && bufferSize[0] > 0 {
+		size = bufferSize[0]
+	}
+	return &Broker{
+		BufferSize: size,
+		Heartbeat:  HEARTBEAT_EVERY,
+		ids:        map[string]uint64{},
+		topics:     map[string]*topic{},
+	}
+}
+
+func (b *Broker) getTopic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{
+			buffer: []bufferedEvent{},
+			subs:   map[*subscriber]struct{}{},
+		}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// UseBackplane forward published events to brokers of other instances subscribed to the same channel,
+// generated ids are prefixed by the node id so they stay unique, a client can resume on any instance
+func (b *Broker) UseBackplane(bp backplane.Backplane, channel string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bpUnsub != nil {
+		b.bpUnsub()
+		b.bp, b.bpUnsub = nil, nil
+	}
+	if bp == nil {
+		return nil
+	}
+	unsub, err := bp.Subscribe(channel, func(msg backplane.Message) {
+		var re remoteEvent
+		if err := json.Unmarshal(msg.Data, &re); err != nil {
+			logger.Error("sse: backplane message:", err)
+			return
+		}
+		b.publish(re.Topic, re.Event)
+	})
+	if err != nil {
+		return err
+	}
+	b.bp, b.bpChannel, b.bpUnsub = bp, channel, unsub
+	return nil
+}
+
+// Publish send e to all subscribers of topicName, an Id is generated if e.Id is empty, the published event is returned
+func (b *Broker) Publish(topicName string, e Event) Event {
+	e = b.publish(topicName, e)
+	b.mu.Lock()
+	bp, channel := b.bp, b.bpChannel
+	b.mu.Unlock()
+	if bp != nil {
+		data, _ := json.Marshal(remoteEvent{Topic: topicName, Event: e})
+		if err := bp.Publish(channel, data); err != nil {
+			logger.Error("sse: backplane publish:", err)
+		}
+	}
+	return e
+}
+
+func (b *Broker) publish(topicName string, e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	if e.Id == "" {
+		e.Id = strconv.FormatUint(b.seq, 10)
+		if b.bp != nil {
+			e.Id = b.bp.NodeID() + "-" + e.Id
+		}
+	}
+	t := b.getTopic(topicName)
+	t.buffer = append(t.buffer, bufferedEvent{seq: b.seq, Event: e})
+	b.ids[e.Id] = b.seq
+	if over := len(t.buffer) - b.BufferSize; over > 0 {
+		for _, old := range t.buffer[:over] {
+			if b.ids[old.Id] == old.seq {
+				delete(b.ids, old.Id)
+			}
+		}
+		t.buffer = append(t.buffer[:0], t.buffer[over:]...)
+	}
+	for s := range t.subs {
+		select {
+		case s.ch <- e:
+		default:
+			// slow consumer, drop it, the client will reconnect and resume from its Last-Event-ID
+			b.removeLocked(t, s)
+		}
+	}
+	return e
+}
+
+// Subscribe return a channel receiving events published on topicName, replay contain buffered events published after lastEventId
+func (b *Broker) Subscribe(topicName, lastEventId string) (events <-chan Event, replay []Event, cancel func()) {
+	events, buffered, cancel := b.subscribe(topicName, lastEventId)
+	return events, toEvents(buffered), cancel
+}
+
+func (b *Broker) subscribe(topicName, lastEventId string) (<-chan Event, []bufferedEvent, func()) {
+	s := &subscriber{ch: make(chan Event, SUBSCRIBER_QUEUE)}
+	b.mu.Lock()
+	t := b.getTopic(topicName)
+	var replay []bufferedEvent
+	if lastEventId != "" {
+		replay = t.since(b.lastSeq(lastEventId))
+	}
+	t.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s.ch, replay, func() {
+		b.mu.Lock()
+		b.removeLocked(t, s)
+		b.mu.Unlock()
+	}
+}
+
+// lastSeq return the sequence of the event lastEventId, 0 when it is unknown, so everything still buffered is replayed
+func (b *Broker) lastSeq(lastEventId string) uint64 {
+	if seq, ok := b.ids[lastEventId]; ok {
+		return seq
+	}
+	// a generated id which fell out of the buffers
+	id := lastEventId
+	if b.bp != nil {
+		prefix := b.bp.NodeID() + "-"
+		if !strings.HasPrefix(id, prefix) {
+			return 0
+		}
+		id = id[len(prefix):]
+	}
+	if n, err := strconv.ParseUint(id, 10, 64); err == nil && n <= b.seq {
+		return n
+	}
+	return 0
+}
+
+// Subscribers return the number of subscribers of topicName
+func (b *Broker) Subscribers(topicName string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.topics[topicName]; ok {
+		return len(t.subs)
+	}
+	return 0
+}
+
+func (b *Broker) removeLocked(t *topic, s *subscriber) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(t.subs, s)
+	close(s.ch)
+}
+
+// since return the buffered events published after the sequence seq
+func (t *topic) since(seq uint64) []bufferedEvent {
+	i := sort.Search(len(t.buffer), func(i int) bool { return t.buffer[i].seq > seq })
+	return append([]bufferedEvent(nil), t.buffer[i:]...)
+}
+
+func toEvents(buffered []bufferedEvent) []Event {
+	res := make([]Event, 0, len(buffered))
+	for _, be := range buffered {
+		res = append(res, be.Event)
+	}
+	return res
+}
+
+// Stream send events of topics to sw until the client disconnect, replaying missed events first
+func (b *Broker) Stream(sw *Writer, topics ...string) error {
+	merged := make(chan Event, SUBSCRIBER_QUEUE)
+	closed := make(chan struct{}, len(topics))
+	quit := make(chan struct{})
+	defer close(quit)
+	replay := []bufferedEvent{}
+	cancels := make([]func(), 0, len(topics))
+	for _, name := range topics {
+		ch, r, cancel := b.subscribe(name, sw.LastEventID())
+		replay = append(replay, r...)
+		cancels = append(cancels, cancel)
+		go func(ch <-chan Event) {
+			for e := range ch {
+				select {
+				case merged <- e:
+				case <-quit:
+				}
+			}
+			closed <- struct{}{}
+		}(ch)
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	// replay the missed events of all topics in the order they were published
+	sort.Slice(replay, func(i, j int) bool { return replay[i].seq < replay[j].seq })
+	for _, e := range replay {
+		if err := sw.Send(e.Event); err != nil {
+			return err
+		}
+	}
+	// send headers right away, so the client know it's connected
+	if len(replay) == 0 {
+		if err := sw.Comment("connected"); err != nil {
+			return err
+		}
+	}
+	if b.Heartbeat > 0 {
+		stop := sw.KeepAlive(b.Heartbeat)
+		defer stop()
+	}
+	for {
+		select {
+		case <-sw.Done():
+			return nil
+		case <-closed:
+			// dropped as a slow consumer
+			return nil
+		case e := <-merged:
+			if err := sw.Send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Serve stream topics to the client of r, blocking until it disconnect
+func (b *Broker) Serve(w http.ResponseWriter, r *http.Request, topics ...string) error {
+	return b.Stream(NewWriter(w, r), topics...)
+}
@@ -0,0 +1,90 @@
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func ids(events []Event) string {
+	res := make([]string, 0, len(events))
+	for _, e := range events {
+		res = append(res, e.Id)
+	}
+	return strings.Join(res, ",")
+}
+
+func TestReplay(t *testing.T) {
+	b := NewBroker(3)
+	b.Publish("news", Event{Data: "1"})
+	b.Publish("news", Event{Id: "custom", Data: "2"})
+	b.Publish("other", Event{Data: "3"})
+	b.Publish("news", Event{Data: "4"})
+
+	tests := []struct {
+		lastId string
+		want   string
+	}{
+		{"", ""},
+		{"1", "custom,4"},
+		{"custom", "4"},
+		// an id of another topic resume where the client stopped
+		{"3", "4"},
+		{"4", ""},
+		// unknown ids replay everything buffered instead of skipping events
+		{"unknown", "1,custom,4"},
+		{"999", "1,custom,4"},
+	}
+	for _, tt := range tests {
+		_, replay, cancel := b.Subscribe("news", tt.lastId)
+		cancel()
+		if got := ids(replay); got != tt.want {
+			t.Errorf("resume from %q: got %q want %q", tt.lastId, got, tt.want)
+		}
+	}
+
+	// 1 fell out of the buffer, the events after it which are still buffered are replayed
+	b.Publish("news", Event{Data: "5"})
+	_, replay, _ := b.Subscribe("news", "1")
+	if got := ids(replay); got != "custom,4,5" {
+		t.Error("resume after eviction: got", got)
+	}
+	_, replay, _ = b.Subscribe("news", "custom")
+	if got := ids(replay); got != "4,5" {
+		t.Error("resume from a custom id: got", got)
+	}
+}
+
+func TestStreamResume(t *testing.T) {
+	b := NewBroker()
+	b.Heartbeat = 0
+	b.Publish("a", Event{Data: "1"})
+	b.Publish("b", Event{Data: "2"})
+	b.Publish("a", Event{Data: "3"})
+	b.Publish("b", Event{Data: "4"})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = b.Serve(w, r, "a", "b")
+	}))
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got := []string{}
+	sc := bufio.NewScanner(resp.Body)
+	for len(got) < 3 && sc.Scan() {
+		if line := sc.Text(); strings.HasPrefix(line, "id: ") {
+			got = append(got, line[len("id: "):])
+		}
+	}
+	// missed events of both topics, in the order they were published
+	if strings.Join(got, ",") != "2,3,4" {
+		t.Fatal("bad replay:", got)
+	}
+}
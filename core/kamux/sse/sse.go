@@ -0,0 +1,172 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrStreamingUnsupported = errors.New("sse: streaming unsupported, http.Flusher not implemented by the ResponseWriter")
+
+// Event is a single server sent event, Data can be multiline, Retry is sent only if > 0
+type Event struct {
+	Id    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// Writer write events to a text/event-stream response, safe for concurrent use
+type Writer struct {
+	w           http.ResponseWriter
+	flusher     http.Flusher
+	ctx         context.Context
+	lastEventId string
+	headersSent bool
+	mu          sync.Mutex
+}
+
+// NewWriter create a Writer for w, the stream is considered closed when r.Context() is done
+func NewWriter(w http.ResponseWriter, r *http.Request) *Writer {
+	f, _ := w.(http.Flusher)
+	lastId := r.Header.Get("Last-Event-ID")
+	if lastId == "" {
+		// EventSource polyfills can't set headers
+		lastId = r.URL.Query().Get("lastEventId")
+	}
+	return &Writer{
+		w:           w,
+		flusher:     f,
+		ctx:         r.Context(),
+		lastEventId: lastId,
+	}
+}
+
+// LastEventID return the id sent by the client when reconnecting
+func (sw *Writer) LastEventID() string {
+	return sw.lastEventId
+}
+
+// Done is closed when the client disconnect
+func (sw *Writer) Done() <-chan struct{} {
+	return sw.ctx.Done()
+}
+
+// Send write the event e and flush it to the client
+func (sw *Writer) Send(e Event) error {
+	b := strings.Builder{}
+	if e.Id != "" {
+		b.WriteString("id: ")
+		b.WriteString(singleLine(e.Id))
+		b.WriteByte('\n')
+	}
+	if e.Event != "" {
+		b.WriteString("event: ")
+		b.WriteString(singleLine(e.Event))
+		b.WriteByte('\n')
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: ")
+		b.WriteString(strconv.FormatInt(e.Retry.Milliseconds(), 10))
+		b.WriteByte('\n')
+	}
+	// a lone \r end a line too, left as is it would let data inject fields
+	data := newlines.Replace(e.Data)
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return sw.write(b.String())
+}
+
+// Data send an event containing only data
+func (sw *Writer) Data(data string) error {
+	return sw.Send(Event{Data: data})
+}
+
+// Retry tell the client how long to wait before reconnecting
+func (sw *Writer) Retry(d time.Duration) error {
+	return sw.write("retry: " + strconv.FormatInt(d.Milliseconds(), 10) + "\n\n")
+}
+
+// Comment send a comment line, ignored by clients, useful to keep the connection alive
+func (sw *Writer) Comment(text string) error {
+	return sw.write(": " + singleLine(text) + "\n\n")
+}
+
+// KeepAlive send a comment every interval until the client disconnect or stop is called
+func (sw *Writer) KeepAlive(every time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-sw.ctx.Done():
+				return
+			case <-t.C:
+				if err := sw.Comment("ping"); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// Flush send buffered data to the client
+func (sw *Writer) Flush() {
+	sw.mu.Lock()
+	sw.flush()
+	sw.mu.Unlock()
+}
+
+func (sw *Writer) flush() {
+	if sw.flusher != nil {
+		sw.flusher.Flush()
+	}
+}
+
+func (sw *Writer) write(s string) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if err := sw.ctx.Err(); err != nil {
+		return err
+	}
+	if !sw.headersSent {
+		if sw.flusher == nil {
+			return ErrStreamingUnsupported
+		}
+		h := sw.w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no")
+		sw.w.WriteHeader(http.StatusOK)
+		sw.headersSent = true
+	}
+	if _, err := sw.w.Write([]byte(s)); err != nil {
+		return err
+	}
+	sw.flush()
+	return nil
+}
+
+var newlines = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+func singleLine(s string) string {
+	if strings.ContainsAny(s, "\r\n") {
+		s = strings.NewReplacer("\r", "", "\n", " ").Replace(s)
+	}
+	return s
+}
@@ -0,0 +1,30 @@
+package sse
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name string
+		e    Event
+		want string
+	}{
+		{"data", Event{Data: "hello"}, "data: hello\n\n"},
+		{"fields", Event{Id: "1", Event: "msg", Data: "a"}, "id: 1\nevent: msg\ndata: a\n\n"},
+		{"crlf", Event{Data: "a\r\nb"}, "data: a\ndata: b\n\n"},
+		{"lone cr", Event{Data: "x\revent: y"}, "data: x\ndata: event: y\n\n"},
+		{"lf", Event{Data: "x\n\nid: 9"}, "data: x\ndata: \ndata: id: 9\n\n"},
+		{"event cr", Event{Event: "a\rdata: b", Data: "c"}, "event: adata: b\ndata: c\n\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if err := NewWriter(w, httptest.NewRequest("GET", "/", nil)).Send(tt.e); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -3,17 +3,21 @@ package kamux
 import (
 	"embed"
 	"fmt"
+	"html"
 	"html/template"
 	"io/fs"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/kamalshkeir/kago/core/kamux/csrf"
+	"github.com/kamalshkeir/kago/core/kamux/secure"
 	"github.com/kamalshkeir/kago/core/orm"
 	"github.com/kamalshkeir/kago/core/settings"
 	"github.com/kamalshkeir/kago/core/utils"
@@ -23,6 +27,15 @@ import (
 
 var allTemplates = template.New("")
 
+// defaultTemplates are parsed after the templates folder, see AddDefaultTemplates
+var defaultTemplates = []defaultTemplate{}
+
+type defaultTemplate struct {
+	fsys fs.FS
+	root string
+	as   string
+}
+
 // initTemplatesAndAssets init templates from a folder and download admin skeleton html files
 func initTemplatesAndAssets(router *Router) {
 	var wg sync.WaitGroup
@@ -45,6 +58,33 @@ func initTemplatesAndAssets(router *Router) {
 	}
 }
 
+// AddDefaultTemplates register the html files of fsys under root as templates named as+"/"+file, they are parsed when the server run,
+// skipping names already loaded from the templates folder, so packages can ship pages that users override in assets
+func AddDefaultTemplates(fsys fs.FS, root, as string) {
+	defaultTemplates = append(defaultTemplates, defaultTemplate{fsys: fsys, root: root, as: as})
+}
+
+func loadDefaultTemplates() {
+	for _, d := range defaultTemplates {
+		err := fs.WalkDir(d.fsys, d.root, func(p string, info fs.DirEntry, err error) error {
+			if err != nil || info.IsDir() || !strings.HasSuffix(p, ".html") {
+				return err
+			}
+			name := path.Join(d.as, strings.TrimPrefix(p, d.root+"/"))
+			if allTemplates.Lookup(name) != nil {
+				return nil
+			}
+			b, err := fs.ReadFile(d.fsys, p)
+			if err != nil {
+				return err
+			}
+			_, err = allTemplates.New(name).Funcs(functions).Parse(string(b))
+			return err
+		})
+		logger.CheckError(err)
+	}
+}
+
 func (router *Router) NewFuncMap(funcName string, function any) {
 	if _, ok := functions[funcName]; ok {
 		logger.Error("unable to add", funcName, ",already exist")
@@ -184,10 +224,21 @@ func (router *Router) initDefaultUrls() {
 		}
 	}
 	// MEDIA
-	media_root := http.FileServer(http.Dir("./" + settings.MEDIA_DIR))
-	router.GET(`/`+settings.MEDIA_DIR+`/*`, func(c *Context) {
-		http.StripPrefix("/"+settings.MEDIA_DIR+"/", media_root).ServeHTTP(c.ResponseWriter, c.Request)
-	})
+	router.GET(`/`+settings.MEDIA_DIR+`/*`, serveMedia(settings.MEDIA_DIR))
+}
+
+// serveMedia serve the files of dir, ?download serve them as an attachment
+func serveMedia(dir string) Handler {
+	media_root := http.FileServer(http.Dir("./" + dir))
+	return func(c *Context) {
+		if _, ok := c.Request.URL.Query()["download"]; ok {
+			// cleaned as rooted so .. can't leave dir
+			name := path.Clean("/" + strings.TrimPrefix(c.Request.URL.Path, "/"+dir+"/"))
+			c.DownloadFile(filepath.Join(dir, filepath.FromSlash(name)))
+			return
+		}
+		http.StripPrefix("/"+dir+"/", media_root).ServeHTTP(c.ResponseWriter, c.Request)
+	}
 }
 
 func (router *Router) cloneTemplatesAndStatic() {
@@ -369,13 +420,19 @@ var functions = template.FuncMap{
 			return v
 		}
 	},
+	// csrf_token render the hidden input sent with forms, checked by the CSRF middleware
 	"csrf_token": func(r *http.Request) template.HTML {
-		csrf, _ := r.Cookie("csrf_token")
-		if csrf != nil {
-			return template.HTML(fmt.Sprintf("   <input type=\"hidden\" id=\"csrf_token\" value=\"%s\">   ", csrf.Value))
-		} else {
+		token := csrf.Token(r)
+		if token == "" {
 			return template.HTML("")
 		}
+		return template.HTML(fmt.Sprintf(`<input type="hidden" id="%s" name="%s" value="%s">`, csrf.FIELD, csrf.FIELD, html.EscapeString(token)))
+	},
+	// cspNonce return the nonce of the Content-Security-Policy, ex: <script nonce="{{cspNonce .Request}}">
+	"cspNonce": secure.Nonce,
+	// hasPerm report if the user of the request have all codenames, ex: {{if hasPerm .Request "orders.change"}}
+	"hasPerm": func(r *http.Request, codenames ...string) bool {
+		return (&Context{Request: r}).HasPermission(codenames...)
 	},
 	"translateFromRequest": func(translation string, request *http.Request) any {
 		var lg string
@@ -0,0 +1,60 @@
+package kamux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeMediaDownload(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "media", "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "media", "docs", "a.txt"), []byte("public"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	handler := serveMedia("media")
+	tests := []struct {
+		name   string
+		target string
+		status int
+		body   string
+	}{
+		{"download", "/media/docs/a.txt?download", http.StatusOK, "public"},
+		{"dot dot", "/media/../secret.txt?download", http.StatusNotFound, ""},
+		{"nested dot dot", "/media/docs/../../secret.txt?download", http.StatusNotFound, ""},
+		{"encoded slash", "/media/..%2Fsecret.txt?download", http.StatusNotFound, ""},
+		{"backslash", "/media/..%5Csecret.txt?download", http.StatusNotFound, ""},
+		{"directory", "/media/docs?download", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(&Context{ResponseWriter: w, Request: httptest.NewRequest("GET", tt.target, nil), Params: map[string]string{}})
+			if w.Code != tt.status {
+				t.Fatal("status", w.Code, w.Body.String())
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Fatal("body", w.Body.String())
+			}
+			if tt.status != http.StatusOK && w.Body.String() == "secret" {
+				t.Fatal("file outside media served")
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+// Store persist the failures and locks of keys
+type Store interface {
+	// Get return the failures of key, ErrNotFound if none
+	Get(key string) (models.LoginLock, error)
+	// Fail count a failure of key at now, restarting from 1 if the previous ones are older than RESET_AFTER or their lock expired
+	Fail(key string, now time.Time) (models.LoginLock, error)
+	Lock(key string, until time.Time) error
+	Delete(key string) error
+	// Locked return the keys locked at now
+	Locked(now time.Time) ([]models.LoginLock, error)
+	// DeleteExpired forget the keys neither locked nor failed since RESET_AFTER
+	DeleteExpired(now time.Time) error
+}
+
+// STORE keep the failures, New use the orm table login_locks, BareBone keep them in memory
+var STORE Store = NewMemory()
+
+func restart(l models.LoginLock, now time.Time) bool {
+	return now.Unix()-l.LastFailure > int64(RESET_AFTER/time.Second) || (l.LockedUntil != 0 && l.LockedUntil <= now.Unix())
+}
+
+// Memory is an in memory Store, lost on restart
+type Memory struct {
+	locks map[string]models.LoginLock
+	mu    sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{locks: map[string]models.LoginLock{}}
+}
+
+func (m *Memory) Get(key string) (models.LoginLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.locks[key]
+	if !ok {
+		return l, ErrNotFound
+	}
+	return l, nil
+}
+
+func (m *Memory) Fail(key string, now time.Time) (models.LoginLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.locks[key]
+	if !ok || restart(l, now) {
+		l = models.LoginLock{LockKey: key}
+	}
+	l.Failures++
+	l.LastFailure = now.Unix()
+	m.locks[key] = l
+	return l, nil
+}
+
+func (m *Memory) Lock(key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.locks[key]
+	if !ok {
+		l = models.LoginLock{LockKey: key}
+	}
+	l.LockedUntil = until.Unix()
+	m.locks[key] = l
+	return nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.locks, key)
+	return nil
+}
+
+func (m *Memory) Locked(now time.Time) ([]models.LoginLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []models.LoginLock{}
+	for _, l := range m.locks {
+		if l.LockedUntil > now.Unix() {
+			res = append(res, l)
+		}
+	}
+	return res, nil
+}
+
+func (m *Memory) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, l := range m.locks {
+		if l.LockedUntil <= now.Unix() && now.Unix()-l.LastFailure > int64(RESET_AFTER/time.Second) {
+			delete(m.locks, k)
+		}
+	}
+	return nil
+}
+
+// ORM keep the failures in the table login_locks
+type ORM struct {
+	dbName string
+}
+
+// NewORM return a store using dbName, the default database if empty
+func NewORM(dbName ...string) *ORM {
+	s := &ORM{}
+	if len(dbName) > 0 {
+		s.dbName = dbName[0]
+	}
+	return s
+}
+
+const lockColumns = "id,lock_key,failures,last_failure,locked_until"
+
+func lockFromRow(r map[string]any) models.LoginLock {
+	l := models.LoginLock{
+		Id:          int(orm.ToInt64(r["id"])),
+		Failures:    int(orm.ToInt64(r["failures"])),
+		LastFailure: orm.ToInt64(r["last_failure"]),
+		LockedUntil: orm.ToInt64(r["locked_until"]),
+	}
+	switch v := r["lock_key"].(type) {
+	case string:
+		l.LockKey = v
+	case []byte:
+		l.LockKey = string(v)
+	}
+	return l
+}
+
+func (o *ORM) Get(key string) (models.LoginLock, error) {
+	rows, err := orm.Query(o.dbName, "SELECT "+lockColumns+" FROM login_locks WHERE lock_key = ?", key)
+	if err != nil || len(rows) == 0 {
+		if err == nil || err.Error() == "no data found" {
+			err = ErrNotFound
+		}
+		return models.LoginLock{}, err
+	}
+	return lockFromRow(rows[0]), nil
+}
+
+// Fail increment the failures in a single statement, so concurrent logins are all counted
+func (o *ORM) Fail(key string, now time.Time) (models.LoginLock, error) {
+	unix := now.Unix()
+	since := unix - int64(RESET_AFTER/time.Second)
+	n, err := orm.ExecAffected(o.dbName, "UPDATE login_locks SET failures = CASE WHEN last_failure < ? OR (locked_until <> 0 AND locked_until <= ?) THEN 1 ELSE failures + 1 END,"+
+		" locked_until = CASE WHEN locked_until <= ? THEN 0 ELSE locked_until END, last_failure = ? WHERE lock_key = ?",
+		since, unix, unix, unix, key)
+	if err != nil {
+		return models.LoginLock{}, err
+	}
+	if n == 0 {
+		if _, err := orm.ExecAffected(o.dbName, "INSERT INTO login_locks (lock_key,failures,last_failure,locked_until) VALUES (?,1,?,0)", key, unix); err != nil {
+			// inserted meanwhile by a concurrent failure
+			if _, err := orm.ExecAffected(o.dbName, "UPDATE login_locks SET failures = failures + 1, last_failure = ? WHERE lock_key = ?", unix, key); err != nil {
+				return models.LoginLock{}, err
+			}
+		}
+	}
+	return o.Get(key)
+}
+
+func (o *ORM) Lock(key string, until time.Time) error {
+	_, err := orm.ExecAffected(o.dbName, "UPDATE login_locks SET locked_until = ? WHERE lock_key = ?", until.Unix(), key)
+	return err
+}
+
+func (o *ORM) Delete(key string) error {
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM login_locks WHERE lock_key = ?", key)
+	return err
+}
+
+func (o *ORM) Locked(now time.Time) ([]models.LoginLock, error) {
+	rows, err := orm.Query(o.dbName, "SELECT "+lockColumns+" FROM login_locks WHERE locked_until > ? ORDER BY locked_until DESC", now.Unix())
+	if err != nil {
+		if err.Error() == "no data found" {
+			return []models.LoginLock{}, nil
+		}
+		return nil, err
+	}
+	res := make([]models.LoginLock, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, lockFromRow(r))
+	}
+	return res, nil
+}
+
+func (o *ORM) DeleteExpired(now time.Time) error {
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM login_locks WHERE locked_until <= ? AND last_failure < ?", now.Unix(), now.Unix()-int64(RESET_AFTER/time.Second))
+	return err
+}
@@ -0,0 +1,247 @@
+// Package throttle slow down password guessing, counting the failed logins of each account and ip.
+// Every failure of an account double the wait before its next try, and MAX_FAILURES lock it for LOCKOUT,
+// ips are only locked, after IP_MAX_FAILURES, so users behind the same address don't slow each other
+package throttle
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/utils/eventbus"
+)
+
+var (
+	// MAX_FAILURES lock an account, IP_MAX_FAILURES an ip, 0 disable the lock
+	MAX_FAILURES    = 5
+	IP_MAX_FAILURES = 20
+	// LOCKOUT is how long a lock last
+	LOCKOUT = 15 * time.Minute
+	// BASE_DELAY is the wait after the first failure of an account, doubled by each failure until MAX_DELAY
+	BASE_DELAY = time.Second
+	MAX_DELAY  = time.Minute
+	// RESET_AFTER forget the failures of a key without failure for this long
+	RESET_AFTER = time.Hour
+	// LOCK_TOPIC receive a map[string]string {"event":"locked"|"cleared","key","failures","until"} on lock and clear,
+	// subscribe with eventbus.Subscribe(throttle.LOCK_TOPIC, func(data map[string]string) {...})
+	LOCK_TOPIC = "login-lock"
+)
+
+var (
+	ErrLocked   = errors.New("too many failed logins, try again later")
+	ErrTooSoon  = errors.New("wait before trying again")
+	ErrNotFound = errors.New("no failure for this key")
+)
+
+const (
+	accountPrefix   = "account:"
+	ipPrefix        = "ip:"
+	twoFactorPrefix = "2fa:"
+)
+
+// AccountKey return the key counting the failures of email
+func AccountKey(email string) string {
+	return accountPrefix + strings.ToLower(strings.TrimSpace(email))
+}
+
+// TwoFactorKey return the key counting the wrong two factor codes of userId, kept apart from its AccountKey
+// which is cleared by each right password
+func TwoFactorKey(userId int) string {
+	return twoFactorPrefix + strconv.Itoa(userId)
+}
+
+// IPKey return the key counting the failures of ip, the port is ignored and only the first of a forwarded list kept
+func IPKey(ip string) string {
+	ip = strings.TrimSpace(strings.Split(ip, ",")[0])
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ipPrefix + ip
+}
+
+// Keys return the keys of a login attempt of email from ip
+func Keys(email, ip string) []string {
+	return []string{AccountKey(email), IPKey(ip)}
+}
+
+func isIP(key string) bool {
+	return strings.HasPrefix(key, ipPrefix)
+}
+
+func maxFailures(key string) int {
+	if isIP(key) {
+		return IP_MAX_FAILURES
+	}
+	return MAX_FAILURES
+}
+
+// backoff return the wait after failures of key
+func backoff(key string, failures int) time.Duration {
+	if isIP(key) || failures <= 0 || BASE_DELAY <= 0 {
+		return 0
+	}
+	d := BASE_DELAY
+	for i := 1; i < failures && d < MAX_DELAY; i++ {
+		d *= 2
+	}
+	if d > MAX_DELAY {
+		d = MAX_DELAY
+	}
+	return d
+}
+
+// wait return how long l must wait at now, and ErrLocked or ErrTooSoon
+func wait(l models.LoginLock, now time.Time) (time.Duration, error) {
+	if l.LockedUntil > now.Unix() {
+		return time.Unix(l.LockedUntil, 0).Sub(now), ErrLocked
+	}
+	if l.LockedUntil != 0 || l.Failures == 0 || now.Sub(time.Unix(l.LastFailure, 0)) > RESET_AFTER {
+		return 0, nil
+	}
+	if d := time.Unix(l.LastFailure, 0).Add(backoff(l.LockKey, l.Failures)).Sub(now); d > 0 {
+		return d, ErrTooSoon
+	}
+	return 0, nil
+}
+
+// Check return ErrLocked or ErrTooSoon and the time to wait if one of keys can't try to log in now
+func Check(keys ...string) (time.Duration, error) {
+	now := time.Now()
+	var longest time.Duration
+	var res error
+	for _, k := range keys {
+		l, err := STORE.Get(k)
+		if err != nil {
+			continue
+		}
+		if d, err := wait(l, now); err != nil && d > longest {
+			longest, res = d, err
+		}
+	}
+	return longest, res
+}
+
+var (
+	inflight   = map[string]int{}
+	inflightMu sync.Mutex
+)
+
+// inflightLimit return how many attempts of key can be checked at once: one for an account, so each wait for the failure
+// of the previous, and for an ip the failures left before its lock, 0 if unlimited
+func inflightLimit(l models.LoginLock, now time.Time) int {
+	if !isIP(l.LockKey) {
+		if MAX_FAILURES <= 0 && BASE_DELAY <= 0 {
+			return 0
+		}
+		return 1
+	}
+	if IP_MAX_FAILURES <= 0 {
+		return 0
+	}
+	failures := l.Failures
+	if (l.LockedUntil != 0 && l.LockedUntil <= now.Unix()) || now.Sub(time.Unix(l.LastFailure, 0)) > RESET_AFTER {
+		failures = 0
+	}
+	if left := IP_MAX_FAILURES - failures; left > 0 {
+		return left
+	}
+	return 1
+}
+
+// Begin check keys like Check and reserve an attempt, so parallel guesses can't all pass before their failures are counted.
+// If it return no error, call Fail or Success then End with the same keys once the password is checked
+func Begin(keys ...string) (time.Duration, error) {
+	inflightMu.Lock()
+	defer inflightMu.Unlock()
+	if wait, err := Check(keys...); err != nil {
+		return wait, err
+	}
+	now := time.Now()
+	for _, k := range keys {
+		l, err := STORE.Get(k)
+		if err != nil {
+			l = models.LoginLock{LockKey: k}
+		}
+		if limit := inflightLimit(l, now); limit > 0 && inflight[k] >= limit {
+			wait := BASE_DELAY
+			if wait <= 0 {
+				wait = time.Second
+			}
+			return wait, ErrTooSoon
+		}
+	}
+	for _, k := range keys {
+		inflight[k]++
+	}
+	return 0, nil
+}
+
+// End release the attempt reserved by Begin
+func End(keys ...string) {
+	inflightMu.Lock()
+	defer inflightMu.Unlock()
+	for _, k := range keys {
+		if inflight[k] <= 1 {
+			delete(inflight, k)
+		} else {
+			inflight[k]--
+		}
+	}
+}
+
+// Fail count a failed login for keys, locking the ones reaching their maximum
+func Fail(keys ...string) error {
+	now := time.Now()
+	for _, k := range keys {
+		l, err := STORE.Fail(k, now)
+		if err != nil {
+			return err
+		}
+		max := maxFailures(k)
+		if max <= 0 || l.Failures < max || l.LockedUntil > now.Unix() {
+			continue
+		}
+		until := now.Add(LOCKOUT)
+		if err := STORE.Lock(k, until); err != nil {
+			return err
+		}
+		eventbus.Publish(LOCK_TOPIC, map[string]string{
+			"event":    "locked",
+			"key":      k,
+			"failures": strconv.Itoa(l.Failures),
+			"until":    until.Format(time.RFC3339),
+		})
+	}
+	return nil
+}
+
+// Success forget the failures of keys, call it with the AccountKey only, an ip with a valid account can still guess others
+func Success(keys ...string) error {
+	for _, k := range keys {
+		if err := STORE.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Locks return the keys locked now
+func Locks() ([]models.LoginLock, error) {
+	return STORE.Locked(time.Now())
+}
+
+// Clear unlock key and forget its failures
+func Clear(key string) error {
+	if err := STORE.Delete(key); err != nil {
+		return err
+	}
+	eventbus.Publish(LOCK_TOPIC, map[string]string{
+		"event": "cleared",
+		"key":   key,
+	})
+	return nil
+}
@@ -0,0 +1,143 @@
+package throttle
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/utils/eventbus"
+)
+
+func TestBackoffAndLock(t *testing.T) {
+	STORE = NewMemory()
+	locked := make(chan map[string]string, 2)
+	eventbus.Subscribe(LOCK_TOPIC, func(data map[string]string) { locked <- data })
+
+	keys := Keys("A@b.c", "10.0.0.1:5555")
+	if keys[0] != "account:a@b.c" || keys[1] != "ip:10.0.0.1" {
+		t.Fatal(keys)
+	}
+	if _, err := Check(keys...); err != nil {
+		t.Fatal(err)
+	}
+	if err := Fail(keys...); err != nil {
+		t.Fatal(err)
+	}
+	wait, err := Check(keys...)
+	if err != ErrTooSoon || wait <= 0 || wait > BASE_DELAY {
+		t.Fatal(wait, err)
+	}
+	if d := backoff(keys[0], 3); d != 4*BASE_DELAY {
+		t.Fatal(d)
+	}
+	if d := backoff(keys[0], 100); d != MAX_DELAY {
+		t.Fatal(d)
+	}
+	for i := 1; i < MAX_FAILURES; i++ {
+		if err := Fail(keys...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Check(keys[0]); err != ErrLocked {
+		t.Fatal("account not locked", err)
+	}
+	if _, err := Check(keys[1]); err != nil {
+		t.Fatal("ip locked before IP_MAX_FAILURES", err)
+	}
+	select {
+	case ev := <-locked:
+		if ev["event"] != "locked" || ev["key"] != keys[0] {
+			t.Fatal(ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no lock event")
+	}
+	if locks, _ := Locks(); len(locks) != 1 {
+		t.Fatal(locks)
+	}
+	if err := Clear(keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Check(keys[0]); err != nil {
+		t.Fatal("still locked", err)
+	}
+}
+
+func TestLockExpiry(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		m.Fail("account:x", now)
+	}
+	_ = m.Lock("account:x", now.Add(time.Minute))
+	l, _ := m.Fail("account:x", now.Add(2*time.Minute))
+	if l.Failures != 1 {
+		t.Fatal("failures not restarted after the lock", l.Failures)
+	}
+	_ = m.DeleteExpired(now.Add(2*time.Minute + RESET_AFTER + time.Second))
+	if _, err := m.Get("account:x"); err != ErrNotFound {
+		t.Fatal("not deleted", err)
+	}
+}
+
+func TestTwoFactorKey(t *testing.T) {
+	STORE = NewMemory()
+	key := TwoFactorKey(3)
+	for i := 0; i < MAX_FAILURES; i++ {
+		_ = Fail(key)
+		// a right password don't forget the wrong codes
+		_ = Success(AccountKey("a@b.c"))
+	}
+	if _, err := Check(key); err != ErrLocked {
+		t.Fatal("wrong codes not locked", err)
+	}
+}
+
+func TestConcurrentAttempts(t *testing.T) {
+	STORE = NewMemory()
+	defer func(max int) { IP_MAX_FAILURES = max }(IP_MAX_FAILURES)
+	IP_MAX_FAILURES = 3
+
+	// parallel guesses, each failing, only as many as allowed are checked before the failures are counted
+	guess := func(n int, keys func(i int) []string) int {
+		var (
+			wg      sync.WaitGroup
+			start   = make(chan struct{})
+			checked int32
+		)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(keys []string) {
+				defer wg.Done()
+				<-start
+				if _, err := Begin(keys...); err != nil {
+					return
+				}
+				atomic.AddInt32(&checked, 1)
+				time.Sleep(20 * time.Millisecond) // the password hash
+				_ = Fail(keys...)
+				End(keys...)
+			}(keys(i))
+		}
+		close(start)
+		wg.Wait()
+		return int(checked)
+	}
+	if n := guess(20, func(int) []string { return Keys("a@b.c", "10.0.0.1") }); n != 1 {
+		t.Fatal("account guesses checked at once:", n)
+	}
+	if _, err := Check(AccountKey("a@b.c")); err != ErrTooSoon {
+		t.Fatal("backoff skipped", err)
+	}
+	if n := guess(20, func(i int) []string { return Keys("user"+strconv.Itoa(i)+"@b.c", "10.0.0.2") }); n != IP_MAX_FAILURES {
+		t.Fatal("ip guesses checked before its lock:", n)
+	}
+	if _, err := Check(IPKey("10.0.0.2")); err != ErrLocked {
+		t.Fatal("ip not locked", err)
+	}
+	if len(inflight) != 0 {
+		t.Fatal("attempts not released", inflight)
+	}
+}
@@ -0,0 +1,202 @@
+package totp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/orm"
+)
+
+// Store persist totp secrets and recovery codes
+type Store interface {
+	// Get return the secret of userId, ErrNotEnabled if none
+	Get(userId int) (models.TwoFactor, error)
+	// Save create or replace the secret of tf.UserId
+	Save(tf models.TwoFactor) error
+	// Delete remove the secret and recovery codes of userId
+	Delete(userId int) error
+	// UseStep record step as the last used, false if step is not after the previous one
+	UseStep(userId int, step int64) (bool, error)
+	SetRecovery(userId int, hashes []string) error
+	// UseRecovery mark the code having hash used, false if unknown or already used
+	UseRecovery(userId int, hash string, at time.Time) (bool, error)
+	RecoveryLeft(userId int) (int, error)
+}
+
+// STORE keep the secrets, New use the orm tables two_factors and recovery_codes, BareBone keep them in memory
+var STORE Store = NewMemory()
+
+// Memory is an in memory Store, lost on restart
+type Memory struct {
+	secrets  map[int]models.TwoFactor
+	recovery map[int]map[string]int64
+	mu       sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{secrets: map[int]models.TwoFactor{}, recovery: map[int]map[string]int64{}}
+}
+
+func (m *Memory) Get(userId int) (models.TwoFactor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tf, ok := m.secrets[userId]
+	if !ok {
+		return tf, ErrNotEnabled
+	}
+	return tf, nil
+}
+
+func (m *Memory) Save(tf models.TwoFactor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secrets[tf.UserId] = tf
+	return nil
+}
+
+func (m *Memory) Delete(userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.secrets, userId)
+	delete(m.recovery, userId)
+	return nil
+}
+
+func (m *Memory) UseStep(userId int, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tf, ok := m.secrets[userId]
+	if !ok || step <= tf.LastStep {
+		return false, nil
+	}
+	tf.LastStep = step
+	m.secrets[userId] = tf
+	return true, nil
+}
+
+func (m *Memory) SetRecovery(userId int, hashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	codes := make(map[string]int64, len(hashes))
+	for _, h := range hashes {
+		codes[h] = 0
+	}
+	m.recovery[userId] = codes
+	return nil
+}
+
+func (m *Memory) UseRecovery(userId int, hash string, at time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used, ok := m.recovery[userId][hash]
+	if !ok || used != 0 {
+		return false, nil
+	}
+	m.recovery[userId][hash] = at.Unix()
+	return true, nil
+}
+
+func (m *Memory) RecoveryLeft(userId int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, used := range m.recovery[userId] {
+		if used == 0 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ORM keep secrets in the table two_factors and recovery codes in recovery_codes
+type ORM struct {
+	dbName string
+}
+
+// NewORM return a store using dbName, the default database if empty
+func NewORM(dbName ...string) *ORM {
+	s := &ORM{}
+	if len(dbName) > 0 {
+		s.dbName = dbName[0]
+	}
+	return s
+}
+
+func (o *ORM) Get(userId int) (models.TwoFactor, error) {
+	rows, err := orm.Query(o.dbName, "SELECT id,secret,confirmed_at,last_step,created_at FROM two_factors WHERE user_id = ?", userId)
+	if err != nil || len(rows) == 0 {
+		if err == nil || err.Error() == "no data found" {
+			err = ErrNotEnabled
+		}
+		return models.TwoFactor{}, err
+	}
+	secret, _ := rows[0]["secret"].(string)
+	if b, ok := rows[0]["secret"].([]byte); ok {
+		secret = string(b)
+	}
+	return models.TwoFactor{
+		Id:          int(orm.ToInt64(rows[0]["id"])),
+		UserId:      userId,
+		Secret:      secret,
+		ConfirmedAt: orm.ToInt64(rows[0]["confirmed_at"]),
+		LastStep:    orm.ToInt64(rows[0]["last_step"]),
+		CreatedAt:   orm.ToInt64(rows[0]["created_at"]),
+	}, nil
+}
+
+func (o *ORM) Save(tf models.TwoFactor) error {
+	_, err := o.Get(tf.UserId)
+	if err == nil {
+		_, err = orm.ExecAffected(o.dbName, "UPDATE two_factors SET secret = ?, confirmed_at = ?, last_step = ? WHERE user_id = ?", tf.Secret, tf.ConfirmedAt, tf.LastStep, tf.UserId)
+		return err
+	}
+	if err != ErrNotEnabled {
+		return err
+	}
+	_, err = orm.ExecAffected(o.dbName, "INSERT INTO two_factors (user_id,secret,confirmed_at,last_step,created_at) VALUES (?,?,?,?,?)",
+		tf.UserId, tf.Secret, tf.ConfirmedAt, tf.LastStep, tf.CreatedAt)
+	return err
+}
+
+func (o *ORM) Delete(userId int) error {
+	if _, err := orm.ExecAffected(o.dbName, "DELETE FROM recovery_codes WHERE user_id = ?", userId); err != nil {
+		return err
+	}
+	_, err := orm.ExecAffected(o.dbName, "DELETE FROM two_factors WHERE user_id = ?", userId)
+	return err
+}
+
+// UseStep update only if step is newer, so a code can't be used by two concurrent logins
+func (o *ORM) UseStep(userId int, step int64) (bool, error) {
+	n, err := orm.ExecAffected(o.dbName, "UPDATE two_factors SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userId, step)
+	return n == 1, err
+}
+
+func (o *ORM) SetRecovery(userId int, hashes []string) error {
+	if _, err := orm.ExecAffected(o.dbName, "DELETE FROM recovery_codes WHERE user_id = ?", userId); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := orm.ExecAffected(o.dbName, "INSERT INTO recovery_codes (user_id,code_hash,used_at) VALUES (?,?,?)", userId, h, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *ORM) UseRecovery(userId int, hash string, at time.Time) (bool, error) {
+	n, err := orm.ExecAffected(o.dbName, "UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at = 0", at.Unix(), userId, hash)
+	return n == 1, err
+}
+
+func (o *ORM) RecoveryLeft(userId int) (int, error) {
+	rows, err := orm.Query(o.dbName, "SELECT id FROM recovery_codes WHERE user_id = ? AND used_at = 0", userId)
+	if err != nil {
+		if err.Error() == "no data found" {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(rows), nil
+}
@@ -0,0 +1,305 @@
+// Package totp implement RFC 6238 time based one time passwords for two factor authentication,
+// with single use recovery codes of which only the sha256 is stored
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/settings"
+	"github.com/kamalshkeir/kago/core/utils/qrcode"
+)
+
+var (
+	// ISSUER is the name shown by authenticator apps
+	ISSUER = "Kago"
+	DIGITS = 6
+	PERIOD = 30 * time.Second
+	// SKEW accept codes of this number of periods before and after the current one, for clock drift
+	SKEW           = 1
+	RECOVERY_CODES = 10
+)
+
+var (
+	ErrInvalidCode    = errors.New("invalid code")
+	ErrNotEnabled     = errors.New("two factor authentication not enabled")
+	ErrAlreadyEnabled = errors.New("two factor authentication already enabled")
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret return a random base32 secret of 160 bits
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+	return b32.DecodeString(strings.TrimRight(secret, "="))
+}
+
+func codeAt(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < DIGITS; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", DIGITS, v%mod)
+}
+
+func step(t time.Time) int64 {
+	return t.Unix() / int64(PERIOD/time.Second)
+}
+
+// Code return the code of secret at t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return codeAt(key, step(t)), nil
+}
+
+// Validate check code against secret at t, allowing SKEW periods of drift, and return the time step it matched
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if err != nil || len(code) != DIGITS {
+		return 0, false
+	}
+	now := step(t)
+	for i := -SKEW; i <= SKEW; i++ {
+		if subtle.ConstantTimeCompare([]byte(codeAt(key, now+int64(i))), []byte(code)) == 1 {
+			return now + int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// URI return the otpauth uri of secret for account, the content of the enrollment qr code
+func URI(account, secret string) string {
+	label := url.PathEscape(ISSUER) + ":" + url.PathEscape(account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", ISSUER)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(DIGITS))
+	q.Set("period", fmt.Sprint(int(PERIOD/time.Second)))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// QR return the enrollment qr code of secret for account as an svg image
+func QR(account, secret string) (string, error) {
+	c, err := qrcode.Encode(URI(account, secret))
+	if err != nil {
+		return "", err
+	}
+	return c.SVG(4), nil
+}
+
+func hashCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// secrets are encrypted with settings.Secret when set, a secret set later can't read the plain ones, and stay plain
+const sealedPrefix = "enc:"
+
+func sealKey() cipher.AEAD {
+	if settings.Secret == "" {
+		return nil
+	}
+	key := sha256.Sum256([]byte("totp:" + settings.Secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil
+	}
+	return gcm
+}
+
+func seal(secret string) (string, error) {
+	gcm := sealKey()
+	if gcm == nil {
+		return secret, nil
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return sealedPrefix + hex.EncodeToString(gcm.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+func open(stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return stored, nil
+	}
+	gcm := sealKey()
+	if gcm == nil {
+		return "", errors.New("totp: secret encrypted, settings.Secret not set")
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil || len(b) < gcm.NonceSize() {
+		return "", errors.New("totp: invalid encrypted secret")
+	}
+	plain, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Enabled report if userId confirmed a totp secret
+func Enabled(userId int) bool {
+	tf, err := STORE.Get(userId)
+	return err == nil && tf.ConfirmedAt != 0
+}
+
+// Enroll return the secret to show to userId until confirmed, the pending one if any
+func Enroll(userId int) (string, error) {
+	tf, err := STORE.Get(userId)
+	if err == nil {
+		if tf.ConfirmedAt != 0 {
+			return "", ErrAlreadyEnabled
+		}
+		return open(tf.Secret)
+	}
+	if err != ErrNotEnabled {
+		return "", err
+	}
+	secret, err := GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(secret)
+	if err != nil {
+		return "", err
+	}
+	err = STORE.Save(models.TwoFactor{UserId: userId, Secret: sealed, CreatedAt: time.Now().Unix()})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Confirm enable the pending secret of userId if code is valid, and return new recovery codes
+func Confirm(userId int, code string) ([]string, error) {
+	tf, err := STORE.Get(userId)
+	if err != nil {
+		return nil, err
+	}
+	if tf.ConfirmedAt != 0 {
+		return nil, ErrAlreadyEnabled
+	}
+	secret, err := open(tf.Secret)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := Validate(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	tf.ConfirmedAt = time.Now().Unix()
+	tf.LastStep = st
+	if err := STORE.Save(tf); err != nil {
+		return nil, err
+	}
+	return NewRecoveryCodes(userId)
+}
+
+// Verify check a totp or a recovery code of userId, each can be used once
+func Verify(userId int, code string) error {
+	tf, err := STORE.Get(userId)
+	if err != nil {
+		return err
+	}
+	if tf.ConfirmedAt == 0 {
+		return ErrNotEnabled
+	}
+	code = strings.TrimSpace(code)
+	if len(code) > DIGITS {
+		ok, err := STORE.UseRecovery(userId, hashCode(code), time.Now())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+	secret, err := open(tf.Secret)
+	if err != nil {
+		return err
+	}
+	st, ok := Validate(secret, code, time.Now())
+	if !ok {
+		return ErrInvalidCode
+	}
+	// a code seen once is refused, even in its validity window
+	ok, err = STORE.UseStep(userId, st)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// NewRecoveryCodes replace the recovery codes of userId, they are returned once
+func NewRecoveryCodes(userId int) ([]string, error) {
+	codes := make([]string, RECOVERY_CODES)
+	hashes := make([]string, RECOVERY_CODES)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(b32.EncodeToString(b))
+		codes[i] = c[:4] + "-" + c[4:8] + "-" + c[8:12] + "-" + c[12:]
+		hashes[i] = hashCode(codes[i])
+	}
+	if err := STORE.SetRecovery(userId, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RecoveryLeft return the number of unused recovery codes of userId
+func RecoveryLeft(userId int) int {
+	n, _ := STORE.RecoveryLeft(userId)
+	return n
+}
+
+// Reset disable two factor authentication of userId, deleting its secret and recovery codes
+func Reset(userId int) error {
+	return STORE.Delete(userId)
+}
@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/settings"
+)
+
+func TestRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	// rfc 6238 sha1 vectors, truncated to 6 digits
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		if got, _ := Code(secret, time.Unix(unix, 0)); got != want {
+			t.Fatal(unix, got, want)
+		}
+	}
+	code, _ := Code(secret, time.Unix(59, 0))
+	if _, ok := Validate(secret, code, time.Unix(59+30, 0)); !ok {
+		t.Fatal("previous period refused")
+	}
+	if _, ok := Validate(secret, code, time.Unix(59+90, 0)); ok {
+		t.Fatal("old code accepted")
+	}
+	if u := URI("a@b.c", "ABC"); !strings.HasPrefix(u, "otpauth://totp/Kago:a@b.c?") || !strings.Contains(u, "secret=ABC") {
+		t.Fatal(u)
+	}
+}
+
+func TestEnrollAndVerify(t *testing.T) {
+	STORE = NewMemory()
+	settings.Secret = "test secret"
+	defer func() { settings.Secret = "" }()
+
+	secret, err := Enroll(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := Enroll(1); again != secret {
+		t.Fatal("pending secret changed")
+	}
+	if tf, _ := STORE.Get(1); !strings.HasPrefix(tf.Secret, sealedPrefix) {
+		t.Fatal("secret stored in clear")
+	}
+	if Enabled(1) || Verify(1, "000000") != ErrNotEnabled {
+		t.Fatal("enabled before confirmation")
+	}
+	if _, err := Confirm(1, "000000"); err != ErrInvalidCode {
+		t.Fatal("wrong code confirmed", err)
+	}
+	code, _ := Code(secret, time.Now())
+	recovery, err := Confirm(1, code)
+	if err != nil || len(recovery) != RECOVERY_CODES || !Enabled(1) {
+		t.Fatal(err, recovery)
+	}
+	// the confirmation code can't be replayed
+	if err := Verify(1, code); err != ErrInvalidCode {
+		t.Fatal("code replayed", err)
+	}
+	if err := Verify(1, strings.ToUpper(recovery[0])); err != nil {
+		t.Fatal("recovery code", err)
+	}
+	if err := Verify(1, recovery[0]); err != ErrInvalidCode {
+		t.Fatal("recovery code used twice")
+	}
+	if RecoveryLeft(1) != RECOVERY_CODES-1 {
+		t.Fatal("recovery left", RecoveryLeft(1))
+	}
+	_ = Reset(1)
+	if Enabled(1) || RecoveryLeft(1) != 0 {
+		t.Fatal("not reset")
+	}
+}
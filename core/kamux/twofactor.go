@@ -0,0 +1,141 @@
+package kamux
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/kamux/perms"
+	"github.com/kamalshkeir/kago/core/kamux/throttle"
+	"github.com/kamalshkeir/kago/core/kamux/totp"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+var (
+	// TWO_FACTOR_ADMINS require two factor authentication for the users able to enter the admin, admins and users having permissions,
+	// the ones without are sent to TWO_FACTOR_PATH to enroll
+	TWO_FACTOR_ADMINS = false
+	// TWO_FACTOR_PATH is the enrollment page, TWO_FACTOR_LOGIN_PATH the second login step
+	TWO_FACTOR_PATH       = "/admin/2fa"
+	TWO_FACTOR_LOGIN_PATH = "/admin/login/2fa"
+	// TWO_FACTOR_TTL limit the time between the password and the code
+	TWO_FACTOR_TTL = 5 * time.Minute
+	// TWO_FACTOR_MAX_TRIES wrong codes restart the login
+	TWO_FACTOR_MAX_TRIES = 5
+)
+
+const twoFactorKey = "2fa"
+
+// NeedsTwoFactor report if user must enter a code to log in
+func NeedsTwoFactor(user models.User) bool {
+	return totp.Enabled(user.Id) || (TWO_FACTOR_ADMINS && perms.IsStaff(user))
+}
+
+func sessionNumber(v any) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// LoginOrChallenge log user in, or if a second factor is needed keep them pending in the session and return true,
+// next is where CompleteTwoFactor send them
+func (c *Context) LoginOrChallenge(user models.User, next string) (bool, error) {
+	if !NeedsTwoFactor(user) {
+		return false, c.Login(user)
+	}
+	s := c.Session()
+	if err := s.Rotate(); err != nil {
+		return false, err
+	}
+	return true, s.Set(twoFactorKey, map[string]any{
+		"user":  user.Id,
+		"at":    time.Now().Unix(),
+		"next":  next,
+		"tries": 0,
+	})
+}
+
+func (c *Context) twoFactorPending() (map[string]any, bool) {
+	p, ok := c.Session().Get(twoFactorKey).(map[string]any)
+	if !ok || time.Since(time.Unix(sessionNumber(p["at"]), 0)) > TWO_FACTOR_TTL {
+		return nil, false
+	}
+	return p, true
+}
+
+// TwoFactorPending return the user who entered their password and must now enter a code
+func (c *Context) TwoFactorPending() (models.User, bool) {
+	p, ok := c.twoFactorPending()
+	if !ok {
+		return models.User{}, false
+	}
+	user, err := userById(int(sessionNumber(p["user"])))
+	return user, err == nil
+}
+
+// VerifyTwoFactor check code, a totp or a recovery code of user, wrong codes are counted by the throttle like wrong passwords,
+// so starting the login again don't give more tries. It return throttle.ErrLocked or throttle.ErrTooSoon with the wait
+func (c *Context) VerifyTwoFactor(user models.User, code string) (time.Duration, error) {
+	keys := []string{throttle.TwoFactorKey(user.Id), throttle.IPKey(c.GetUserIP())}
+	if wait, err := throttle.Begin(keys...); err != nil {
+		return wait, err
+	}
+	defer throttle.End(keys...)
+	if err := totp.Verify(user.Id, code); err != nil {
+		logger.CheckError(throttle.Fail(keys...))
+		return 0, err
+	}
+	logger.CheckError(throttle.Success(keys[0]))
+	return 0, nil
+}
+
+func throttled(err error) bool {
+	return errors.Is(err, throttle.ErrLocked) || errors.Is(err, throttle.ErrTooSoon)
+}
+
+// TwoFactorFailed count a wrong code, and return true when TWO_FACTOR_MAX_TRIES is reached and the login must restart
+func (c *Context) TwoFactorFailed() bool {
+	p, ok := c.twoFactorPending()
+	if !ok {
+		return true
+	}
+	tries := sessionNumber(p["tries"]) + 1
+	if tries >= int64(TWO_FACTOR_MAX_TRIES) {
+		_ = c.Session().Delete(twoFactorKey)
+		return true
+	}
+	np := map[string]any{}
+	for k, v := range p {
+		np[k] = v
+	}
+	np["tries"] = tries
+	_ = c.Session().Set(twoFactorKey, np)
+	return false
+}
+
+// CompleteTwoFactor log the pending user in once their code is checked, and return where to send them
+func (c *Context) CompleteTwoFactor() (string, error) {
+	p, ok := c.twoFactorPending()
+	if !ok {
+		return "", totp.ErrNotEnabled
+	}
+	user, err := userById(int(sessionNumber(p["user"])))
+	if err != nil {
+		return "", err
+	}
+	s := c.Session()
+	if err := s.Delete(twoFactorKey); err != nil {
+		return "", err
+	}
+	if err := c.Login(user); err != nil {
+		return "", err
+	}
+	next, _ := p["next"].(string)
+	return next, nil
+}
@@ -0,0 +1,170 @@
+package kamux
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/utils"
+)
+
+var (
+	// WS_TOKEN_PARAM is the query param read by TokenAuth on websocket upgrades, browsers websockets can't set headers
+	WS_TOKEN_PARAM = "token"
+	// WS_TOKEN_PROTOCOL prefix a websocket subprotocol carrying the token, ex: new WebSocket(url, ["kago", "bearer."+token]),
+	// it's never selected as the connection subprotocol, so browsers clients must offer another one
+	WS_TOKEN_PROTOCOL = "bearer."
+)
+
+// OriginPolicy decide if the Origin of a websocket upgrade request is accepted,
+// requests without Origin don't come from browsers and are accepted, authenticate them using middlewares
+type OriginPolicy func(origin string, r *http.Request) bool
+
+// WsOptions configure a websocket route registered using WSWith
+type WsOptions struct {
+	// Middlewares run on the upgrade request before the handshake, ex: kamux.Auth, kamux.Admin or kamux.TokenAuth(...)
+	Middlewares []func(Handler) Handler
+	// Origin default to AllowOrigins(allowed origines) if given, SameOrigin otherwise
+	Origin OriginPolicy
+}
+
+// SameOrigin accept origins having the same host as the request, or allowed globally using AllowOrigines
+var SameOrigin OriginPolicy = func(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	if err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range Origines {
+		if originMatch(origin, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyOrigin accept all origins, use it only for routes authenticated by token
+var AnyOrigin OriginPolicy = func(string, *http.Request) bool {
+	return true
+}
+
+// AllowOrigins accept origins matching one of patterns: "*", "https://app.example.com", "app.example.com:8080" or "*.example.com"
+func AllowOrigins(patterns ...string) OriginPolicy {
+	return func(origin string, r *http.Request) bool {
+		for _, p := range patterns {
+			if originMatch(origin, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// originMatch check origin against pattern, a pattern with a scheme must match scheme and host, otherwise the host only
+func originMatch(origin, pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "/"))
+	if scheme, rest, ok := strings.Cut(pattern, "://"); ok {
+		if scheme != strings.ToLower(u.Scheme) {
+			return false
+		}
+		pattern = rest
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:]
+		if !strings.Contains(suffix, ":") {
+			host = u.Hostname()
+		}
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	if !strings.Contains(pattern, ":") {
+		host = strings.ToLower(u.Hostname())
+	}
+	return host == pattern
+}
+
+// authorizationToken return the token of the "Authorization: Bearer" header of r
+func authorizationToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+func isWebsocketUpgrade(r *http.Request) bool {
+	for _, h := range r.Header.Values("Upgrade") {
+		if strings.EqualFold(strings.TrimSpace(h), "websocket") {
+			return true
+		}
+	}
+	return false
+}
+
+// BearerToken return the token of r from the Authorization header, or for websocket upgrades only, the WS_TOKEN_PARAM query param
+// or a WS_TOKEN_PROTOCOL subprotocol. Other requests never read it from the url, it end up in logs, referers and history
+func BearerToken(r *http.Request) string {
+	if tok := authorizationToken(r); tok != "" {
+		return tok
+	}
+	if !isWebsocketUpgrade(r) {
+		return ""
+	}
+	if tok := r.URL.Query().Get(WS_TOKEN_PARAM); tok != "" {
+		return tok
+	}
+	for _, protocols := range r.Header.Values("Sec-Websocket-Protocol") {
+		for _, p := range strings.Split(protocols, ",") {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, WS_TOKEN_PROTOCOL) {
+				return p[len(WS_TOKEN_PROTOCOL):]
+			}
+		}
+	}
+	return ""
+}
+
+// TokenAuth authenticate requests using BearerToken and validate, the user is available using c.User() and WsContext.User(),
+// requests without a valid token get 401
+var TokenAuth = func(validate func(token string) (models.User, error)) func(Handler) Handler {
+	const key utils.ContextKey = "user"
+	return func(handler Handler) Handler {
+		return func(c *Context) {
+			token := BearerToken(c.Request)
+			if token == "" {
+				c.SetHeader("WWW-Authenticate", "Bearer")
+				c.Status(http.StatusUnauthorized).Text("Unauthorized")
+				return
+			}
+			user, err := validate(token)
+			if err != nil {
+				c.SetHeader("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.Status(http.StatusUnauthorized).Text("Unauthorized")
+				return
+			}
+			ctx := context.WithValue(c.Request.Context(), key, user)
+			*c = Context{
+				ResponseWriter: c.ResponseWriter,
+				Request:        c.Request.WithContext(ctx),
+				Params:         c.Params,
+			}
+			handler(c)
+		}
+	}
+}
+
+// selectProtocol drop token subprotocols and select the first remaining one
+func selectProtocol(protocols []string) []string {
+	for _, p := range protocols {
+		if !strings.HasPrefix(p, WS_TOKEN_PROTOCOL) {
+			return []string{p}
+		}
+	}
+	return nil
+}
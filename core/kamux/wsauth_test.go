@@ -0,0 +1,149 @@
+package kamux
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/utils/websocket"
+)
+
+func TestOriginMatch(t *testing.T) {
+	tests := []struct {
+		origin, pattern string
+		want            bool
+	}{
+		{"https://app.example.com", "*", true},
+		{"https://app.example.com", "https://app.example.com", true},
+		{"https://app.example.com", "https://app.example.com/", true},
+		{"http://app.example.com", "https://app.example.com", false},
+		{"https://APP.example.com", "app.example.com", true},
+		{"https://app.example.com:8443", "app.example.com", true},
+		{"https://app.example.com:8443", "app.example.com:8080", false},
+		{"https://app.example.com:8080", "app.example.com:8080", true},
+		{"https://a.b.example.com", "*.example.com", true},
+		{"https://example.com", "*.example.com", false},
+		{"https://evilexample.com", "*.example.com", false},
+		{"https://example.com.evil.com", "*.example.com", false},
+		{"https://a.example.com:9000", "*.example.com:8080", false},
+		{"https://a.example.com:8080", "*.example.com:8080", true},
+		{"http://a.example.com", "https://*.example.com", false},
+		{"https://a.example.com", "https://*.example.com", true},
+		{"null", "example.com", false},
+		{"", "example.com", false},
+	}
+	for _, tt := range tests {
+		if got := originMatch(tt.origin, tt.pattern); got != tt.want {
+			t.Errorf("originMatch(%q, %q) = %v, want %v", tt.origin, tt.pattern, got, tt.want)
+		}
+	}
+	policy := AllowOrigins("https://app.example.com", "*.example.org")
+	r := httptest.NewRequest("GET", "/", nil)
+	for origin, want := range map[string]bool{"https://app.example.com": true, "https://x.example.org": true, "https://example.net": false} {
+		if got := policy(origin, r); got != want {
+			t.Errorf("AllowOrigins(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		headers map[string]string
+		want    string
+	}{
+		{"header", "/api", map[string]string{"Authorization": "Bearer abc"}, "abc"},
+		{"header case", "/api", map[string]string{"Authorization": "bearer  abc "}, "abc"},
+		{"basic", "/api", map[string]string{"Authorization": "Basic abc"}, ""},
+		{"query on http", "/api?token=abc", nil, ""},
+		{"protocol on http", "/api", map[string]string{"Sec-Websocket-Protocol": "kago, bearer.abc"}, ""},
+		{"query on upgrade", "/ws?token=abc", map[string]string{"Upgrade": "websocket"}, "abc"},
+		{"protocol on upgrade", "/ws", map[string]string{"Upgrade": "WebSocket", "Sec-Websocket-Protocol": "kago, bearer.abc"}, "abc"},
+		{"header first", "/ws?token=query", map[string]string{"Upgrade": "websocket", "Authorization": "Bearer header"}, "header"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := BearerToken(r); got != tt.want {
+			t.Errorf("%s: BearerToken = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	auth := TokenAuth(func(token string) (models.User, error) {
+		if token != "good" {
+			return models.User{}, errors.New("invalid")
+		}
+		return models.User{Id: 7}, nil
+	})
+	handler := auth(func(c *Context) {
+		user, _ := c.User()
+		c.Text(user.Email)
+	})
+	tests := []struct {
+		name, url, auth string
+		code            int
+		challenge       string
+	}{
+		{"no token", "/api", "", http.StatusUnauthorized, "Bearer"},
+		{"bad token", "/api", "Bearer bad", http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"query ignored", "/api?token=good", "", http.StatusUnauthorized, "Bearer"},
+		{"good token", "/api", "Bearer good", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		handler(&Context{ResponseWriter: w, Request: r, Params: map[string]string{}})
+		if w.Code != tt.code || w.Header().Get("WWW-Authenticate") != tt.challenge {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, w.Code, w.Header().Get("WWW-Authenticate"), tt.code, tt.challenge)
+		}
+	}
+}
+
+func TestSelectProtocol(t *testing.T) {
+	tests := []struct {
+		offered []string
+		want    string
+	}{
+		{nil, ""},
+		{[]string{"bearer.secret"}, ""},
+		{[]string{"bearer.secret", "kago"}, "kago"},
+		{[]string{"kago", "bearer.secret"}, "kago"},
+		{[]string{"chat", "kago"}, "chat"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(selectProtocol(tt.offered), ","); got != tt.want {
+			t.Errorf("selectProtocol(%v) = %q, want %q", tt.offered, got, tt.want)
+		}
+	}
+
+	// the handshake never answer with the token
+	srv := httptest.NewServer(websocket.Server{Handshake: wsHandshake, Handler: func(conn *websocket.Conn) {}})
+	defer srv.Close()
+	for _, tt := range tests[1:3] {
+		r, _ := http.NewRequest("GET", srv.URL, nil)
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Sec-WebSocket-Protocol", strings.Join(tt.offered, ", "))
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if got := res.Header.Get("Sec-WebSocket-Protocol"); res.StatusCode != http.StatusSwitchingProtocols || got != tt.want {
+			t.Errorf("handshake offering %v: %d %q, want %q", tt.offered, res.StatusCode, got, tt.want)
+		}
+	}
+}
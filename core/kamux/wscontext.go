@@ -1,17 +1,32 @@
 package kamux
 
 import (
+	"net/http"
 	"sync"
 
-	"golang.org/x/net/websocket"
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/utils"
+	"github.com/kamalshkeir/kago/core/utils/websocket"
 )
 
-var m sync.RWMutex
-
 type WsContext struct {
-	Ws     *websocket.Conn
-	Params map[string]string
+	Ws *websocket.Conn
+	// Request is the upgrade request, after route middlewares
+	Request *http.Request
+	Params  map[string]string
 	Route
+	client *WsClient
+	events *WsEvents
+	acks   map[uint64]*pendingAck
+	ackSeq uint64
+	ackMu  sync.Mutex
+}
+
+// User return the user authenticated by the route middlewares
+func (c *WsContext) User() (models.User, bool) {
+	const key utils.ContextKey = "user"
+	user, ok := c.Request.Context().Value(key).(models.User)
+	return user, ok
 }
 
 // ReceiveText receive text from ws and disconnect when stop receiving
@@ -35,89 +50,61 @@ func (c *WsContext) ReceiveJson() (map[string]any, error) {
 	return data, nil
 }
 
+// Client return the hub client of the current connection
+func (c *WsContext) Client() *WsClient {
+	return c.client
+}
+
 // Json send json to the client
-func (c *WsContext) Json(data map[string]any) error {
-	err := websocket.JSON.Send(c.Ws, data)
-	if err != nil {
-		return err
-	}
-	return nil
+func (c *WsContext) Json(data any) error {
+	return c.client.Json(data)
 }
 
-// Broadcast send message to all clients in c.Clients
+// Text send text to the client
+func (c *WsContext) Text(data string) error {
+	return c.client.Text(data)
+}
+
+// Broadcast send message to all clients connected to this route
 func (c *WsContext) Broadcast(data any) error {
-	m.RLock()
-	for _, ws := range c.Route.Clients {
-		err := websocket.JSON.Send(ws, data)
-		if err != nil {
-			m.RUnlock()
-			return err
-		}
-	}
-	m.RUnlock()
-	return nil
+	return c.Hub.Broadcast(data)
 }
 
-// BroadcastExceptCaller send message to all clients in c.Clients
-func (c *WsContext) BroadcastExceptCaller(data map[string]any) error {
-	m.RLock()
-	for _, ws := range c.Route.Clients {
-		if ws != c.Ws {
-			err := websocket.JSON.Send(ws, data)
-			if err != nil {
-				m.RUnlock()
-				return err
-			}
-		}
-	}
-	m.RUnlock()
-	return nil
+// BroadcastExceptCaller send message to all clients connected to this route except the current one
+func (c *WsContext) BroadcastExceptCaller(data any) error {
+	return c.Hub.Broadcast(data, c.client)
 }
 
-// Text send text to the client
-func (c *WsContext) Text(data string) error {
-	err := websocket.Message.Send(c.Ws, data)
-	if err != nil {
-		return err
-	}
-	return nil
+// Join add the current client to room
+func (c *WsContext) Join(room string) {
+	c.Hub.Join(c.client, room)
 }
 
-// RemoveRequester remove the client from Clients list in context
-func (c *WsContext) RemoveRequester(name ...string) {
-	m.Lock()
-	for k, ws := range c.Route.Clients {
-		if len(name) > 1 {
-			n := name[0]
-			if conn, ok := c.Route.Clients[n]; ok {
-				delete(c.Route.Clients, n)
-				_ = conn.Close()
-			}
-		} else {
-			if ws == c.Ws {
-				if conn, ok := c.Route.Clients[k]; ok {
-					delete(c.Route.Clients, k)
-					_ = conn.Close()
-				}
-
-			}
-		}
+// Leave remove the current client from room
+func (c *WsContext) Leave(room string) {
+	c.Hub.Leave(c.client, room)
+}
 
+// BroadcastRoom send message to all clients in room
+func (c *WsContext) BroadcastRoom(room string, data any) error {
+	return c.Hub.BroadcastRoom(room, data)
+}
+
+// BroadcastRoomExceptCaller send message to all clients in room except the current one
+func (c *WsContext) BroadcastRoomExceptCaller(room string, data any) error {
+	return c.Hub.BroadcastRoom(room, data, c.client)
+}
+
+// RemoveRequester disconnect the client added with name, or the current client if no name given
+func (c *WsContext) RemoveRequester(name ...string) {
+	if len(name) > 0 && name[0] != "" {
+		c.Hub.Close(name[0])
+		return
 	}
-	m.Unlock()
+	c.client.Close()
 }
 
-// AddClient add client to clients_list
+// AddClient name the current client, so it can be reached using Hub.SendTo(key) or removed using RemoveRequester(key)
 func (c *WsContext) AddClient(key string) {
-	m.Lock()
-	if _, ok := c.Route.Clients[key]; !ok {
-		c.Route.Clients[key] = c.Ws
-	} else {
-		for k, ws := range c.Route.Clients {
-			if ws == c.Ws {
-				c.Route.Clients[k] = c.Ws
-			}
-		}
-	}
-	m.Unlock()
+	c.Hub.setKey(c.client, key)
 }
@@ -0,0 +1,315 @@
+package kamux
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/utils/logger"
+	"github.com/kamalshkeir/kago/core/utils/msgpack"
+	"github.com/kamalshkeir/kago/core/utils/websocket"
+)
+
+var (
+	ErrWsUnknownEvent = errors.New("unknown event")
+	ErrWsAckTimeout   = errors.New("timeout waiting ack")
+)
+
+// WS_ACK_TIMEOUT is how long EmitWithAck wait for the client answer
+var WS_ACK_TIMEOUT = 10 * time.Second
+
+// WsCodec encode and decode events, Binary codecs are sent using binary frames
+type WsCodec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	Binary() bool
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Binary() bool                       { return false }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+func (msgpackCodec) Binary() bool                       { return true }
+
+var (
+	JsonCodec    WsCodec = jsonCodec{}
+	MsgPackCodec WsCodec = msgpackCodec{}
+)
+
+// WsEvent is the envelope of messages handled by WsEvents, Id is set by the side waiting for an ack,
+// acks answer with Ack set to this Id
+type WsEvent struct {
+	Event string `json:"event,omitempty"`
+	Id    uint64 `json:"id,omitempty"`
+	Ack   uint64 `json:"ack,omitempty"`
+	Data  any    `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// eventHandler decode the event data with codec and call the handler registered using On
+type eventHandler func(c *WsContext, codec WsCodec, data any) (any, error)
+
+// WsEvents dispatch events to typed handlers, use its Handler as WsHandler
+type WsEvents struct {
+	Codec        WsCodec
+	handlers     map[string]eventHandler
+	onConnect    func(c *WsContext) error
+	onDisconnect func(c *WsContext)
+	mu           sync.RWMutex
+}
+
+// NewWsEvents create an event router, using JsonCodec if no codec given
+func NewWsEvents(codec ...WsCodec) *WsEvents {
+	ws := &WsEvents{
+		Codec:    JsonCodec,
+		handlers: map[string]eventHandler{},
+	}
+	if len(codec) > 0 && codec[0] != nil {
+		ws.Codec = codec[0]
+	}
+	return ws
+}
+
+// On register fn for event on ws, the event data is decoded into T,
+// the returned value or error is sent back as ack if the client asked for one
+func On[T any](ws *WsEvents, event string, fn func(c *WsContext, payload T) (any, error)) {
+	h := func(c *WsContext, codec WsCodec, data any) (any, error) {
+		var payload T
+		if data != nil {
+			if v, ok := data.(T); ok {
+				payload = v
+			} else {
+				// data was decoded generically with the envelope, re-encode it into T
+				b, err := codec.Marshal(data)
+				if err != nil {
+					return nil, err
+				}
+				if err := codec.Unmarshal(b, &payload); err != nil {
+					return nil, fmt.Errorf("invalid payload: %w", err)
+				}
+			}
+		}
+		return fn(c, payload)
+	}
+	ws.mu.Lock()
+	ws.handlers[event] = h
+	ws.mu.Unlock()
+}
+
+// OnConnect is called before reading events, returning an error close the connection
+func (ws *WsEvents) OnConnect(fn func(c *WsContext) error) {
+	ws.onConnect = fn
+}
+
+// OnDisconnect is called when the client disconnect
+func (ws *WsEvents) OnDisconnect(fn func(c *WsContext)) {
+	ws.onDisconnect = fn
+}
+
+// Handler read events of the client and dispatch them until it disconnect
+func (ws *WsEvents) Handler(c *WsContext) {
+	c.events = ws
+	if ws.onConnect != nil {
+		if err := ws.onConnect(c); err != nil {
+			return
+		}
+	}
+	if ws.onDisconnect != nil {
+		defer ws.onDisconnect(c)
+	}
+	defer c.cancelAcks(ErrWsClosed)
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(c.Ws, &data); err != nil {
+			if err == websocket.ErrFrameTooLarge {
+				continue
+			}
+			return
+		}
+		var e WsEvent
+		if err := ws.Codec.Unmarshal(data, &e); err != nil {
+			continue
+		}
+		if e.Event == "" {
+			// an answer to EmitWithAck, ignored if not waited for anymore
+			if e.Ack != 0 {
+				c.resolveAck(e)
+			}
+			continue
+		}
+		resp, err := ws.dispatch(c, &e)
+		if e.Id == 0 {
+			if err != nil {
+				logger.Error("ws event", e.Event, ":", err)
+			}
+			continue
+		}
+		ack := WsEvent{Ack: e.Id, Data: resp}
+		if err != nil {
+			ack.Data = nil
+			ack.Error = err.Error()
+		}
+		if err := c.sendEvent(ack); err != nil {
+			return
+		}
+	}
+}
+
+func (ws *WsEvents) dispatch(c *WsContext, e *WsEvent) (resp any, err error) {
+	ws.mu.RLock()
+	h, ok := ws.handlers[e.Event]
+	ws.mu.RUnlock()
+	if !ok {
+		return nil, ErrWsUnknownEvent
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("ws event", e.Event, "panic:", r)
+			resp, err = nil, fmt.Errorf("internal error")
+		}
+	}()
+	return h(c, ws.Codec, e.Data)
+}
+
+func (c *WsContext) encodeEvent(e WsEvent) (wsMessage, error) {
+	codec := JsonCodec
+	if c.events != nil {
+		codec = c.events.Codec
+	}
+	b, err := codec.Marshal(e)
+	if err != nil {
+		return wsMessage{}, err
+	}
+	if codec.Binary() {
+		return wsMessage{data: b, typ: websocket.BinaryFrame}, nil
+	}
+	return wsMessage{data: b, typ: websocket.TextFrame}, nil
+}
+
+func (c *WsContext) sendEvent(e WsEvent) error {
+	m, err := c.encodeEvent(e)
+	if err != nil {
+		return err
+	}
+	return c.client.enqueue(m)
+}
+
+// Emit send event to the current client
+func (c *WsContext) Emit(event string, data any) error {
+	return c.sendEvent(WsEvent{Event: event, Data: data})
+}
+
+// EmitAll send event to all clients connected to this route
+func (c *WsContext) EmitAll(event string, data any) error {
+	m, err := c.encodeEvent(WsEvent{Event: event, Data: data})
+	if err != nil {
+		return err
+	}
+	c.Hub.broadcast(m, nil)
+	return nil
+}
+
+// EmitAllExceptCaller send event to all clients connected to this route except the current one
+func (c *WsContext) EmitAllExceptCaller(event string, data any) error {
+	m, err := c.encodeEvent(WsEvent{Event: event, Data: data})
+	if err != nil {
+		return err
+	}
+	c.Hub.broadcast(m, []*WsClient{c.client})
+	return nil
+}
+
+// EmitRoom send event to all clients in room
+func (c *WsContext) EmitRoom(room, event string, data any) error {
+	m, err := c.encodeEvent(WsEvent{Event: event, Data: data})
+	if err != nil {
+		return err
+	}
+	c.Hub.broadcastRoom(room, m, nil)
+	return nil
+}
+
+// EmitRoomExceptCaller send event to all clients in room except the current one
+func (c *WsContext) EmitRoomExceptCaller(room, event string, data any) error {
+	m, err := c.encodeEvent(WsEvent{Event: event, Data: data})
+	if err != nil {
+		return err
+	}
+	c.Hub.broadcastRoom(room, m, []*WsClient{c.client})
+	return nil
+}
+
+type pendingAck struct {
+	fn    func(resp any, err error)
+	timer *time.Timer
+}
+
+// EmitWithAck send event to the current client and call fn with its answer, or with ErrWsAckTimeout after WS_ACK_TIMEOUT,
+// answers are read by WsEvents.Handler, so the route must use it
+func (c *WsContext) EmitWithAck(event string, data any, fn func(resp any, err error)) error {
+	c.ackMu.Lock()
+	if c.acks == nil {
+		c.acks = map[uint64]*pendingAck{}
+	}
+	c.ackSeq++
+	id := c.ackSeq
+	p := &pendingAck{fn: fn}
+	c.acks[id] = p
+	p.timer = time.AfterFunc(WS_ACK_TIMEOUT, func() {
+		if c.takeAck(id) != nil {
+			fn(nil, ErrWsAckTimeout)
+		}
+	})
+	c.ackMu.Unlock()
+	if err := c.sendEvent(WsEvent{Event: event, Id: id, Data: data}); err != nil {
+		if c.takeAck(id) != nil {
+			p.timer.Stop()
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *WsContext) takeAck(id uint64) *pendingAck {
+	c.ackMu.Lock()
+	defer c.ackMu.Unlock()
+	p, ok := c.acks[id]
+	if !ok {
+		return nil
+	}
+	delete(c.acks, id)
+	return p
+}
+
+func (c *WsContext) resolveAck(e WsEvent) {
+	p := c.takeAck(e.Ack)
+	if p == nil {
+		return
+	}
+	p.timer.Stop()
+	if e.Error != "" {
+		p.fn(nil, errors.New(e.Error))
+		return
+	}
+	p.fn(e.Data, nil)
+}
+
+// cancelAcks call the callbacks still waiting with err
+func (c *WsContext) cancelAcks(err error) {
+	c.ackMu.Lock()
+	acks := c.acks
+	c.acks = nil
+	c.ackMu.Unlock()
+	for _, p := range acks {
+		p.timer.Stop()
+		p.fn(nil, err)
+	}
+}
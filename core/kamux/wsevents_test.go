@@ -0,0 +1,78 @@
+package kamux
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWsDispatch(t *testing.T) {
+	type point struct {
+		X int    `json:"x"`
+		Y int    `json:"y"`
+		N string `json:"name"`
+	}
+	for _, codec := range []WsCodec{JsonCodec, MsgPackCodec} {
+		ws := NewWsEvents(codec)
+		On(ws, "point", func(c *WsContext, p point) (any, error) {
+			return p.X + p.Y, nil
+		})
+		On(ws, "text", func(c *WsContext, s string) (any, error) {
+			return s, nil
+		})
+		On(ws, "raw", func(c *WsContext, v any) (any, error) {
+			return v, nil
+		})
+		On(ws, "fail", func(c *WsContext, _ any) (any, error) {
+			return nil, errors.New("refused")
+		})
+		On(ws, "panic", func(c *WsContext, _ any) (any, error) {
+			var m map[string]int
+			m["boom"]++
+			return nil, nil
+		})
+
+		// data as decoded with the envelope
+		decode := func(v any) any {
+			b, err := codec.Marshal(WsEvent{Event: "x", Data: v})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var e WsEvent
+			if err := codec.Unmarshal(b, &e); err != nil {
+				t.Fatal(err)
+			}
+			return e.Data
+		}
+		tests := []struct {
+			event string
+			data  any
+			resp  any
+			err   string
+		}{
+			{"point", decode(map[string]any{"x": 2, "y": 3, "name": "p"}), 5, ""},
+			{"point", nil, 0, ""},
+			{"point", decode("not a point"), nil, "invalid payload"},
+			{"text", decode("hello"), "hello", ""},
+			{"raw", "as is", "as is", ""},
+			{"fail", nil, nil, "refused"},
+			{"panic", nil, nil, "internal error"},
+			{"missing", nil, nil, ErrWsUnknownEvent.Error()},
+		}
+		for _, tt := range tests {
+			resp, err := ws.dispatch(&WsContext{}, &WsEvent{Event: tt.event, Data: tt.data})
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Errorf("%T %s: error %v, want %q", codec, tt.event, err, tt.err)
+				}
+				continue
+			}
+			if err != nil || resp != tt.resp {
+				t.Errorf("%T %s: got %v %v, want %v", codec, tt.event, resp, err, tt.resp)
+			}
+		}
+		if _, err := ws.dispatch(&WsContext{}, &WsEvent{Event: "missing"}); err != ErrWsUnknownEvent {
+			t.Error("unknown event", err)
+		}
+	}
+}
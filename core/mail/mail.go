@@ -0,0 +1,443 @@
+// Package mail build multipart mails, with html and text alternatives, attachments and inline images, rendered from the templates of the app,
+// and send them using TRANSPORT: smtp, the console, files, or the in memory OUTBOX during development.
+// SendAsync queue mails as background jobs, retried on failure
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/jobs"
+	"github.com/kamalshkeir/kago/core/settings"
+)
+
+var (
+	// FROM is the default sender, SMTP_EMAIL if empty
+	FROM = ""
+	// JOB is the name of the background jobs sending the mails of SendAsync
+	JOB = "mail"
+	// MAX_ATTEMPTS to send a mail queued by SendAsync
+	MAX_ATTEMPTS = 5
+)
+
+var (
+	ErrNoRecipient = errors.New("mail has no recipient")
+	ErrNoRender    = errors.New("mail templates need mail.RENDER, set by kamux once the templates are loaded")
+	ErrNoTransport = errors.New("mail: SMTP_HOST is not set, set it or mail.TRANSPORT")
+)
+
+// RENDER execute a template of the app into a string, set by kamux to render Template and TextTemplate
+var RENDER func(name string, data map[string]any) (string, error)
+
+// Attachment is a file attached to a Message, or an inline image when ContentID is set
+type Attachment struct {
+	Filename string `json:"filename"`
+	// ContentType is guessed from the extension of Filename if empty
+	ContentType string `json:"content_type,omitempty"`
+	Data        []byte `json:"data"`
+	// ContentID make the attachment an inline image, shown by <img src="cid:ContentID">
+	ContentID string `json:"content_id,omitempty"`
+}
+
+// Message is a mail, Bcc recipients receive it without being listed in the headers
+type Message struct {
+	From    string   `json:"from,omitempty"`
+	To      []string `json:"to,omitempty"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	ReplyTo string   `json:"reply_to,omitempty"`
+	Subject string   `json:"subject,omitempty"`
+	// HTML and Text are sent as alternatives when both are set
+	HTML string `json:"html,omitempty"`
+	Text string `json:"text,omitempty"`
+	// Template and TextTemplate render HTML and Text from the templates of the app, using Data
+	Template     string            `json:"template,omitempty"`
+	TextTemplate string            `json:"text_template,omitempty"`
+	Data         map[string]any    `json:"-"`
+	Attachments  []Attachment      `json:"attachments,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+}
+
+// New return a message to to with subject
+func New(subject string, to ...string) *Message {
+	return &Message{Subject: subject, To: to}
+}
+
+// Attach data as filename
+func (m *Message) Attach(filename string, data []byte) *Message {
+	m.Attachments = append(m.Attachments, Attachment{Filename: filename, Data: data})
+	return m
+}
+
+// AttachFile attach the file at path
+func (m *Message) AttachFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m.Attach(filepath.Base(path), data)
+	return nil
+}
+
+// Embed add the image data, shown in the html by <img src="cid:contentID">
+func (m *Message) Embed(contentID, filename string, data []byte) *Message {
+	m.Attachments = append(m.Attachments, Attachment{Filename: filename, Data: data, ContentID: contentID})
+	return m
+}
+
+// Render execute Template and TextTemplate into HTML and Text, then clear them
+func (m *Message) Render() error {
+	if m.Template == "" && m.TextTemplate == "" {
+		return nil
+	}
+	if RENDER == nil {
+		return ErrNoRender
+	}
+	if m.Template != "" {
+		html, err := RENDER(m.Template, m.Data)
+		if err != nil {
+			return err
+		}
+		m.HTML, m.Template = html, ""
+	}
+	if m.TextTemplate != "" {
+		text, err := RENDER(m.TextTemplate, m.Data)
+		if err != nil {
+			return err
+		}
+		m.Text, m.TextTemplate = text, ""
+	}
+	return nil
+}
+
+// Recipients return To, Cc and Bcc addresses, without names
+func (m *Message) Recipients() ([]string, error) {
+	res := []string{}
+	for _, list := range [][]string{m.To, m.Cc, m.Bcc} {
+		for _, a := range list {
+			addr, err := netmail.ParseAddress(a)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", a, err)
+			}
+			res = append(res, addr.Address)
+		}
+	}
+	if len(res) == 0 {
+		return nil, ErrNoRecipient
+	}
+	return res, nil
+}
+
+func (m *Message) sender() string {
+	if m.From != "" {
+		return m.From
+	}
+	if FROM != "" {
+		return FROM
+	}
+	return settings.Config.Smtp.Email
+}
+
+// Bytes return the message encoded as MIME, ready to be sent
+func (m *Message) Bytes() ([]byte, error) {
+	from, err := netmail.ParseAddress(m.sender())
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", m.sender(), err)
+	}
+	if _, err := m.Recipients(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	h := textproto.MIMEHeader{}
+	h.Set("From", from.String())
+	if err := setAddresses(h, "To", m.To); err != nil {
+		return nil, err
+	}
+	if err := setAddresses(h, "Cc", m.Cc); err != nil {
+		return nil, err
+	}
+	if m.ReplyTo != "" {
+		if err := setAddresses(h, "Reply-To", []string{m.ReplyTo}); err != nil {
+			return nil, err
+		}
+	}
+	h.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	h.Set("Date", time.Now().Format(time.RFC1123Z))
+	h.Set("Message-ID", "<"+randomHex(16)+"@"+domain(from.Address)+">")
+	h.Set("MIME-Version", "1.0")
+	for k, v := range m.Headers {
+		h.Set(k, v)
+	}
+	for k, values := range h {
+		for _, v := range values {
+			if strings.ContainsAny(k+v, "\r\n") {
+				return nil, fmt.Errorf("invalid header %s", k)
+			}
+		}
+	}
+
+	var inline, attached []Attachment
+	for _, a := range m.Attachments {
+		if a.ContentID != "" {
+			inline = append(inline, a)
+		} else {
+			attached = append(attached, a)
+		}
+	}
+	p := &part{w: &buf, header: h}
+	if len(attached) == 0 {
+		if err := m.writeBody(p, inline); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	mixed, err := p.multipart("mixed")
+	if err != nil {
+		return nil, err
+	}
+	if err := m.writeBody(&part{parent: mixed}, inline); err != nil {
+		return nil, err
+	}
+	for _, a := range attached {
+		if err := writeAttachment(mixed, a); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// part create an entity, the body of the message using its header when parent is nil, or a part of parent
+type part struct {
+	w      io.Writer
+	header textproto.MIMEHeader
+	parent *multipart.Writer
+}
+
+func (p *part) create(h textproto.MIMEHeader) (io.Writer, error) {
+	if p.parent != nil {
+		return p.parent.CreatePart(h)
+	}
+	for k, v := range h {
+		p.header[k] = v
+	}
+	writeHeader(p.w, p.header)
+	return p.w, nil
+}
+
+// multipart create a multipart entity of kind mixed, related or alternative
+func (p *part) multipart(kind string) (*multipart.Writer, error) {
+	boundary := randomHex(16)
+	w, err := p.create(textproto.MIMEHeader{"Content-Type": {"multipart/" + kind + "; boundary=" + boundary}})
+	if err != nil {
+		return nil, err
+	}
+	mw := multipart.NewWriter(w)
+	return mw, mw.SetBoundary(boundary)
+}
+
+// writeBody write the html and text alternatives, related to the inline images
+func (m *Message) writeBody(p *part, inline []Attachment) error {
+	if len(inline) == 0 {
+		return m.writeAlternatives(p)
+	}
+	related, err := p.multipart("related")
+	if err != nil {
+		return err
+	}
+	if err := m.writeAlternatives(&part{parent: related}); err != nil {
+		return err
+	}
+	for _, a := range inline {
+		if err := writeAttachment(related, a); err != nil {
+			return err
+		}
+	}
+	return related.Close()
+}
+
+func (m *Message) writeAlternatives(p *part) error {
+	if m.HTML == "" || m.Text == "" {
+		if m.HTML != "" {
+			return writeText(p, "text/html", m.HTML)
+		}
+		return writeText(p, "text/plain", m.Text)
+	}
+	alt, err := p.multipart("alternative")
+	if err != nil {
+		return err
+	}
+	// the preferred alternative come last
+	if err := writeText(&part{parent: alt}, "text/plain", m.Text); err != nil {
+		return err
+	}
+	if err := writeText(&part{parent: alt}, "text/html", m.HTML); err != nil {
+		return err
+	}
+	return alt.Close()
+}
+
+func writeText(p *part, contentType, text string) error {
+	w, err := p.create(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qp, text); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeAttachment(mw *multipart.Writer, a Attachment) error {
+	ct := a.ContentType
+	if ct == "" {
+		ct = mime.TypeByExtension(filepath.Ext(a.Filename))
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", ct)
+	h.Set("Content-Transfer-Encoding", "base64")
+	disposition := "attachment"
+	if a.ContentID != "" {
+		h.Set("Content-ID", "<"+a.ContentID+">")
+		disposition = "inline"
+	}
+	// quoted and escaped, or encoded as filename* (RFC 2231) when not ascii
+	if v := mime.FormatMediaType(disposition, map[string]string{"filename": filepath.Base(a.Filename)}); v != "" {
+		disposition = v
+	}
+	h.Set("Content-Disposition", disposition)
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	enc := base64.StdEncoding.EncodeToString(a.Data)
+	for len(enc) > 76 {
+		if _, err := io.WriteString(w, enc[:76]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err = io.WriteString(w, enc+"\r\n")
+	return err
+}
+
+func setAddresses(h textproto.MIMEHeader, key string, list []string) error {
+	if len(list) == 0 {
+		return nil
+	}
+	formatted := make([]string, 0, len(list))
+	for _, a := range list {
+		addr, err := netmail.ParseAddress(a)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %w", a, err)
+		}
+		formatted = append(formatted, addr.String())
+	}
+	h.Set(key, strings.Join(formatted, ", "))
+	return nil
+}
+
+func writeHeader(w io.Writer, h textproto.MIMEHeader) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+	}
+	_, _ = io.WriteString(w, "\r\n")
+}
+
+func domain(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return address[i+1:]
+	}
+	return "localhost"
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Send render m and send it using TRANSPORT
+func Send(m *Message) error {
+	if err := m.Render(); err != nil {
+		return err
+	}
+	if m.From == "" {
+		m.From = m.sender()
+	}
+	t, err := transport()
+	if err != nil {
+		return err
+	}
+	return t.Send(m)
+}
+
+// SendAsync render m now and queue it, to be sent by the jobs workers, retried up to MAX_ATTEMPTS times
+func SendAsync(m *Message) (int, error) {
+	if err := m.Render(); err != nil {
+		return 0, err
+	}
+	if m.From == "" {
+		m.From = m.sender()
+	}
+	if _, err := m.Recipients(); err != nil {
+		return 0, err
+	}
+	return jobs.Enqueue(JOB, *m, jobs.Options{MaxAttempts: MAX_ATTEMPTS})
+}
+
+func init() {
+	jobs.Register(JOB, func(ctx context.Context, m Message) error {
+		err := Send(&m)
+		if permanent(err) {
+			return jobs.Permanent(err)
+		}
+		return err
+	})
+}
+
+// permanent report if retrying err is useless, like for invalid addresses or 5xx smtp replies
+func permanent(err error) bool {
+	if err == nil {
+		return false
+	}
+	var perr *textproto.Error
+	if errors.As(err, &perr) {
+		return perr.Code >= 500
+	}
+	return errors.Is(err, ErrNoRecipient) || strings.HasPrefix(err.Error(), "invalid ")
+}
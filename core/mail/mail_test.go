@@ -0,0 +1,126 @@
+package mail
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/jobs"
+	"github.com/kamalshkeir/kago/core/settings"
+)
+
+func TestBytes(t *testing.T) {
+	m := New("Héllo", "Bob <bob@example.com>")
+	m.From = "app@example.com"
+	m.Cc = []string{"carol@example.com"}
+	m.Bcc = []string{"secret@example.com"}
+	m.ReplyTo = "support@example.com"
+	m.Text = "hello"
+	m.HTML = `<p>hello</p><img src="cid:logo">`
+	m.Embed("logo", "logo.png", []byte("png")).Attach(`facture "été".pdf`, []byte("pdf"))
+
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := netmail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); subject != "Héllo" {
+		t.Fatal("bad subject", subject)
+	}
+	if msg.Header.Get("Bcc") != "" || strings.Contains(string(raw), "secret@") {
+		t.Fatal("bcc leaked")
+	}
+	if rcpt, _ := m.Recipients(); len(rcpt) != 3 || rcpt[0] != "bob@example.com" {
+		t.Fatal("bad recipients", rcpt)
+	}
+
+	// mixed(related(alternative(text, html), logo), invoice)
+	types, names := []string{}, []string{}
+	var walk func(r io.Reader, contentType string)
+	walk = func(r io.Reader, contentType string) {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, mediaType)
+		if !strings.HasPrefix(mediaType, "multipart/") {
+			return
+		}
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name := p.FileName(); name != "" {
+				names = append(names, name)
+			}
+			walk(p, p.Header.Get("Content-Type"))
+		}
+	}
+	walk(msg.Body, msg.Header.Get("Content-Type"))
+	want := "multipart/mixed multipart/related multipart/alternative text/plain text/html image/png application/pdf"
+	if got := strings.Join(types, " "); got != want {
+		t.Fatal("bad structure\n got:", got, "\nwant:", want)
+	}
+	if got := strings.Join(names, ","); got != `logo.png,facture "été".pdf` {
+		t.Fatal("bad filenames", got)
+	}
+
+	if _, err := (&Message{From: "app@example.com"}).Bytes(); !errors.Is(err, ErrNoRecipient) {
+		t.Fatal("expected no recipient, got", err)
+	}
+	m.Headers = map[string]string{"X-Test": "a\r\nBcc: evil@example.com"}
+	if _, err := m.Bytes(); err == nil {
+		t.Fatal("header injection")
+	}
+}
+
+func TestSendAsync(t *testing.T) {
+	box := &Outbox{}
+	TRANSPORT = box
+	defer func() { TRANSPORT = nil }()
+	jobs.STORE = jobs.NewMemory()
+	jobs.POLL_EVERY = 10 * time.Millisecond
+	RENDER = func(name string, data map[string]any) (string, error) {
+		return "<b>" + data["name"].(string) + "</b>", nil
+	}
+
+	if _, err := SendAsync(&Message{From: "app@example.com", To: []string{"bob@example.com"}, Subject: "hi", Template: "hi.html", Data: map[string]any{"name": "Bob"}}); err != nil {
+		t.Fatal(err)
+	}
+	jobs.Start(1)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(box.Messages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	jobs.Stop()
+	sent := box.Messages()
+	if len(sent) != 1 || sent[0].Message.HTML != "<b>Bob</b>" {
+		t.Fatal("mail not sent", sent)
+	}
+}
+
+func TestNoTransport(t *testing.T) {
+	if settings.Config.Smtp.Host != "" {
+		t.Skip("SMTP_HOST is set")
+	}
+	err := Send(&Message{From: "app@example.com", To: []string{"bob@example.com"}, Subject: "reset", Text: "link"})
+	if !errors.Is(err, ErrNoTransport) {
+		t.Fatal("mail without transport not refused:", err)
+	}
+	if len(OUTBOX.Messages()) != 0 {
+		t.Fatal("mail kept in the outbox without opting in")
+	}
+}
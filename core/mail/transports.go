@@ -0,0 +1,284 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/settings"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+// Transport deliver messages
+type Transport interface {
+	Send(m *Message) error
+}
+
+// TRANSPORT send the mails, a SMTP transport using the SMTP_ settings when nil. Without SMTP_HOST, Send return ErrNoTransport,
+// set mail.TRANSPORT = mail.OUTBOX during development to read them at /admin/outbox
+var TRANSPORT Transport
+
+var (
+	defaultTransport Transport
+	transportMu      sync.Mutex
+)
+
+func transport() (Transport, error) {
+	if TRANSPORT != nil {
+		return TRANSPORT, nil
+	}
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	if defaultTransport == nil {
+		if settings.Config.Smtp.Host == "" {
+			return nil, ErrNoTransport
+		}
+		defaultTransport = NewSMTP()
+	}
+	return defaultTransport, nil
+}
+
+// TLS mode of a SMTP transport
+const (
+	// STARTTLS upgrade the connection when the server support it, it is required unless the server is local
+	STARTTLS = "starttls"
+	// IMPLICIT_TLS connect using tls, usually on port 465
+	IMPLICIT_TLS = "tls"
+	// NO_TLS never upgrade the connection, for local test servers
+	NO_TLS = "none"
+)
+
+// SMTP send mails to a smtp server
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// TLS is STARTTLS, IMPLICIT_TLS or NO_TLS, STARTTLS by default, IMPLICIT_TLS on port 465
+	TLS       string
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+}
+
+// NewSMTP return a transport using SMTP_HOST, SMTP_PORT, SMTP_EMAIL and SMTP_PASS
+func NewSMTP() *SMTP {
+	port, err := strconv.Atoi(settings.Config.Smtp.Port)
+	if err != nil || port == 0 {
+		port = 587
+	}
+	return &SMTP{
+		Host:     settings.Config.Smtp.Host,
+		Port:     port,
+		Username: settings.Config.Smtp.Email,
+		Password: settings.Config.Smtp.Pass,
+	}
+}
+
+func (s *SMTP) Send(m *Message) error {
+	raw, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	to, err := m.Recipients()
+	if err != nil {
+		return err
+	}
+	from, err := senderAddress(m)
+	if err != nil {
+		return err
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	mode := s.TLS
+	if mode == "" {
+		mode = STARTTLS
+		if s.Port == 465 {
+			mode = IMPLICIT_TLS
+		}
+	}
+	tlsConfig := s.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: s.Host}
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if mode == IMPLICIT_TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if mode == STARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if !isLocal(s.Host) {
+			return errors.New("smtp server " + s.Host + " does not support STARTTLS")
+		}
+	}
+	if s.Username != "" {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func isLocal(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Console print the mails to Writer, os.Stdout if nil
+type Console struct {
+	Writer io.Writer
+}
+
+func (c Console) Send(m *Message) error {
+	raw, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	w := c.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	_, err = fmt.Fprintf(w, "---------- mail ----------\r\n%s\r\n--------------------------\r\n", raw)
+	return err
+}
+
+// File write each mail to Dir as a .eml file, opened by mail clients
+type File struct {
+	Dir string
+}
+
+func (f File) Send(m *Message) error {
+	raw, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+	name := time.Now().Format("20060102-150405") + "-" + randomHex(4) + ".eml"
+	return os.WriteFile(filepath.Join(f.Dir, name), raw, 0o644)
+}
+
+// MAX_OUTBOX is the number of mails kept by the OUTBOX, the oldest are dropped
+var MAX_OUTBOX = 100
+
+// Sent is a mail kept by an Outbox
+type Sent struct {
+	Id      int
+	At      time.Time
+	Message Message
+	Raw     []byte
+}
+
+// Outbox keep the last mails in memory, to be read at /admin/outbox during development
+type Outbox struct {
+	sent   []Sent
+	lastId int
+	mu     sync.Mutex
+}
+
+// OUTBOX keep the mails in memory instead of sending them, for development: mail.TRANSPORT = mail.OUTBOX
+var OUTBOX = &Outbox{}
+
+func (o *Outbox) Send(m *Message) error {
+	raw, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lastId++
+	o.sent = append(o.sent, Sent{Id: o.lastId, At: time.Now(), Message: *m, Raw: raw})
+	if len(o.sent) > MAX_OUTBOX {
+		o.sent = o.sent[len(o.sent)-MAX_OUTBOX:]
+	}
+	logger.Info("mail to", m.To, ":", m.Subject, "kept in the outbox, read it at /admin/outbox")
+	return nil
+}
+
+// Messages return the mails kept, the last first
+func (o *Outbox) Messages() []Sent {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	res := make([]Sent, 0, len(o.sent))
+	for i := len(o.sent) - 1; i >= 0; i-- {
+		res = append(res, o.sent[i])
+	}
+	return res
+}
+
+// Get return the mail id
+func (o *Outbox) Get(id int) (Sent, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, s := range o.sent {
+		if s.Id == id {
+			return s, true
+		}
+	}
+	return Sent{}, false
+}
+
+func (o *Outbox) Clear() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent = nil
+}
+
+func senderAddress(m *Message) (string, error) {
+	addr, err := netmail.ParseAddress(m.sender())
+	if err != nil {
+		return "", fmt.Errorf("invalid sender %q: %w", m.sender(), err)
+	}
+	return addr.Address, nil
+}
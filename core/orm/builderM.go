@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/kamalshkeir/kago/core/settings"
+	"github.com/kamalshkeir/kago/core/utils"
 	"github.com/kamalshkeir/kago/core/utils/eventbus"
 	"github.com/kamalshkeir/kago/core/utils/logger"
 	"github.com/kamalshkeir/kstrct"
@@ -372,6 +373,7 @@ func (b *BuilderM) Insert(fields_comma_separated string, fields_values []any) (i
 		}
 		return affectedRows, err
 	}
+	publishWrite("create", b.tableName, b.database)
 	rows, err := res.RowsAffected()
 	if err != nil {
 		return int(rows), err
@@ -420,6 +422,7 @@ func (b *BuilderM) Set(query string, args ...any) (int, error) {
 		}
 		return 0, err
 	}
+	publishWrite("update", b.tableName, b.database)
 	aff, err := res.RowsAffected()
 	if err != nil {
 		return 0, err
@@ -465,6 +468,7 @@ func (b *BuilderM) Delete() (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	publishWrite("delete", b.tableName, b.database)
 	affectedRows, err := res.RowsAffected()
 	if err != nil {
 		return int(affectedRows), err
@@ -498,6 +502,7 @@ func (b *BuilderM) Drop() (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	publishWrite("drop", b.tableName, b.database)
 	aff, err := res.RowsAffected()
 	if err != nil {
 		return int(aff), err
@@ -615,14 +620,177 @@ func Query(dbName string, statement string, args ...any) ([]map[string]interface
 	return listMap, nil
 }
 
+// QueryEach run statement and call fn with each row, without holding the result in memory, it stop at the first error returned by fn
+func QueryEach(dbName string, statement string, fn func(row map[string]any) error, args ...any) error {
+	if dbName == "" {
+		dbName = settings.Config.Db.Name
+	}
+	db, err := GetMemoryDatabase(dbName)
+	if logger.CheckError(err) {
+		return err
+	}
+	adaptPlaceholdersToDialect(&statement, db.Dialect)
+
+	rows, err := db.Conn.Query(statement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	models := make([]interface{}, len(columns))
+	modelsPtrs := make([]interface{}, len(columns))
+	for rows.Next() {
+		for i := range models {
+			models[i] = &modelsPtrs[i]
+		}
+		if err := rows.Scan(models...); err != nil {
+			return err
+		}
+		m := make(map[string]any, len(columns))
+		for i := range columns {
+			if v, ok := modelsPtrs[i].([]byte); ok {
+				modelsPtrs[i] = string(v)
+			}
+			m[columns[i]] = modelsPtrs[i]
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Exec run query on dbName, an insert, update, delete, drop or truncate of a table is published on WRITE_TOPIC
 func Exec(dbName, query string, args ...any) error {
 	_, err := GetConnection(dbName).Exec(query, args...)
 	if logger.CheckError(err) {
 		return err
 	}
+	if typ, table, ok := writtenTable(query); ok {
+		if dbName == "" {
+			dbName = settings.Config.Db.Name
+		}
+		publishWrite(typ, table, dbName)
+	}
 	return nil
 }
 
+// ExecResult run statement on dbName, the default database if empty, adapting its ? placeholders to the dialect.
+// It skip the orm cache, for stores writing on each request, and publish the write on WRITE_TOPIC like Exec
+func ExecResult(dbName, statement string, args ...any) (sql.Result, error) {
+	if dbName == "" {
+		dbName = settings.Config.Db.Name
+	}
+	db, err := GetMemoryDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+	res, err := db.Conn.Exec(Rebind(db, statement), args...)
+	if err != nil {
+		return nil, err
+	}
+	if typ, table, ok := writtenTable(statement); ok {
+		publishWrite(typ, table, dbName)
+	}
+	return res, nil
+}
+
+// ExecAffected run statement like ExecResult and return the number of rows affected
+func ExecAffected(dbName, statement string, args ...any) (int64, error) {
+	res, err := ExecResult(dbName, statement, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Rebind adapt the ? placeholders of statement to the dialect of db, for statements run on db.Conn or in a transaction
+func Rebind(db *DatabaseEntity, statement string) string {
+	adaptPlaceholdersToDialect(&statement, db.Dialect)
+	return statement
+}
+
+// ToInt64 convert a value read from a row, integer, float, string or []byte, to int64
+func ToInt64(v any) int64 {
+	switch t := v.(type) {
+	case int64:
+		return t
+	case int32:
+		return int64(t)
+	case int:
+		return int64(t)
+	case float64:
+		return int64(t)
+	case string:
+		n, _ := strconv.ParseInt(t, 10, 64)
+		return n
+	case []byte:
+		n, _ := strconv.ParseInt(string(t), 10, 64)
+		return n
+	}
+	return 0
+}
+
+// writtenTable return the write type and the table of a statement starting with INSERT [OR x] INTO, REPLACE INTO,
+// UPDATE [OR x], DELETE FROM, DROP TABLE [IF EXISTS] or TRUNCATE [TABLE]
+func writtenTable(query string) (typ, table string, ok bool) {
+	words := strings.Fields(strings.ToLower(query))
+	next := func(skip ...string) bool {
+		if len(words) > 0 && utils.SliceContains(skip, words[0]) {
+			words = words[1:]
+			return true
+		}
+		return false
+	}
+	if len(words) == 0 {
+		return "", "", false
+	}
+	first := words[0]
+	words = words[1:]
+	switch first {
+	case "insert", "replace":
+		typ = "create"
+		if next("or") {
+			next("replace", "ignore", "abort", "fail", "rollback")
+		}
+		if !next("into") {
+			return "", "", false
+		}
+	case "update":
+		typ = "update"
+		if next("or") {
+			next("replace", "ignore", "abort", "fail", "rollback")
+		}
+	case "delete":
+		typ = "delete"
+		if !next("from") {
+			return "", "", false
+		}
+	case "truncate":
+		typ = "delete"
+		next("table")
+	case "drop":
+		typ = "drop"
+		if !next("table") {
+			return "", "", false
+		}
+		if next("if") {
+			next("exists")
+		}
+	default:
+		return "", "", false
+	}
+	if len(words) == 0 {
+		return "", "", false
+	}
+	table = strings.Trim(strings.SplitN(strings.TrimRight(words[0], ";"), "(", 2)[0], "`\"[]")
+	return typ, table, table != ""
+}
+
 func (b *BuilderM) AddRelated(relatedTable string, whereRelatedTable string, whereRelatedArgs ...any) (int, error) {
 	if b.tableName == "" {
 		return 0, errors.New("unable to find model, try korm.AutoMigrate before")
@@ -164,6 +164,7 @@ func (b *Builder[T]) Insert(model *T) (int, error) {
 		}
 		return affectedRows, err
 	}
+	publishWrite("create", b.tableName, b.database)
 	rows, err := res.RowsAffected()
 	if err != nil {
 		return int(rows), err
@@ -218,6 +219,7 @@ func (b *Builder[T]) Set(query string, args ...any) (int, error) {
 		}
 		return 0, err
 	}
+	publishWrite("update", b.tableName, b.database)
 	aff, err := res.RowsAffected()
 	if err != nil {
 		return 0, err
@@ -268,6 +270,7 @@ func (b *Builder[T]) Delete() (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	publishWrite("delete", b.tableName, b.database)
 	affectedRows, err := res.RowsAffected()
 	if err != nil {
 		return int(affectedRows), err
@@ -306,6 +309,7 @@ func (b *Builder[T]) Drop() (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	publishWrite("drop", b.tableName, b.database)
 	aff, err := res.RowsAffected()
 	if err != nil {
 		return int(aff), err
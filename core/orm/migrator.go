@@ -20,6 +20,82 @@ func Migrate() error {
 	if logger.CheckError(err) {
 		return err
 	}
+	err = AutoMigrate[models.Session]("sessions", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.RevokedToken]("revoked_tokens", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.APIKey]("api_keys", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.Identity]("identities", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.UserToken]("user_tokens", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.VerifiedEmail]("verified_emails", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.TwoFactor]("two_factors", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.RecoveryCode]("recovery_codes", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.LoginLock]("login_locks", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.RateLimit]("rate_limits", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.Job]("jobs", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.CronJob]("cron_jobs", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.CronRun]("cron_runs", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.Group]("auth_groups", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.Permission]("auth_permissions", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.GroupPermission]("auth_group_permissions", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.UserGroup]("auth_user_groups", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = AutoMigrate[models.UserPermission]("auth_user_permissions", settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
+	err = SyncPermissions(settings.Config.Db.Name)
+	if logger.CheckError(err) {
+		return err
+	}
 	return nil
 }
 
@@ -359,6 +435,18 @@ func autoMigrate[T comparable](db *DatabaseEntity, tableName string, execute boo
 }
 
 func AutoMigrate[T comparable](tableName string, dbName ...string) error {
+	err := autoMigrateModel[T](tableName, dbName...)
+	if err == nil {
+		dbname := settings.Config.Db.Name
+		if len(dbName) == 1 {
+			dbname = dbName[0]
+		}
+		logger.CheckError(syncTablePermissions(dbname, tableName))
+	}
+	return err
+}
+
+func autoMigrateModel[T comparable](tableName string, dbName ...string) error {
 	if _, ok := mModelTablename[*new(T)]; !ok {
 		mModelTablename[*new(T)] = tableName
 	}
@@ -35,6 +35,9 @@ var (
 const (
 	MIGRATION_FOLDER = "migrations"
 	CACHE_TOPIC      = "internale-db-cache"
+	// WRITE_TOPIC receive map[string]string{"type","table","database"} after each insert, update, delete or drop done by the builders
+	// or orm.Exec, ExecResult and ExecAffected, statements run directly on GetConnection() or in transactions are not published
+	WRITE_TOPIC = "orm-write"
 )
 const (
 	SQLITE    = "sqlite"
@@ -149,6 +152,15 @@ func DisableCache() {
 	UseCache = false
 }
 
+// publishWrite tell WRITE_TOPIC subscribers, like the http cache, that table was written
+func publishWrite(typ, table, database string) {
+	eventbus.Publish(WRITE_TOPIC, map[string]string{
+		"type":     typ,
+		"table":    table,
+		"database": database,
+	})
+}
+
 func DisableCheck() {
 	migrationAutoCheck = false
 }
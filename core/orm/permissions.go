@@ -0,0 +1,81 @@
+package orm
+
+import (
+	"github.com/kamalshkeir/kago/core/settings"
+)
+
+// PERMISSION_ACTIONS are the permissions created for each table, as table.action
+var PERMISSION_ACTIONS = []string{"view", "add", "change", "delete", "drop"}
+
+const permissionsTable = "auth_permissions"
+
+// SyncPermissions create the missing permissions of all the tables of dbName, called by Migrate
+func SyncPermissions(dbName ...string) error {
+	name := settings.Config.Db.Name
+	if len(dbName) > 0 {
+		name = dbName[0]
+	}
+	tables, err := GetMemoryTables(name)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if err := syncTablePermissions(name, t.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncTablePermissions create the missing permissions of table, once the permissions table is migrated
+func syncTablePermissions(dbName, table string) error {
+	if _, err := GetMemoryTable(permissionsTable, dbName); err != nil {
+		return nil
+	}
+	existing := map[string]bool{}
+	rows, err := Query(dbName, "SELECT codename FROM "+permissionsTable+" WHERE table_name = ?", table)
+	if err != nil && err.Error() != "no data found" {
+		return err
+	}
+	for _, r := range rows {
+		switch v := r["codename"].(type) {
+		case string:
+			existing[v] = true
+		case []byte:
+			existing[string(v)] = true
+		}
+	}
+	for _, action := range PERMISSION_ACTIONS {
+		codename := table + "." + action
+		if existing[codename] {
+			continue
+		}
+		_, err := Table(permissionsTable).Database(dbName).Insert("codename,table_name,action", []any{codename, table, action})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteTablePermissions delete the permissions of a dropped table, and their grants
+func DeleteTablePermissions(table string, dbName ...string) error {
+	name := settings.Config.Db.Name
+	if len(dbName) > 0 {
+		name = dbName[0]
+	}
+	if _, err := GetMemoryTable(permissionsTable, name); err != nil {
+		return nil
+	}
+	for _, grants := range []string{"auth_group_permissions", "auth_user_permissions"} {
+		_, err := Table(grants).Database(name).Where("permission_id IN (SELECT id FROM "+permissionsTable+" WHERE table_name = ?)", table).Delete()
+		if err != nil && err.Error() != "no data found" {
+			return err
+		}
+	}
+	_, err := Table(permissionsTable).Database(name).Where("table_name = ?", table).Delete()
+	if err != nil && err.Error() != "no data found" {
+		return err
+	}
+	return nil
+}
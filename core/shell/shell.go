@@ -10,7 +10,12 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/kamalshkeir/kago/core/admin/models"
+	"github.com/kamalshkeir/kago/core/cron"
+	"github.com/kamalshkeir/kago/core/kamux/throttle"
+	"github.com/kamalshkeir/kago/core/kamux/totp"
 	"github.com/kamalshkeir/kago/core/orm"
 	"github.com/kamalshkeir/kago/core/settings"
 	"github.com/kamalshkeir/kago/core/utils"
@@ -19,7 +24,7 @@ import (
 )
 
 const helpS string = `Commands :  
-[databases, use, tables, columns, migrate, createsuperuser, createuser, getall, get, drop, delete, clear/cls, q/quit/exit, help/commands]
+[databases, use, tables, columns, migrate, createsuperuser, createuser, reset2fa, locks, unlock, crons, cronrun, getall, get, drop, delete, clear/cls, q/quit/exit, help/commands]
   'databases':
 	  list all connected databases
 
@@ -41,6 +46,21 @@ const helpS string = `Commands :
   'createuser':
 	  create a regular user
 
+  'reset2fa':
+	  disable two factor authentication of a user, deleting its secret and recovery codes
+
+  'locks':
+	  list the accounts and ips locked after too many failed logins
+
+  'unlock':
+	  unlock an account given its email, or an ip given as ip:addr
+
+  'crons':
+	  list the cron jobs, their schedule, next run and last runs
+
+  'cronrun':
+	  run a cron job now, on a running instance of the app
+
   'getall':
 	  get all rows given a table name
 
@@ -57,7 +77,7 @@ const helpS string = `Commands :
 	  clear console
 `
 
-const commandsS string = "Commands :  [databases, use, tables, columns, migrate, createsuperuser, createuser, getall, get, drop, delete, clear/cls, q!/quit/exit, help/commands]"
+const commandsS string = "Commands :  [databases, use, tables, columns, migrate, createsuperuser, createuser, reset2fa, locks, unlock, crons, cronrun, getall, get, drop, delete, clear/cls, q!/quit/exit, help/commands]"
 
 // InitShell init the shell and return true if used to stop main
 func InitShell() bool {
@@ -113,7 +133,7 @@ func InitShell() bool {
 				return true
 			case "clear", "cls":
 				input.Clear()
-				fmt.Printf(logger.Yellow, "Commands :  [migrate, createsuperuser, createuser, getall, get, drop, delete, clear/cls, quit/exit, help/commands]")
+				fmt.Printf(logger.Yellow, "Commands :  [migrate, createsuperuser, createuser, reset2fa, locks, unlock, crons, cronrun, getall, get, drop, delete, clear/cls, quit/exit, help/commands]")
 			case "help":
 				fmt.Printf(logger.Yellow, helpS)
 			case "commands":
@@ -164,6 +184,16 @@ func InitShell() bool {
 				dropTable()
 			case "delete":
 				deleteRow()
+			case "reset2fa":
+				resetTwoFactor()
+			case "locks":
+				listLocks()
+			case "unlock":
+				unlock()
+			case "crons":
+				listCrons()
+			case "cronrun":
+				runCron()
 			default:
 				fmt.Printf(logger.Red, "command not handled, use 'help' or 'commands' to list available commands ")
 			}
@@ -240,6 +270,103 @@ func createsuperuser() {
 	}
 }
 
+func resetTwoFactor() {
+	email := input.Input(input.Blue, "User email : ")
+	if email == "" {
+		fmt.Printf(logger.Red, "email is empty")
+		return
+	}
+	user, err := orm.Model[models.User]().Where("email = ?", email).One()
+	if err != nil || user.Id == 0 {
+		fmt.Printf(logger.Red, "user "+email+" not found")
+		return
+	}
+	if err := totp.Reset(user.Id); err != nil {
+		fmt.Printf(logger.Red, "unable to reset 2fa: "+err.Error())
+		return
+	}
+	fmt.Printf(logger.Green, "two factor authentication of "+email+" reset, they can log in with their password and enroll again")
+}
+
+func listLocks() {
+	locks, err := throttle.Locks()
+	if err != nil {
+		fmt.Printf(logger.Red, "unable to list locks: "+err.Error())
+		return
+	}
+	if len(locks) == 0 {
+		fmt.Printf(logger.Green, "nothing locked")
+		return
+	}
+	for _, l := range locks {
+		fmt.Printf(logger.Blue, fmt.Sprintf("%s  failures: %d  locked until: %s", l.LockKey, l.Failures, time.Unix(l.LockedUntil, 0).Format("2006-01-02 15:04:05")))
+	}
+}
+
+func unlock() {
+	key := input.Input(input.Blue, "Email or ip:addr : ")
+	if key == "" {
+		fmt.Printf(logger.Red, "key is empty")
+		return
+	}
+	if !strings.HasPrefix(key, "ip:") && !strings.HasPrefix(key, "account:") {
+		key = throttle.AccountKey(key)
+	}
+	if err := throttle.Clear(key); err != nil {
+		fmt.Printf(logger.Red, "unable to unlock: "+err.Error())
+		return
+	}
+	fmt.Printf(logger.Green, key+" unlocked")
+}
+
+func listCrons() {
+	list, err := cron.STORE.Jobs()
+	if err != nil {
+		fmt.Printf(logger.Red, "unable to list cron jobs: "+err.Error())
+		return
+	}
+	if len(list) == 0 {
+		fmt.Printf(logger.Green, "no cron jobs, they are listed once the app started")
+		return
+	}
+	format := func(sec int64) string {
+		if sec == 0 {
+			return "-"
+		}
+		return time.Unix(sec, 0).Format("2006-01-02 15:04:05")
+	}
+	for _, j := range list {
+		fmt.Printf(logger.Blue, fmt.Sprintf("%s  [%s]  next: %s", j.Name, j.Spec, format(j.NextRun)))
+		runs, err := cron.STORE.Runs(j.Name, 3)
+		if err != nil {
+			continue
+		}
+		for _, r := range runs {
+			status := "ok"
+			if r.Error != "" {
+				status = "error: " + r.Error
+			}
+			if r.Manual {
+				status += " (manual)"
+			}
+			fmt.Printf(logger.Yellow, fmt.Sprintf("    %s  %dms  on %s  %s", format(r.StartedAt), r.Duration, r.Instance, status))
+		}
+	}
+}
+
+func runCron() {
+	name := input.Input(input.Blue, "Cron job name : ")
+	if name == "" {
+		fmt.Printf(logger.Red, "name is empty")
+		return
+	}
+	if err := cron.STORE.Trigger(name); err != nil {
+		fmt.Printf(logger.Red, "unable to trigger "+name+": "+err.Error())
+		return
+	}
+	fmt.Printf(logger.Green, name+" triggered, it will run within "+cron.POLL_EVERY.String()+" on a running instance")
+}
+
 func migratefromfile(path string) error {
 	if !utils.SliceContains([]string{orm.POSTGRES, orm.SQLITE, orm.MYSQL,orm.MARIA}, settings.Config.Db.Type) {
 		logger.Error("database is neither postgres, sqlite or mysql ")
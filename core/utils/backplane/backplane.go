@@ -0,0 +1,131 @@
+// Package backplane fan out messages between instances of the same app, so websocket hubs, sse brokers and the eventbus
+// reach clients connected to other instances
+package backplane
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+var ErrClosed = errors.New("backplane closed")
+
+// Message is a message received from another instance, Origin is the NodeID of the publisher
+type Message struct {
+	Origin  string
+	Channel string
+	Data    []byte
+}
+
+// Backplane publish messages to other instances, messages published by a node are never delivered back to it
+type Backplane interface {
+	NodeID() string
+	Publish(channel string, data []byte) error
+	Subscribe(channel string, fn func(msg Message)) (unsubscribe func(), err error)
+	Close() error
+}
+
+// NewNodeID return a random id identifying an instance
+func NewNodeID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type wire struct {
+	Origin string `json:"o"`
+	Data   []byte `json:"d"`
+}
+
+// Encode wrap data with the origin node id, used by implementations sending through text only transports
+func Encode(origin string, data []byte) []byte {
+	b, _ := json.Marshal(wire{Origin: origin, Data: data})
+	return b
+}
+
+// Decode unwrap a payload made by Encode
+func Decode(payload []byte) (origin string, data []byte, err error) {
+	var w wire
+	if err := json.Unmarshal(payload, &w); err != nil {
+		return "", nil, err
+	}
+	return w.Origin, w.Data, nil
+}
+
+type subscription struct {
+	fn func(msg Message)
+}
+
+// Subscriptions keep subscribers by channel for implementations, messages from the local node are dropped
+type Subscriptions struct {
+	NodeID string
+	subs   map[string]map[*subscription]struct{}
+	mu     sync.RWMutex
+}
+
+// NewSubscriptions create an empty registry for nodeId
+func NewSubscriptions(nodeId string) *Subscriptions {
+	return &Subscriptions{
+		NodeID: nodeId,
+		subs:   map[string]map[*subscription]struct{}{},
+	}
+}
+
+// Add register fn for channel, first is true if it's the first subscriber of channel,
+// remove return true if it was the last one
+func (s *Subscriptions) Add(channel string, fn func(msg Message)) (first bool, remove func() (last bool)) {
+	sub := &subscription{fn: fn}
+	s.mu.Lock()
+	m, ok := s.subs[channel]
+	if !ok {
+		m = map[*subscription]struct{}{}
+		s.subs[channel] = m
+	}
+	m[sub] = struct{}{}
+	first = len(m) == 1
+	s.mu.Unlock()
+	var once sync.Once
+	return first, func() (last bool) {
+		once.Do(func() {
+			s.mu.Lock()
+			if m, ok := s.subs[channel]; ok {
+				delete(m, sub)
+				if len(m) == 0 {
+					delete(s.subs, channel)
+					last = true
+				}
+			}
+			s.mu.Unlock()
+		})
+		return last
+	}
+}
+
+// Channels return channels having subscribers
+func (s *Subscriptions) Channels() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]string, 0, len(s.subs))
+	for ch := range s.subs {
+		res = append(res, ch)
+	}
+	return res
+}
+
+// Deliver call subscribers of msg.Channel, unless msg come from this node
+func (s *Subscriptions) Deliver(msg Message) {
+	if msg.Origin == s.NodeID {
+		return
+	}
+	s.mu.RLock()
+	fns := make([]func(Message), 0, len(s.subs[msg.Channel]))
+	for sub := range s.subs[msg.Channel] {
+		fns = append(fns, sub.fn)
+	}
+	s.mu.RUnlock()
+	for _, fn := range fns {
+		fn(msg)
+	}
+}
@@ -0,0 +1,254 @@
+package backplane
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a minimal server speaking the redis protocol, supporting AUTH, PING, PUBLISH, SUBSCRIBE and UNSUBSCRIBE
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+	conns    map[*respConn]map[string]bool
+	mu       sync.Mutex
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{ln: ln, password: password, conns: map[*respConn]map[string]bool{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(&respConn{Conn: c, r: bufio.NewReader(c)})
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		s.dropAll()
+	})
+	return s
+}
+
+func (s *fakeRedis) addr() string { return s.ln.Addr().String() }
+
+// dropAll close all client connections, like a server restart
+func (s *fakeRedis) dropAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+}
+
+func (s *fakeRedis) subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, chans := range s.conns {
+		if chans[channel] {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *fakeRedis) serve(c *respConn) {
+	s.mu.Lock()
+	s.conns[c] = map[string]bool{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+	authed := s.password == ""
+	for {
+		v, err := c.read()
+		if err != nil {
+			return
+		}
+		arr, _ := v.([]any)
+		args := make([]string, len(arr))
+		for i, a := range arr {
+			args[i], _ = a.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		s.mu.Lock()
+		if _, ok := s.conns[c]; !ok {
+			s.mu.Unlock()
+			return
+		}
+		switch {
+		case args[0] == "AUTH":
+			if len(args) == 2 && args[1] == s.password {
+				authed = true
+				fmt.Fprint(c, "+OK\r\n")
+			} else {
+				fmt.Fprint(c, "-WRONGPASS invalid password\r\n")
+			}
+		case !authed:
+			fmt.Fprint(c, "-NOAUTH Authentication required\r\n")
+		case args[0] == "PING":
+			fmt.Fprint(c, "+PONG\r\n")
+		case args[0] == "PUBLISH" && len(args) == 3:
+			n := 0
+			for sub, chans := range s.conns {
+				if chans[args[1]] {
+					n++
+					fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(args[2]), args[2])
+				}
+			}
+			fmt.Fprintf(c, ":%d\r\n", n)
+		case args[0] == "SUBSCRIBE" || args[0] == "UNSUBSCRIBE":
+			kind := "subscribe"
+			if args[0] == "UNSUBSCRIBE" {
+				kind = "unsubscribe"
+			}
+			for _, ch := range args[1:] {
+				if kind == "subscribe" {
+					s.conns[c][ch] = true
+				} else {
+					delete(s.conns[c], ch)
+				}
+				fmt.Fprintf(c, "*3\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n:%d\r\n", len(kind), kind, len(ch), ch, len(s.conns[c]))
+			}
+		default:
+			fmt.Fprint(c, "-ERR unknown command '"+args[0]+"'\r\n")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// testNodes check that messages reach the other node only, with the publisher id as Origin
+func testNodes(t *testing.T, a, b Backplane, subscribed func(n int) bool) {
+	recvA := make(chan Message, 10)
+	recvB := make(chan Message, 10)
+	unsubA, err := a.Subscribe("chat", func(msg Message) { recvA <- msg })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubA()
+	unsubB, err := b.Subscribe("chat", func(msg Message) { recvB <- msg })
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "subscriptions", func() bool { return subscribed(2) })
+
+	if err := a.Publish("chat", []byte("from a")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-recvB:
+		if string(msg.Data) != "from a" || msg.Origin != a.NodeID() || msg.Channel != "chat" {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("b didn't receive the message of a")
+	}
+	select {
+	case msg := <-recvA:
+		t.Fatalf("a received its own message %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unsubB()
+	waitFor(t, "unsubscribe", func() bool { return subscribed(1) })
+	if err := a.Publish("chat", []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-recvB:
+		t.Fatalf("b received %+v after unsubscribe", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemory(t *testing.T) {
+	a := NewMemory()
+	b := a.Peer()
+	defer a.Close()
+	defer b.Close()
+	testNodes(t, a, b, func(int) bool { return true })
+}
+
+func TestRedis(t *testing.T) {
+	srv := newFakeRedis(t, "secret")
+	if _, err := NewRedis(srv.addr(), RedisOptions{Password: "wrong"}); err == nil {
+		t.Fatal("expected auth error")
+	}
+	opts := RedisOptions{Password: "secret", Prefix: "app:", ReconnectEvery: 10 * time.Millisecond}
+	a, err := NewRedis(srv.addr(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewRedis(srv.addr(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	testNodes(t, a, b, func(n int) bool { return srv.subscribers("app:chat") == n })
+}
+
+func TestRedisReconnect(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	opts := RedisOptions{ReconnectEvery: 10 * time.Millisecond}
+	a, err := NewRedis(srv.addr(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewRedis(srv.addr(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	recv := make(chan Message, 10)
+	unsub, _ := b.Subscribe("events", func(msg Message) { recv <- msg })
+	defer unsub()
+	waitFor(t, "subscription", func() bool { return srv.subscribers("events") == 1 })
+
+	srv.dropAll()
+	waitFor(t, "resubscription", func() bool { return srv.subscribers("events") == 1 })
+	for i := 0; i < 3; i++ {
+		if err := a.Publish("events", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-recv:
+			if string(msg.Data) != strconv.Itoa(i) {
+				t.Fatalf("got %q, want %d", msg.Data, i)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("message lost after reconnect")
+		}
+	}
+}
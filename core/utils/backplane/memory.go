@@ -0,0 +1,80 @@
+package backplane
+
+import "sync"
+
+// Memory is an in-process backplane, peers created with Peer share the same bus, useful to run several nodes in one process
+type Memory struct {
+	id     string
+	bus    *memoryBus
+	subs   *Subscriptions
+	closed bool
+	mu     sync.Mutex
+}
+
+type memoryBus struct {
+	nodes map[*Memory]struct{}
+	mu    sync.RWMutex
+}
+
+// NewMemory create a node on a new in-process bus
+func NewMemory() *Memory {
+	return newMemoryNode(&memoryBus{nodes: map[*Memory]struct{}{}})
+}
+
+func newMemoryNode(bus *memoryBus) *Memory {
+	m := &Memory{
+		id:  NewNodeID(),
+		bus: bus,
+	}
+	m.subs = NewSubscriptions(m.id)
+	bus.mu.Lock()
+	bus.nodes[m] = struct{}{}
+	bus.mu.Unlock()
+	return m
+}
+
+// Peer create another node on the same bus
+func (m *Memory) Peer() *Memory {
+	return newMemoryNode(m.bus)
+}
+
+func (m *Memory) NodeID() string {
+	return m.id
+}
+
+// Publish deliver data synchronously to subscribers of the other nodes
+func (m *Memory) Publish(channel string, data []byte) error {
+	m.mu.Lock()
+	closed := m.closed
+	m.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	msg := Message{Origin: m.id, Channel: channel, Data: data}
+	m.bus.mu.RLock()
+	nodes := make([]*Memory, 0, len(m.bus.nodes))
+	for n := range m.bus.nodes {
+		nodes = append(nodes, n)
+	}
+	m.bus.mu.RUnlock()
+	for _, n := range nodes {
+		n.subs.Deliver(msg)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(channel string, fn func(msg Message)) (func(), error) {
+	_, remove := m.subs.Add(channel, fn)
+	return func() { remove() }, nil
+}
+
+// Close remove the node from the bus
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.bus.mu.Lock()
+	delete(m.bus.nodes, m)
+	m.bus.mu.Unlock()
+	return nil
+}
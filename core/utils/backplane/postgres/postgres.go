@@ -0,0 +1,251 @@
+// Package postgres is a backplane using postgres LISTEN/NOTIFY through an orm connection.
+// Receiving notifications need a driver exposing them, the pgx stdlib driver (github.com/jackc/pgx/v4/stdlib or v5) is supported.
+package postgres
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kago/core/orm"
+	"github.com/kamalshkeir/kago/core/utils/backplane"
+	"github.com/kamalshkeir/kago/core/utils/logger"
+)
+
+var (
+	ErrNotPostgres              = errors.New("postgres backplane: database is not postgres")
+	ErrNotificationsUnsupported = errors.New("postgres backplane: the sql driver doesn't expose notifications, use the pgx stdlib driver")
+	// MaxPayload is the limit of a NOTIFY payload on a default postgres build
+	MaxPayload = 8000
+)
+
+// Postgres is a backplane using a dedicated connection of the orm pool to LISTEN, and the pool to NOTIFY
+type Postgres struct {
+	db      *sql.DB
+	id      string
+	subs    *backplane.Subscriptions
+	listen  chan string
+	cancel  context.CancelFunc
+	ctx     context.Context
+	wg      sync.WaitGroup
+	started chan error
+}
+
+// New create a backplane on the orm database dbName, the default one if empty
+func New(dbName ...string) (*Postgres, error) {
+	name := orm.DefaultDB
+	if len(dbName) > 0 && dbName[0] != "" {
+		name = dbName[0]
+	}
+	db, err := orm.GetMemoryDatabase(name)
+	if err != nil {
+		return nil, err
+	}
+	if db.Dialect != orm.POSTGRES {
+		return nil, ErrNotPostgres
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Postgres{
+		db:      db.Conn,
+		id:      backplane.NewNodeID(),
+		listen:  make(chan string, 16),
+		ctx:     ctx,
+		cancel:  cancel,
+		started: make(chan error, 1),
+	}
+	p.subs = backplane.NewSubscriptions(p.id)
+	p.wg.Add(1)
+	go p.run()
+	if err := <-p.started; err != nil {
+		cancel()
+		p.wg.Wait()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Postgres) NodeID() string {
+	return p.id
+}
+
+// Publish send data using pg_notify, data is limited to MaxPayload bytes once encoded
+func (p *Postgres) Publish(channel string, data []byte) error {
+	if p.ctx.Err() != nil {
+		return backplane.ErrClosed
+	}
+	payload := backplane.Encode(p.id, data)
+	if len(payload) > MaxPayload {
+		return errors.New("postgres backplane: payload too large for NOTIFY")
+	}
+	_, err := p.db.ExecContext(p.ctx, "SELECT pg_notify($1, $2)", channelName(channel), string(payload))
+	return err
+}
+
+func (p *Postgres) Subscribe(channel string, fn func(msg backplane.Message)) (func(), error) {
+	name := channelName(channel)
+	first, remove := p.subs.Add(name, func(msg backplane.Message) {
+		msg.Channel = channel
+		fn(msg)
+	})
+	if first {
+		select {
+		case p.listen <- name:
+		case <-p.ctx.Done():
+			remove()
+			return nil, backplane.ErrClosed
+		}
+	}
+	// channels stay listened, notifications without subscribers are dropped
+	return func() { remove() }, nil
+}
+
+func (p *Postgres) Close() error {
+	p.cancel()
+	p.wg.Wait()
+	return nil
+}
+
+// run keep a connection listening, reconnecting on failure
+func (p *Postgres) run() {
+	defer p.wg.Done()
+	first := true
+	for p.ctx.Err() == nil {
+		err := p.listenOn(first)
+		if first {
+			first = false
+			if errors.Is(err, ErrNotificationsUnsupported) {
+				return
+			}
+		}
+		if p.ctx.Err() != nil {
+			return
+		}
+		logger.Error("postgres backplane:", err)
+		select {
+		case <-p.ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (p *Postgres) listenOn(first bool) error {
+	conn, err := p.db.Conn(p.ctx)
+	if err != nil {
+		if first {
+			p.started <- err
+		}
+		return err
+	}
+	defer conn.Close()
+	err = conn.Raw(func(driverConn any) error {
+		_, ok := notificationWaiter(driverConn)
+		if !ok {
+			return ErrNotificationsUnsupported
+		}
+		return nil
+	})
+	if first {
+		p.started <- err
+	}
+	if err != nil {
+		return err
+	}
+	for _, ch := range p.subs.Channels() {
+		if _, err := conn.ExecContext(p.ctx, "LISTEN "+quoteIdent(ch)); err != nil {
+			return err
+		}
+	}
+	for {
+		waitCtx, cancelWait := context.WithCancel(p.ctx)
+		type notification struct {
+			channel, payload string
+			err              error
+		}
+		res := make(chan notification, 1)
+		go func() {
+			var n notification
+			n.err = conn.Raw(func(driverConn any) error {
+				wait, _ := notificationWaiter(driverConn)
+				n.channel, n.payload, n.err = wait(waitCtx)
+				return n.err
+			})
+			res <- n
+		}()
+		select {
+		case ch := <-p.listen:
+			// stop waiting to run LISTEN on the same connection
+			cancelWait()
+			<-res
+			if p.ctx.Err() != nil {
+				return nil
+			}
+			if _, err := conn.ExecContext(p.ctx, "LISTEN "+quoteIdent(ch)); err != nil {
+				return err
+			}
+		case n := <-res:
+			cancelWait()
+			if n.err != nil {
+				return n.err
+			}
+			origin, data, err := backplane.Decode([]byte(n.payload))
+			if err != nil {
+				continue
+			}
+			p.subs.Deliver(backplane.Message{Origin: origin, Channel: n.channel, Data: data})
+		case <-p.ctx.Done():
+			cancelWait()
+			<-res
+			return nil
+		}
+	}
+}
+
+// notificationWaiter return a func waiting for the next notification on driverConn.
+// pgx stdlib conns expose Conn() *pgx.Conn, having WaitForNotification(ctx) (*pgconn.Notification, error),
+// it's called by reflection so kago doesn't depend on a driver
+func notificationWaiter(driverConn any) (func(ctx context.Context) (channel, payload string, err error), bool) {
+	if w, ok := driverConn.(interface {
+		WaitForNotification(ctx context.Context) (channel, payload string, err error)
+	}); ok {
+		return w.WaitForNotification, true
+	}
+	getConn := reflect.ValueOf(driverConn).MethodByName("Conn")
+	if !getConn.IsValid() || getConn.Type().NumIn() != 0 || getConn.Type().NumOut() != 1 {
+		return nil, false
+	}
+	pgxConn := getConn.Call(nil)[0]
+	wait := pgxConn.MethodByName("WaitForNotification")
+	if !wait.IsValid() || wait.Type().NumIn() != 1 || wait.Type().NumOut() != 2 {
+		return nil, false
+	}
+	return func(ctx context.Context) (string, string, error) {
+		out := wait.Call([]reflect.Value{reflect.ValueOf(ctx)})
+		if err, _ := out[1].Interface().(error); err != nil {
+			return "", "", err
+		}
+		n := reflect.Indirect(out[0])
+		if n.Kind() != reflect.Struct {
+			return "", "", ErrNotificationsUnsupported
+		}
+		return n.FieldByName("Channel").String(), n.FieldByName("Payload").String(), nil
+	}, true
+}
+
+// channelName keep channels under the 63 bytes limit of postgres identifiers
+func channelName(channel string) string {
+	if len(channel) <= 63 {
+		return channel
+	}
+	h := sha1.Sum([]byte(channel))
+	return channel[:22] + "_" + hex.EncodeToString(h[:])
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
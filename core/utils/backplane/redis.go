@@ -0,0 +1,307 @@
+package backplane
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisOptions configure the connection to a server speaking the redis protocol (redis, valkey, keydb, dragonfly...)
+type RedisOptions struct {
+	Password       string
+	Prefix         string // prepended to channels names
+	DialTimeout    time.Duration
+	ReconnectEvery time.Duration
+}
+
+// Redis is a backplane using redis PUBLISH/SUBSCRIBE, the subscriber connection reconnect and resubscribe automatically
+type Redis struct {
+	addr   string
+	opts   RedisOptions
+	id     string
+	subs   *Subscriptions
+	pub    *respConn
+	pubMu  sync.Mutex
+	sub    *respConn
+	subMu  sync.Mutex
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewRedis connect to the redis server at addr, ex: localhost:6379
+func NewRedis(addr string, opts ...RedisOptions) (*Redis, error) {
+	r := &Redis{
+		addr:   addr,
+		id:     NewNodeID(),
+		closed: make(chan struct{}),
+	}
+	if len(opts) > 0 {
+		r.opts = opts[0]
+	}
+	if r.opts.DialTimeout <= 0 {
+		r.opts.DialTimeout = 5 * time.Second
+	}
+	if r.opts.ReconnectEvery <= 0 {
+		r.opts.ReconnectEvery = time.Second
+	}
+	r.subs = NewSubscriptions(r.id)
+	conn, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+	r.pub = conn
+	go r.subscribeLoop()
+	return r, nil
+}
+
+func (r *Redis) NodeID() string {
+	return r.id
+}
+
+func (r *Redis) Publish(channel string, data []byte) error {
+	select {
+	case <-r.closed:
+		return ErrClosed
+	default:
+	}
+	payload := Encode(r.id, data)
+	r.pubMu.Lock()
+	defer r.pubMu.Unlock()
+	var err error
+	// retry once on a fresh connection, the previous one may have been closed by the server
+	for i := 0; i < 2; i++ {
+		if r.pub == nil {
+			if r.pub, err = r.dial(); err != nil {
+				return err
+			}
+		}
+		if _, err = r.pub.do("PUBLISH", r.opts.Prefix+channel, string(payload)); err == nil {
+			return nil
+		}
+		var rerr redisError
+		if errors.As(err, &rerr) {
+			return err
+		}
+		r.pub.Close()
+		r.pub = nil
+	}
+	return err
+}
+
+func (r *Redis) Subscribe(channel string, fn func(msg Message)) (func(), error) {
+	name := r.opts.Prefix + channel
+	first, remove := r.subs.Add(name, func(msg Message) {
+		msg.Channel = channel
+		fn(msg)
+	})
+	if first {
+		r.subMu.Lock()
+		if r.sub != nil {
+			// on failure the read loop reconnect and subscribe again
+			_ = r.sub.write("SUBSCRIBE", name)
+		}
+		r.subMu.Unlock()
+	}
+	return func() {
+		if remove() {
+			r.subMu.Lock()
+			if r.sub != nil {
+				_ = r.sub.write("UNSUBSCRIBE", name)
+			}
+			r.subMu.Unlock()
+		}
+	}, nil
+}
+
+func (r *Redis) Close() error {
+	r.once.Do(func() {
+		close(r.closed)
+		r.pubMu.Lock()
+		if r.pub != nil {
+			r.pub.Close()
+		}
+		r.pubMu.Unlock()
+		r.subMu.Lock()
+		if r.sub != nil {
+			r.sub.Close()
+		}
+		r.subMu.Unlock()
+	})
+	return nil
+}
+
+func (r *Redis) dial() (*respConn, error) {
+	c, err := net.DialTimeout("tcp", r.addr, r.opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &respConn{Conn: c, r: bufio.NewReader(c)}
+	if r.opts.Password != "" {
+		if _, err := conn.do("AUTH", r.opts.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// subscribeLoop keep a subscriber connection open and deliver received messages
+func (r *Redis) subscribeLoop() {
+	for {
+		select {
+		case <-r.closed:
+			return
+		default:
+		}
+		conn, err := r.dial()
+		if err != nil {
+			select {
+			case <-r.closed:
+				return
+			case <-time.After(r.opts.ReconnectEvery):
+				continue
+			}
+		}
+		r.subMu.Lock()
+		select {
+		case <-r.closed:
+			r.subMu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		r.sub = conn
+		if channels := r.subs.Channels(); len(channels) > 0 {
+			err = conn.write(append([]string{"SUBSCRIBE"}, channels...)...)
+		}
+		r.subMu.Unlock()
+		if err == nil {
+			r.readMessages(conn)
+		}
+		r.subMu.Lock()
+		r.sub = nil
+		r.subMu.Unlock()
+		conn.Close()
+	}
+}
+
+func (r *Redis) readMessages(conn *respConn) {
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return
+		}
+		arr, ok := reply.([]any)
+		if !ok || len(arr) != 3 {
+			continue
+		}
+		if kind, _ := arr[0].(string); kind != "message" {
+			continue
+		}
+		channel, _ := arr[1].(string)
+		payload, _ := arr[2].(string)
+		origin, data, err := Decode([]byte(payload))
+		if err != nil {
+			continue
+		}
+		r.subs.Deliver(Message{Origin: origin, Channel: channel, Data: data})
+	}
+}
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// respConn speak RESP2
+type respConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *respConn) write(args ...string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, a := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(a)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, a...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := c.Write(buf)
+	return err
+}
+
+func (c *respConn) do(args ...string) (any, error) {
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if rerr, ok := reply.(redisError); ok {
+		return nil, rerr
+	}
+	return reply, nil
+}
+
+func (c *respConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: bad line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// read return a reply as string, int64, nil, redisError or []any
+func (c *respConn) read() (any, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		res := make([]any, n)
+		for i := range res {
+			if res[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
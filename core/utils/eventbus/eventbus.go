@@ -1,13 +1,71 @@
 package eventbus
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
+
+	"github.com/kamalshkeir/kago/core/utils/backplane"
 )
 
 var mTopicBus = map[string]any{}
 var mTopicType = map[string]any{}
 
+var (
+	bp         backplane.Backplane
+	bpTopics   map[string]bool
+	bpDecoders = map[string]func(data []byte){}
+	bpUnsubs   = map[string]func(){}
+	bpMu       sync.Mutex
+)
+
+// UseBackplane forward published events to other instances using bp, only for topics if given, all topics otherwise.
+// Events are sent as json, remote events are dispatched to local subscribers only
+func UseBackplane(b backplane.Backplane, topics ...string) {
+	bpMu.Lock()
+	defer bpMu.Unlock()
+	for _, unsub := range bpUnsubs {
+		unsub()
+	}
+	bpUnsubs = map[string]func(){}
+	bp = b
+	bpTopics = nil
+	if len(topics) > 0 {
+		bpTopics = make(map[string]bool, len(topics))
+		for _, t := range topics {
+			bpTopics[t] = true
+		}
+	}
+	if bp == nil {
+		return
+	}
+	for topic, decode := range bpDecoders {
+		subscribeRemote(topic, decode)
+	}
+}
+
+func forwarded(topic string) bool {
+	return bp != nil && (bpTopics == nil || bpTopics[topic])
+}
+
+// subscribeRemote must be called with bpMu locked
+func subscribeRemote(topic string, decode func(data []byte)) {
+	if !forwarded(topic) {
+		return
+	}
+	if _, ok := bpUnsubs[topic]; ok {
+		return
+	}
+	unsub, err := bp.Subscribe("eventbus:"+topic, func(msg backplane.Message) {
+		decode(msg.Data)
+	})
+	if err != nil {
+		fmt.Printf("eventbus: backplane subscribe on %s: %v\n", topic, err)
+		return
+	}
+	bpUnsubs[topic] = unsub
+}
+
 type Bus[T any] struct {
 	Topic string
 	Subs  map[string][]chan T
@@ -51,16 +109,52 @@ func Subscribe[T any](topic string, fn func(data T)) {
 			fn(v)
 		}
 	}()
+
+	bpMu.Lock()
+	if _, ok := bpDecoders[topic]; !ok {
+		decode := func(data []byte) {
+			var v T
+			if err := json.Unmarshal(data, &v); err != nil {
+				fmt.Printf("eventbus: cannot decode remote event on %s: %v\n", topic, err)
+				return
+			}
+			publish(topic, v)
+		}
+		bpDecoders[topic] = decode
+		if bp != nil {
+			subscribeRemote(topic, decode)
+		}
+	}
+	bpMu.Unlock()
 }
 
 func Publish[T any](topic string, data T) {
+	if !publish(topic, data) {
+		return
+	}
+	bpMu.Lock()
+	b, ok := bp, forwarded(topic)
+	bpMu.Unlock()
+	if ok {
+		payload, err := json.Marshal(data)
+		if err == nil {
+			err = b.Publish("eventbus:"+topic, payload)
+		}
+		if err != nil {
+			fmt.Printf("eventbus: backplane publish on %s: %v\n", topic, err)
+		}
+	}
+}
+
+// publish dispatch data to local subscribers, it return false if the topic has another type
+func publish[T any](topic string, data T) bool {
 	var b *Bus[T]
 	if topicbus, ok := mTopicBus[topic]; ok {
 		if bb, ok := topicbus.(*Bus[T]); ok {
 			b = bb
 		} else {
 			fmt.Printf("Publish on %s doesn't match data type: want %T got %T\n", topic, mTopicType[topic], *new(T))
-			return
+			return false
 		}
 	} else {
 		b = &Bus[T]{
@@ -80,4 +174,5 @@ func Publish[T any](topic string, data T) {
 		}(data, channels)
 	}
 	b.mu.RUnlock()
+	return true
 }
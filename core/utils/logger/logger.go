@@ -91,8 +91,9 @@ func CheckError(err error) bool {
 		caller := runtime.FuncForPC(pc).Name()
 		fmt.Printf("\033[1;31m [ERROR] %s [line:%d] : %v \033[0m \n", caller, line, err)
 		if settings.Config.Logs {
-			StreamLogs = append(StreamLogs, fmt.Sprintf("[ERROR] %s [line:%d] : %v \n", caller, line, err))
-			eventbus.Publish("internal-logs", map[string]string{})
+			log := fmt.Sprintf("[ERROR] %s [line:%d] : %v \n", caller, line, err)
+			StreamLogs = append(StreamLogs, log)
+			eventbus.Publish("internal-logs", map[string]string{"log": log})
 		}
 		return true
 	}
@@ -107,8 +108,9 @@ func Error(anything ...interface{}) {
 	ph := strings.Replace(placeholder[:len(placeholder)-1], ",", "  ", -1)
 	new := fmt.Sprintf("\033[1;31m [ERROR] %s [line:%d] : %s \033[0m \n", caller, line, ph)
 	if settings.Config.Logs {
-		StreamLogs = append(StreamLogs, fmt.Sprintf("[ERROR] %s [line:%d] : %v \n", caller, line, fmt.Sprintf(ph, anything...)))
-		eventbus.Publish("internal-logs", map[string]string{})
+		log := fmt.Sprintf("[ERROR] %s [line:%d] : %v \n", caller, line, fmt.Sprintf(ph, anything...))
+		StreamLogs = append(StreamLogs, log)
+		eventbus.Publish("internal-logs", map[string]string{"log": log})
 	}
 	fmt.Printf(new, anything...)
 }
@@ -121,8 +123,9 @@ func Info(anything ...interface{}) {
 	ph := strings.Replace(placeholder[:len(placeholder)-1], ",", "  ", -1)
 	new := fmt.Sprintf("\033[1;34m [INFO] %s [line:%d] : %s \033[0m \n", caller, line, ph)
 	if settings.Config.Logs {
-		StreamLogs = append(StreamLogs, fmt.Sprintf("[INFO] %s [line:%d] : %v \n", caller, line, fmt.Sprintf(ph, anything...)))
-		eventbus.Publish("internal-logs", map[string]string{})
+		log := fmt.Sprintf("[INFO] %s [line:%d] : %v \n", caller, line, fmt.Sprintf(ph, anything...))
+		StreamLogs = append(StreamLogs, log)
+		eventbus.Publish("internal-logs", map[string]string{"log": log})
 	}
 	fmt.Printf(new, anything...)
 }
@@ -135,8 +138,9 @@ func Debug(anything ...interface{}) {
 	ph := strings.Replace(placeholder[:len(placeholder)-1], ",", "  ", -1)
 	new := fmt.Sprintf("\033[1;34m [DEBUG] %s [line:%d] : %s \033[0m \n", caller, line, ph)
 	if settings.Config.Logs {
-		StreamLogs = append(StreamLogs, fmt.Sprintf("[DEBUG] %s [line:%d] : %v \n", caller, line, fmt.Sprintf(ph, anything...)))
-		eventbus.Publish("internal-logs", map[string]string{})
+		log := fmt.Sprintf("[DEBUG] %s [line:%d] : %v \n", caller, line, fmt.Sprintf(ph, anything...))
+		StreamLogs = append(StreamLogs, log)
+		eventbus.Publish("internal-logs", map[string]string{"log": log})
 	}
 	fmt.Printf(new, anything...)
 }
@@ -149,8 +153,9 @@ func Success(anything ...interface{}) {
 	ph := strings.Replace(placeholder[:len(placeholder)-1], ",", "  ", -1)
 	new := fmt.Sprintf("\033[1;32m [SUCCESS] %s [line:%d] : %s \033[0m \n", caller, line, ph)
 	if settings.Config.Logs {
-		StreamLogs = append(StreamLogs, fmt.Sprintf("[SUCCESS] %s [line:%d] : %v \n", caller, line, fmt.Sprintf(ph, anything...)))
-		eventbus.Publish("internal-logs", map[string]string{})
+		log := fmt.Sprintf("[SUCCESS] %s [line:%d] : %v \n", caller, line, fmt.Sprintf(ph, anything...))
+		StreamLogs = append(StreamLogs, log)
+		eventbus.Publish("internal-logs", map[string]string{"log": log})
 	}
 	fmt.Printf(new, anything...)
 }
@@ -163,8 +168,9 @@ func Warn(anything ...interface{}) {
 	ph := strings.Replace(placeholder[:len(placeholder)-1], ",", "  ", -1)
 	new := fmt.Sprintf("\033[1;35m [WARN] %s [line:%d] : %s \033[0m \n", caller, line, ph)
 	if settings.Config.Logs {
-		StreamLogs = append(StreamLogs, fmt.Sprintf("[WARN] %s [line:%d] : %v \n", caller, line, fmt.Sprintf(ph, anything...)))
-		eventbus.Publish("internal-logs", map[string]string{})
+		log := fmt.Sprintf("[WARN] %s [line:%d] : %v \n", caller, line, fmt.Sprintf(ph, anything...))
+		StreamLogs = append(StreamLogs, log)
+		eventbus.Publish("internal-logs", map[string]string{"log": log})
 	}
 	fmt.Printf(new, anything...)
 }
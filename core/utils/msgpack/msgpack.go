@@ -0,0 +1,672 @@
+// Package msgpack is a small MessagePack encoder and decoder, structs fields use the msgpack tag, then the json tag
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrShortBuffer = errors.New("msgpack: unexpected end of data")
+	ErrMaxDepth    = errors.New("msgpack: max depth exceeded")
+)
+
+const maxDepth = 1000
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Marshal return the MessagePack encoding of v
+func Marshal(v any) ([]byte, error) {
+	e := encoder{}
+	if err := e.encode(reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+// Unmarshal decode data into v, v must be a non nil pointer
+func Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("msgpack: Unmarshal need a non nil pointer, got %T", v)
+	}
+	d := decoder{data: data}
+	if err := d.decode(rv.Elem(), 0); err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return fmt.Errorf("msgpack: %d bytes left after decoding", len(d.data)-d.pos)
+	}
+	return nil
+}
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldsCache sync.Map
+
+// fields return exported fields of t with their names, embedded structs are flattened
+func fields(t reflect.Type) []field {
+	if f, ok := fieldsCache.Load(t); ok {
+		return f.([]field)
+	}
+	res := []field{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() && !sf.Anonymous {
+			continue
+		}
+		tag, ok := sf.Tag.Lookup("msgpack")
+		if !ok {
+			tag = sf.Tag.Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			for _, f := range fields(sf.Type) {
+				f.index = append([]int{i}, f.index...)
+				res = append(res, f)
+			}
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		res = append(res, field{name: name, index: []int{i}, omitEmpty: strings.Contains(opts, "omitempty")})
+	}
+	fieldsCache.Store(t, res)
+	return res
+}
+
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) encode(v reflect.Value, depth int) error {
+	if depth > maxDepth {
+		return ErrMaxDepth
+	}
+	if !v.IsValid() {
+		e.WriteByte(0xc0)
+		return nil
+	}
+	if v.Type() == timeType {
+		e.encodeString(v.Interface().(time.Time).Format(time.RFC3339Nano))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			e.WriteByte(0xc0)
+			return nil
+		}
+		return e.encode(v.Elem(), depth+1)
+	case reflect.Bool:
+		if v.Bool() {
+			e.WriteByte(0xc3)
+		} else {
+			e.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.WriteByte(0xca)
+		e.write32(math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.WriteByte(0xcb)
+		e.write64(math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.WriteByte(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.encodeBin(b)
+			return nil
+		}
+		e.encodeLen(v.Len(), 0x90, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.WriteByte(0xc0)
+			return nil
+		}
+		e.encodeLen(v.Len(), 0x80, 0xde, 0xdf)
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key(), depth+1); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value(), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fs := fields(v.Type())
+		values := make([]reflect.Value, 0, len(fs))
+		names := make([]string, 0, len(fs))
+		for _, f := range fs {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || (f.omitEmpty && fv.IsZero()) {
+				continue
+			}
+			values = append(values, fv)
+			names = append(names, f.name)
+		}
+		e.encodeLen(len(values), 0x80, 0xde, 0xdf)
+		for i, fv := range values {
+			e.encodeString(names[i])
+			if err := e.encode(fv, depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// fieldByIndex is like v.FieldByIndex, but return false on nil embedded pointers
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func (e *encoder) write16(n uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], n)
+	e.Write(b[:])
+}
+
+func (e *encoder) write32(n uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	e.Write(b[:])
+}
+
+func (e *encoder) write64(n uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	e.Write(b[:])
+}
+
+func (e *encoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.WriteByte(byte(n))
+	case n >= math.MinInt8:
+		e.WriteByte(0xd0)
+		e.WriteByte(byte(n))
+	case n >= math.MinInt16:
+		e.WriteByte(0xd1)
+		e.write16(uint16(n))
+	case n >= math.MinInt32:
+		e.WriteByte(0xd2)
+		e.write32(uint32(n))
+	default:
+		e.WriteByte(0xd3)
+		e.write64(uint64(n))
+	}
+}
+
+func (e *encoder) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		e.WriteByte(0xcc)
+		e.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.WriteByte(0xcd)
+		e.write16(uint16(n))
+	case n <= math.MaxUint32:
+		e.WriteByte(0xce)
+		e.write32(uint32(n))
+	default:
+		e.WriteByte(0xcf)
+		e.write64(n)
+	}
+}
+
+func (e *encoder) encodeString(s string) {
+	n := len(s)
+	switch {
+	case n <= 31:
+		e.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		e.WriteByte(0xd9)
+		e.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.WriteByte(0xda)
+		e.write16(uint16(n))
+	default:
+		e.WriteByte(0xdb)
+		e.write32(uint32(n))
+	}
+	e.WriteString(s)
+}
+
+func (e *encoder) encodeBin(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.WriteByte(0xc4)
+		e.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.WriteByte(0xc5)
+		e.write16(uint16(n))
+	default:
+		e.WriteByte(0xc6)
+		e.write32(uint32(n))
+	}
+	e.Write(b)
+}
+
+// encodeLen write an array or map header, fix is the fixarray or fixmap prefix
+func (e *encoder) encodeLen(n int, fix, code16, code32 byte) {
+	switch {
+	case n <= 15:
+		e.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		e.WriteByte(code16)
+		e.write16(uint16(n))
+	default:
+		e.WriteByte(code32)
+		e.write32(uint32(n))
+	}
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, ErrShortBuffer
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uintN(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// readAny decode the next value as nil, bool, int64, uint64, float64, string, []byte, []any or map[string]any
+func (d *decoder) readAny(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, ErrMaxDepth
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return d.readStr(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return d.readArray(int(c&0x0f), depth)
+	case c&0xf0 == 0x80:
+		return d.readMap(int(c&0x0f), depth)
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uintN(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		bin, err := d.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), bin...), nil
+	case 0xca:
+		n, err := d.uintN(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uintN(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uintN(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		return n, nil
+	case 0xd0:
+		n, err := d.uintN(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := d.uintN(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := d.uintN(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := d.uintN(8)
+		return int64(n), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uintN(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.readStr(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uintN(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.readArray(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uintN(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.readMap(int(n), depth)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		// fixext, skipped as nil
+		_, err := d.next(1 + 1<<(c-0xd4))
+		return nil, err
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uintN(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		_, err = d.next(int(n) + 1)
+		return nil, err
+	}
+	return nil, fmt.Errorf("msgpack: invalid code 0x%x", c)
+}
+
+func (d *decoder) readStr(n int) (string, error) {
+	b, err := d.next(n)
+	return string(b), err
+}
+
+func (d *decoder) readArray(n int, depth int) ([]any, error) {
+	if n > len(d.data)-d.pos {
+		return nil, ErrShortBuffer
+	}
+	res := make([]any, n)
+	for i := range res {
+		v, err := d.readAny(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = v
+	}
+	return res, nil
+}
+
+func (d *decoder) readMap(n int, depth int) (map[string]any, error) {
+	if n > len(d.data)-d.pos {
+		return nil, ErrShortBuffer
+	}
+	res := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		k, err := d.readAny(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.readAny(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := k.(string); ok {
+			res[s] = v
+		} else {
+			res[fmt.Sprint(k)] = v
+		}
+	}
+	return res, nil
+}
+
+// decode read the next value into v
+func (d *decoder) decode(v reflect.Value, depth int) error {
+	x, err := d.readAny(depth)
+	if err != nil {
+		return err
+	}
+	return assign(v, x)
+}
+
+// assign set v from a generic decoded value
+func assign(v reflect.Value, x any) error {
+	if x == nil {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	if v.Type() == timeType {
+		s, ok := x.(string)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return assign(v.Elem(), x)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return typeError(x, v.Type())
+		}
+		v.Set(reflect.ValueOf(x))
+	case reflect.Bool:
+		b, ok := x.(bool)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch n := x.(type) {
+		case int64:
+			if v.OverflowInt(n) {
+				return typeError(x, v.Type())
+			}
+			v.SetInt(n)
+		case float64:
+			v.SetInt(int64(n))
+		default:
+			return typeError(x, v.Type())
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch n := x.(type) {
+		case int64:
+			if n < 0 || v.OverflowUint(uint64(n)) {
+				return typeError(x, v.Type())
+			}
+			v.SetUint(uint64(n))
+		case uint64:
+			if v.OverflowUint(n) {
+				return typeError(x, v.Type())
+			}
+			v.SetUint(n)
+		case float64:
+			v.SetUint(uint64(n))
+		default:
+			return typeError(x, v.Type())
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := x.(type) {
+		case float64:
+			v.SetFloat(n)
+		case int64:
+			v.SetFloat(float64(n))
+		case uint64:
+			v.SetFloat(float64(n))
+		default:
+			return typeError(x, v.Type())
+		}
+	case reflect.String:
+		switch s := x.(type) {
+		case string:
+			v.SetString(s)
+		case []byte:
+			v.SetString(string(s))
+		default:
+			return typeError(x, v.Type())
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			switch b := x.(type) {
+			case []byte:
+				v.SetBytes(b)
+				return nil
+			case string:
+				v.SetBytes([]byte(b))
+				return nil
+			}
+		}
+		arr, ok := x.([]any)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		s := reflect.MakeSlice(v.Type(), len(arr), len(arr))
+		for i, item := range arr {
+			if err := assign(s.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		arr, ok := x.([]any)
+		if !ok {
+			if b, isBin := x.([]byte); isBin && v.Type().Elem().Kind() == reflect.Uint8 {
+				reflect.Copy(v, reflect.ValueOf(b))
+				return nil
+			}
+			return typeError(x, v.Type())
+		}
+		for i := 0; i < v.Len() && i < len(arr); i++ {
+			if err := assign(v.Index(i), arr[i]); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := x.(map[string]any)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), len(m)))
+		}
+		kt, et := v.Type().Key(), v.Type().Elem()
+		for k, item := range m {
+			key := reflect.New(kt).Elem()
+			if kt.Kind() == reflect.String {
+				key.SetString(k)
+			} else if _, err := fmt.Sscan(k, key.Addr().Interface()); err != nil {
+				return typeError(k, kt)
+			}
+			ev := reflect.New(et).Elem()
+			if err := assign(ev, item); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, ev)
+		}
+	case reflect.Struct:
+		m, ok := x.(map[string]any)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		for _, f := range fields(v.Type()) {
+			item, ok := m[f.name]
+			if !ok {
+				continue
+			}
+			fv := v
+			for i, idx := range f.index {
+				if i > 0 && fv.Kind() == reflect.Pointer {
+					if fv.IsNil() {
+						fv.Set(reflect.New(fv.Type().Elem()))
+					}
+					fv = fv.Elem()
+				}
+				fv = fv.Field(idx)
+			}
+			if err := assign(fv, item); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func typeError(x any, t reflect.Type) error {
+	return fmt.Errorf("msgpack: cannot decode %T into %s", x, t)
+}
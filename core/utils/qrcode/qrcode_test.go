@@ -0,0 +1,168 @@
+package qrcode
+
+import (
+	"strings"
+	"testing"
+)
+
+// decode read c back like a reader would: format bits, unmasking, codewords, error correction check and payload
+func decode(t *testing.T, c *Code) string {
+	t.Helper()
+	size := c.Size
+	bits := 0
+	for i := 0; i < 8; i++ {
+		if c.Modules[8][size-1-i] {
+			bits |= 1 << uint(i)
+		}
+	}
+	for i := 8; i < 15; i++ {
+		if c.Modules[size-15+i][8] {
+			bits |= 1 << uint(i)
+		}
+	}
+	format := -1
+	for data := 0; data < 32; data++ {
+		rem := data
+		for i := 0; i < 10; i++ {
+			rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+		}
+		if (data<<10|rem)^0x5412 == bits {
+			format = data
+		}
+	}
+	if format < 0 || format>>3 != eccFormatBits {
+		t.Fatalf("invalid format bits %015b", bits)
+	}
+	mask := format & 7
+
+	// reserved areas, rebuilt from a fresh code of the same version
+	ref := &Code{Version: c.Version, Size: size, Modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range ref.Modules {
+		ref.Modules[i] = make([]bool, size)
+		ref.function[i] = make([]bool, size)
+	}
+	ref.drawFunctionPatterns()
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if ref.function[y][x] && !(x == 8 || y == 8) && ref.Modules[y][x] != c.Modules[y][x] {
+				t.Fatalf("function pattern differ at %d,%d", x, y)
+			}
+		}
+	}
+
+	var stream []byte
+	var cur byte
+	n := 0
+	for x := size - 1; x > 0; x -= 2 {
+		if x == 6 {
+			x--
+		}
+		upward := ((size-1-x)/2)%2 == 0
+		if x < 6 {
+			upward = ((size-2-x)/2)%2 == 0
+		}
+		for k := 0; k < size; k++ {
+			y := k
+			if upward {
+				y = size - 1 - k
+			}
+			for _, xx := range []int{x, x - 1} {
+				if ref.function[y][xx] {
+					continue
+				}
+				v := c.Modules[y][xx] != maskBit(mask, xx, y)
+				cur <<= 1
+				if v {
+					cur |= 1
+				}
+				n++
+				if n%8 == 0 {
+					stream = append(stream, cur)
+					cur = 0
+				}
+			}
+		}
+	}
+
+	// deinterleave and check each block is a multiple of the generator: its syndromes are zero
+	blocksCount, eccLen := numBlocks[c.Version], eccPerBlock[c.Version]
+	raw := rawDataModules(c.Version) / 8
+	stream = stream[:raw]
+	shortBlocks := blocksCount - raw%blocksCount
+	shortLen := raw / blocksCount
+	blocks := make([][]byte, blocksCount)
+	k := 0
+	for i := 0; i < shortLen+1; i++ {
+		for j := range blocks {
+			if i == shortLen-eccLen && j < shortBlocks {
+				continue
+			}
+			blocks[j] = append(blocks[j], stream[k])
+			k++
+		}
+	}
+	var data []byte
+	for _, b := range blocks {
+		alpha := byte(1)
+		for s := 0; s < eccLen; s++ {
+			var syndrome byte
+			for _, v := range b {
+				syndrome = gfMul(syndrome, alpha) ^ v
+			}
+			if syndrome != 0 {
+				t.Fatalf("block %v has syndrome %d", b, s)
+			}
+			alpha = gfMul(alpha, 2)
+		}
+		data = append(data, b[:len(b)-eccLen]...)
+	}
+
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("mode %x", data[0]>>4)
+	}
+	read := func(bit, n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(data[(bit+i)/8]>>(7-uint((bit+i)%8))&1)
+		}
+		return v
+	}
+	length := read(4, countBits(c.Version))
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = byte(read(4+countBits(c.Version)+8*i, 8))
+	}
+	return string(out)
+}
+
+func TestEncode(t *testing.T) {
+	texts := []string{
+		"",
+		"HELLO WORLD",
+		"otpauth://totp/Kago:admin@example.com?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=Kago&algorithm=SHA1&digits=6&period=30",
+		strings.Repeat("0123456789abcdef", 40),
+		strings.Repeat("x", 2331),
+	}
+	for _, text := range texts {
+		c, err := Encode(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decode(t, c); got != text {
+			t.Fatalf("version %d decoded %q", c.Version, got)
+		}
+	}
+	if c, _ := Encode("HELLO WORLD"); c.Version != 1 || c.Size != 21 {
+		t.Fatal("version", c.Version)
+	}
+	if _, err := Encode(strings.Repeat("x", 2332)); err != ErrTooLong {
+		t.Fatal("too long text encoded")
+	}
+	c, _ := Encode("abc")
+	if svg := c.SVG(4); !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, `width="116"`) {
+		t.Fatal(svg)
+	}
+	if b, err := c.PNG(2); err != nil || len(b) == 0 {
+		t.Fatal(err)
+	}
+}
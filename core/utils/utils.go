@@ -286,6 +286,8 @@ func StringContains(s string, subs ...string) bool {
 }
 
 // Send Email
+//
+// Deprecated: use mail.Send, rendering templates of the app, returning errors and supporting text alternatives and attachments
 func SendEmail(to_email string, subject string, textToSend string) {
 	from := settings.Config.Smtp.Email
 	pass := settings.Config.Smtp.Pass
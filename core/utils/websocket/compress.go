@@ -0,0 +1,268 @@
+package websocket
+
+// This file implements the permessage-deflate extension.
+// https://www.rfc-editor.org/rfc/rfc7692
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	deflateExtension = "permessage-deflate"
+	maxWindowSize    = 1 << 15
+)
+
+// deflateTail is removed from each compressed message by the sender and added back by the receiver.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// CompressionOptions enable permessage-deflate when set in Config.Compression.
+type CompressionOptions struct {
+	// Level is the flate compression level, 0 means flate.BestSpeed, flate.DefaultCompression means 6,
+	// flate.HuffmanOnly and levels out of range are replaced by the closest of 1..9.
+	Level int
+
+	// Threshold is the minimum payload size to compress, smaller messages are sent as is.
+	Threshold int
+
+	// ServerNoContextTakeover ask the server to compress each message independently.
+	// Without context takeover compressors can be pooled, with it each connection
+	// keep its compressor, so compression ratio is better but memory usage is higher.
+	ServerNoContextTakeover bool
+
+	// ClientNoContextTakeover ask the client to compress each message independently.
+	ClientNoContextTakeover bool
+}
+
+// level return the compression level between flate.BestSpeed and flate.BestCompression, used to index flateWriterPools
+func (o *CompressionOptions) level() int {
+	switch {
+	case o.Level == flate.DefaultCompression:
+		return 6
+	case o.Level < flate.BestSpeed:
+		return flate.BestSpeed
+	case o.Level > flate.BestCompression:
+		return flate.BestCompression
+	}
+	return o.Level
+}
+
+// deflateParams are the parameters agreed during the handshake.
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+}
+
+// String return the extension as written in the Sec-WebSocket-Extensions response header.
+func (p *deflateParams) String() string {
+	s := deflateExtension
+	if p.serverNoContextTakeover {
+		s += "; server_no_context_takeover"
+	}
+	if p.clientNoContextTakeover {
+		s += "; client_no_context_takeover"
+	}
+	return s
+}
+
+// parseExtensions parse a Sec-WebSocket-Extensions header into a list of extensions with their parameters.
+func parseExtensions(header []string) []map[string]string {
+	var res []map[string]string
+	for _, h := range header {
+		for _, ext := range strings.Split(h, ",") {
+			parts := strings.Split(ext, ";")
+			name := strings.TrimSpace(parts[0])
+			if name == "" {
+				continue
+			}
+			params := map[string]string{"": name}
+			for _, p := range parts[1:] {
+				k, v, _ := strings.Cut(p, "=")
+				params[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(v), `"`)
+			}
+			res = append(res, params)
+		}
+	}
+	return res
+}
+
+// negotiateDeflate pick the first permessage-deflate offer of the client that can be accepted.
+func negotiateDeflate(opts *CompressionOptions, header []string) *deflateParams {
+	if opts == nil {
+		return nil
+	}
+offers:
+	for _, offer := range parseExtensions(header) {
+		if offer[""] != deflateExtension {
+			continue
+		}
+		p := &deflateParams{
+			serverNoContextTakeover: opts.ServerNoContextTakeover,
+			clientNoContextTakeover: opts.ClientNoContextTakeover,
+		}
+		for k, v := range offer {
+			switch k {
+			case "":
+			case "server_no_context_takeover":
+				p.serverNoContextTakeover = true
+			case "client_no_context_takeover":
+				p.clientNoContextTakeover = true
+			case "server_max_window_bits":
+				// compress/flate always use a 32KB window
+				if bits, err := strconv.Atoi(v); err != nil || bits != 15 {
+					continue offers
+				}
+			case "client_max_window_bits":
+				// the client window is never larger than ours, nothing to answer
+			default:
+				continue offers
+			}
+		}
+		return p
+	}
+	return nil
+}
+
+// clientDeflateOffer return the Sec-WebSocket-Extensions request header for opts.
+func clientDeflateOffer(opts *CompressionOptions) string {
+	s := deflateExtension + "; client_max_window_bits"
+	if opts.ServerNoContextTakeover {
+		s += "; server_no_context_takeover"
+	}
+	if opts.ClientNoContextTakeover {
+		s += "; client_no_context_takeover"
+	}
+	return s
+}
+
+// acceptDeflateResponse check the permessage-deflate response of the server.
+func acceptDeflateResponse(opts *CompressionOptions, header []string) (*deflateParams, error) {
+	exts := parseExtensions(header)
+	if len(exts) == 0 {
+		return nil, nil
+	}
+	if opts == nil || len(exts) > 1 || exts[0][""] != deflateExtension {
+		return nil, ErrUnsupportedExtensions
+	}
+	p := &deflateParams{}
+	for k, v := range exts[0] {
+		switch k {
+		case "":
+		case "server_no_context_takeover":
+			p.serverNoContextTakeover = true
+		case "client_no_context_takeover":
+			p.clientNoContextTakeover = true
+		case "server_max_window_bits":
+			// a smaller server window is fine to inflate
+		case "client_max_window_bits":
+			if bits, err := strconv.Atoi(v); err != nil || bits != 15 {
+				return nil, ErrUnsupportedExtensions
+			}
+		default:
+			return nil, ErrUnsupportedExtensions
+		}
+	}
+	return p, nil
+}
+
+var flateWriterPools [flate.BestCompression + 1]sync.Pool
+
+var flateReaderPool = sync.Pool{New: func() any {
+	return flate.NewReader(nil)
+}}
+
+// deflateState is the compression state of a connection.
+type deflateState struct {
+	level      int
+	threshold  int
+	writeReset bool // no context takeover for messages we send
+	readReset  bool // no context takeover for messages we receive
+	fw         *flate.Writer
+	wbuf       bytes.Buffer
+	dict       []byte
+}
+
+func newDeflateState(opts *CompressionOptions, p *deflateParams, isServer bool) *deflateState {
+	if opts == nil {
+		opts = &CompressionOptions{}
+	}
+	d := &deflateState{
+		level:     opts.level(),
+		threshold: opts.Threshold,
+	}
+	if isServer {
+		d.writeReset, d.readReset = p.serverNoContextTakeover, p.clientNoContextTakeover
+	} else {
+		d.writeReset, d.readReset = p.clientNoContextTakeover, p.serverNoContextTakeover
+	}
+	return d
+}
+
+// compress return the compressed payload of msg, caller must hold the write lock
+func (d *deflateState) compress(msg []byte) ([]byte, error) {
+	d.wbuf.Reset()
+	fw := d.fw
+	if fw == nil {
+		if v := flateWriterPools[d.level].Get(); v != nil {
+			fw = v.(*flate.Writer)
+			fw.Reset(&d.wbuf)
+		} else {
+			var err error
+			fw, err = flate.NewWriter(&d.wbuf, d.level)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, err := fw.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	if d.writeReset {
+		flateWriterPools[d.level].Put(fw)
+		d.fw = nil
+	} else {
+		d.fw = fw
+	}
+	out := bytes.TrimSuffix(d.wbuf.Bytes(), deflateTail)
+	res := make([]byte, len(out))
+	copy(res, out)
+	return res, nil
+}
+
+// decompress inflate a received message, reading at most limit bytes, caller must hold the read lock
+func (d *deflateState) decompress(data []byte, limit int) ([]byte, error) {
+	fr := flateReaderPool.Get().(io.ReadCloser)
+	defer flateReaderPool.Put(fr)
+	src := io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail))
+	var dict []byte
+	if !d.readReset {
+		dict = d.dict
+	}
+	if err := fr.(flate.Resetter).Reset(src, dict); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	n, err := io.Copy(&out, io.LimitReader(fr, int64(limit)+1))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if n > int64(limit) {
+		return nil, ErrFrameTooLarge
+	}
+	res := out.Bytes()
+	if !d.readReset {
+		// keep the last 32KB as dictionary for the next message
+		d.dict = append(d.dict, res...)
+		if over := len(d.dict) - maxWindowSize; over > 0 {
+			d.dict = append(d.dict[:0], d.dict[over:]...)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,94 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeflateRoundTrip(t *testing.T) {
+	msgs := [][]byte{
+		[]byte(strings.Repeat("hello websocket ", 100)),
+		[]byte(strings.Repeat("hello websocket ", 100)),
+		[]byte("short"),
+		{},
+		bytes.Repeat([]byte{0, 1, 2, 3, 255}, 20000), // larger than the window
+	}
+	levels := []int{flate.HuffmanOnly, flate.DefaultCompression, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 42}
+	for _, level := range levels {
+		for _, p := range []deflateParams{{}, {serverNoContextTakeover: true}, {clientNoContextTakeover: true}, {true, true}} {
+			opts := &CompressionOptions{Level: level}
+			server, client := newDeflateState(opts, &p, true), newDeflateState(opts, &p, false)
+			for _, pair := range [][2]*deflateState{{server, client}, {client, server}} {
+				sender, receiver := pair[0], pair[1]
+				var sizes []int
+				for i, msg := range msgs {
+					data, err := sender.compress(msg)
+					if err != nil {
+						t.Fatal(level, p, err)
+					}
+					sizes = append(sizes, len(data))
+					got, err := receiver.decompress(data, len(msg))
+					if err != nil {
+						t.Fatal(level, p, i, err)
+					}
+					if !bytes.Equal(got, msg) {
+						t.Fatalf("level %d %+v: message %d corrupted", level, p, i)
+					}
+				}
+				// with context takeover the repeated message is compressed using the previous one
+				if !sender.writeReset && sizes[1] >= sizes[0] {
+					t.Errorf("level %d %+v: context not kept, sizes %v", level, p, sizes)
+				}
+			}
+		}
+	}
+}
+
+func TestCompressedConn(t *testing.T) {
+	for _, opts := range []*CompressionOptions{
+		{Level: flate.DefaultCompression},
+		{Level: flate.BestCompression, ServerNoContextTakeover: true, ClientNoContextTakeover: true},
+		{Level: flate.HuffmanOnly, Threshold: 100},
+	} {
+		srv := httptest.NewServer(Server{Config: Config{Compression: opts}, Handler: func(ws *Conn) {
+			for {
+				var msg string
+				if err := Message.Receive(ws, &msg); err != nil {
+					return
+				}
+				if err := Message.Send(ws, msg); err != nil {
+					return
+				}
+			}
+		}})
+		config, err := NewConfig("ws"+strings.TrimPrefix(srv.URL, "http"), "http://localhost")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Compression = opts
+		ws, err := DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws.deflate == nil {
+			t.Fatal("permessage-deflate not negotiated")
+		}
+		for _, msg := range []string{"small", strings.Repeat("compressed message ", 50), strings.Repeat("compressed message ", 50)} {
+			if err := Message.Send(ws, msg); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			if err := Message.Receive(ws, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != msg {
+				t.Fatalf("%+v: got %q", opts, got)
+			}
+		}
+		ws.Close()
+		srv.Close()
+	}
+}
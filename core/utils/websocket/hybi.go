@@ -299,6 +299,8 @@ func (handler *hybiFrameHandler) HandleFrame(frame frameReader) (frameReader, er
 			if _, err := handler.WritePong(b[:n]); err != nil {
 				return nil, err
 			}
+		} else if handler.conn.PongHandler != nil {
+			handler.conn.PongHandler(string(b[:n]))
 		}
 		return nil, nil
 	}
@@ -345,6 +347,9 @@ func newHybiConn(config *Config, buf *bufio.ReadWriter, rwc io.ReadWriteCloser,
 		PayloadType:        TextFrame,
 		defaultCloseStatus: closeStatusNormal}
 	ws.frameHandler = &hybiFrameHandler{conn: ws}
+	if config.deflate != nil {
+		ws.deflate = newDeflateState(config.Compression, config.deflate, request != nil)
+	}
 	return ws
 }
 
@@ -426,7 +431,9 @@ func hybiClientHandshake(config *Config, br *bufio.Reader, bw *bufio.Writer) (er
 	if len(config.Protocol) > 0 {
 		bw.WriteString("Sec-WebSocket-Protocol: " + strings.Join(config.Protocol, ", ") + "\r\n")
 	}
-	// TODO(ukai): send Sec-WebSocket-Extensions.
+	if config.Compression != nil {
+		bw.WriteString("Sec-WebSocket-Extensions: " + clientDeflateOffer(config.Compression) + "\r\n")
+	}
 	err = config.Header.WriteSubset(bw, handshakeHeader)
 	if err != nil {
 		return err
@@ -455,8 +462,9 @@ func hybiClientHandshake(config *Config, br *bufio.Reader, bw *bufio.Writer) (er
 	if resp.Header.Get("Sec-WebSocket-Accept") != string(expectedAccept) {
 		return ErrChallengeResponse
 	}
-	if resp.Header.Get("Sec-WebSocket-Extensions") != "" {
-		return ErrUnsupportedExtensions
+	config.deflate, err = acceptDeflateResponse(config.Compression, resp.Header.Values("Sec-WebSocket-Extensions"))
+	if err != nil {
+		return err
 	}
 	offeredProtocol := resp.Header.Get("Sec-WebSocket-Protocol")
 	if offeredProtocol != "" {
@@ -527,6 +535,7 @@ func (c *hybiServerHandshaker) ReadHandshake(buf *bufio.Reader, req *http.Reques
 			c.Protocol = append(c.Protocol, strings.TrimSpace(protocols[i]))
 		}
 	}
+	c.deflate = negotiateDeflate(c.Compression, req.Header.Values("Sec-Websocket-Extensions"))
 	c.accept, err = getNonceAccept([]byte(key))
 	if err != nil {
 		return http.StatusInternalServerError, err
@@ -562,7 +571,9 @@ func (c *hybiServerHandshaker) AcceptHandshake(buf *bufio.Writer) (err error) {
 	if len(c.Protocol) > 0 {
 		buf.WriteString("Sec-WebSocket-Protocol: " + c.Protocol[0] + "\r\n")
 	}
-	// TODO(ukai): send Sec-WebSocket-Extensions.
+	if c.deflate != nil {
+		buf.WriteString("Sec-WebSocket-Extensions: " + c.deflate.String() + "\r\n")
+	}
 	if c.Header != nil {
 		err := c.Header.WriteSubset(buf, handshakeHeader)
 		if err != nil {
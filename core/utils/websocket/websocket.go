@@ -10,10 +10,11 @@
 //
 //	https://godoc.org/github.com/gorilla/websocket
 //	https://godoc.org/nhooyr.io/websocket
-package websocket // import "golang.org/x/net/websocket"
+package websocket
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -66,7 +67,7 @@ var (
 	ErrNotSupported         = &ProtocolError{"not supported"}
 )
 
-// ErrFrameTooLarge is returned by Codec's Receive method if payload size
+// ErrFrameTooLarge is returned by Codec's Receive method if message size
 // exceeds limit set by Conn.MaxPayloadBytes
 var ErrFrameTooLarge = errors.New("websocket: frame payload size exceeds limit")
 
@@ -101,7 +102,13 @@ type Config struct {
 	// Dialer used when opening websocket connections.
 	Dialer *net.Dialer
 
+	// Compression enable permessage-deflate if the peer support it.
+	Compression *CompressionOptions
+
 	handshakeData map[string]string
+
+	// deflate is set when permessage-deflate was negotiated.
+	deflate *deflateParams
 }
 
 // serverHandshaker is an interface to handle WebSocket server side handshake.
@@ -179,9 +186,17 @@ type Conn struct {
 	PayloadType        byte
 	defaultCloseStatus int
 
-	// MaxPayloadBytes limits the size of frame payload received over Conn
-	// by Codec's Receive method. If zero, DefaultMaxPayloadBytes is used.
+	// MaxPayloadBytes limits the size of messages received over Conn
+	// by Codec's Receive method, after reassembly of fragments and decompression.
+	// If zero, DefaultMaxPayloadBytes is used.
 	MaxPayloadBytes int
+
+	// PongHandler is called from the reading goroutine when a pong is received.
+	PongHandler func(appData string)
+
+	deflate     *deflateState
+	pending     *bytes.Reader
+	skipMessage bool
 }
 
 // Read implements the io.Reader interface:
@@ -193,18 +208,29 @@ func (ws *Conn) Read(msg []byte) (n int, err error) {
 	ws.rio.Lock()
 	defer ws.rio.Unlock()
 again:
-	if ws.frameReader == nil {
-		frame, err := ws.frameReaderFactory.NewFrameReader()
-		if err != nil {
-			return 0, err
+	if ws.pending != nil {
+		n, err = ws.pending.Read(msg)
+		if err == io.EOF {
+			ws.pending = nil
+			goto again
 		}
-		ws.frameReader, err = ws.frameHandler.HandleFrame(frame)
+		return n, err
+	}
+	if ws.frameReader == nil {
+		frame, err := ws.nextFrame()
 		if err != nil {
 			return 0, err
 		}
-		if ws.frameReader == nil {
+		if frameCompressed(frame) {
+			// compressed messages can't be streamed, inflate the whole message
+			_, data, err := ws.readMessage(frame, ws.maxPayloadBytes())
+			if err != nil {
+				return 0, err
+			}
+			ws.pending = bytes.NewReader(data)
 			goto again
 		}
+		ws.frameReader = frame
 	}
 	n, err = ws.frameReader.Read(msg)
 	if err == io.EOF {
@@ -222,13 +248,7 @@ again:
 func (ws *Conn) Write(msg []byte) (n int, err error) {
 	ws.wio.Lock()
 	defer ws.wio.Unlock()
-	w, err := ws.frameWriterFactory.NewFrameWriter(ws.PayloadType)
-	if err != nil {
-		return 0, err
-	}
-	n, err = w.Write(msg)
-	w.Close()
-	return n, err
+	return ws.writeMessage(ws.PayloadType, msg)
 }
 
 // Close implements the io.Closer interface.
@@ -312,62 +332,153 @@ func (cd Codec) Send(ws *Conn, v interface{}) (err error) {
 	}
 	ws.wio.Lock()
 	defer ws.wio.Unlock()
-	w, err := ws.frameWriterFactory.NewFrameWriter(payloadType)
-	if err != nil {
-		return err
-	}
-	_, err = w.Write(data)
-	w.Close()
+	_, err = ws.writeMessage(payloadType, data)
 	return err
 }
 
-// Receive receives single frame from ws, unmarshaled by cd.Unmarshal and stores
-// in v. The whole frame payload is read to an in-memory buffer; max size of
-// payload is defined by ws.MaxPayloadBytes. If frame payload size exceeds
-// limit, ErrFrameTooLarge is returned; in this case frame is not read off wire
-// completely. The next call to Receive would read and discard leftover data of
-// previous oversized frame before processing next frame.
+// Receive receives a message from ws, unmarshaled by cd.Unmarshal and stores
+// in v. Fragmented messages are reassembled and compressed ones inflated, the whole
+// message is read to an in-memory buffer; max size of message is defined by
+// ws.MaxPayloadBytes. If message size exceeds limit, ErrFrameTooLarge is returned;
+// in this case message is not read off wire completely. The next call to Receive
+// would read and discard leftover data of previous oversized message before
+// processing next message.
 func (cd Codec) Receive(ws *Conn, v interface{}) (err error) {
 	ws.rio.Lock()
 	defer ws.rio.Unlock()
-	if ws.frameReader != nil {
-		_, err = io.Copy(ioutil.Discard, ws.frameReader)
-		if err != nil {
-			return err
-		}
-		ws.frameReader = nil
+	if err = ws.discardPending(); err != nil {
+		return err
 	}
-again:
-	frame, err := ws.frameReaderFactory.NewFrameReader()
+	frame, err := ws.nextFrame()
 	if err != nil {
 		return err
 	}
-	frame, err = ws.frameHandler.HandleFrame(frame)
+	payloadType, data, err := ws.readMessage(frame, ws.maxPayloadBytes())
 	if err != nil {
 		return err
 	}
-	if frame == nil {
-		goto again
+	return cd.Unmarshal(data, payloadType, v)
+}
+
+func (ws *Conn) maxPayloadBytes() int {
+	if ws.MaxPayloadBytes == 0 {
+		return DefaultMaxPayloadBytes
+	}
+	return ws.MaxPayloadBytes
+}
+
+// nextFrame return the next data frame, control frames are handled on the way.
+func (ws *Conn) nextFrame() (frameReader, error) {
+	for {
+		frame, err := ws.frameReaderFactory.NewFrameReader()
+		if err != nil {
+			return nil, err
+		}
+		frame, err = ws.frameHandler.HandleFrame(frame)
+		if err != nil {
+			return nil, err
+		}
+		if frame != nil {
+			return frame, nil
+		}
 	}
-	maxPayloadBytes := ws.MaxPayloadBytes
-	if maxPayloadBytes == 0 {
-		maxPayloadBytes = DefaultMaxPayloadBytes
+}
+
+// discardPending drop leftover data of a partially read or oversized message.
+func (ws *Conn) discardPending() error {
+	ws.pending = nil
+	for ws.frameReader != nil || ws.skipMessage {
+		if ws.frameReader == nil {
+			frame, err := ws.nextFrame()
+			if err != nil {
+				return err
+			}
+			ws.frameReader = frame
+			ws.skipMessage = !frameFin(frame)
+		}
+		if _, err := io.Copy(ioutil.Discard, ws.frameReader); err != nil {
+			return err
+		}
+		ws.frameReader = nil
 	}
-	if hf, ok := frame.(*hybiFrameReader); ok && hf.header.Length > int64(maxPayloadBytes) {
-		// payload size exceeds limit, no need to call Unmarshal
-		//
-		// set frameReader to current oversized frame so that
-		// the next call to this function can drain leftover
-		// data before processing the next frame
-		ws.frameReader = frame
-		return ErrFrameTooLarge
+	return nil
+}
+
+// readMessage read the message starting with frame, reassembling fragments
+// and inflating it if compressed. The caller must hold ws.rio.
+func (ws *Conn) readMessage(frame frameReader, max int) (payloadType byte, data []byte, err error) {
+	payloadType = frame.PayloadType()
+	compressed := frameCompressed(frame)
+	if compressed && ws.deflate == nil {
+		ws.frameHandler.WriteClose(closeStatusProtocolError)
+		return 0, nil, ErrBadFrame
+	}
+	for {
+		if hf, ok := frame.(*hybiFrameReader); ok && int64(len(data))+hf.header.Length > int64(max) {
+			// set frameReader to current oversized frame so that
+			// the next read can drain leftover data of the message
+			ws.frameReader = frame
+			ws.skipMessage = !hf.header.Fin
+			return 0, nil, ErrFrameTooLarge
+		}
+		b, err := ioutil.ReadAll(frame)
+		if err != nil {
+			return 0, nil, err
+		}
+		data = append(data, b...)
+		if frameFin(frame) {
+			break
+		}
+		if frame, err = ws.nextFrame(); err != nil {
+			return 0, nil, err
+		}
+	}
+	if compressed {
+		data, err = ws.deflate.decompress(data, max)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return payloadType, data, nil
+}
+
+// writeMessage write msg as a single frame, compressed if negotiated. The caller must hold ws.wio.
+func (ws *Conn) writeMessage(payloadType byte, msg []byte) (n int, err error) {
+	data, compressed := msg, false
+	if ws.deflate != nil && (payloadType == TextFrame || payloadType == BinaryFrame) && len(msg) >= ws.deflate.threshold {
+		data, err = ws.deflate.compress(msg)
+		if err != nil {
+			return 0, err
+		}
+		compressed = true
 	}
-	payloadType := frame.PayloadType()
-	data, err := ioutil.ReadAll(frame)
+	w, err := ws.frameWriterFactory.NewFrameWriter(payloadType)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return cd.Unmarshal(data, payloadType, v)
+	if hf, ok := w.(*hybiFrameWriter); ok && compressed {
+		hf.header.Rsv[0] = true
+	}
+	_, err = w.Write(data)
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	return len(msg), nil
+}
+
+func frameFin(frame frameReader) bool {
+	if hf, ok := frame.(*hybiFrameReader); ok {
+		return hf.header.Fin
+	}
+	return true
+}
+
+func frameCompressed(frame frameReader) bool {
+	if hf, ok := frame.(*hybiFrameReader); ok {
+		return hf.header.Rsv[0]
+	}
+	return false
 }
 
 func marshal(v interface{}) (msg []byte, payloadType byte, err error) {